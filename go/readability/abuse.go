@@ -0,0 +1,310 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	// READ_RATE is how many pages a minute one client that is neither
+	// signed in nor using an API token may have read, in bursts of up to
+	// READ_BURST, 0 doesn't limit them. It keeps a public instance from
+	// being used to fetch pages for others.
+	READ_RATE  = envFloat("READ_RATE", 30)
+	READ_BURST = envInt("READ_BURST", 10)
+	// READ_POW_BITS has those clients solve a proof of work, finding a
+	// SHA-256 with that many leading zero bits, before they can read at
+	// all, 0 doesn't. A solution is good for READ_POW_TTL.
+	READ_POW_BITS = envInt("READ_POW_BITS", 0)
+	READ_POW_TTL  = envDuration("READ_POW_TTL", 24*time.Hour)
+	// REAL_IP_HEADER is the header a reverse proxy puts the client's
+	// address in, e.g. X-Forwarded-For or CF-Connecting-IP. Without it the
+	// connection's address is the client's.
+	REAL_IP_HEADER = envOr("REAL_IP_HEADER", "")
+
+	clientLimitersMu sync.Mutex
+	clientLimiters   = make(map[string]*rate.Limiter)
+)
+
+const (
+	passCookie = "readability-pass"
+	// powChallengeTTL is how long a proof of work challenge can be solved.
+	powChallengeTTL = 10 * time.Minute
+)
+
+// clientIP is the address of whoever sent r, from REAL_IP_HEADER when
+// behind a proxy. Of a list, the last address, added by the proxy, is
+// taken, the others could be made up.
+func clientIP(r *http.Request) string {
+	if REAL_IP_HEADER != "" {
+		if v := r.Header.Get(REAL_IP_HEADER); v != "" {
+			parts := strings.Split(v, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// clientKey is what a client is limited by, its address, or its /64 for
+// IPv6 where one gets many.
+func clientKey(r *http.Request) string {
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil {
+		return clientIP(r)
+	}
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+
+	return ip.String()
+}
+
+func clientLimiter(key string) *rate.Limiter {
+	clientLimitersMu.Lock()
+	defer clientLimitersMu.Unlock()
+
+	l, ok := clientLimiters[key]
+	if !ok {
+		if len(clientLimiters) >= maxHostLimiters {
+			clientLimiters = make(map[string]*rate.Limiter)
+		}
+		l = rate.NewLimiter(rate.Limit(READ_RATE/60), READ_BURST)
+		clientLimiters[key] = l
+	}
+
+	return l
+}
+
+// readsPage reports whether r may have a page fetched.
+func readsPage(r *http.Request) bool {
+	p := r.URL.Path
+	switch {
+	case p == "/read" || strings.HasPrefix(p, "/read/"):
+		return r.Method == http.MethodGet || r.Method == http.MethodHead
+	case p == "/api/v1/article", p == "/api/v1/jobs", p == "/api/v1/batch":
+		return r.Method != http.MethodDelete
+	case p == "/snapshot", p == "/kindle", strings.HasPrefix(p, "/export/"):
+		return true
+	case p == "/import":
+		return r.Method == http.MethodPost
+	}
+
+	return false
+}
+
+// anonymous reports whether r comes from someone neither signed in nor
+// using a valid API token.
+func anonymous(r *http.Request) bool {
+	if _, ok := r.Context().Value(libraryContextKey{}).(*library); ok {
+		return false
+	}
+	if token := requestAPIToken(r); token != "" {
+		if _, ok := lookupAPIToken(token); ok {
+			return false
+		}
+	}
+	_, ok := requestSession(r)
+
+	return !ok
+}
+
+// chargeReads counts n more pages read by the client of r when it's
+// anonymous, for the requests reading several, which abuseGuard counted as
+// one. When that's more than it may read now it answers 429 and returns
+// false.
+func chargeReads(w http.ResponseWriter, r *http.Request, n int) bool {
+	if READ_RATE <= 0 || n <= 0 || !anonymous(r) {
+		return true
+	}
+
+	res := clientLimiter(clientKey(r)).ReserveN(time.Now(), n)
+	delay := res.Delay()
+	if res.OK() && delay == 0 {
+		return true
+	}
+	res.Cancel()
+	if !res.OK() {
+		// More than a burst, it's how long they'd take at READ_RATE.
+		delay = time.Duration(float64(n) / READ_RATE * float64(time.Minute))
+	}
+	readsRejectedTotal.WithLabelValues("rate").Inc()
+	writeTooManyRequests(w, "too many pages to read at once, try fewer, later, or sign in", delay, strings.HasPrefix(r.URL.Path, "/api/"))
+
+	return false
+}
+
+// abuseGuard limits how many pages anonymous clients have read, and has
+// them solve a proof of work first when READ_POW_BITS is set. It limits
+// how many articles every client sends to a Kindle too.
+func abuseGuard(next http.Handler) http.Handler {
+	if READ_RATE <= 0 && READ_POW_BITS <= 0 && KINDLE_RATE <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/kindle" && KINDLE_RATE > 0 && !anonymous(r) {
+			res := kindleLimiter(clientKey(r)).Reserve()
+			if delay := res.Delay(); !res.OK() || delay > 0 {
+				res.Cancel()
+				writeTooManyRequests(w, "too many articles sent to Kindle, try again later", delay, false)
+				return
+			}
+		}
+
+		if READ_RATE <= 0 && READ_POW_BITS <= 0 || !readsPage(r) || !anonymous(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := clientKey(r)
+		asJSON := strings.HasPrefix(r.URL.Path, "/api/")
+		if READ_POW_BITS > 0 && !validPass(r, key) {
+			readsRejectedTotal.WithLabelValues("pow").Inc()
+			writeChallenge(w, r, key, asJSON)
+			return
+		}
+
+		if READ_RATE > 0 {
+			res := clientLimiter(key).Reserve()
+			if delay := res.Delay(); !res.OK() || delay > 0 {
+				res.Cancel()
+				readsRejectedTotal.WithLabelValues("rate").Inc()
+				writeTooManyRequests(w, "too many pages read, try again later or sign in", delay, asJSON)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// powSign signs what the challenges and passes hold with the session key,
+// so neither has to be stored.
+func powSign(payload string) string {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte("pow\x00" + payload))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// newChallenge is a challenge for the client key, "expires.random.signature".
+func newChallenge(key string) string {
+	payload := strconv.FormatInt(time.Now().Add(powChallengeTTL).Unix(), 10) + "." + randomID(8)
+	return payload + "." + powSign(payload+"."+key)
+}
+
+// solved reports whether nonce solves challenge, one given to key that
+// hasn't expired.
+func solved(challenge, nonce, key string) bool {
+	payload, sig, ok := cutLast(challenge, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(powSign(payload+"."+key))) || expired(payload) {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+	zeros := 0
+	for _, b := range sum {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+
+	return zeros >= READ_POW_BITS
+}
+
+// validPass reports whether r has a pass, given for solving a challenge,
+// for key.
+func validPass(r *http.Request, key string) bool {
+	c, err := r.Cookie(passCookie)
+	if err != nil {
+		return false
+	}
+
+	expires, sig, ok := cutLast(c.Value, ".")
+	return ok && hmac.Equal([]byte(sig), []byte(powSign(expires+"."+key))) && !expired(expires)
+}
+
+func cutLast(s, sep string) (before, after string, ok bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+
+	return s[:i], s[i+len(sep):], true
+}
+
+// expired reports whether the Unix time s starts with has passed.
+func expired(s string) bool {
+	at, _, _ := strings.Cut(s, ".")
+	t, err := strconv.ParseInt(at, 10, 64)
+	return err != nil || time.Now().Unix() > t
+}
+
+func writeChallenge(w http.ResponseWriter, r *http.Request, key string, asJSON bool) {
+	challenge := newChallenge(key)
+	if asJSON {
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{
+			"error":     fmt.Sprintf("solve the proof of work: a nonce for sha256(challenge + \":\" + nonce) to start with %d zero bits, then POST both to /pow", READ_POW_BITS),
+			"challenge": challenge,
+			"bits":      READ_POW_BITS,
+		})
+		return
+	}
+
+	next := "/"
+	if r.Method == http.MethodGet {
+		next = r.URL.RequestURI()
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+	executePage(w, r, "pow.html", map[string]interface{}{
+		"Challenge": challenge,
+		"Bits":      READ_POW_BITS,
+		"Next":      next,
+	})
+}
+
+// powHandler checks a solved challenge, giving a pass for READ_POW_TTL.
+func powHandler(w http.ResponseWriter, r *http.Request) {
+	key := clientKey(r)
+	if READ_POW_BITS <= 0 || !solved(r.FormValue("challenge"), r.FormValue("nonce"), key) {
+		http.Error(w, "the proof of work doesn't check out", http.StatusForbidden)
+		return
+	}
+
+	expires := time.Now().Add(READ_POW_TTL)
+	value := strconv.FormatInt(expires.Unix(), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     passCookie,
+		Value:    value + "." + powSign(value+"."+key),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, safeBack(r.FormValue("next")), http.StatusSeeOther)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ADMIN_USERS are the names of the users who may open /admin, comma
+	// separated, with MULTI_USER. Without users, /admin asks for
+	// ADMIN_PASSWORD instead, and isn't served without either.
+	ADMIN_USERS    = splitList(envOr("ADMIN_USERS", ""))
+	ADMIN_PASSWORD = envOr("ADMIN_PASSWORD", "")
+)
+
+const (
+	adminRecentFailures = 20
+	adminTopDomains     = 10
+	adminRecentArticles = 20
+)
+
+// extractionFailure is an article that couldn't be read, kept for /admin.
+type extractionFailure struct {
+	URL   string
+	Error string
+	At    time.Time
+}
+
+// extractionStats counts the articles read since startup, not those found
+// in the cache.
+var extractionStats = struct {
+	mu     sync.Mutex
+	since  time.Time
+	total  int64
+	failed int64
+	recent []extractionFailure
+}{since: time.Now()}
+
+func recordExtraction(uri string, art *article) {
+	extractionStats.mu.Lock()
+	defer extractionStats.mu.Unlock()
+
+	extractionStats.total++
+	if art != nil && art.ErrMsg == "" {
+		extractionsTotal.WithLabelValues("ok").Inc()
+		return
+	}
+	extractionsTotal.WithLabelValues("failed").Inc()
+
+	f := extractionFailure{URL: uri, Error: "nothing extracted", At: time.Now()}
+	if art != nil {
+		f.Error = art.ErrMsg
+	}
+	extractionStats.failed++
+	extractionStats.recent = append([]extractionFailure{f}, extractionStats.recent...)
+	if len(extractionStats.recent) > adminRecentFailures {
+		extractionStats.recent = extractionStats.recent[:adminRecentFailures]
+	}
+}
+
+func resetExtractionStats() {
+	extractionStats.mu.Lock()
+	defer extractionStats.mu.Unlock()
+
+	extractionStats.since = time.Now()
+	extractionStats.total, extractionStats.failed = 0, 0
+	extractionStats.recent = nil
+}
+
+// adminAllowed reports whether r may open /admin, asking for the password
+// when it's what lets it. The owner signed in on a single-user instance
+// may too.
+func adminAllowed(w http.ResponseWriter, r *http.Request) bool {
+	if MULTI_USER && len(ADMIN_USERS) > 0 {
+		s, ok := requestSession(r)
+		if !ok {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return false
+		}
+		for _, name := range ADMIN_USERS {
+			if u, err := store.GetUser(name); err == nil && u != nil && u.ID == s.ID {
+				return true
+			}
+		}
+		http.Error(w, "only admins can open this page", http.StatusForbidden)
+		return false
+	}
+
+	if ADMIN_PASSWORD == "" {
+		http.NotFound(w, r)
+		return false
+	}
+	if s, ok := requestSession(r); ok && !MULTI_USER && s.ID == ownerUser.ID {
+		return true
+	}
+	if _, password, ok := r.BasicAuth(); !ok || !secureEqual(password, ADMIN_PASSWORD) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Readability admin"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// adminLibrary is the library /admin looks into, ?library={user ID} or the
+// instance's.
+func adminLibrary(r *http.Request) (*library, error) {
+	if id := r.FormValue("library"); id != "" && MULTI_USER {
+		return userLibrary(id)
+	}
+
+	return instanceLib, nil
+}
+
+type adminLibraryUsage struct {
+	ID, Owner string
+	Articles  int
+	Size      int64
+}
+
+// MiB is the size in MiB, to a tenth.
+func (u adminLibraryUsage) MiB() float64 {
+	return float64(u.Size*10>>20) / 10
+}
+
+type adminDomain struct {
+	Domain   string
+	Articles int
+}
+
+func adminLibraries() []adminLibraryUsage {
+	owners := map[string]string{"": "Instance"}
+	if users, err := store.Users(); err == nil {
+		for _, u := range users {
+			owners[u.ID] = u.displayName()
+		}
+	}
+
+	var usage []adminLibraryUsage
+	for _, lib := range allLibraries() {
+		count, size, err := lib.store.Usage()
+		if err != nil {
+			slog.Error("failed to get cache usage", "library", lib.User, "err", err)
+		}
+		usage = append(usage, adminLibraryUsage{ID: lib.User, Owner: owners[lib.User], Articles: count, Size: size})
+	}
+
+	return usage
+}
+
+// topDomains returns the domains most articles of lib come from.
+func (lib *library) topDomains(n int) ([]adminDomain, error) {
+	keys, err := lib.store.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, key := range keys {
+		uri, err := lib.store.ArticleURL(key)
+		if err != nil || uri == "" {
+			continue
+		}
+		if u, err := url.Parse(uri); err == nil {
+			counts[u.Hostname()]++
+		}
+	}
+
+	domains := make([]adminDomain, 0, len(counts))
+	for domain, count := range counts {
+		domains = append(domains, adminDomain{Domain: domain, Articles: count})
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		if domains[i].Articles != domains[j].Articles {
+			return domains[i].Articles > domains[j].Articles
+		}
+		return domains[i].Domain < domains[j].Domain
+	})
+	if len(domains) > n {
+		domains = domains[:n]
+	}
+
+	return domains, nil
+}
+
+// adminAction runs what a button of /admin posted on lib, returning what
+// it did.
+func adminAction(r *http.Request, lib *library) (string, error) {
+	switch r.FormValue("action") {
+	case "purge":
+		uri := normalizeURL(cleanURL(r.FormValue("url")))
+		key := lib.resolveKey(uri)
+		if art, err := lib.store.GetArticle(key); err != nil {
+			return "", err
+		} else if art == nil {
+			return "", errArticleNotFound
+		}
+		if err := lib.deleteArticle(key); err != nil {
+			return "", err
+		}
+		return "Purged " + uri + ".", nil
+	case "reindex":
+		for _, l := range allLibraries() {
+			l.index.Reset()
+			l.buildSearchIndex()
+		}
+		return "Rebuilt the search indexes.", nil
+	case "expire":
+		for _, l := range allLibraries() {
+			l.purgeExpired()
+			l.evictArticles()
+		}
+		return "Purged the expired articles and evicted over the limits.", nil
+	case "stats":
+		resetExtractionStats()
+		return "Reset the extraction stats.", nil
+	}
+
+	return "", nil
+}
+
+// adminHandler shows how big the cache is, where articles come from and
+// how reading them goes, with buttons to purge an article or flush what
+// is derived from the articles.
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAllowed(w, r) {
+		return
+	}
+
+	lib, err := adminLibrary(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var done, errMsg string
+	if r.Method == http.MethodPost {
+		if origin := r.Header.Get("Origin"); origin != "" && origin != baseURL(r) {
+			http.Error(w, "cross-origin request refused", http.StatusForbidden)
+			return
+		}
+		if done, err = adminAction(r, lib); err != nil {
+			errMsg = err.Error()
+		}
+	}
+
+	domains, err := lib.topDomains(adminTopDomains)
+	if err != nil {
+		errMsg = err.Error()
+	}
+
+	var recents []archiveEntry
+	keys, err := lib.getLastNArticles(adminRecentArticles)
+	if err != nil {
+		errMsg = err.Error()
+	}
+	for _, key := range keys {
+		if art, err := lib.store.GetArticle(key); err == nil && art != nil {
+			recents = append(recents, lib.newArchiveEntry(key, art))
+		}
+	}
+
+	extractionStats.mu.Lock()
+	since, total, failed := extractionStats.since, extractionStats.total, extractionStats.failed
+	failures := append([]extractionFailure(nil), extractionStats.recent...)
+	extractionStats.mu.Unlock()
+
+	errorRate := 0.0
+	if total > 0 {
+		errorRate = float64(failed) / float64(total) * 100
+	}
+
+	executePage(w, r, "admin.html", map[string]interface{}{
+		"Libraries": adminLibraries(),
+		"Library":   lib.User,
+		"Domains":   domains,
+		"Recents":   recents,
+		"Since":     since,
+		"Total":     total,
+		"Failed":    failed,
+		"ErrorRate": errorRate,
+		"Failures":  failures,
+		"Done":      done,
+		"Error":     errMsg,
+	})
+}
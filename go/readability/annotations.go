@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// highlightContext is how much text around a highlight is kept, in
+	// runes, to find it again where its text is more than once.
+	highlightContext = 32
+	// highlightMax caps the text and note of a highlight, in runes.
+	highlightMax = 10000
+)
+
+var errHighlightNotFound = errors.New("highlight not found")
+
+// highlight is a passage of an article the reader marked, found again in
+// the article by its Text, between Prefix and Suffix when it's there more
+// than once.
+type highlight struct {
+	ID        string    `json:"id"`
+	Text      string    `json:"text"`
+	Prefix    string    `json:"prefix,omitempty"`
+	Suffix    string    `json:"suffix,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// addHighlight stores h with the article at uri, returning it with its ID.
+func (lib *library) addHighlight(uri string, h highlight) (highlight, error) {
+	h.ID = randomID(8)
+	h.CreatedAt = time.Now()
+	h.Text = truncateRunes(h.Text, highlightMax)
+	h.Note = truncateRunes(h.Note, highlightMax)
+	h.Prefix = lastRunes(h.Prefix, highlightContext)
+	h.Suffix = truncateRunes(h.Suffix, highlightContext)
+
+	err := lib.store.UpdateArticle(lib.resolveKey(uri), func(art *article) {
+		art.Highlights = append(art.Highlights, h)
+	})
+
+	return h, err
+}
+
+// updateHighlight replaces the note of the highlight id of the article at
+// uri.
+func (lib *library) updateHighlight(uri, id, note string) (highlight, error) {
+	var updated highlight
+	found := false
+	err := lib.store.UpdateArticle(lib.resolveKey(uri), func(art *article) {
+		// A copy, the stored article's may be shared with readers.
+		highlights := append([]highlight(nil), art.Highlights...)
+		for i := range highlights {
+			if highlights[i].ID == id {
+				highlights[i].Note = truncateRunes(note, highlightMax)
+				updated, found = highlights[i], true
+			}
+		}
+		art.Highlights = highlights
+	})
+	if err == nil && !found {
+		err = errHighlightNotFound
+	}
+
+	return updated, err
+}
+
+// deleteHighlight drops the highlight id of the article at uri.
+func (lib *library) deleteHighlight(uri, id string) error {
+	found := false
+	err := lib.store.UpdateArticle(lib.resolveKey(uri), func(art *article) {
+		kept := make([]highlight, 0, len(art.Highlights))
+		for _, h := range art.Highlights {
+			if h.ID == id {
+				found = true
+				continue
+			}
+			kept = append(kept, h)
+		}
+		art.Highlights = kept
+	})
+	if err == nil && !found {
+		err = errHighlightNotFound
+	}
+
+	return err
+}
+
+// highlightedArticle is an article with its highlights, for /highlights.
+type highlightedArticle struct {
+	URL        string      `json:"url"`
+	Title      string      `json:"title"`
+	Highlights []highlight `json:"highlights"`
+	// latest is when the last highlight was made.
+	latest time.Time
+}
+
+// highlightedArticles returns the articles with highlights, the most
+// recently highlighted first.
+func (lib *library) highlightedArticles() ([]highlightedArticle, error) {
+	keys, err := lib.store.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	var arts []highlightedArticle
+	for _, key := range keys {
+		art, err := lib.store.GetArticle(key)
+		if err != nil || art == nil || len(art.Highlights) == 0 {
+			continue
+		}
+		ha := highlightedArticle{URL: art.URL, Title: art.Title, Highlights: art.Highlights}
+		for _, h := range art.Highlights {
+			if h.CreatedAt.After(ha.latest) {
+				ha.latest = h.CreatedAt
+			}
+		}
+		arts = append(arts, ha)
+	}
+	sort.Slice(arts, func(i, j int) bool {
+		return arts[i].latest.After(arts[j].latest)
+	})
+
+	return arts, nil
+}
+
+// lastRunes is the end of s, n runes long at most.
+func lastRunes(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[len(runes)-n:])
+	}
+
+	return s
+}
+
+func highlightsHandler(w http.ResponseWriter, r *http.Request) {
+	arts, err := libraryFor(r).highlightedArticles()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	executePage(w, r, "highlights.html", map[string]interface{}{
+		"Articles": arts,
+	})
+}
+
+// highlightRequest is a highlight to make, or the new note of one.
+type highlightRequest struct {
+	URL    string `json:"url"`
+	Text   string `json:"text"`
+	Prefix string `json:"prefix,omitempty"`
+	Suffix string `json:"suffix,omitempty"`
+	Note   string `json:"note,omitempty"`
+}
+
+var (
+	apiHighlightsOp = apiOperation{
+		Summary:     "List the highlights",
+		Description: "The highlights of the article at url, or without it the articles with highlights, the most recently highlighted first.",
+		Tag:         "articles",
+		Query:       []apiParam{{Name: "url", Description: "The article's URL."}},
+		Response:    []highlightedArticle{},
+		Errors:      []int{http.StatusNotFound, http.StatusInternalServerError},
+	}
+	apiAddHighlightOp = apiOperation{
+		Summary:  "Highlight a passage of an article",
+		Tag:      "articles",
+		Body:     highlightRequest{},
+		Status:   http.StatusCreated,
+		Response: highlight{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+	}
+	apiUpdateHighlightOp = apiOperation{
+		Summary:  "Change the note of a highlight",
+		Tag:      "articles",
+		Body:     highlightRequest{},
+		Response: highlight{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+	}
+	apiDeleteHighlightOp = apiOperation{
+		Summary:  "Delete a highlight",
+		Tag:      "articles",
+		Query:    []apiParam{{Name: "url", Description: "The article's URL.", Required: true}},
+		Response: map[string]string{"deleted": ""},
+		Errors:   []int{http.StatusNotFound, http.StatusInternalServerError},
+	}
+)
+
+func apiHighlightsHandler(w http.ResponseWriter, r *http.Request) {
+	lib := libraryFor(r)
+
+	uri := r.URL.Query().Get("url")
+	if uri == "" {
+		arts, err := lib.highlightedArticles()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if arts == nil {
+			arts = []highlightedArticle{}
+		}
+		writeJSON(w, http.StatusOK, arts)
+		return
+	}
+
+	art, err := lib.store.GetArticle(lib.resolveKey(uri))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if art == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errArticleNotFound.Error()})
+		return
+	}
+
+	highlights := art.Highlights
+	if highlights == nil {
+		highlights = []highlight{}
+	}
+	writeJSON(w, http.StatusOK, []highlightedArticle{{URL: art.URL, Title: art.Title, Highlights: highlights}})
+}
+
+func apiAddHighlightHandler(w http.ResponseWriter, r *http.Request) {
+	var req highlightRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if req.URL == "" || strings.TrimSpace(req.Text) == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "url and text are required"})
+		return
+	}
+
+	h, err := libraryFor(r).addHighlight(req.URL, highlight{Text: req.Text, Prefix: req.Prefix, Suffix: req.Suffix, Note: req.Note})
+	if err != nil {
+		writeHighlightError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, h)
+}
+
+func apiUpdateHighlightHandler(w http.ResponseWriter, r *http.Request) {
+	var req highlightRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if req.URL == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "url is required"})
+		return
+	}
+
+	h, err := libraryFor(r).updateHighlight(req.URL, mux.Vars(r)["id"], req.Note)
+	if err != nil {
+		writeHighlightError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h)
+}
+
+func apiDeleteHighlightHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := libraryFor(r).deleteHighlight(r.URL.Query().Get("url"), id); err != nil {
+		writeHighlightError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"deleted": id})
+}
+
+func writeHighlightError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errArticleNotFound) || errors.Is(err, errHighlightNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+
+	slog.ErrorContext(r.Context(), "failed to store highlight", "err", err)
+	writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
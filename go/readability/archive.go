@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const archivePageSize = 20
+
+// archiveEntry is an article as the archive and the index list it.
+type archiveEntry struct {
+	URL    string
+	Title  string
+	Domain string
+	Saved  time.Time
+	Views  int64
+	// WordCount and ReadingTime are the article's, Changed is set when it
+	// changed since it was last read.
+	WordCount   int
+	ReadingTime int
+	Changed     bool
+}
+
+func (lib *library) newArchiveEntry(key string, art *article) archiveEntry {
+	if art.WordCount == 0 && art.Content != "" {
+		art.countWords()
+	}
+	entry := archiveEntry{
+		URL:         art.URL,
+		Title:       art.Title,
+		Saved:       art.CreatedAt,
+		WordCount:   art.WordCount,
+		ReadingTime: art.ReadingTime,
+		Changed:     art.Changed,
+	}
+	if u, err := url.Parse(art.URL); err == nil {
+		entry.Domain = u.Hostname()
+	}
+
+	views, err := lib.store.ViewCount(key)
+	if err != nil {
+		slog.Error("failed to get view count", "key", key, "err", err)
+	}
+	entry.Views = views
+
+	return entry
+}
+
+// archiveHandler pages through every saved article, newest first.
+func archiveHandler(w http.ResponseWriter, r *http.Request) {
+	lib := libraryFor(r)
+	keys, err := lib.store.Keys()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pages := (len(keys) + archivePageSize - 1) / archivePageSize
+	if pages == 0 {
+		pages = 1
+	}
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	if page > pages {
+		page = pages
+	}
+
+	start := (page - 1) * archivePageSize
+	end := start + archivePageSize
+	if end > len(keys) {
+		end = len(keys)
+	}
+
+	entries := make([]archiveEntry, 0, end-start)
+	for _, key := range keys[start:end] {
+		if art, err := lib.store.GetArticle(key); err == nil && art != nil {
+			entries = append(entries, lib.newArchiveEntry(key, art))
+		}
+	}
+
+	data := map[string]interface{}{
+		"Articles": entries,
+		"Total":    len(keys),
+		"Page":     page,
+		"Pages":    pages,
+	}
+	if page > 1 {
+		data["Prev"] = page - 1
+	}
+	if page < pages {
+		data["Next"] = page + 1
+	}
+
+	executePage(w, r, "archive.html", data)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// backupVersion is the version of the backups written, restore reads those
+// up to it.
+const backupVersion = 1
+
+const (
+	backupManifestName = "manifest.json"
+	backupUsersName    = "users.json"
+	backupArticlesName = "articles.jsonl"
+	// backupUserPrefix is where the users' libraries are, by user name.
+	backupUserPrefix = "users/"
+)
+
+// backupManifest lists the files of a backup with their checksums, so a
+// damaged or cut backup is told apart before anything is restored from it.
+// It's the last file of the archive.
+type backupManifest struct {
+	Version   int          `json:"version"`
+	CreatedAt time.Time    `json:"created_at"`
+	Storage   string       `json:"storage"`
+	Files     []backupFile `json:"files"`
+}
+
+type backupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Count is how many articles, or accounts, the file holds.
+	Count int `json:"count"`
+}
+
+// backupWriter writes the files of a backup to a tar, noting them in its
+// manifest.
+type backupWriter struct {
+	tw       *tar.Writer
+	manifest backupManifest
+}
+
+// add writes the file name with write, which returns how many records it
+// wrote. It's spooled to a temporary file first, tar needs the size up
+// front and libraries don't fit in memory.
+func (b *backupWriter) add(name string, write func(w io.Writer) (int, error)) error {
+	tmp, err := os.CreateTemp("", "readability-backup-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	sum := sha256.New()
+	bw := bufio.NewWriter(io.MultiWriter(tmp, sum))
+	n, err := write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := b.tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: size, ModTime: b.manifest.CreatedAt}); err != nil {
+		return err
+	}
+	if _, err := io.Copy(b.tw, tmp); err != nil {
+		return err
+	}
+
+	b.manifest.Files = append(b.manifest.Files, backupFile{
+		Name:   name,
+		Size:   size,
+		SHA256: hex.EncodeToString(sum.Sum(nil)),
+		Count:  n,
+	})
+
+	return nil
+}
+
+// close writes the manifest and ends the tar.
+func (b *backupWriter) close() error {
+	data, err := json.MarshalIndent(b.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := b.tw.WriteHeader(&tar.Header{Name: backupManifestName, Mode: 0o600, Size: int64(len(data)), ModTime: b.manifest.CreatedAt}); err != nil {
+		return err
+	}
+	if _, err := b.tw.Write(data); err != nil {
+		return err
+	}
+
+	return b.tw.Close()
+}
+
+// backupCommand snapshots the accounts and every library's articles, read
+// through the storage like the instance does, which may be serving
+// meanwhile, into a zstd compressed tar:
+//
+//	readability backup [-out file.tar.zst]
+func backupCommand(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := fs.String("out", "readability-"+time.Now().Format("2006-01-02")+".tar.zst", "the file to write the backup to")
+	fs.Parse(args)
+
+	if _, ok := store.(*memoryStorage); ok {
+		return errors.New("the memory storage goes with the instance, there's nothing to back up")
+	}
+
+	// Written next to it and renamed once complete, a failed backup doesn't
+	// leave one looking good.
+	f, err := os.CreateTemp(filepath.Dir(*out), filepath.Base(*out)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	zw, err := zstd.NewWriter(f)
+	if err != nil {
+		return err
+	}
+	b := &backupWriter{tw: tar.NewWriter(zw), manifest: backupManifest{
+		Version:   backupVersion,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Storage:   STORAGE,
+	}}
+
+	users, err := store.Users()
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		if err := b.add(backupUsersName, func(w io.Writer) (int, error) {
+			return len(users), json.NewEncoder(w).Encode(users)
+		}); err != nil {
+			return err
+		}
+	}
+	if err := b.add(backupArticlesName, instanceLib.exportArticles); err != nil {
+		return err
+	}
+	for _, u := range users {
+		lib, err := userLibrary(u.ID)
+		if err != nil {
+			return err
+		}
+		if err := b.add(backupUserPrefix+u.Name+".jsonl", lib.exportArticles); err != nil {
+			return err
+		}
+	}
+
+	if err := b.close(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), *out); err != nil {
+		return err
+	}
+
+	articles := 0
+	for _, file := range b.manifest.Files {
+		if file.Name != backupUsersName {
+			articles += file.Count
+		}
+	}
+	fmt.Fprintf(os.Stderr, "backed up %d articles of %d libraries to %s\n", articles, len(users)+1, *out)
+
+	return nil
+}
+
+// readBackup calls fn with every file of the backup at path, in order.
+func readBackup(path string, fn func(name string, r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(hdr.Name, tr); err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+	}
+}
+
+// verifyBackup reads the whole backup at path and returns its manifest
+// when every file it lists is there and matches its checksum, and nothing
+// else is.
+func verifyBackup(path string) (*backupManifest, error) {
+	var manifest *backupManifest
+	sums := make(map[string]backupFile)
+	err := readBackup(path, func(name string, r io.Reader) error {
+		if name == backupManifestName {
+			manifest = &backupManifest{}
+			return json.NewDecoder(r).Decode(manifest)
+		}
+
+		sum := sha256.New()
+		n, err := io.Copy(sum, r)
+		sums[name] = backupFile{Name: name, Size: n, SHA256: hex.EncodeToString(sum.Sum(nil))}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, errors.New("the backup has no manifest, it's incomplete")
+	}
+	if manifest.Version > backupVersion {
+		return nil, fmt.Errorf("the backup is version %d, this readability reads up to %d", manifest.Version, backupVersion)
+	}
+
+	for _, file := range manifest.Files {
+		got, ok := sums[file.Name]
+		if !ok {
+			return nil, fmt.Errorf("%s is missing from the backup", file.Name)
+		}
+		if got.Size != file.Size || got.SHA256 != file.SHA256 {
+			return nil, fmt.Errorf("%s doesn't match its checksum", file.Name)
+		}
+		delete(sums, file.Name)
+	}
+	for name := range sums {
+		return nil, fmt.Errorf("%s isn't in the manifest", name)
+	}
+
+	return manifest, nil
+}
+
+// restoreBackup restores the backup at path, checked already: the accounts
+// missing are added, and the articles replace those saved under the same
+// URL, the others are kept.
+func restoreBackup(path string) error {
+	return readBackup(path, func(name string, r io.Reader) error {
+		var lib *library
+		switch {
+		case name == backupUsersName:
+			var users []*user
+			if err := json.NewDecoder(r).Decode(&users); err != nil {
+				return err
+			}
+			for _, u := range users {
+				existing, err := store.GetUser(u.Name)
+				if err != nil {
+					return err
+				}
+				if existing != nil {
+					continue
+				}
+				if err := store.SetUser(u); err != nil {
+					return err
+				}
+			}
+			return nil
+		case name == backupArticlesName:
+			lib = instanceLib
+		case strings.HasPrefix(name, backupUserPrefix) && strings.HasSuffix(name, ".jsonl"):
+			id, err := userID(strings.TrimSuffix(strings.TrimPrefix(name, backupUserPrefix), ".jsonl"))
+			if err != nil {
+				return err
+			}
+			if lib, err = userLibrary(id); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+
+		status, err := lib.importArticles(r)
+		fmt.Fprintf(os.Stderr, "%s: restored %d articles, %d failed\n", name, status.Done-status.Failed, status.Failed)
+		for _, e := range status.Errors {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		return err
+	})
+}
+
+// restoreCommand checks a backup against its manifest, then restores it
+// into the storage the instance uses, which may be serving meanwhile:
+//
+//	readability restore [-verify] file.tar.zst
+func restoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	verify := fs.Bool("verify", false, "only check the backup, restoring nothing")
+	fs.Parse(args)
+
+	path := fs.Arg(0)
+	if path == "" {
+		return errors.New("usage: restore [-verify] file.tar.zst")
+	}
+
+	manifest, err := verifyBackup(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	var summary bytes.Buffer
+	for _, file := range manifest.Files {
+		fmt.Fprintf(&summary, "\n  %s: %d, sha256 %s", file.Name, file.Count, file.SHA256[:12])
+	}
+	fmt.Fprintf(os.Stderr, "%s: backup of %s storage made %s, checksums match%s\n",
+		path, manifest.Storage, manifest.CreatedAt.Format(time.RFC3339), summary.String())
+	if *verify {
+		return nil
+	}
+
+	return restoreBackup(path)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Proxies and browsers merge the slashes of a URL in a path, leaving
+	// https:/example.com.
+	mergedScheme = regexp.MustCompile(`(?i)^(https?):/+`)
+	hasScheme    = regexp.MustCompile(`(?i)^https?://`)
+	bareHost     = regexp.MustCompile(`^[\w-]+(\.[\w-]+)+(:\d+)?$`)
+)
+
+// cleanURL fixes up a URL the way browsers and share sheets hand them over,
+// percent-encoded whole, with its slashes merged or without a scheme.
+func cleanURL(s string) string {
+	s = strings.TrimSpace(s)
+
+	if lower := strings.ToLower(s); strings.HasPrefix(lower, "http%3a") || strings.HasPrefix(lower, "https%3a") {
+		if unescaped, err := url.PathUnescape(s); err == nil {
+			s = unescaped
+		}
+	}
+
+	s = mergedScheme.ReplaceAllString(s, "$1://")
+
+	if s != "" && !hasScheme.MatchString(s) {
+		host, _, _ := strings.Cut(s, "/")
+		if bareHost.MatchString(host) {
+			s = "https://" + s
+		}
+	}
+
+	return s
+}
+
+// bookmarklet is the javascript: URL opening the page it's clicked on in
+// base's /read/, in a new tab with newTab.
+func bookmarklet(base string, newTab bool) template.URL {
+	prefix, _ := json.Marshal(base + "/read/")
+
+	open := "location.href=u"
+	if newTab {
+		open = "window.open(u)"
+	}
+
+	return template.URL("javascript:(function(){var u=" + string(prefix) + "+encodeURIComponent(location.href);" + open + "})()")
+}
+
+func bookmarkletHandler(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+
+	executePage(w, r, "bookmarklet.html", map[string]interface{}{
+		"Read":       bookmarklet(base, false),
+		"ReadNewTab": bookmarklet(base, true),
+		"Save":       base + "/save?url=%s",
+	})
+}
+
+// saveHandler reads the URL in the url, text or title parameter, whichever
+// has one, as share targets and search shortcuts put it in any of them.
+func saveHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	uri := cleanURL(q.Get("url"))
+	if !hasScheme.MatchString(uri) {
+		uri = ""
+		for _, param := range []string{"text", "title"} {
+			if found := messageURL.FindString(q.Get(param)); found != "" {
+				uri = strings.TrimRight(found, ".,;:!?)>\"'")
+				break
+			}
+		}
+	}
+	if uri == "" {
+		http.Error(w, "no URL to read", http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, articlePath("read", normalizeURL(uri)), http.StatusSeeOther)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// S3_BUCKET keeps the snapshots and proxied images of S3_MIN_SIZE bytes
+	// or more in an S3-compatible bucket instead of the storage, which
+	// keeps what refers to them. They're read back from it when requested.
+	S3_BUCKET   = envOr("S3_BUCKET", "")
+	S3_ENDPOINT = envOr("S3_ENDPOINT", "https://s3.amazonaws.com")
+	S3_REGION   = envOr("S3_REGION", "us-east-1")
+	// S3_PREFIX is put before the names of the objects, to share a bucket.
+	S3_PREFIX            = envOr("S3_PREFIX", "")
+	S3_ACCESS_KEY_ID     = envOr("S3_ACCESS_KEY_ID", "")
+	S3_SECRET_ACCESS_KEY = envOr("S3_SECRET_ACCESS_KEY", "")
+	S3_MIN_SIZE          = envInt("S3_MIN_SIZE", 64<<10)
+
+	s3Client = &http.Client{Timeout: time.Minute}
+)
+
+var errObjectNotFound = errors.New("object not found in the bucket")
+
+// bucketEnabled reports whether large objects go to S3_BUCKET.
+func bucketEnabled() bool {
+	return S3_BUCKET != ""
+}
+
+// bucketObject is the name of an object in the bucket.
+func bucketObject(kind, id string) string {
+	return S3_PREFIX + kind + "/" + id
+}
+
+// s3Request sends a request for the object name of S3_BUCKET, signed with
+// AWS Signature Version 4, addressing the bucket in the path as every
+// S3-compatible server takes it.
+func s3Request(ctx context.Context, method, name string, body []byte, contentType string) (*http.Response, error) {
+	path := "/" + S3_BUCKET + "/" + s3Escape(name)
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(S3_ENDPOINT, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		method,
+		path,
+		"",
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signed,
+		payloadHash,
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	scope := day + "/" + S3_REGION + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := s3HMAC([]byte("AWS4"+S3_SECRET_ACCESS_KEY), day)
+	for _, part := range []string{S3_REGION, "s3", "aws4_request"} {
+		key = s3HMAC(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		S3_ACCESS_KEY_ID, scope, signed, hex.EncodeToString(s3HMAC(key, toSign))))
+
+	return s3Client.Do(req)
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape escapes an object name as Signature Version 4 wants it, every
+// byte but the unreserved characters and the slashes.
+func s3Escape(name string) string {
+	var sb strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", c)
+	}
+
+	return sb.String()
+}
+
+// putObject stores data in the bucket under name.
+func putObject(name string, data []byte, contentType string) error {
+	resp, err := s3Request(context.Background(), http.MethodPut, name, data, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to store %s in the bucket: %s: %s", name, resp.Status, msg)
+	}
+
+	return nil
+}
+
+// getObject reads the object name of the bucket, errObjectNotFound when
+// there is none.
+func getObject(name string) ([]byte, error) {
+	resp, err := s3Request(context.Background(), http.MethodGet, name, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, errObjectNotFound
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	return nil, fmt.Errorf("failed to read %s from the bucket: %s: %s", name, resp.Status, msg)
+}
+
+// setSnapshot stores snap, its HTML in the bucket when it's large. A
+// snapshot stored without HTML has it there.
+func (lib *library) setSnapshot(snap *snapshot) error {
+	if !bucketEnabled() || len(snap.HTML) < S3_MIN_SIZE {
+		return lib.store.SetSnapshot(snap)
+	}
+
+	if err := putObject(bucketObject("snapshots", snap.ID), snap.HTML, "text/html; charset=utf-8"); err != nil {
+		return err
+	}
+	stored := *snap
+	stored.HTML = nil
+
+	return lib.store.SetSnapshot(&stored)
+}
+
+// snapshot returns the snapshot id, with its HTML read from the bucket when
+// it's there, nil when there is none.
+func (lib *library) snapshot(id string) (*snapshot, error) {
+	snap, err := lib.store.GetSnapshot(id)
+	if err != nil || snap == nil || len(snap.HTML) > 0 {
+		return snap, err
+	}
+
+	if snap.HTML, err = getObject(bucketObject("snapshots", id)); err != nil {
+		return nil, err
+	}
+
+	return snap, nil
+}
+
+// setImage caches img, its data in the bucket when it's large. An image
+// fetched but stored without data has it there.
+func setImage(hash string, img *cachedImage) error {
+	if !bucketEnabled() || len(img.Data) < S3_MIN_SIZE {
+		return store.SetImage(hash, img)
+	}
+
+	if err := putObject(bucketObject("images", hash), img.Data, img.ContentType); err != nil {
+		return err
+	}
+	stored := *img
+	stored.Data = nil
+
+	return store.SetImage(hash, &stored)
+}
+
+// getImage returns the image of hash, with its data read from the bucket
+// when it's there. An image missing from the bucket comes without data, to
+// be fetched again.
+func getImage(hash string) (*cachedImage, error) {
+	img, err := store.GetImage(hash)
+	if err != nil || img == nil || len(img.Data) > 0 || img.FetchedAt.IsZero() || !bucketEnabled() {
+		return img, err
+	}
+
+	img.Data, err = getObject(bucketObject("images", hash))
+	if err == errObjectNotFound {
+		slog.Warn("image missing from the bucket, fetching it again", "url", img.URL)
+		return img, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// offloadCommand moves the snapshots of S3_MIN_SIZE bytes or more of every
+// library to S3_BUCKET, those taken before it was set:
+//
+//	readability offload
+func offloadCommand(args []string) error {
+	fs := flag.NewFlagSet("offload", flag.ExitOnError)
+	fs.Parse(args)
+
+	if !bucketEnabled() {
+		return errors.New("S3_BUCKET is not set")
+	}
+
+	moved, size := 0, 0
+	for _, lib := range allLibraries() {
+		keys, err := lib.store.Keys()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			ids, err := lib.store.Snapshots(key)
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				snap, err := lib.store.GetSnapshot(id)
+				if err != nil {
+					return err
+				}
+				if snap == nil || len(snap.HTML) < S3_MIN_SIZE {
+					continue
+				}
+				if err := lib.setSnapshot(snap); err != nil {
+					return err
+				}
+				moved++
+				size += len(snap.HTML)
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "moved %d snapshots, %d bytes, to the bucket\n", moved, size)
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/publicsuffix"
+)
+
+// TRACKING_PARAMS are the query parameters dropped from URLs, campaign and
+// click IDs the same article gets shared with, comma separated. Those
+// ending in * are prefixes.
+var TRACKING_PARAMS = splitList(envOr("TRACKING_PARAMS", "utm_*,fbclid,gclid,dclid,gbraid,wbraid,msclkid,yclid,twclid,igshid,mc_cid,mc_eid,_hsenc,_hsmi,mkt_tok,oly_anon_id,oly_enc_id,vero_id,_ga,_gl,ref_src"))
+
+// trackingParam reports whether the query parameter name is one of
+// TRACKING_PARAMS.
+func trackingParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, param := range TRACKING_PARAMS {
+		param = strings.ToLower(param)
+		if prefix, ok := strings.CutSuffix(param, "*"); (ok && strings.HasPrefix(name, prefix)) || name == param {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeURL puts uri in the form it is cached under: lowercase scheme and
+// host, no default port, no fragment, no tracking parameters and sorted
+// query parameters, so the same page submitted differently ends up in one
+// entry.
+func normalizeURL(uri string) string {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return uri
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return uri
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && !(u.Scheme == "http" && port == "80") && !(u.Scheme == "https" && port == "443") {
+		host += ":" + port
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	u.Host = host
+
+	u.Fragment, u.RawFragment = "", ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name := range query {
+			if trackingParam(name) {
+				query.Del(name)
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	u.ForceQuery = false
+
+	return u.String()
+}
+
+// canonicalLink returns the normalized <link rel="canonical"> of doc,
+// resolved against base, or "" when the page has none. A canonical on
+// another site than base is ignored, the page would otherwise be saved as
+// that site's article.
+func canonicalLink(doc *html.Node, base *url.URL) string {
+	var href string
+
+	var walk func(n *html.Node) bool
+	walk = func(n *html.Node) bool {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Link {
+			var rel, h string
+			for _, attr := range n.Attr {
+				switch attr.Key {
+				case "rel":
+					rel = attr.Val
+				case "href":
+					h = attr.Val
+				}
+			}
+			if strings.EqualFold(strings.TrimSpace(rel), "canonical") && h != "" {
+				href = h
+				return true
+			}
+		}
+		if n.DataAtom == atom.Body {
+			return false
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if walk(c) {
+				return true
+			}
+		}
+		return false
+	}
+	walk(doc)
+
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if href == "" || err != nil {
+		return ""
+	}
+	if base != nil {
+		ref = base.ResolveReference(ref)
+	}
+	if ref.Scheme != "http" && ref.Scheme != "https" {
+		return ""
+	}
+	if base != nil && !sameSite(ref, base) {
+		return ""
+	}
+
+	return normalizeURL(ref.String())
+}
+
+// sameSite reports whether a and b are on the same registrable domain,
+// e.g. www.example.com and example.com, or the same host for IP addresses
+// and hosts without one.
+func sameSite(a, b *url.URL) bool {
+	ha, hb := strings.ToLower(a.Hostname()), strings.ToLower(b.Hostname())
+	if ha == hb {
+		return true
+	}
+	if net.ParseIP(ha) != nil || net.ParseIP(hb) != nil {
+		return false
+	}
+
+	da, err := publicsuffix.EffectiveTLDPlusOne(ha)
+	if err != nil {
+		return false
+	}
+	db, err := publicsuffix.EffectiveTLDPlusOne(hb)
+
+	return err == nil && da == db
+}
+
+// canonicalTarget is the URL the page read at final is saved under, its
+// canonical when it's on the same site, else final.
+func canonicalTarget(canonical, final string) string {
+	c, err := url.Parse(canonical)
+	if canonical == "" || err != nil {
+		return final
+	}
+	f, err := url.Parse(final)
+	if err != nil || !sameSite(c, f) {
+		return final
+	}
+
+	return canonical
+}
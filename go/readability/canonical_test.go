@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestCanonicalLink(t *testing.T) {
+	tests := []struct {
+		base string
+		href string
+		want string
+	}{
+		{base: "https://example.com/a", href: "https://example.com/b", want: "https://example.com/b"},
+		{base: "https://www.example.com/a", href: "/b", want: "https://www.example.com/b"},
+		{base: "https://www.example.com/a", href: "https://example.com/b", want: "https://example.com/b"},
+		{base: "https://blog.example.co.uk/a", href: "https://example.co.uk/b", want: "https://example.co.uk/b"},
+		{base: "https://evil.com/a", href: "https://example.com/b", want: ""},
+		{base: "https://example.com.evil.com/a", href: "https://example.com/b", want: ""},
+		{base: "https://alice.github.io/a", href: "https://bob.github.io/b", want: ""},
+		{base: "http://10.0.0.1/a", href: "http://10.0.0.2/b", want: ""},
+		{base: "https://example.com/a", href: "javascript:alert(1)", want: ""},
+	}
+
+	for _, tt := range tests {
+		doc, err := html.Parse(strings.NewReader(`<html><head><link rel="canonical" href="` + tt.href + `"></head><body></body></html>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		base, _ := url.Parse(tt.base)
+		if got := canonicalLink(doc, base); got != tt.want {
+			t.Errorf("canonicalLink(%q) on %s = %q, want %q", tt.href, tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalTarget(t *testing.T) {
+	tests := []struct {
+		canonical string
+		final     string
+		want      string
+	}{
+		{canonical: "", final: "https://example.com/a", want: "https://example.com/a"},
+		{canonical: "https://example.com/b", final: "https://www.example.com/a", want: "https://example.com/b"},
+		{canonical: "https://victim.com/b", final: "https://evil.com/a", want: "https://evil.com/a"},
+	}
+
+	for _, tt := range tests {
+		if got := canonicalTarget(tt.canonical, tt.final); got != tt.want {
+			t.Errorf("canonicalTarget(%q, %q) = %q, want %q", tt.canonical, tt.final, got, tt.want)
+		}
+	}
+}
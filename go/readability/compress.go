@@ -0,0 +1,169 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressMinSize is the smallest response worth compressing, when its
+// length is known.
+const compressMinSize = 1024
+
+// compressTypes are the content types compressed, the others are either
+// compressed already, images, PDFs and EPUBs, or streamed.
+var compressTypes = map[string]bool{
+	"text/html":              true,
+	"text/css":               true,
+	"text/plain":             true,
+	"text/javascript":        true,
+	"text/markdown":          true,
+	"text/xml":               true,
+	"application/json":       true,
+	"application/javascript": true,
+	"application/xml":        true,
+	"application/rss+xml":    true,
+	"application/atom+xml":   true,
+	"image/svg+xml":          true,
+}
+
+var (
+	gzipWriters   = sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}
+	brotliWriters = sync.Pool{New: func() interface{} { return brotli.NewWriterLevel(io.Discard, 5) }}
+)
+
+// acceptsEncoding reports whether an Accept-Encoding header takes enc.
+func acceptsEncoding(header, enc string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), enc) {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// compressHTTP compresses the text responses of next with brotli or gzip,
+// whichever the client takes, brotli first.
+func compressHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		var enc string
+		switch accept := r.Header.Get("Accept-Encoding"); {
+		case r.Method == http.MethodHead:
+		case acceptsEncoding(accept, "br"):
+			enc = "br"
+		case acceptsEncoding(accept, "gzip"):
+			enc = "gzip"
+		}
+		if enc == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, enc: enc}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter decides whether to compress on the response's first
+// write, once its headers are known.
+type compressWriter struct {
+	http.ResponseWriter
+	enc     string
+	decided bool
+	w       io.WriteCloser
+}
+
+func (cw *compressWriter) decide(code int) {
+	if cw.decided {
+		return
+	}
+	cw.decided = true
+
+	h := cw.Header()
+	mediatype, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if !compressTypes[mediatype] || h.Get("Content-Encoding") != "" ||
+		code < http.StatusOK || code == http.StatusNoContent || code == http.StatusPartialContent || code == http.StatusNotModified {
+		return
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < compressMinSize {
+		return
+	}
+
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", cw.enc)
+	switch cw.enc {
+	case "br":
+		bw := brotliWriters.Get().(*brotli.Writer)
+		bw.Reset(cw.ResponseWriter)
+		cw.w = bw
+	case "gzip":
+		gw := gzipWriters.Get().(*gzip.Writer)
+		gw.Reset(cw.ResponseWriter)
+		cw.w = gw
+	}
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	cw.decide(code)
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.w == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+
+	return cw.w.Write(b)
+}
+
+func (cw *compressWriter) Flush() {
+	switch w := cw.w.(type) {
+	case *brotli.Writer:
+		w.Flush()
+	case *gzip.Writer:
+		w.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close ends the compressed stream, returning the writer to its pool.
+func (cw *compressWriter) Close() {
+	switch w := cw.w.(type) {
+	case *brotli.Writer:
+		w.Close()
+		brotliWriters.Put(w)
+	case *gzip.Writer:
+		w.Close()
+		gzipWriters.Put(w)
+	}
+	cw.w = nil
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// config is where the settings are read from, loaded before the package
+// variables holding them: -set flags first, then the environment, then
+// the configuration file, then their defaults.
+var config = loadConfig(os.Args[1:])
+
+// configSetting is a setting read by the app, for the unknown ones in the
+// file to be told apart and the config command to list them.
+type configSetting struct {
+	Name    string
+	Default string
+	Value   string
+	// Source is where Value comes from, default, file, env, set or flag.
+	Source string
+}
+
+type configuration struct {
+	// path is the configuration file, file its settings by name, and sets
+	// the -set ones.
+	path string
+	file map[string]string
+	sets map[string]string
+
+	settings map[string]*configSetting
+	errs     []string
+}
+
+// flagSettings are the settings the command line flags set.
+var flagSettings = map[string]string{
+	"storage":      "STORAGE",
+	"sqlite":       "SQLITE_PATH",
+	"tls-cert":     "TLS_CERT",
+	"tls-key":      "TLS_KEY",
+	"acme-domains": "ACME_DOMAINS",
+}
+
+// loadConfig reads the -config and -set flags of args, which the flag
+// package only parses once the settings are read, and the configuration
+// file, -config or CONFIG_FILE.
+func loadConfig(args []string) *configuration {
+	c := &configuration{
+		path:     os.Getenv("CONFIG_FILE"),
+		file:     make(map[string]string),
+		sets:     make(map[string]string),
+		settings: make(map[string]*configSetting),
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		// Every flag but -h takes a value.
+		if !hasValue && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		switch name {
+		case "config":
+			c.path = value
+		case "set":
+			key, v, ok := strings.Cut(value, "=")
+			if !ok {
+				c.errs = append(c.errs, fmt.Sprintf("-set %s: want KEY=value", value))
+				continue
+			}
+			c.sets[settingName(key)] = v
+		}
+	}
+
+	if c.path != "" {
+		if err := c.readFile(); err != nil {
+			c.errs = append(c.errs, fmt.Sprintf("config file %s: %v", c.path, err))
+		}
+	}
+
+	return c
+}
+
+// readFile reads the YAML, JSON or TOML configuration file. Its sections
+// are prefixes of the settings' names, redis: {url: ...} sets REDIS_URL,
+// and its lists are comma separated lists.
+func (c *configuration) readFile() error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(c.path)) {
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		// JSON is YAML too.
+		err = yaml.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return err
+	}
+
+	flattenConfig("", values, c.file)
+	return nil
+}
+
+func flattenConfig(prefix string, values map[string]interface{}, into map[string]string) {
+	for key, value := range values {
+		name := settingName(key)
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flattenConfig(name, v, into)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			into[name] = strings.Join(items, ",")
+		case nil:
+			into[name] = ""
+		default:
+			into[name] = fmt.Sprint(v)
+		}
+	}
+}
+
+// settingName is the name of the setting key, redis-url is REDIS_URL.
+func settingName(key string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(key), "-", "_"))
+}
+
+// lookup is the value of the setting key, "" when it isn't set, and
+// records it with its default def.
+func (c *configuration) lookup(key, def string) string {
+	s := &configSetting{Name: key, Default: def, Value: def, Source: "default"}
+	c.settings[key] = s
+
+	if v, ok := c.sets[key]; ok {
+		s.Value, s.Source = v, "set"
+	} else if v := os.Getenv(key); v != "" {
+		s.Value, s.Source = v, "env"
+	} else if v, ok := c.file[key]; ok && v != "" {
+		s.Value, s.Source = v, "file"
+	} else {
+		return ""
+	}
+
+	return s.Value
+}
+
+// invalid records that the setting key can't be value, which isn't what.
+func (c *configuration) invalid(key, value, what string) {
+	c.errs = append(c.errs, fmt.Sprintf("%s: %q is not %s", key, value, what))
+}
+
+// setFlags records the settings set by the command line flags.
+func (c *configuration) setFlags() {
+	flag.Visit(func(f *flag.Flag) {
+		if s, ok := c.settings[flagSettings[f.Name]]; ok {
+			s.Value, s.Source = f.Value.String(), "flag"
+		}
+	})
+}
+
+// configCheck is a check of settings that depend on each other or can
+// only take a few values, it returns what's wrong, "" when nothing is.
+type configCheck func() string
+
+var configChecks = []configCheck{
+	func() string {
+		return oneOf("STORAGE", STORAGE, "", "redis", "sqlite", "postgres", "memory")
+	},
+	func() string {
+		if STORAGE == "redis" && !redisConfigured() {
+			return "STORAGE=redis needs REDIS_URL or REDIS_ADDRS"
+		}
+		return ""
+	},
+	func() string {
+		if STORAGE == "postgres" && POSTGRES_URL == "" {
+			return "STORAGE=postgres needs POSTGRES_URL"
+		}
+		return ""
+	},
+	func() string {
+		if S3_BUCKET != "" && (S3_ACCESS_KEY_ID == "" || S3_SECRET_ACCESS_KEY == "") {
+			return "S3_BUCKET needs S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY"
+		}
+		return ""
+	},
+	func() string {
+		if S3_BUCKET != "" && S3_MIN_SIZE < 0 {
+			return "S3_MIN_SIZE must not be negative"
+		}
+		return ""
+	},
+	func() string {
+		if REDIS_MASTER_NAME != "" && len(REDIS_ADDRS) == 0 {
+			return "REDIS_MASTER_NAME needs REDIS_ADDRS, the addresses of the Sentinels"
+		}
+		return ""
+	},
+	func() string {
+		if (REDIS_TLS_CERT == "") != (REDIS_TLS_KEY == "") {
+			return "REDIS_TLS_CERT and REDIS_TLS_KEY go together, set both or neither"
+		}
+		return ""
+	},
+	func() string {
+		if (TLS_CERT == "") != (TLS_KEY == "") {
+			return "TLS_CERT and TLS_KEY go together, set both or neither"
+		}
+		return ""
+	},
+	func() string {
+		var port int
+		if _, err := fmt.Sscan(PORT, &port); PORT != "" && (err != nil || port < 1 || port > 65535) {
+			return fmt.Sprintf("PORT: %q is not a port number", PORT)
+		}
+		return ""
+	},
+	func() string {
+		return oneOf("LOG_FORMAT", strings.ToLower(LOG_FORMAT), "text", "json")
+	},
+	func() string {
+		return oneOf("LOG_LEVEL", strings.ToLower(LOG_LEVEL), "debug", "info", "warn", "error")
+	},
+	func() string {
+		return oneOf("SANITIZE_POLICY", SANITIZE_POLICY, "reader", "ugc", "off")
+	},
+	func() string {
+		return oneOf("EMBEDDINGS", EMBEDDINGS, "", "openai", "ollama", "hash")
+	},
+	func() string {
+		return oneOf("TRANSLATE", TRANSLATE, "", "deepl", "libretranslate")
+	},
+	func() string {
+		if TRANSLATE == "deepl" && TRANSLATE_API_KEY == "" {
+			return "TRANSLATE=deepl needs TRANSLATE_API_KEY"
+		}
+		return ""
+	},
+	func() string {
+		return oneOf("TTS", TTS, "", "openai", "command")
+	},
+	func() string {
+		if TTS == "command" && TTS_COMMAND == "" {
+			return "TTS=command needs TTS_COMMAND"
+		}
+		return ""
+	},
+	func() string {
+		if u, err := url.Parse(BASE_URL); BASE_URL != "" && (err != nil || u.Scheme == "" || u.Host == "") {
+			return fmt.Sprintf("BASE_URL: %q is not an absolute URL, e.g. https://read.example.com", BASE_URL)
+		}
+		return ""
+	},
+	func() string {
+		if IMPORT_WORKERS < 1 {
+			return "IMPORT_WORKERS must be at least 1"
+		}
+		return ""
+	},
+	func() string {
+		if CORS_CREDENTIALS && slices.Contains(CORS_ORIGINS, "*") {
+			return "CORS_CREDENTIALS=true can't go with CORS_ORIGINS=*, list the origins"
+		}
+		return ""
+	},
+}
+
+func oneOf(key, value string, allowed ...string) string {
+	for _, a := range allowed {
+		if value == a {
+			return ""
+		}
+	}
+
+	var names []string
+	for _, a := range allowed {
+		if a != "" {
+			names = append(names, a)
+		}
+	}
+	return fmt.Sprintf("%s: %q is not one of %s", key, value, strings.Join(names, ", "))
+}
+
+// validateConfig checks the settings once they are all read and the flags
+// parsed, and reports everything wrong with them at once: values that
+// don't parse, settings of the file and -set flags the app doesn't have,
+// and the configChecks.
+func validateConfig() error {
+	config.setFlags()
+
+	errs := config.errs
+	errs = append(errs, config.unknown(config.file, "in "+config.path)...)
+	errs = append(errs, config.unknown(config.sets, "in -set")...)
+	for _, check := range configChecks {
+		if msg := check(); msg != "" {
+			errs = append(errs, msg)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errors.New("invalid configuration:\n  " + strings.Join(errs, "\n  "))
+}
+
+func (c *configuration) unknown(values map[string]string, where string) []string {
+	var errs []string
+	for name := range values {
+		if _, ok := c.settings[name]; ok {
+			continue
+		}
+		msg := fmt.Sprintf("unknown setting %s %s", name, where)
+		if near := c.nearest(name); near != "" {
+			msg += ", did you mean " + near + "?"
+		}
+		errs = append(errs, msg)
+	}
+	sort.Strings(errs)
+
+	return errs
+}
+
+// nearest is the setting whose name is closest to name, "" when none is
+// close.
+func (c *configuration) nearest(name string) string {
+	best, bestDist := "", 3
+	for known := range c.settings {
+		if d := editDistance(name, known); d < bestDist || d == bestDist && known < best {
+			best, bestDist = known, d
+		}
+	}
+
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
+
+// configCommand prints the settings with where they come from, secrets
+// masked: readability [-config file] config [-all]. Without -all those
+// left to their defaults are left out.
+func configCommand(args []string) error {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	all := fs.Bool("all", false, "list the settings left to their defaults too")
+	fs.Parse(args)
+
+	names := make([]string, 0, len(config.settings))
+	for name := range config.settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		s := config.settings[name]
+		if s.Source == "default" && !*all {
+			continue
+		}
+		fmt.Printf("%s=%s\t# %s\n", name, maskSetting(name, s.Value), s.Source)
+	}
+
+	return nil
+}
+
+// maskSetting hides the value of secret settings, and the passwords of
+// URLs.
+func maskSetting(name, value string) string {
+	if value == "" {
+		return ""
+	}
+	for _, suffix := range []string{"_SECRET", "_PASSWORD", "_TOKEN", "_KEY"} {
+		if strings.HasSuffix(name, suffix) {
+			return "********"
+		}
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+
+	return value
+}
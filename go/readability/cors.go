@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// CORS_ORIGINS are the origins, e.g. https://reader.example.com, whose
+	// pages may call the API without a token, for a frontend hosted
+	// elsewhere. Requests with a token may come from any origin anyway.
+	CORS_ORIGINS = splitList(envOr("CORS_ORIGINS", ""))
+	// CORS_METHODS and CORS_HEADERS are what the preflights allow, and
+	// CORS_EXPOSE_HEADERS the response headers the pages may read.
+	CORS_METHODS        = envOr("CORS_METHODS", "GET, POST, DELETE")
+	CORS_HEADERS        = envOr("CORS_HEADERS", "Authorization, Content-Type")
+	CORS_EXPOSE_HEADERS = envOr("CORS_EXPOSE_HEADERS", "Location, Retry-After, ETag")
+	// CORS_CREDENTIALS=true lets the CORS_ORIGINS send the session cookie.
+	CORS_CREDENTIALS = envOr("CORS_CREDENTIALS", "") == "true"
+	// CORS_MAX_AGE is how long browsers keep a preflight's answer.
+	CORS_MAX_AGE = envDuration("CORS_MAX_AGE", 24*time.Hour)
+)
+
+// corsOrigin is the Access-Control-Allow-Origin of a request from origin,
+// "" when it gets none: listed origins are echoed, the others get "*"
+// when the request has a token, which a page can't get from the browser,
+// or when "*" is listed. "*" is never sent credentials.
+func corsOrigin(origin string, withToken bool) string {
+	if origin == "" {
+		return ""
+	}
+	for _, allowed := range CORS_ORIGINS {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	if withToken {
+		return "*"
+	}
+
+	return ""
+}
+
+// setCORS sets the CORS headers of the response to r, when it gets any.
+func setCORS(w http.ResponseWriter, r *http.Request, withToken bool) {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+
+	origin := corsOrigin(r.Header.Get("Origin"), withToken)
+	if origin == "" {
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if origin != "*" && CORS_CREDENTIALS {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if CORS_EXPOSE_HEADERS != "" {
+		h.Set("Access-Control-Expose-Headers", CORS_EXPOSE_HEADERS)
+	}
+}
+
+// corsPreflight answers the preflight r, which can't tell whether the
+// request will have a token: as if it does, unless from a listed origin.
+func corsPreflight(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	if origin := corsOrigin(r.Header.Get("Origin"), true); origin != "" {
+		h.Set("Access-Control-Allow-Origin", origin)
+		if origin != "*" && CORS_CREDENTIALS {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+	h.Set("Access-Control-Allow-Methods", CORS_METHODS)
+	h.Set("Access-Control-Allow-Headers", CORS_HEADERS)
+	h.Set("Access-Control-Max-Age", strconv.Itoa(int(CORS_MAX_AGE.Seconds())))
+	w.WriteHeader(http.StatusNoContent)
+}
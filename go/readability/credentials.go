@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CREDENTIALS_KEY encrypts the cookies and Authorization headers stored
+// for subscription sites, they can't be stored without it.
+var CREDENTIALS_KEY = envOr("CREDENTIALS_KEY", "")
+
+// credential is what is sent to a domain to fetch it logged in.
+type credential struct {
+	Cookie        string `json:"cookie,omitempty"`
+	Authorization string `json:"authorization,omitempty"`
+}
+
+var (
+	credsMu sync.RWMutex
+	creds   = make(map[string]credential)
+)
+
+func credentialsAEAD() (cipher.AEAD, error) {
+	if CREDENTIALS_KEY == "" {
+		return nil, errors.New("CREDENTIALS_KEY is not set")
+	}
+
+	key := sha256.Sum256([]byte(CREDENTIALS_KEY))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// sealCredential encrypts c with AES-GCM, bound to its domain so a sealed
+// credential can't be moved to another one.
+func sealCredential(domain string, c credential) ([]byte, error) {
+	aead, err := credentialsAEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plain, []byte(domain)), nil
+}
+
+func openCredential(domain string, sealed []byte) (credential, error) {
+	var c credential
+
+	aead, err := credentialsAEAD()
+	if err != nil {
+		return c, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return c, errors.New("sealed credential is too short")
+	}
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(domain))
+	if err != nil {
+		return c, err
+	}
+
+	return c, json.Unmarshal(plain, &c)
+}
+
+// loadCredentials decrypts the stored credentials into memory, where the
+// fetcher reads them from.
+func loadCredentials() {
+	if CREDENTIALS_KEY == "" {
+		return
+	}
+
+	sealed, err := store.Credentials()
+	if err != nil {
+		slog.Error("failed to load credentials", "err", err)
+		return
+	}
+
+	credsMu.Lock()
+	defer credsMu.Unlock()
+
+	for domain, data := range sealed {
+		c, err := openCredential(domain, data)
+		if err != nil {
+			slog.Error("failed to decrypt credentials", "domain", domain, "err", err)
+			continue
+		}
+		creds[domain] = c
+	}
+}
+
+// credentialFor returns the credential of the most specific stored domain
+// host is, or is a subdomain of. Credentials only go over HTTPS.
+func credentialFor(u *url.URL) (credential, bool) {
+	if u.Scheme != "https" {
+		return credential{}, false
+	}
+
+	credsMu.RLock()
+	defer credsMu.RUnlock()
+
+	labels := strings.Split(strings.ToLower(u.Hostname()), ".")
+	for i := 0; i < len(labels)-1; i++ {
+		if c, ok := creds[strings.Join(labels[i:], ".")]; ok {
+			return c, true
+		}
+	}
+
+	return credential{}, false
+}
+
+func credentialDomains() []string {
+	credsMu.RLock()
+	defer credsMu.RUnlock()
+
+	domains := make([]string, 0, len(creds))
+	for domain := range creds {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	return domains
+}
+
+// credentialDomain cleans up a domain typed into the form, which may be a
+// URL.
+func credentialDomain(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if u, err := url.Parse(s); err == nil && u.Host != "" {
+		s = u.Hostname()
+	}
+
+	return strings.Trim(s, ".")
+}
+
+// credentialsHandler lists the domains with credentials, never the
+// credentials themselves, and stores or removes them. They are the
+// instance's, only its admins may.
+func credentialsHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAllowed(w, r) {
+		return
+	}
+
+	var errMsg string
+
+	if r.Method == http.MethodPost {
+		domain := credentialDomain(r.FormValue("domain"))
+		switch {
+		case domain == "" || !strings.Contains(domain, "."):
+			errMsg = "enter a domain like example.com"
+		case r.FormValue("delete") != "":
+			if err := store.DeleteCredential(domain); err != nil {
+				errMsg = err.Error()
+				break
+			}
+			credsMu.Lock()
+			delete(creds, domain)
+			credsMu.Unlock()
+		default:
+			c := credential{
+				Cookie:        strings.TrimSpace(r.FormValue("cookie")),
+				Authorization: strings.TrimSpace(r.FormValue("authorization")),
+			}
+			if c.Cookie == "" && c.Authorization == "" {
+				errMsg = "enter a cookie or an Authorization header"
+				break
+			}
+			sealed, err := sealCredential(domain, c)
+			if err == nil {
+				err = store.SetCredential(domain, sealed)
+			}
+			if err != nil {
+				errMsg = err.Error()
+				break
+			}
+			credsMu.Lock()
+			creds[domain] = c
+			credsMu.Unlock()
+		}
+
+		if errMsg == "" {
+			http.Redirect(w, r, "/credentials", http.StatusSeeOther)
+			return
+		}
+	}
+
+	executePage(w, r, "credentials.html", map[string]interface{}{
+		"Domains": credentialDomains(),
+		"Enabled": CREDENTIALS_KEY != "",
+		"Error":   errMsg,
+	})
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+var (
+	// DIGEST_EMAIL gets a summary of the articles saved every day, or every
+	// week with DIGEST_SCHEDULE=weekly, comma separated. It is sent at
+	// DIGEST_HOUR, on DIGEST_WEEKDAY for weekly ones, in local time.
+	DIGEST_EMAIL    = splitList(envOr("DIGEST_EMAIL", ""))
+	DIGEST_SCHEDULE = envOr("DIGEST_SCHEDULE", "daily")
+	DIGEST_HOUR     = envInt("DIGEST_HOUR", 8)
+	DIGEST_WEEKDAY  = envOr("DIGEST_WEEKDAY", "monday")
+)
+
+// digestMaxArticles caps the articles looked at and listed in a digest.
+const digestMaxArticles = 200
+
+type digestEntry struct {
+	Title       string
+	URL         string
+	Link        string
+	ReadingTime int
+	Excerpt     string
+}
+
+func digestPeriod() time.Duration {
+	if DIGEST_SCHEDULE == "weekly" {
+		return 7 * 24 * time.Hour
+	}
+
+	return 24 * time.Hour
+}
+
+// nextDigest returns when the digest after now is due.
+func nextDigest(now time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), DIGEST_HOUR, 0, 0, 0, now.Location())
+
+	if DIGEST_SCHEDULE == "weekly" {
+		for i := 0; i < 7 && !strings.EqualFold(next.Weekday().String(), DIGEST_WEEKDAY); i++ {
+			next = next.AddDate(0, 0, 1)
+		}
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 7)
+		}
+		return next
+	}
+
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+
+	return next
+}
+
+func digestScheduler() {
+	if len(DIGEST_EMAIL) == 0 {
+		return
+	}
+	if err := smtpConfigured(); err != nil {
+		slog.Warn("not sending digests", "err", err)
+		return
+	}
+	if BASE_URL == "" {
+		slog.Warn("BASE_URL is not set, digests will link to the articles' pages instead of the saved copies")
+	}
+
+	for {
+		next := nextDigest(time.Now())
+		time.Sleep(time.Until(next))
+
+		if err := sendDigest(next.Add(-digestPeriod()), next); err != nil {
+			slog.Error("failed to send digest", "err", err)
+		}
+	}
+}
+
+// digestEntries lists the articles saved from since until until, oldest
+// first.
+func digestEntries(since, until time.Time) ([]digestEntry, error) {
+	keys, err := instanceLib.getLastNArticles(digestMaxArticles)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []digestEntry
+	for _, key := range keys {
+		art, err := instanceLib.store.GetArticle(key)
+		if err != nil || art == nil || art.CreatedAt.Before(since) || !art.CreatedAt.Before(until) {
+			continue
+		}
+
+		link := art.URL
+		if BASE_URL != "" {
+			link = permalink(BASE_URL, art)
+		}
+		text := art.Excerpt
+		if text == "" {
+			text = excerpt(htmlText(art.Content), 200)
+		}
+
+		entries = append([]digestEntry{{
+			Title:       art.Title,
+			URL:         art.URL,
+			Link:        link,
+			ReadingTime: art.ReadingTime,
+			Excerpt:     text,
+		}}, entries...)
+	}
+
+	return entries, nil
+}
+
+func sendDigest(since, until time.Time) error {
+	entries, err := digestEntries(since, until)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		slog.Info("no articles saved, skipping the digest", "since", since)
+		return nil
+	}
+
+	msg, err := digestMail(entries, since)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("sending a digest", "articles", len(entries))
+
+	return sendMail(DIGEST_EMAIL, msg)
+}
+
+func digestSubject(n int, since time.Time) string {
+	return fmt.Sprintf("Readability: %d article%s saved since %s", n, plural(n), since.Format("Jan 2"))
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+
+	return "s"
+}
+
+// digestMail is the digest as a text and HTML multipart/alternative mail.
+func digestMail(entries []digestEntry, since time.Time) ([]byte, error) {
+	subject := digestSubject(len(entries), since)
+
+	var text strings.Builder
+	for _, e := range entries {
+		title := e.Title
+		if title == "" {
+			title = e.URL
+		}
+		fmt.Fprintf(&text, "%s\n%s\n", title, e.Link)
+		if e.ReadingTime > 0 {
+			fmt.Fprintf(&text, "%d min read\n", e.ReadingTime)
+		}
+		if e.Excerpt != "" {
+			fmt.Fprintf(&text, "%s\n", e.Excerpt)
+		}
+		text.WriteString("\n")
+	}
+
+	var htmlBody bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&htmlBody, "digest.html", map[string]interface{}{
+		"Subject":  subject,
+		"Articles": entries,
+	}); err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		data        []byte
+	}{
+		{"text/plain; charset=utf-8", []byte(text.String())},
+		{"text/html; charset=utf-8", htmlBody.Bytes()},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		w.Write(part.data)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	header := []string{
+		"From: " + SMTP_FROM,
+		"To: " + strings.Join(DIGEST_EMAIL, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + mw.Boundary(),
+	}
+	msg.WriteString(strings.Join(header, "\r\n") + "\r\n\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
+
+// digestHandler previews the digest of the current period.
+func digestHandler(w http.ResponseWriter, r *http.Request) {
+	until := time.Now()
+	since := until.Add(-digestPeriod())
+
+	entries, err := digestEntries(since, until)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = tmpl.ExecuteTemplate(w, "digest.html", map[string]interface{}{
+		"Subject":  digestSubject(len(entries), since),
+		"Articles": entries,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
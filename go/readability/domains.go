@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ALLOWED_DOMAINS, when set, are the only domains articles are fetched
+	// from, comma separated. A domain covers its subdomains.
+	ALLOWED_DOMAINS = parseDomains(envOr("ALLOWED_DOMAINS", ""))
+	// BLOCKED_DOMAINS are never fetched, even when allowed.
+	BLOCKED_DOMAINS = parseDomains(envOr("BLOCKED_DOMAINS", ""))
+)
+
+func parseDomains(s string) map[string]bool {
+	domains := make(map[string]bool)
+	for _, d := range strings.Split(s, ",") {
+		d = strings.ToLower(strings.Trim(strings.TrimSpace(d), "."))
+		if d != "" {
+			domains[d] = true
+		}
+	}
+
+	return domains
+}
+
+// inDomains reports whether host is one of domains or a subdomain of one.
+func inDomains(host string, domains map[string]bool) bool {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(host, ".")), ".")
+	for i := range labels {
+		if domains[strings.Join(labels[i:], ".")] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkDomain returns an error saying why uri may not be fetched, nil when
+// it may.
+func checkDomain(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+
+	host := u.Hostname()
+	if inDomains(host, BLOCKED_DOMAINS) {
+		return fmt.Errorf("%s is blocked on this instance, its articles can't be read here", host)
+	}
+	if len(ALLOWED_DOMAINS) != 0 && !inDomains(host, ALLOWED_DOMAINS) {
+		return fmt.Errorf("%s is not one of the sites this instance reads articles from", host)
+	}
+
+	return nil
+}
+
+// checkRedirect stops redirects to a domain that may not be fetched, unless
+// they stay on the host the request started at.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Hostname() == via[0].URL.Hostname() {
+		return nil
+	}
+
+	return checkDomain(req.URL.String())
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// MAILGUN_SIGNING_KEY takes mail forwarded by a Mailgun route on
+	// /integrations/email, checking its signature. EMAIL_INBOUND_TOKEN
+	// takes it from other services posting the same fields with
+	// ?token={EMAIL_INBOUND_TOKEN}.
+	MAILGUN_SIGNING_KEY = envOr("MAILGUN_SIGNING_KEY", "")
+	EMAIL_INBOUND_TOKEN = envOr("EMAIL_INBOUND_TOKEN", "")
+	// EMAIL_SENDERS are the addresses mail is taken from, comma separated,
+	// mail from anyone is read when it is empty.
+	EMAIL_SENDERS = splitList(strings.ToLower(envOr("EMAIL_SENDERS", "")))
+)
+
+// emailMaxURLs caps the articles queued from one message.
+const emailMaxURLs = 20
+
+func verifyMailgun(r *http.Request) bool {
+	ts, err := strconv.ParseInt(r.FormValue("timestamp"), 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > slackMaxAge || age < -slackMaxAge {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(MAILGUN_SIGNING_KEY))
+	mac.Write([]byte(r.FormValue("timestamp") + r.FormValue("token")))
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(r.FormValue("signature")))
+}
+
+func emailSenderAllowed(from string) bool {
+	if len(EMAIL_SENDERS) == 0 {
+		return true
+	}
+
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range EMAIL_SENDERS {
+		if strings.ToLower(addr.Address) == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// emailURLs collects the links of a message, from its text and the hrefs
+// of its HTML part.
+func emailURLs(text, htmlBody string) []string {
+	urls := messageURL.FindAllString(text, -1)
+	if htmlBody != "" {
+		if links, err := htmlLinks(strings.NewReader(htmlBody)); err == nil {
+			urls = append(urls, links...)
+		}
+	}
+
+	for i, uri := range urls {
+		urls[i] = strings.TrimRight(uri, ".,;:!?)>\"'")
+	}
+
+	urls = uniqueURLs(urls)
+	if len(urls) > emailMaxURLs {
+		urls = urls[:emailMaxURLs]
+	}
+
+	return urls
+}
+
+// emailHandler takes an inbound message as Mailgun posts it, sender,
+// subject, body-plain and body-html form fields, and queues a job for
+// every link in it.
+func emailHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil && err != http.ErrNotMultipart {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case MAILGUN_SIGNING_KEY != "" && r.FormValue("signature") != "":
+		if !verifyMailgun(r) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+	case EMAIL_INBOUND_TOKEN != "":
+		if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(EMAIL_INBOUND_TOKEN)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	from := r.FormValue("sender")
+	if from == "" {
+		from = r.FormValue("from")
+	}
+	if !emailSenderAllowed(from) {
+		slog.Warn("ignoring mail", "from", from)
+		// Accepted all the same, a refusal only gets it sent again.
+		writeJSON(w, http.StatusOK, map[string]interface{}{"queued": []jobStatus{}})
+		return
+	}
+
+	urls := emailURLs(r.FormValue("subject")+"\n"+r.FormValue("body-plain"), r.FormValue("body-html"))
+
+	queued := make([]jobStatus, 0, len(urls))
+	for _, uri := range urls {
+		uri = normalizeURL(uri)
+		if err := checkURL(uri); err != nil {
+			continue
+		}
+		queued = append(queued, startExtractJob(uri, readOptions{}).Status())
+	}
+	slog.Info("queued mailed articles", "articles", len(queued), "from", from)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"queued": queued})
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// EMBEDDINGS turns on the embeddings of the articles, for "more like
+	// this" and semantic search, computed by openai, OpenAI's API or one
+	// compatible with it, ollama, a local model served by Ollama, or hash,
+	// hashed words computed in process, which needs nothing else but only
+	// finds articles sharing words.
+	EMBEDDINGS = envOr("EMBEDDINGS", "")
+	// EMBEDDINGS_URL and EMBEDDINGS_MODEL default to the provider's, the
+	// API is called with EMBEDDINGS_API_KEY.
+	EMBEDDINGS_URL     = envOr("EMBEDDINGS_URL", "")
+	EMBEDDINGS_MODEL   = envOr("EMBEDDINGS_MODEL", "")
+	EMBEDDINGS_API_KEY = envOr("EMBEDDINGS_API_KEY", "")
+
+	embeddingsClient = &http.Client{Timeout: 60 * time.Second}
+
+	embedder = newEmbedder()
+)
+
+const (
+	// embeddingInput is how much of an article is embedded, in runes, the
+	// models take a few thousand tokens.
+	embeddingInput = 6000
+	// hashDimensions is the size of the hash embeddings.
+	hashDimensions = 512
+)
+
+var errEmbeddingsOff = errors.New("embeddings are off, see EMBEDDINGS")
+
+// embeddingProvider computes the embeddings of texts.
+type embeddingProvider interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+	// Model names what the embeddings are computed with, those of another
+	// model aren't compared with them.
+	Model() string
+}
+
+func newEmbedder() embeddingProvider {
+	switch EMBEDDINGS {
+	case "openai":
+		return &openAIEmbeddings{
+			url:   strings.TrimSuffix(envDefault(EMBEDDINGS_URL, "https://api.openai.com/v1"), "/"),
+			model: envDefault(EMBEDDINGS_MODEL, "text-embedding-3-small"),
+		}
+	case "ollama":
+		return &ollamaEmbeddings{
+			url:   strings.TrimSuffix(envDefault(EMBEDDINGS_URL, "http://localhost:11434"), "/"),
+			model: envDefault(EMBEDDINGS_MODEL, "nomic-embed-text"),
+		}
+	case "hash":
+		return hashEmbeddings{}
+	}
+
+	return nil
+}
+
+// envDefault is v, def when it's empty.
+func envDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+
+	return v
+}
+
+type openAIEmbeddings struct {
+	url, model string
+}
+
+func (e *openAIEmbeddings) Model() string {
+	return "openai:" + e.model
+}
+
+func (e *openAIEmbeddings) Embed(ctx context.Context, text string) ([]float32, error) {
+	var resp struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	err := embeddingsCall(ctx, e.url+"/embeddings", map[string]string{"model": e.model, "input": text}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, errors.New("embeddings: no embedding in the response")
+	}
+
+	return resp.Data[0].Embedding, nil
+}
+
+type ollamaEmbeddings struct {
+	url, model string
+}
+
+func (e *ollamaEmbeddings) Model() string {
+	return "ollama:" + e.model
+}
+
+func (e *ollamaEmbeddings) Embed(ctx context.Context, text string) ([]float32, error) {
+	var resp struct {
+		Embedding []float32 `json:"embedding"`
+	}
+	err := embeddingsCall(ctx, e.url+"/api/embeddings", map[string]string{"model": e.model, "prompt": text}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Embedding) == 0 {
+		return nil, errors.New("embeddings: no embedding in the response")
+	}
+
+	return resp.Embedding, nil
+}
+
+// embeddingsCall posts body as JSON to url, decoding the answer into out.
+func embeddingsCall(ctx context.Context, url string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if EMBEDDINGS_API_KEY != "" {
+		req.Header.Set("Authorization", "Bearer "+EMBEDDINGS_API_KEY)
+	}
+
+	resp, err := embeddingsClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error json.RawMessage `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		if msg := errorMessage(e.Error); msg != "" {
+			return fmt.Errorf("embeddings: %s: %s", resp.Status, msg)
+		}
+		return fmt.Errorf("embeddings: %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// hashEmbeddings are the words of a text hashed into hashDimensions, each
+// counted once and signed by another bit of its hash, so that texts
+// sharing words point the same way.
+type hashEmbeddings struct{}
+
+func (hashEmbeddings) Model() string {
+	return fmt.Sprintf("hash:%d", hashDimensions)
+}
+
+func (hashEmbeddings) Embed(_ context.Context, text string) ([]float32, error) {
+	counts := make(map[string]int)
+	for _, term := range tokenize(text) {
+		if len(term) >= 3 {
+			counts[term]++
+		}
+	}
+
+	vec := make([]float32, hashDimensions)
+	for term, n := range counts {
+		h := fnv.New64a()
+		h.Write([]byte(term))
+		sum := h.Sum64()
+		weight := float32(1 + math.Log(float64(n)))
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		vec[sum%hashDimensions] += weight
+	}
+
+	return vec, nil
+}
+
+// embeddingText is what an article is embedded by, its title and the
+// beginning of its text.
+func embeddingText(art *article) string {
+	return truncateRunes(art.Title+"\n\n"+htmlText(art.Content), embeddingInput)
+}
+
+// normalize scales vec to a length of 1, for the cosine similarity of two
+// vectors to be their dot product.
+func normalize(vec []float32) []float32 {
+	var sum float64
+	for _, v := range vec {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		return vec
+	}
+
+	norm := float32(math.Sqrt(sum))
+	for i := range vec {
+		vec[i] /= norm
+	}
+	return vec
+}
+
+// embedArticle computes the embedding of the article stored under key,
+// stores it with it and indexes it.
+func (lib *library) embedArticle(ctx context.Context, key string, art *article) error {
+	vec, err := embedder.Embed(ctx, embeddingText(art))
+	if err != nil {
+		return err
+	}
+	vec = normalize(vec)
+	model := embedder.Model()
+
+	err = lib.store.UpdateArticle(key, func(art *article) {
+		art.Embedding = vec
+		art.EmbeddingModel = model
+	})
+	if err != nil {
+		return err
+	}
+	lib.index.SetVector(key, vec)
+
+	return nil
+}
+
+// embedMissing computes the embeddings of the articles saved without one,
+// or with one of another model, one after the other.
+func (lib *library) embedMissing() {
+	keys, err := lib.store.Keys()
+	if err != nil {
+		slog.Error("failed to list articles to embed", "err", err)
+		return
+	}
+
+	n := 0
+	for _, key := range keys {
+		if lib.index.HasVector(key) {
+			continue
+		}
+		art, err := lib.store.GetArticle(key)
+		if err != nil || art == nil || art.Content == "" {
+			continue
+		}
+		if err := lib.embedArticle(context.Background(), key, art); err != nil {
+			slog.Error("failed to embed article", "key", key, "err", err)
+			continue
+		}
+		n++
+	}
+	if n > 0 {
+		slog.Info("embedded articles", "articles", n, "model", embedder.Model())
+	}
+}
+
+// SetVector indexes the embedding of the article stored under key.
+func (idx *searchIndex) SetVector(key string, vec []float32) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if _, ok := idx.docs[key]; ok {
+		idx.vectors[key] = vec
+	}
+}
+
+// HasVector reports whether the embedding of the article stored under key
+// is indexed.
+func (idx *searchIndex) HasVector(key string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	_, ok := idx.vectors[key]
+	return ok
+}
+
+// Vector is the indexed embedding of the article stored under key.
+func (idx *searchIndex) Vector(key string) []float32 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return idx.vectors[key]
+}
+
+// Nearest returns up to n articles whose embeddings are the most similar
+// to vec, but the one stored under skip and, when public is set, the
+// private ones.
+func (idx *searchIndex) Nearest(vec []float32, n int, skip string, public bool) []searchResult {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	results := make([]searchResult, 0, len(idx.vectors))
+	for key, v := range idx.vectors {
+		if key == skip || len(v) != len(vec) {
+			continue
+		}
+		var dot float64
+		for i := range v {
+			dot += float64(v[i]) * float64(vec[i])
+		}
+		res := idx.docs[key]
+		if public && res.private {
+			continue
+		}
+		res.Similarity = math.Round(dot*1000) / 1000
+		results = append(results, res)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Similarity != results[j].Similarity {
+			return results[i].Similarity > results[j].Similarity
+		}
+		return results[i].URL < results[j].URL
+	})
+	if len(results) > n {
+		results = results[:n]
+	}
+
+	return results
+}
+
+// similarArticles returns up to n articles like the one at uri.
+func (lib *library) similarArticles(uri string, n int) ([]searchResult, error) {
+	if embedder == nil {
+		return nil, errEmbeddingsOff
+	}
+
+	key := lib.resolveKey(uri)
+	vec := lib.index.Vector(key)
+	if vec == nil {
+		return nil, errArticleNotFound
+	}
+
+	return lib.index.Nearest(vec, n, key, lib.public), nil
+}
+
+// semanticSearch returns up to n articles closest in meaning to q.
+func (lib *library) semanticSearch(ctx context.Context, q string, n int) ([]searchResult, error) {
+	if embedder == nil {
+		return nil, errEmbeddingsOff
+	}
+
+	vec, err := embedder.Embed(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return lib.index.Nearest(normalize(vec), n, "", lib.public), nil
+}
+
+// similarHandler shows the articles like the one at ?url=.
+func similarHandler(w http.ResponseWriter, r *http.Request) {
+	uri := r.URL.Query().Get("url")
+	results, err := libraryFor(r).similarArticles(uri, 20)
+	switch {
+	case errors.Is(err, errEmbeddingsOff):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	case err != nil:
+		http.Error(w, "the article isn't saved, or its embedding isn't computed yet", http.StatusNotFound)
+		return
+	}
+
+	executePage(w, r, "search.html", map[string]interface{}{
+		"Similar": uri,
+		"Results": results,
+	})
+}
+
+type similarResponse struct {
+	URL     string         `json:"url"`
+	Results []searchResult `json:"results"`
+}
+
+var apiSimilarOp = apiOperation{
+	Summary:     "Find articles like another",
+	Description: "The saved articles whose embeddings are the closest to the article's, with their cosine similarity. Needs EMBEDDINGS.",
+	Tag:         "library",
+	Query:       []apiParam{{Name: "url", Description: "The article's URL.", Required: true}},
+	Response:    similarResponse{},
+	Errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotImplemented},
+}
+
+func apiSimilarHandler(w http.ResponseWriter, r *http.Request) {
+	uri := r.URL.Query().Get("url")
+	if uri == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing url"})
+		return
+	}
+
+	results, err := libraryFor(r).similarArticles(uri, 20)
+	switch {
+	case errors.Is(err, errEmbeddingsOff):
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		return
+	case err != nil:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "the article isn't saved, or its embedding isn't computed yet"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, similarResponse{URL: uri, Results: results})
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	epubMaxImages    = 100
+	epubMaxImageSize = 5 << 20
+)
+
+var epubImageTypes = map[string]string{
+	"image/jpeg":    ".jpg",
+	"image/png":     ".png",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/svg+xml": ".svg",
+}
+
+var epubClient = &http.Client{Timeout: 15 * time.Second, Transport: proxyTransport}
+
+// epubBook is a minimal EPUB 3 writer: a title page, one chapter per article
+// and the images those articles reference.
+type epubBook struct {
+	ID       string
+	Title    string
+	Chapters []epubChapter
+	Images   []epubImage
+
+	images map[string]string
+}
+
+type epubChapter struct {
+	Title string
+	URL   string
+	Body  string
+	File  string
+}
+
+type epubImage struct {
+	File      string
+	MediaType string
+	Data      []byte
+}
+
+func newEpubBook(title string) *epubBook {
+	var id [16]byte
+	rand.Read(id[:])
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	return &epubBook{
+		ID:     fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]),
+		Title:  title,
+		images: make(map[string]string),
+	}
+}
+
+// AddArticle converts the article content to XHTML, downloading the images it
+// references so the book can be read offline.
+func (b *epubBook) AddArticle(art *article) error {
+	nodes, err := html.ParseFragment(strings.NewReader(art.Content), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	base, _ := url.Parse(art.URL)
+
+	var body bytes.Buffer
+	for _, n := range nodes {
+		b.embedImages(n, base)
+		writeXHTML(&body, n)
+	}
+	if art.Notes != "" {
+		fmt.Fprintf(&body, "<h2>Notes</h2><p>%s</p>", strings.ReplaceAll(html.EscapeString(art.Notes), "\n", "<br/>"))
+	}
+	if len(art.Highlights) > 0 {
+		body.WriteString("<h2>Highlights</h2>")
+		for _, h := range art.Highlights {
+			fmt.Fprintf(&body, "<blockquote><p>%s</p></blockquote>", html.EscapeString(h.Text))
+			if h.Note != "" {
+				fmt.Fprintf(&body, "<p><em>%s</em></p>", html.EscapeString(h.Note))
+			}
+		}
+	}
+
+	b.Chapters = append(b.Chapters, epubChapter{
+		Title: art.Title,
+		URL:   art.URL,
+		Body:  body.String(),
+		File:  fmt.Sprintf("chapter%d.xhtml", len(b.Chapters)+1),
+	})
+
+	return nil
+}
+
+func (b *epubBook) embedImages(n *html.Node, base *url.URL) {
+	if n.Type == html.ElementNode && n.DataAtom == atom.Img {
+		for i, attr := range n.Attr {
+			if attr.Key != "src" {
+				continue
+			}
+
+			if file := b.fetchImage(attr.Val, base); file != "" {
+				n.Attr[i].Val = file
+			} else {
+				n.Attr[i].Val = ""
+			}
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.embedImages(c, base)
+	}
+}
+
+func (b *epubBook) fetchImage(src string, base *url.URL) string {
+	ref, err := url.Parse(src)
+	if err != nil || src == "" {
+		return ""
+	}
+	if base != nil {
+		ref = base.ResolveReference(ref)
+	}
+
+	abs := ref.String()
+	if file, ok := b.images[abs]; ok {
+		return file
+	}
+	if len(b.Images) >= epubMaxImages || (ref.Scheme != "http" && ref.Scheme != "https") {
+		return ""
+	}
+
+	resp, err := epubClient.Get(abs)
+	if err != nil {
+		slog.Error("failed to fetch epub image", "url", abs, "err", err)
+		return ""
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, epubMaxImageSize+1))
+	if err != nil || resp.StatusCode != http.StatusOK || len(data) > epubMaxImageSize {
+		return ""
+	}
+
+	mediaType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	if _, ok := epubImageTypes[mediaType]; !ok {
+		mediaType = http.DetectContentType(data)
+	}
+	ext, ok := epubImageTypes[mediaType]
+	if !ok {
+		return ""
+	}
+
+	file := fmt.Sprintf("images/image%d%s", len(b.Images)+1, ext)
+	b.Images = append(b.Images, epubImage{File: file, MediaType: mediaType, Data: data})
+	b.images[abs] = file
+
+	return file
+}
+
+func (b *epubBook) Write(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	// The mimetype entry must come first and be stored uncompressed.
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	io.WriteString(mw, "application/epub+zip")
+
+	files := []struct {
+		name string
+		tmpl *template.Template
+		data interface{}
+	}{
+		{"META-INF/container.xml", epubContainerTmpl, nil},
+		{"OEBPS/content.opf", epubPackageTmpl, b},
+		{"OEBPS/nav.xhtml", epubNavTmpl, b},
+		{"OEBPS/title.xhtml", epubTitleTmpl, b},
+	}
+	for _, ch := range b.Chapters {
+		files = append(files, struct {
+			name string
+			tmpl *template.Template
+			data interface{}
+		}{"OEBPS/" + ch.File, epubChapterTmpl, ch})
+	}
+
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		// html/template would escape the declaration, write it ourselves.
+		io.WriteString(fw, xml.Header)
+		if err := f.tmpl.Execute(fw, f.data); err != nil {
+			return err
+		}
+	}
+
+	for _, img := range b.Images {
+		fw, err := zw.Create("OEBPS/" + img.File)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(img.Data); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+var epubVoidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true,
+	"img": true, "input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+var epubDroppedElements = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"form": true, "input": true, "button": true, "noscript": true,
+}
+
+// writeXHTML serializes n as well-formed XHTML, which EPUB readers require
+// and the html package does not produce.
+func writeXHTML(buf *bytes.Buffer, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		buf.WriteString(html.EscapeString(n.Data))
+	case html.ElementNode:
+		if epubDroppedElements[n.Data] {
+			return
+		}
+
+		buf.WriteString("<" + n.Data)
+		for _, attr := range n.Attr {
+			if attr.Namespace != "" || !validXMLName(attr.Key) || strings.HasPrefix(attr.Key, "on") {
+				continue
+			}
+			fmt.Fprintf(buf, ` %s="%s"`, attr.Key, html.EscapeString(attr.Val))
+		}
+
+		if epubVoidElements[n.Data] {
+			buf.WriteString("/>")
+			return
+		}
+		buf.WriteString(">")
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			writeXHTML(buf, c)
+		}
+		buf.WriteString("</" + n.Data + ">")
+	case html.DocumentNode:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			writeXHTML(buf, c)
+		}
+	}
+}
+
+func validXMLName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+		case i > 0 && (r >= '0' && r <= '9' || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+var epubFuncs = template.FuncMap{
+	"safeHTML": func(content string) template.HTML {
+		return template.HTML(content)
+	},
+	"now": func() string {
+		return time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	},
+}
+
+var epubContainerTmpl = template.Must(template.New("container").Parse(`<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`))
+
+var epubPackageTmpl = template.Must(template.New("package").Funcs(epubFuncs).Parse(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="bookid">{{.ID}}</dc:identifier>
+    <dc:title>{{.Title}}</dc:title>
+    <dc:language>en</dc:language>
+    <dc:publisher>Readability</dc:publisher>
+    <meta property="dcterms:modified">{{now}}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="title" href="title.xhtml" media-type="application/xhtml+xml"/>
+    {{- range $i, $ch := .Chapters}}
+    <item id="chapter{{$i}}" href="{{$ch.File}}" media-type="application/xhtml+xml"/>
+    {{- end}}
+    {{- range $i, $img := .Images}}
+    <item id="image{{$i}}" href="{{$img.File}}" media-type="{{$img.MediaType}}"/>
+    {{- end}}
+  </manifest>
+  <spine>
+    <itemref idref="title"/>
+    {{- range $i, $ch := .Chapters}}
+    <itemref idref="chapter{{$i}}"/>
+    {{- end}}
+  </spine>
+</package>
+`))
+
+var epubNavTmpl = template.Must(template.New("nav").Parse(`<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>{{.Title}}</title></head>
+<body>
+  <nav epub:type="toc">
+    <h1>Contents</h1>
+    <ol>
+      {{- range .Chapters}}
+      <li><a href="{{.File}}">{{.Title}}</a></li>
+      {{- end}}
+    </ol>
+  </nav>
+</body>
+</html>
+`))
+
+var epubTitleTmpl = template.Must(template.New("title").Funcs(epubFuncs).Parse(`<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>{{.Title}}</title></head>
+<body>
+  <h1>{{.Title}}</h1>
+  {{- range .Chapters}}
+  <p>{{.Title}}<br/><small>{{.URL}}</small></p>
+  {{- end}}
+  <p><small>Generated by Readability, {{now}}</small></p>
+</body>
+</html>
+`))
+
+var epubChapterTmpl = template.Must(template.New("chapter").Funcs(epubFuncs).Parse(`<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>{{.Title}}</title></head>
+<body>
+  <h1>{{.Title}}</h1>
+  <p><small>{{.URL}}</small></p>
+  {{.Body | safeHTML}}
+</body>
+</html>
+`))
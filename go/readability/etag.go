@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hash is the hash of the article's content, the one stored or, for
+// articles saved before it was, computed.
+func (art *article) hash() string {
+	if art.ContentHash != "" {
+		return art.ContentHash
+	}
+
+	return contentHash([]byte(art.Content))
+}
+
+// articleETag is the ETag of art written as format, changing with its
+// content and what is attached to it, tags, star, progress... vary are
+// what else the response depends on.
+func articleETag(art *article, format string, vary ...string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%q\x00%t\x00%g\x00%t\x00%t\x00%t\x00%s\x00%d",
+		art.hash(), format, art.Tags, art.Starred, art.Progress, art.Watched, art.Changed, art.Private, art.Slug, art.RefreshedAt.UnixNano())
+	for _, v := range vary {
+		fmt.Fprintf(h, "\x00%s", v)
+	}
+
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:12]) + `"`
+}
+
+// notModified sets the ETag, and Last-Modified unless modified is zero,
+// of the response to a GET, answering 304 when the client has it already.
+// Responses to the other methods are left alone.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Cache-Control", "private, no-cache")
+	if !modified.IsZero() {
+		h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match wins over If-Modified-Since when both are sent.
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag) {
+			return false
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err != nil || modified.IsZero() || modified.Truncate(time.Second).After(ims) {
+		return false
+	}
+
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches compares etag with those of an If-None-Match, weakly.
+func etagMatches(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pageVersion is a hash of the templates, stylesheets and themes, for the
+// pages to get new ETags when they change.
+var pageVersion = sync.OnceValue(func() string {
+	h := sha256.New()
+	for _, fsys := range []fs.FS{tmplFiles, cssFile} {
+		var names []string
+		for name, hash := range hashFiles(fsys) {
+			names = append(names, name+"="+hash)
+		}
+		sort.Strings(names)
+		fmt.Fprint(h, names)
+	}
+	for _, name := range themeNames {
+		fmt.Fprint(h, name, contentHash(themes[name]))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:6])
+})
+
+// modifiedAt is when art's content last changed.
+func (art *article) modifiedAt() time.Time {
+	if art.RefreshedAt.After(art.CreatedAt) {
+		return art.RefreshedAt
+	}
+
+	return art.CreatedAt
+}
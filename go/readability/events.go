@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ASYNC_READ answers browsers asking for an article that isn't cached with
+// a page following its extraction over /events/{job}, instead of keeping
+// them waiting on the fetch.
+var ASYNC_READ = envOr("ASYNC_READ", "true") == "true"
+
+// readAsync renders the loading page of a background job reading uri, it
+// returns false when the article should be read right away instead.
+func readAsync(w http.ResponseWriter, r *http.Request, uri string, opts readOptions) bool {
+	if !ASYNC_READ || opts.NoCache || opts.Format != "" || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return false
+	}
+	if !opts.Refresh && opts.library().isCached(uri) {
+		return false
+	}
+
+	job := startExtractJob(normalizeURL(uri), opts)
+
+	// Where to go once the article is saved, without refreshing it again.
+	next := *r.URL
+	query := next.Query()
+	query.Del("refresh")
+	next.RawQuery = query.Encode()
+
+	executePage(w, r, "loading.html", map[string]interface{}{
+		"URL":  uri,
+		"Job":  job.status.ID,
+		"Next": next.RequestURI(),
+	})
+
+	return true
+}
+
+// isCached reports whether uri has an article in the cache that hasn't
+// expired, without counting it as a view.
+func (lib *library) isCached(uri string) bool {
+	art, err := lib.store.GetArticle(lib.resolveKey(normalizeURL(uri)))
+
+	return err != nil || (art != nil && !art.Expired())
+}
+
+// eventsHandler streams the states of a job as server-sent events, one
+// "state" event per change until it is done or failed.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := extractJobs.Load(mux.Vars(r)["job"])
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// The stream lasts as long as the job, past WRITE_TIMEOUT.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	// Keeps proxies from closing the stream of a slow page.
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		status, changed := job.(*extractJob).Watch()
+
+		data, _ := json.Marshal(status)
+		fmt.Fprintf(w, "event: state\ndata: %s\n\n", data)
+		flusher.Flush()
+
+		if status.Over() {
+			return
+		}
+
+		for waiting := true; waiting; {
+			select {
+			case <-changed:
+				waiting = false
+			case <-keepalive.C:
+				fmt.Fprint(w, ": keepalive\n\n")
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+)
+
+var (
+	// MAX_ARTICLES and MAX_CACHE_BYTES bound the cache, 0 disables the
+	// limit. Sizes are of the stored, compressed articles.
+	MAX_ARTICLES    = envInt("MAX_ARTICLES", 0)
+	MAX_CACHE_BYTES = int64(envInt("MAX_CACHE_BYTES", 0))
+
+	evictMu sync.Mutex
+)
+
+// evictBatch is how many extra candidates are fetched beyond what is needed
+// to get back under MAX_ARTICLES, room for the kept articles skipped.
+const evictBatch = 50
+
+func cacheFull(count int, size int64) bool {
+	return (MAX_ARTICLES > 0 && count > MAX_ARTICLES) || (MAX_CACHE_BYTES > 0 && size > MAX_CACHE_BYTES)
+}
+
+// evictKept reports whether eviction keeps the article, one the reader did
+// something with: starred, watched, made private, noted or highlighted.
+func evictKept(art *article) bool {
+	return art.Starred || art.Watched || art.Private || art.Notes != "" || len(art.Highlights) > 0
+}
+
+// evictArticles deletes the least recently viewed articles until the cache
+// is within MAX_ARTICLES and MAX_CACHE_BYTES. The evictKept ones are kept.
+func (lib *library) evictArticles() {
+	if MAX_ARTICLES <= 0 && MAX_CACHE_BYTES <= 0 {
+		return
+	}
+
+	evictMu.Lock()
+	defer evictMu.Unlock()
+
+	count, size, err := lib.store.Usage()
+	if err != nil {
+		slog.Error("failed to get cache usage", "err", err)
+		return
+	}
+	if !cacheFull(count, size) {
+		return
+	}
+
+	n := evictBatch
+	if MAX_ARTICLES > 0 && count > MAX_ARTICLES {
+		n += count - MAX_ARTICLES
+	}
+
+	keys, err := lib.store.LeastRecentlyViewed(n)
+	if err != nil {
+		slog.Error("failed to list eviction candidates", "err", err)
+		return
+	}
+
+	evicted := 0
+	for _, key := range keys {
+		art, err := lib.store.GetArticle(key)
+		if err != nil || (art != nil && evictKept(art)) {
+			continue
+		}
+
+		if err := lib.deleteArticle(key); err != nil {
+			continue
+		}
+		evicted++
+
+		if count, size, err = lib.store.Usage(); err != nil || !cacheFull(count, size) {
+			break
+		}
+	}
+
+	slog.Info("evicted articles", "evicted", evicted, "articles", count, "bytes", size)
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestEvictArticles(t *testing.T) {
+	defer func(n int) { MAX_ARTICLES = n }(MAX_ARTICLES)
+	MAX_ARTICLES = 1
+
+	lib := &library{store: newMemoryStorage(100), index: newSearchIndex()}
+	arts := map[string]*article{
+		"plain":       {},
+		"unread":      {},
+		"starred":     {Starred: true},
+		"watched":     {Watched: true},
+		"private":     {Private: true},
+		"noted":       {Notes: "for later"},
+		"highlighted": {Highlights: []highlight{{}}},
+	}
+	for key, art := range arts {
+		art.URL = key
+		if err := lib.store.SetArticle(key, art); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lib.evictArticles()
+
+	for key := range arts {
+		art, err := lib.store.GetArticle(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kept := art != nil; kept != (key != "plain" && key != "unread") {
+			t.Errorf("%s kept %v", key, kept)
+		}
+	}
+}
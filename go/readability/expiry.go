@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// CACHE_JANITOR_INTERVAL is how often expired articles are purged.
+var CACHE_JANITOR_INTERVAL = envDuration("CACHE_JANITOR_INTERVAL", 10*time.Minute)
+
+func (art *article) Expired() bool {
+	return !art.ExpiresAt.IsZero() && time.Now().After(art.ExpiresAt)
+}
+
+// cacheJanitor periodically deletes expired articles, and the recents and
+// view count entries of articles redis already expired on its own.
+func cacheJanitor() {
+	if CACHE_JANITOR_INTERVAL <= 0 {
+		return
+	}
+
+	for range time.Tick(CACHE_JANITOR_INTERVAL) {
+		for _, lib := range allLibraries() {
+			lib.purgeExpired()
+		}
+	}
+}
+
+func (lib *library) purgeExpired() {
+	keys, err := lib.store.Keys()
+	if err != nil {
+		slog.Error("failed to list articles for expiry", "err", err)
+		return
+	}
+
+	purged := 0
+	for _, key := range keys {
+		art, err := lib.store.GetArticle(key)
+		if err != nil || (art != nil && !art.Expired()) {
+			continue
+		}
+
+		if err := lib.deleteArticle(key); err == nil {
+			purged++
+		}
+	}
+
+	if purged > 0 {
+		slog.Info("purged expired articles", "articles", purged)
+	}
+}
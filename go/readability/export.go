@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+)
+
+var mdconverter = md.NewConverter("", true, nil)
+
+func exportMarkdownHandler(w http.ResponseWriter, r *http.Request) {
+	uri, opts := parseURL(r.URL, len("/export/md/"))
+	opts.lib = libraryFor(r)
+	opts.ctx = r.Context()
+
+	if uri == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeMarkdown(w, readabyFormURL(uri, opts))
+}
+
+func writeMarkdown(w http.ResponseWriter, art *article) {
+	if art.ErrMsg != "" {
+		http.Error(w, art.ErrMsg, http.StatusBadGateway)
+		return
+	}
+
+	body, err := mdconverter.ConvertString(art.Content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Content-Disposition", attachment(art.Title, ".md"))
+
+	fmt.Fprintf(w, "# %s\n\n<%s>\n\n%s\n", art.Title, art.URL, body)
+	if art.Notes != "" {
+		fmt.Fprintf(w, "\n## Notes\n\n%s\n", art.Notes)
+	}
+	if len(art.Highlights) > 0 {
+		fmt.Fprintf(w, "\n## Highlights\n\n%s", highlightsMarkdown(art.Highlights))
+	}
+}
+
+// highlightsMarkdown writes highlights as quotes followed by their notes.
+func highlightsMarkdown(highlights []highlight) string {
+	var sb strings.Builder
+	for _, h := range highlights {
+		for _, line := range strings.Split(strings.TrimSpace(h.Text), "\n") {
+			sb.WriteString("> " + strings.TrimSpace(line) + "\n")
+		}
+		sb.WriteString("\n")
+		if h.Note != "" {
+			sb.WriteString(h.Note + "\n\n")
+		}
+	}
+
+	return sb.String()
+}
+
+// exportEpubHandler packages one article, /export/epub/{base64 URL}, or
+// several, /export/epub/?url={URL}&url={URL}, into an EPUB file.
+func exportEpubHandler(w http.ResponseWriter, r *http.Request) {
+	var arts []*article
+
+	if strings.TrimPrefix(r.URL.EscapedPath(), "/export/epub/") == "" {
+		for _, uri := range r.URL.Query()["url"] {
+			arts = append(arts, readabyFormURL(uri, readOptions{lib: libraryFor(r), ctx: r.Context()}))
+		}
+	} else {
+		uri, opts := parseURL(r.URL, len("/export/epub/"))
+		opts.lib = libraryFor(r)
+		opts.ctx = r.Context()
+		arts = append(arts, readabyFormURL(uri, opts))
+	}
+
+	if len(arts) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	title := fmt.Sprintf("Readability - %d articles", len(arts))
+	if len(arts) == 1 {
+		title = arts[0].Title
+	}
+
+	book := newEpubBook(title)
+	for _, art := range arts {
+		if art.ErrMsg != "" {
+			http.Error(w, fmt.Sprintf("%s: %s", art.URL, art.ErrMsg), http.StatusBadGateway)
+			return
+		}
+
+		if err := book.AddArticle(art); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := book.Write(&buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/epub+zip")
+	w.Header().Set("Content-Disposition", attachment(title, ".epub"))
+	w.Write(buf.Bytes())
+}
+
+// attachment builds a Content-Disposition header with a filename derived
+// from the article title.
+func attachment(title, ext string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsNumber(r), r == '-', r == '_':
+			return r
+		case unicode.IsSpace(r):
+			return '-'
+		}
+		return -1
+	}, title)
+
+	if name == "" {
+		name = "article"
+	}
+
+	return mime.FormatMediaType("attachment", map[string]string{"filename": name + ext})
+}
+
+// exportedArticle is an article in a library export, one JSON object a
+// line, with what's attached to it.
+type exportedArticle struct {
+	articleJSON
+	Progress    float64    `json:"progress,omitempty"`
+	Watched     bool       `json:"watched,omitempty"`
+	Slug        string     `json:"slug,omitempty"`
+	RefreshedAt *time.Time `json:"refreshed_at,omitempty"`
+}
+
+// exportArticles writes every article of lib as JSON lines, the oldest
+// first so importing them keeps the recents in order, returning how many
+// it wrote.
+func (lib *library) exportArticles(w io.Writer) (int, error) {
+	keys, err := keysByAge(lib.store)
+	if err != nil {
+		return 0, err
+	}
+
+	enc := json.NewEncoder(w)
+	n := 0
+	for _, key := range keys {
+		art, err := lib.store.GetArticle(key)
+		if err != nil {
+			return n, err
+		}
+		if art == nil {
+			continue
+		}
+
+		rec := exportedArticle{
+			articleJSON: newArticleJSON(art),
+			Progress:    art.Progress,
+			Watched:     art.Watched,
+			Slug:        art.Slug,
+		}
+		if !art.RefreshedAt.IsZero() {
+			rec.RefreshedAt = &art.RefreshedAt
+		}
+		if err := enc.Encode(rec); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// keysByAge returns the keys of the articles saved in s, failed fetches
+// left out, the oldest first. They're sorted first and read again by the
+// callers, libraries don't fit in memory.
+func keysByAge(s Storage) ([]string, error) {
+	keys, err := s.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	created := make(map[string]time.Time, len(keys))
+	for _, key := range keys {
+		art, err := s.GetArticle(key)
+		if err != nil {
+			return nil, err
+		}
+		if art == nil || art.ErrMsg != "" {
+			continue
+		}
+		created[key] = art.CreatedAt
+	}
+	keys = keys[:0]
+	for key := range created {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !created[keys[i]].Equal(created[keys[j]]) {
+			return created[keys[i]].Before(created[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+
+	return keys, nil
+}
+
+var apiExportOp = apiOperation{
+	Summary:     "Export the library",
+	Description: "Every saved article with its metadata, content, tags, notes and highlights, one JSON object a line, the oldest first. POST it to /api/v1/import to restore it.",
+	Tag:         "library",
+	Response:    exportedArticle{},
+	Errors:      []int{http.StatusInternalServerError},
+}
+
+// libraryExportHandler downloads the library as JSON lines, /export.
+func libraryExportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": "readability-" + time.Now().Format("2006-01-02") + ".jsonl",
+	}))
+
+	// Past the first line the status is sent, a failure can only cut the
+	// export short.
+	if n, err := libraryFor(r).exportArticles(w); err != nil {
+		slog.ErrorContext(r.Context(), "failed to export library", "articles", n, "err", err)
+		if n == 0 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// exportCommand writes a library's export to a file, gzipped when its name
+// ends in .gz, or to stdout:
+//
+//	readability export [-user name] [file]
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	name := fs.String("user", "", "the user whose library to export, the instance's when empty")
+	fs.Parse(args)
+
+	lib, err := commandLibrary(*name)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if path := fs.Arg(0); path != "" && path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+		if strings.HasSuffix(path, ".gz") {
+			zw := gzip.NewWriter(f)
+			defer zw.Close()
+			out = zw
+		}
+	}
+
+	bw := bufio.NewWriter(out)
+	n, err := lib.exportArticles(bw)
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d articles\n", n)
+
+	return nil
+}
+
+// commandLibrary is the library of the user name for the commands, the
+// instance's when name is empty.
+func commandLibrary(name string) (*library, error) {
+	if name == "" {
+		return instanceLib, nil
+	}
+
+	id, err := userID(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return userLibrary(id)
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	readability "github.com/go-shiori/go-readability"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	// EXTRACTORS is the order extractors are tried in, the first whose
+	// text is at least EXTRACT_MIN_LENGTH characters wins.
+	EXTRACTORS         = envOr("EXTRACTORS", "readability,density,meta,headless")
+	EXTRACT_MIN_LENGTH = envInt("EXTRACT_MIN_LENGTH", 250)
+	// HEADLESS_URL is a headless browser rendering service, posted
+	// {"url": ...} and answering with the rendered HTML, like browserless'
+	// /content. The headless extractor is skipped without it.
+	HEADLESS_URL = envOr("HEADLESS_URL", "")
+)
+
+// extractor pulls the readable content out of a downloaded page.
+type extractor interface {
+	Name() string
+	Extract(raw []byte, pageURL *url.URL) (readability.Article, error)
+}
+
+var extractors = []extractor{
+	readabilityExtractor{},
+	densityExtractor{},
+	metaExtractor{},
+	headlessExtractor{},
+}
+
+func extractorNamed(name string) extractor {
+	for _, ex := range extractors {
+		if ex.Name() == name {
+			return ex
+		}
+	}
+
+	return nil
+}
+
+// extract runs the EXTRACTORS in order, falling through on errors and on
+// content shorter than EXTRACT_MIN_LENGTH. When none gets there the longest
+// result is used, the first error when there's none at all.
+func extract(ctx context.Context, raw []byte, pageURL *url.URL) (readability.Article, string, error) {
+	var best readability.Article
+	var bestName string
+	var firstErr error
+
+	for _, name := range strings.Split(EXTRACTORS, ",") {
+		name = strings.TrimSpace(name)
+		ex := extractorNamed(name)
+		if ex == nil {
+			continue
+		}
+		if name == "headless" && HEADLESS_URL == "" {
+			continue
+		}
+
+		_, span := tracer.Start(ctx, "extract "+name)
+		art, err := ex.Extract(raw, pageURL)
+		span.End()
+		if err != nil {
+			slog.WarnContext(ctx, "extractor failed", "extractor", name, "url", pageURL.String(), "err", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		length := len([]rune(strings.TrimSpace(art.TextContent)))
+		if length >= EXTRACT_MIN_LENGTH {
+			return art, name, nil
+		}
+		slog.InfoContext(ctx, "extracted too little, trying the next extractor", "extractor", name, "url", pageURL.String(), "characters", length)
+		if bestName == "" || length > len([]rune(strings.TrimSpace(best.TextContent))) {
+			best, bestName = art, name
+		}
+	}
+
+	if bestName != "" {
+		return best, bestName, nil
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("no extractor configured")
+	}
+
+	return readability.Article{}, "", firstErr
+}
+
+type readabilityExtractor struct{}
+
+func (readabilityExtractor) Name() string { return "readability" }
+
+func (readabilityExtractor) Extract(raw []byte, pageURL *url.URL) (readability.Article, error) {
+	return readability.FromReader(bytes.NewReader(raw), pageURL)
+}
+
+// densityExtractor picks the element holding the most paragraph text that
+// isn't links, after dropping navigation and other boilerplate.
+type densityExtractor struct{}
+
+func (densityExtractor) Name() string { return "density" }
+
+func (densityExtractor) Extract(raw []byte, pageURL *url.URL) (readability.Article, error) {
+	doc, err := html.Parse(bytes.NewReader(raw))
+	if err != nil {
+		return readability.Article{}, err
+	}
+	art := pageInfo(doc, pageURL)
+	stripBoilerplate(doc)
+
+	scores := make(map[*html.Node]float64)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.P, atom.Pre, atom.Blockquote, atom.Li:
+				text := strings.TrimSpace(nodeText(n))
+				if len(text) >= 25 {
+					score := float64(len(text)) * (1 - linkDensity(n, len(text)))
+					if p := n.Parent; p != nil {
+						scores[p] += score
+						if gp := p.Parent; gp != nil {
+							scores[gp] += score / 2
+						}
+					}
+				}
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	var top *html.Node
+	for n, score := range scores {
+		if top == nil || score > scores[top] {
+			top = n
+		}
+	}
+	if top == nil {
+		return art, fmt.Errorf("no paragraphs found")
+	}
+
+	var sb strings.Builder
+	for c := top.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&sb, c); err != nil {
+			return art, err
+		}
+	}
+	art.Content = sb.String()
+	art.TextContent = strings.Join(strings.Fields(nodeText(top)), " ")
+	art.Length = len(art.TextContent)
+
+	return art, nil
+}
+
+// metaExtractor falls back to what's left when nothing better is found:
+// the page's description followed by every sizeable paragraph of text.
+type metaExtractor struct{}
+
+func (metaExtractor) Name() string { return "meta" }
+
+func (metaExtractor) Extract(raw []byte, pageURL *url.URL) (readability.Article, error) {
+	doc, err := html.Parse(bytes.NewReader(raw))
+	if err != nil {
+		return readability.Article{}, err
+	}
+	art := pageInfo(doc, pageURL)
+	stripBoilerplate(doc)
+
+	var paragraphs []string
+	if art.Excerpt != "" {
+		paragraphs = append(paragraphs, art.Excerpt)
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.P {
+			if text := strings.Join(strings.Fields(nodeText(n)), " "); len(text) >= 40 && text != art.Excerpt {
+				paragraphs = append(paragraphs, text)
+			}
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	if len(paragraphs) == 0 {
+		return art, fmt.Errorf("no description or paragraphs found")
+	}
+
+	var sb strings.Builder
+	for _, p := range paragraphs {
+		sb.WriteString("<p>" + html.EscapeString(p) + "</p>\n")
+	}
+	art.Content = sb.String()
+	art.TextContent = strings.Join(paragraphs, " ")
+	art.Length = len(art.TextContent)
+
+	return art, nil
+}
+
+// headlessExtractor has HEADLESS_URL render the page, for pages that only
+// have their content after running scripts, and runs readability on that.
+type headlessExtractor struct{}
+
+func (headlessExtractor) Name() string { return "headless" }
+
+func (headlessExtractor) Extract(_ []byte, pageURL *url.URL) (readability.Article, error) {
+	body, err := json.Marshal(map[string]string{"url": pageURL.String()})
+	if err != nil {
+		return readability.Article{}, err
+	}
+
+	resp, err := serviceClient.Post(HEADLESS_URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return readability.Article{}, fmt.Errorf("failed to render the page: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return readability.Article{}, fmt.Errorf("failed to render the page: %s", resp.Status)
+	}
+
+	rendered, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return readability.Article{}, err
+	}
+
+	return readability.FromReader(bytes.NewReader(rendered), pageURL)
+}
+
+// pageInfo is what the page's head says about it, for the extractors that
+// don't get it from readability.
+func pageInfo(doc *html.Node, pageURL *url.URL) readability.Article {
+	metas := metaTags(doc)
+
+	var art readability.Article
+	art.Title = firstNonEmpty(metas["og:title"], metas["twitter:title"], documentTitle(doc))
+	art.Excerpt = firstNonEmpty(metas["og:description"], metas["description"], metas["twitter:description"])
+	art.SiteName = metas["og:site_name"]
+	art.Byline = metas["author"]
+	art.Image = firstNonEmpty(metas["og:image"], metas["twitter:image"])
+	if art.Image != "" {
+		if ref, err := pageURL.Parse(art.Image); err == nil {
+			art.Image = ref.String()
+		}
+	}
+
+	return art
+}
+
+func documentTitle(doc *html.Node) string {
+	var title string
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Title {
+			title = strings.TrimSpace(nodeText(n))
+			return
+		}
+		for c := n.FirstChild; c != nil && title == ""; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	return title
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// stripBoilerplate removes what's never part of an article.
+func stripBoilerplate(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.CommentNode {
+			n.RemoveChild(c)
+		} else if c.Type == html.ElementNode {
+			switch c.DataAtom {
+			case atom.Script, atom.Style, atom.Noscript, atom.Nav, atom.Header, atom.Footer,
+				atom.Aside, atom.Form, atom.Iframe, atom.Svg, atom.Button:
+				n.RemoveChild(c)
+			default:
+				stripBoilerplate(c)
+			}
+		}
+		c = next
+	}
+}
+
+// linkDensity is the share of n's text, length characters, that is links.
+func linkDensity(n *html.Node, length int) float64 {
+	if length == 0 {
+		return 0
+	}
+
+	var links int
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.A {
+			links += len(strings.TrimSpace(nodeText(n)))
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+
+	if links > length {
+		return 1
+	}
+
+	return float64(links) / float64(length)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	// FAVICONS shows the sites' icons next to the articles of the index
+	// and archive, served from /favicon/{host}.
+	FAVICONS = envOr("FAVICONS", "true") != "false"
+	// FAVICON_TTL is how long an icon is kept before it's fetched again,
+	// and how long a site without one isn't asked again.
+	FAVICON_TTL = envDuration("FAVICON_TTL", 30*24*time.Hour)
+)
+
+const (
+	// faviconSize is the width icons are scaled down to.
+	faviconSize = 64
+	// faviconPageBytes is how much of a home page is read for its icon
+	// links, they're in the <head>.
+	faviconPageBytes = 256 << 10
+	faviconTimeout   = 10 * time.Second
+	// faviconHostsRefresh is how often the hosts of the saved articles
+	// may be listed again, for one not listed yet.
+	faviconHostsRefresh = 10 * time.Second
+)
+
+var (
+	faviconMu sync.Mutex
+	// faviconHosts are the hosts of the saved articles, the only ones
+	// icons are fetched for, listed at faviconListed and added to as
+	// pages link their icons.
+	faviconHosts  = make(map[string]bool)
+	faviconListed time.Time
+	// faviconMisses are the hosts no icon was found for, by when, not to
+	// ask them on every page. They're dropped after FAVICON_TTL.
+	faviconMisses = make(map[string]time.Time)
+)
+
+// faviconPlaceholder is served for the sites without an icon.
+var faviconPlaceholder = []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 16 16"><circle cx="8" cy="8" r="6" fill="none" stroke="#888" stroke-width="1.5"/></svg>`)
+
+// faviconPath is where the icon of the site of uri is served, "" with
+// FAVICONS off.
+func faviconPath(uri string) string {
+	u, err := url.Parse(uri)
+	if !FAVICONS || err != nil || u.Host == "" {
+		return ""
+	}
+
+	host := strings.ToLower(u.Host)
+	faviconMu.Lock()
+	faviconHosts[host] = true
+	faviconMu.Unlock()
+
+	return "/favicon/" + host
+}
+
+// faviconHost reports whether an article is saved from host, listing the
+// hosts of every library again when it isn't among those listed, at most
+// every faviconHostsRefresh.
+func faviconHost(host string) bool {
+	faviconMu.Lock()
+	defer faviconMu.Unlock()
+
+	if faviconHosts[host] {
+		return true
+	}
+	if time.Since(faviconListed) < faviconHostsRefresh {
+		return false
+	}
+	faviconListed = time.Now()
+
+	hosts := make(map[string]bool)
+	for _, lib := range allLibraries() {
+		keys, err := lib.store.Keys()
+		if err != nil {
+			slog.Error("failed to list articles for favicons", "err", err)
+			return false
+		}
+		for _, key := range keys {
+			uri, err := lib.store.ArticleURL(key)
+			if err != nil || uri == "" {
+				continue
+			}
+			if u, err := url.Parse(uri); err == nil && u.Host != "" {
+				hosts[strings.ToLower(u.Host)] = true
+			}
+		}
+	}
+	faviconHosts = hosts
+
+	return hosts[host]
+}
+
+// faviconMissed reports whether no icon was found for host in the last
+// FAVICON_TTL.
+func faviconMissed(host string) bool {
+	faviconMu.Lock()
+	defer faviconMu.Unlock()
+
+	missed, ok := faviconMisses[host]
+	return ok && time.Since(missed) <= FAVICON_TTL
+}
+
+// setFaviconMiss records whether no icon was found for host, dropping the
+// misses that expired.
+func setFaviconMiss(host string, missed bool) {
+	faviconMu.Lock()
+	defer faviconMu.Unlock()
+
+	for h, at := range faviconMisses {
+		if time.Since(at) > FAVICON_TTL {
+			delete(faviconMisses, h)
+		}
+	}
+	if missed {
+		faviconMisses[host] = time.Now()
+	} else {
+		delete(faviconMisses, host)
+	}
+}
+
+// faviconHandler serves /favicon/{host}, the icon of the site at host,
+// fetching and caching it with the proxied images on its first request.
+// Only the hosts of saved articles have one.
+func faviconHandler(w http.ResponseWriter, r *http.Request) {
+	host := mux.Vars(r)["host"]
+	if !faviconHost(host) {
+		http.NotFound(w, r)
+		return
+	}
+	hash := imageHash("favicon:" + host)
+
+	img, err := getImage(hash)
+	if err != nil {
+		slog.Error("failed to get favicon", "host", host, "err", err)
+	}
+	if img == nil || len(img.Data) == 0 || time.Since(img.FetchedAt) > FAVICON_TTL {
+		if !faviconMissed(host) {
+			fetched, err := fetchFavicon(r.Context(), host)
+			setFaviconMiss(host, err != nil)
+			switch {
+			case err != nil:
+				slog.Warn("failed to fetch favicon", "host", host, "err", err)
+			default:
+				img = fetched
+				if err := setImage(hash, img); err != nil {
+					slog.Error("failed to cache favicon", "host", host, "err", err)
+				}
+			}
+		}
+	}
+
+	if img == nil || len(img.Data) == 0 {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Write(faviconPlaceholder)
+		return
+	}
+
+	w.Header().Set("Content-Type", img.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(img.Data)))
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(FAVICON_TTL.Seconds())))
+	w.Write(img.Data)
+}
+
+// fetchFavicon fetches the icon of host, the one its home page links to or
+// /favicon.ico, over https or else http.
+func fetchFavicon(ctx context.Context, host string) (*cachedImage, error) {
+	ctx, cancel := context.WithTimeout(ctx, faviconTimeout)
+	defer cancel()
+
+	var err error
+	for _, scheme := range []string{"https", "http"} {
+		home := scheme + "://" + host + "/"
+		if err = checkURL(home); err != nil {
+			return nil, err
+		}
+		if err = checkDomain(home); err != nil {
+			return nil, err
+		}
+
+		var icons []string
+		icons, err = faviconLinks(ctx, home)
+		if err != nil {
+			continue
+		}
+		for _, icon := range icons {
+			var img *cachedImage
+			if img, err = fetchIcon(ctx, icon); err == nil {
+				return img, nil
+			}
+		}
+	}
+
+	return nil, err
+}
+
+// faviconLinks returns the icons the home page at home links to, the best
+// first, then /favicon.ico.
+func faviconLinks(ctx context.Context, home string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, home, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	base := resp.Request.URL
+	fallback := base.ResolveReference(&url.URL{Path: "/favicon.ico"}).String()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
+		return []string{fallback}, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, faviconPageBytes))
+	if err != nil {
+		return []string{fallback}, nil
+	}
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return []string{fallback}, nil
+	}
+
+	var icons, touch []string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Link {
+			rels := strings.Fields(strings.ToLower(attrValue(n, "rel")))
+			href := strings.TrimSpace(attrValue(n, "href"))
+			if ref, err := base.Parse(href); href != "" && err == nil {
+				for _, rel := range rels {
+					switch rel {
+					case "icon":
+						icons = append(icons, ref.String())
+					case "apple-touch-icon":
+						touch = append(touch, ref.String())
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	return append(append(icons, touch...), fallback), nil
+}
+
+// fetchIcon downloads the icon at uri, scaled down to faviconSize.
+func fetchIcon(ctx context.Context, uri string) (*cachedImage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", uri, resp.Status)
+	}
+	ct := resp.Header.Get("Content-Type")
+	// Icons are often served as whatever .ico maps to.
+	if !strings.HasPrefix(ct, "image/") {
+		if !strings.HasSuffix(strings.ToLower(resp.Request.URL.Path), ".ico") {
+			return nil, fmt.Errorf("%s: not an image: %s", uri, ct)
+		}
+		ct = "image/x-icon"
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, IMAGE_MAX_BYTES+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || int64(len(data)) > IMAGE_MAX_BYTES {
+		return nil, fmt.Errorf("%s: icon is empty or larger than %d bytes", uri, IMAGE_MAX_BYTES)
+	}
+
+	img := &cachedImage{URL: uri, ContentType: ct, Data: data, FetchedAt: time.Now()}
+	if resized, ct, err := resizeImage(img.Data, faviconSize); err == nil {
+		img.Data, img.ContentType = resized, ct
+	}
+
+	return img, nil
+}
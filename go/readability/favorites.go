@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+)
+
+func (lib *library) setStarred(key string, starred bool) error {
+	if err := lib.store.SetStarred(key, starred); err != nil {
+		return err
+	}
+
+	lib.index.SetStarred(key, starred)
+
+	return nil
+}
+
+func (lib *library) starredArticles() ([]*article, error) {
+	keys, err := lib.store.StarredArticles()
+	if err != nil {
+		return nil, err
+	}
+
+	var arts []*article
+	for _, key := range keys {
+		if art, err := lib.store.GetArticle(key); err == nil && art != nil {
+			arts = append(arts, art)
+		}
+	}
+
+	return arts, nil
+}
+
+// starHandler stars the article in the url form field, or unstars it when
+// starred is 0.
+func starHandler(w http.ResponseWriter, r *http.Request) {
+	uri := r.FormValue("url")
+	if uri == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	lib := libraryFor(r)
+	if err := lib.setStarred(lib.resolveKey(uri), r.FormValue("starred") != "0"); err != nil {
+		if err == errArticleNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, articlePath("read", uri), http.StatusSeeOther)
+}
+
+func favoritesHandler(w http.ResponseWriter, r *http.Request) {
+	arts, err := libraryFor(r).starredArticles()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	executePage(w, r, "favorites.html", map[string]interface{}{
+		"Articles": arts,
+	})
+}
+
+var apiFavoritesOp = apiOperation{
+	Summary:  "List the starred articles",
+	Tag:      "library",
+	Response: []searchResult{},
+	Errors:   []int{http.StatusInternalServerError},
+}
+
+func apiFavoritesHandler(w http.ResponseWriter, r *http.Request) {
+	arts, err := libraryFor(r).starredArticles()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	results := make([]searchResult, 0, len(arts))
+	for _, art := range arts {
+		results = append(results, searchResult{
+			URL:     art.URL,
+			Title:   art.Title,
+			Excerpt: excerpt(htmlText(art.Content), 200),
+			Starred: true,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const feedSize = 20
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate,omitempty"`
+	Description string `xml:"description"`
+	// Enclosure is the article's narration, in the podcast feed.
+	Enclosure *rssEnclosure `xml:"enclosure,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Link    atomLink `xml:"link"`
+	Updated string   `xml:"updated"`
+	Summary string   `xml:"summary"`
+}
+
+// feedArticles returns the most recently read articles, skipping duplicates
+// in the time queue and entries that are no longer stored.
+func feedArticles(lib *library, n int) []*article {
+	keys, err := lib.getLastNArticles(n * 2)
+	if err != nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	arts := make([]*article, 0, n)
+	for _, key := range keys {
+		if seen[key] || len(arts) == n {
+			continue
+		}
+		seen[key] = true
+
+		art, err := lib.store.GetArticle(key)
+		if err != nil || art == nil {
+			continue
+		}
+		arts = append(arts, art)
+	}
+
+	return arts
+}
+
+func baseURL(r *http.Request) string {
+	if BASE_URL != "" {
+		return BASE_URL
+	}
+
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host
+}
+
+func rssHandler(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       "Readability",
+			Link:        base + "/",
+			Description: "Recently read articles",
+		},
+	}
+
+	for _, art := range feedArticles(libraryFor(r), feedSize) {
+		link := base + articlePath("read", art.URL)
+		item := rssItem{
+			Title:       art.Title,
+			Link:        link,
+			GUID:        link,
+			Description: excerpt(htmlText(art.Content), 500),
+		}
+		if !art.CreatedAt.IsZero() {
+			item.PubDate = art.CreatedAt.Format(time.RFC1123Z)
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	writeXML(w, "application/rss+xml", feed)
+}
+
+func atomHandler(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+
+	feed := atomFeed{
+		Title: "Readability",
+		ID:    base + "/",
+		Link:  atomLink{Href: base + "/feed.atom", Rel: "self"},
+	}
+
+	var updated time.Time
+	for _, art := range feedArticles(libraryFor(r), feedSize) {
+		link := base + articlePath("read", art.URL)
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   art.Title,
+			ID:      link,
+			Link:    atomLink{Href: link},
+			Updated: art.CreatedAt.UTC().Format(time.RFC3339),
+			Summary: excerpt(htmlText(art.Content), 500),
+		})
+		if art.CreatedAt.After(updated) {
+			updated = art.CreatedAt
+		}
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	writeXML(w, "application/atom+xml", feed)
+}
+
+func writeXML(w http.ResponseWriter, contentType string, v interface{}) {
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Write([]byte(xml.Header))
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		slog.Error("failed to encode feed", "err", err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	readability "github.com/go-shiori/go-readability"
+	"golang.org/x/net/html"
+)
+
+var (
+	// FETCH_TIMEOUT bounds a whole request, FETCH_MAX_BYTES the size of a
+	// page.
+	FETCH_TIMEOUT   = envDuration("FETCH_TIMEOUT", 30*time.Second)
+	FETCH_MAX_BYTES = int64(envInt("FETCH_MAX_BYTES", 10<<20))
+
+	fetchClient = &http.Client{
+		Timeout:       FETCH_TIMEOUT,
+		Transport:     traceTransport(headerTransport{proxyTransport}),
+		CheckRedirect: checkRedirect,
+	}
+	// serviceClient talks to the services this instance is set up with,
+	// HEADLESS_URL and WAYBACK_API_URL.
+	serviceClient = &http.Client{
+		Timeout:   FETCH_TIMEOUT,
+		Transport: traceTransport(serviceTransport),
+	}
+)
+
+// readLimited reads a response body, failing once it passes
+// FETCH_MAX_BYTES instead of cutting the page short.
+func readLimited(resp *http.Response) ([]byte, error) {
+	if resp.ContentLength > FETCH_MAX_BYTES {
+		return nil, fmt.Errorf("the page is %d bytes, larger than the %d allowed", resp.ContentLength, FETCH_MAX_BYTES)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, FETCH_MAX_BYTES+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > FETCH_MAX_BYTES {
+		return nil, fmt.Errorf("the page is larger than the %d bytes allowed", FETCH_MAX_BYTES)
+	}
+
+	return data, nil
+}
+
+// fetchedPage is the readable content of a page plus what the page says
+// about itself outside of it.
+type fetchedPage struct {
+	readability.Article
+	// Canonical is the URL of the page's <link rel="canonical">, if any.
+	Canonical string
+	// FinalURL is where the redirects of the URL fetched ended, normalized.
+	FinalURL  string
+	Published time.Time
+	// Raw is the page as it was downloaded.
+	Raw []byte
+	// Extractor is the name of the extractor the content came from.
+	Extractor string
+	// ArchivedFrom is the Wayback Machine snapshot the page was read from
+	// when it is gone, ArchivedAt when the snapshot was taken.
+	ArchivedFrom string
+	ArchivedAt   time.Time
+}
+
+// goneError is a fetch error the page may be on the Wayback Machine for,
+// it doesn't answer or is not found.
+type goneError struct {
+	err error
+}
+
+func (e goneError) Error() string { return e.err.Error() }
+func (e goneError) Unwrap() error { return e.err }
+
+// fetchReadable downloads uri and extracts its readable content with the
+// extract chain, also returning the page's canonical URL and publish
+// date if it declares them. Pages that are gone are read from their
+// latest Wayback Machine snapshot instead.
+func fetchReadable(ctx context.Context, uri string) (fetchedPage, error) {
+	page, err := fetchPage(ctx, uri)
+	if err == nil || !WAYBACK || !errors.As(err, &goneError{}) {
+		return page, err
+	}
+
+	snapURL, snapAt, werr := waybackSnapshot(context.WithoutCancel(ctx), uri)
+	if werr != nil {
+		slog.ErrorContext(ctx, "failed to look up the wayback machine", "url", uri, "err", werr)
+		return page, err
+	}
+	if snapURL == "" {
+		return page, err
+	}
+
+	slog.InfoContext(ctx, "page is gone, reading the wayback machine snapshot", "url", uri, "err", err, "snapshot", snapURL)
+	archived, werr := fetchPage(ctx, snapURL)
+	if werr != nil {
+		slog.ErrorContext(ctx, "failed to read wayback machine snapshot", "snapshot", snapURL, "err", werr)
+		return page, err
+	}
+
+	// The snapshot's canonical can only be the archive's own.
+	archived.Canonical = ""
+	archived.FinalURL = ""
+	archived.ArchivedFrom = snapURL
+	archived.ArchivedAt = snapAt
+
+	return archived, nil
+}
+
+func fetchPage(ctx context.Context, uri string) (fetchedPage, error) {
+	resp, err := getWithRetry(ctx, uri)
+	if permanentFetchError(err) {
+		return fetchedPage{}, err
+	}
+	if err != nil {
+		return fetchedPage{}, goneError{fmt.Errorf("failed to fetch the page: %v", err)}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return fetchedPage{}, goneError{fmt.Errorf("failed to fetch the page: %s", resp.Status)}
+	case resp.StatusCode >= 400:
+		return fetchedPage{}, fmt.Errorf("failed to fetch the page: %s", resp.Status)
+	}
+
+	if ct := resp.Header.Get("Content-Type"); !strings.Contains(ct, "text/html") {
+		return fetchedPage{}, fmt.Errorf("URL is not a HTML document")
+	}
+
+	raw, err := readLimited(resp)
+	if err != nil {
+		return fetchedPage{}, fmt.Errorf("failed to fetch the page: %v", err)
+	}
+
+	doc, err := html.Parse(bytes.NewReader(raw))
+	if err != nil {
+		return fetchedPage{}, fmt.Errorf("failed to parse the page: %v", err)
+	}
+
+	page := fetchedPage{
+		Canonical: canonicalLink(doc, resp.Request.URL),
+		FinalURL:  normalizeURL(resp.Request.URL.String()),
+		Published: publishedTime(doc),
+		Raw:       raw,
+	}
+	// A page fetched logged in may show the account, don't keep it.
+	if _, ok := credentialFor(resp.Request.URL); ok {
+		page.Raw = nil
+	}
+
+	page.Article, page.Extractor, err = extract(ctx, raw, resp.Request.URL)
+	if err != nil {
+		return page, err
+	}
+
+	if page.Image != "" {
+		if ref, err := resp.Request.URL.Parse(page.Image); err == nil {
+			page.Image = ref.String()
+		}
+	}
+
+	return page, nil
+}
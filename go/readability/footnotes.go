@@ -0,0 +1,219 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// footnoteMarker is the text of a link that looks like a footnote reference
+// outside of a <sup>: 1, [2], a, *, †...
+var footnoteMarker = regexp.MustCompile(`^\[?\s*(\d{1,3}|[a-z]|[*†‡§¶])\s*\]?$`)
+
+// footnoteTitleLen is how much of a footnote its reference shows on hover.
+const footnoteTitleLen = 300
+
+// footnotes finds the footnote and endnote references of content, the page
+// at base, links to an element further down from a <sup> or with a marker
+// for text. The links to the page's own anchors, which extractors make
+// absolute, are made relative first, so that they don't go back to the
+// site. A reference gets the footnote-ref class and the note's text as its
+// title, to show on hover, and the note a link back to the reference when
+// it has none. It can run again on its own output.
+func footnotes(content, base string) string {
+	page, err := url.Parse(base)
+	if err != nil || !strings.Contains(content, "href") {
+		return content
+	}
+
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(content), body)
+	if err != nil {
+		return content
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+
+	// The elements by id, and the order they come in.
+	ids := make(map[string]*html.Node)
+	order := make(map[*html.Node]int)
+	var links []*html.Node
+	walkElements(body, func(n *html.Node) {
+		order[n] = len(order)
+		if id := attrValue(n, "id"); id != "" && ids[id] == nil {
+			ids[id] = n
+		}
+		if n.DataAtom == atom.A {
+			if name := attrValue(n, "name"); name != "" && ids[name] == nil {
+				ids[name] = n
+			}
+			if attrValue(n, "href") != "" {
+				links = append(links, n)
+			}
+		}
+	})
+
+	// refs are the references to each note, in order.
+	refs := make(map[*html.Node][]*html.Node)
+	var notes []*html.Node
+	for _, a := range links {
+		id, ok := pageAnchor(attrValue(a, "href"), page)
+		if !ok || ids[id] == nil {
+			continue
+		}
+		setAttr(a, "href", "#"+id)
+
+		target := ids[id]
+		if order[target] < order[a] || isHeading(target) {
+			continue
+		}
+		text := strings.TrimSpace(nodeText(a))
+		if !inSup(a) && !footnoteMarker.MatchString(text) {
+			continue
+		}
+
+		note := target
+		for p := target; p != nil && p != body; p = p.Parent {
+			if p.DataAtom == atom.Li {
+				note = p
+				break
+			}
+		}
+		if refs[note] == nil {
+			notes = append(notes, note)
+		}
+		refs[note] = append(refs[note], a)
+	}
+
+	used := make(map[string]bool, len(ids))
+	for id := range ids {
+		used[id] = true
+	}
+	for _, note := range notes {
+		// The ids a link back may go to, of the reference or its <sup>.
+		back := make(map[string]bool)
+		for _, a := range refs[note] {
+			if attrValue(a, "id") == "" && (a.Parent == nil || a.Parent.DataAtom != atom.Sup || attrValue(a.Parent, "id") == "") {
+				setAttr(a, "id", uniqueAnchor("fnref-"+anchorName(strings.Trim(nodeText(a), "[] ")), used))
+			}
+			back[attrValue(a, "id")] = true
+			if a.Parent != nil && a.Parent.DataAtom == atom.Sup {
+				back[attrValue(a.Parent, "id")] = true
+			}
+		}
+		delete(back, "")
+
+		hasBack := false
+		walkElements(note, func(n *html.Node) {
+			if n.DataAtom != atom.A {
+				return
+			}
+			if back[strings.TrimPrefix(attrValue(n, "href"), "#")] {
+				hasBack = true
+			}
+		})
+
+		title := excerpt(strings.Join(strings.Fields(noteText(note, back)), " "), footnoteTitleLen)
+		for _, a := range refs[note] {
+			addClass(a, "footnote-ref")
+			if title != "" && attrValue(a, "title") == "" {
+				setAttr(a, "title", title)
+			}
+		}
+
+		if !hasBack {
+			ref := refs[note][0]
+			id := attrValue(ref, "id")
+			if id == "" {
+				id = attrValue(ref.Parent, "id")
+			}
+			note.AppendChild(&html.Node{Type: html.TextNode, Data: " "})
+			link := &html.Node{Type: html.ElementNode, Data: "a", DataAtom: atom.A, Attr: []html.Attribute{
+				{Key: "href", Val: "#" + id},
+				{Key: "class", Val: "footnote-back"},
+				{Key: "title", Val: "Back to the text"},
+			}}
+			link.AppendChild(&html.Node{Type: html.TextNode, Data: "↩"})
+			note.AppendChild(link)
+		}
+	}
+
+	var sb strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&sb, c); err != nil {
+			return content
+		}
+	}
+
+	return sb.String()
+}
+
+// pageAnchor returns the fragment href links to when it's on the page
+// itself, relative or absolute.
+func pageAnchor(href string, page *url.URL) (string, bool) {
+	if strings.HasPrefix(href, "#") {
+		return href[1:], len(href) > 1
+	}
+
+	u, err := url.Parse(href)
+	if err != nil || u.Fragment == "" {
+		return "", false
+	}
+	u = page.ResolveReference(u)
+	if !strings.EqualFold(u.Host, page.Host) || strings.TrimSuffix(u.Path, "/") != strings.TrimSuffix(page.Path, "/") || u.RawQuery != page.RawQuery {
+		return "", false
+	}
+
+	return u.Fragment, true
+}
+
+// noteText is the text of note without its links back to back.
+func noteText(n *html.Node, back map[string]bool) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	if n.DataAtom == atom.A && back[strings.TrimPrefix(attrValue(n, "href"), "#")] {
+		return ""
+	}
+
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(noteText(c, back))
+	}
+
+	return sb.String()
+}
+
+func inSup(n *html.Node) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.DataAtom == atom.Sup {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isHeading(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		return true
+	}
+
+	return false
+}
+
+// addClass adds class to those of n, unless it has it.
+func addClass(n *html.Node, class string) {
+	classes := strings.Fields(attrValue(n, "class"))
+	for _, c := range classes {
+		if c == class {
+			return
+		}
+	}
+	setAttr(n, "class", strings.Join(append(classes, class), " "))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	// FETCH_USER_AGENT is sent with every outbound request, many sites
+	// turn away Go's default.
+	FETCH_USER_AGENT = envOr("FETCH_USER_AGENT", "Mozilla/5.0 (compatible; Readability/1.0; +https://github.com/abcdlsj/share/tree/master/go/readability)")
+	// FETCH_HEADERS_FILE is a JSON object of domain to headers sent to it,
+	// e.g. {"*": {"Accept-Language": "en"}, "example.com": {"Referer": "https://example.com/"}}.
+	// A domain covers its subdomains, "*" every domain, and the most
+	// specific domain's headers win.
+	FETCH_HEADERS_FILE = envOr("FETCH_HEADERS_FILE", "")
+
+	domainHeaders = loadDomainHeaders(FETCH_HEADERS_FILE)
+)
+
+func loadDomainHeaders(path string) map[string]map[string]string {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error("failed to read fetch headers", "err", err)
+		return nil
+	}
+
+	var headers map[string]map[string]string
+	if err := json.Unmarshal(data, &headers); err != nil {
+		slog.Error("failed to parse fetch headers", "path", path, "err", err)
+		return nil
+	}
+
+	normalized := make(map[string]map[string]string, len(headers))
+	for domain, set := range headers {
+		normalized[strings.ToLower(strings.TrimPrefix(domain, "."))] = set
+	}
+
+	return normalized
+}
+
+// headersFor returns the headers to send to host, from "*" up to the host
+// itself so more specific domains override.
+func headersFor(host string) http.Header {
+	h := http.Header{}
+	h.Set("User-Agent", FETCH_USER_AGENT)
+
+	for k, v := range domainHeaders["*"] {
+		h.Set(k, v)
+	}
+
+	labels := strings.Split(strings.ToLower(host), ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		for k, v := range domainHeaders[strings.Join(labels[i:], ".")] {
+			h.Set(k, v)
+		}
+	}
+
+	return h
+}
+
+// headerTransport adds the configured headers and credentials to every
+// request it sends, redirects included, once FETCH_RATE lets it through.
+type headerTransport struct {
+	base http.RoundTripper
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := waitForHost(req.Context(), req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	for k, v := range headersFor(req.URL.Hostname()) {
+		req.Header[k] = v
+	}
+
+	// Set per hop, so a redirect elsewhere doesn't carry them along.
+	if c, ok := credentialFor(req.URL); ok {
+		if c.Cookie != "" {
+			req.Header.Set("Cookie", c.Cookie)
+		}
+		if c.Authorization != "" {
+			req.Header.Set("Authorization", c.Authorization)
+		}
+	}
+
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+var startedAt = time.Now()
+
+// healthzHandler answers as long as the process serves requests, for a
+// liveness probe.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+		"uptime": time.Since(startedAt).Round(time.Second).String(),
+	})
+}
+
+// readyzHandler answers 200 when the storage can be reached and every page
+// template is parsed, 503 otherwise or once shutting down, for a readiness
+// probe.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{
+		"storage":   "ok",
+		"templates": "ok",
+	}
+
+	ready := true
+	if shuttingDown.Load() {
+		checks["server"] = "shutting down"
+		ready = false
+	}
+	if err := store.Ping(); err != nil {
+		checks["storage"] = err.Error()
+		ready = false
+	}
+	if err := templatesParsed(); err != nil {
+		checks["templates"] = err.Error()
+		ready = false
+	}
+
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// templatesParsed checks every embedded page has its template.
+func templatesParsed() error {
+	names, err := fs.Glob(tmplFiles, "*.html")
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if tmpl.Lookup(name) == nil {
+			return fmt.Errorf("%s is not parsed", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	// HIGHLIGHT_STYLE is the chroma style code blocks are colored with,
+	// "none" turns highlighting off.
+	HIGHLIGHT_STYLE = envOr("HIGHLIGHT_STYLE", "github")
+	// HIGHLIGHT_CACHE_SIZE is how many highlighted articles are kept.
+	HIGHLIGHT_CACHE_SIZE = envInt("HIGHLIGHT_CACHE_SIZE", 256)
+
+	highlightFormatter = chromahtml.New(chromahtml.TabWidth(4))
+
+	highlightMu    sync.Mutex
+	highlightCache = make(map[[sha256.Size]byte]string)
+)
+
+// highlighted returns content with its code blocks highlighted, from the
+// cache when the same content was highlighted before.
+func highlighted(content string) string {
+	if HIGHLIGHT_STYLE == "none" || !strings.Contains(content, "<pre") {
+		return content
+	}
+
+	sum := sha256.Sum256([]byte(content))
+
+	highlightMu.Lock()
+	out, ok := highlightCache[sum]
+	highlightMu.Unlock()
+	if ok {
+		return out
+	}
+
+	out = highlightCode(content)
+
+	highlightMu.Lock()
+	if len(highlightCache) >= HIGHLIGHT_CACHE_SIZE {
+		highlightCache = make(map[[sha256.Size]byte]string)
+	}
+	highlightCache[sum] = out
+	highlightMu.Unlock()
+
+	return out
+}
+
+// highlightCode replaces every <pre> in content whose language is known,
+// from its class or by guessing, with chroma's highlighted version.
+func highlightCode(content string) string {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(content), body)
+	if err != nil {
+		return content
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+
+	var pres []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Pre {
+			pres = append(pres, n)
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(body)
+
+	style := styles.Get(HIGHLIGHT_STYLE)
+	for _, pre := range pres {
+		code := nodeText(pre)
+		lexer := codeLexer(pre, code)
+		if lexer == nil {
+			continue
+		}
+
+		it, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+		if err != nil {
+			continue
+		}
+		var sb strings.Builder
+		if err := highlightFormatter.Format(&sb, style, it); err != nil {
+			continue
+		}
+
+		repl, err := html.ParseFragment(strings.NewReader(sb.String()), pre.Parent)
+		if err != nil {
+			continue
+		}
+		for _, n := range repl {
+			pre.Parent.InsertBefore(n, pre)
+		}
+		pre.Parent.RemoveChild(pre)
+	}
+
+	var sb strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&sb, c); err != nil {
+			return content
+		}
+	}
+
+	return sb.String()
+}
+
+// codeLexer picks the lexer from a language-, lang- or highlight-source-
+// class on the <pre> or its <code>, or a bare language name as class, and
+// guesses from the code when there's none.
+func codeLexer(pre *html.Node, code string) chroma.Lexer {
+	nodes := []*html.Node{pre}
+	for c := pre.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Code {
+			nodes = append(nodes, c)
+		}
+	}
+
+	for _, n := range nodes {
+		for _, class := range strings.Fields(attrValue(n, "class")) {
+			name := strings.ToLower(class)
+			for _, prefix := range []string{"language-", "lang-", "highlight-source-", "highlight-"} {
+				name = strings.TrimPrefix(name, prefix)
+			}
+			if lexer := lexers.Get(name); lexer != nil {
+				return lexer
+			}
+		}
+	}
+
+	return lexers.Analyse(code)
+}
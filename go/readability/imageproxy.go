@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/image/draw"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	// IMAGE_PROXY serves the images of extracted articles from /img/{hash}
+	// so they keep working when the origin blocks hotlinking or goes away.
+	IMAGE_PROXY = envOr("IMAGE_PROXY", "true") != "false"
+	// IMAGE_MAX_BYTES is the largest image the proxy downloads.
+	IMAGE_MAX_BYTES = int64(envInt("IMAGE_MAX_BYTES", 10<<20))
+	// IMAGE_MAX_WIDTH scales down wider JPEG and PNG images before they are
+	// cached, 0 keeps them as they are.
+	IMAGE_MAX_WIDTH = envInt("IMAGE_MAX_WIDTH", 1600)
+)
+
+// cachedImage is an image of a saved article. Its URL is recorded when the
+// article is extracted, the image itself is fetched the first time it is
+// requested.
+type cachedImage struct {
+	URL         string
+	ContentType string
+	Data        []byte
+	FetchedAt   time.Time
+}
+
+func imageHash(uri string) string {
+	sum := sha256.Sum256([]byte(uri))
+	return hex.EncodeToString(sum[:])
+}
+
+// proxyImages points the <img> elements of content at the image proxy,
+// resolving their sources against base. <picture> sources and srcset are
+// dropped, they would load from the origin instead.
+func proxyImages(content, base string) string {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return content
+	}
+
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(content), body)
+	if err != nil {
+		return content
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+
+	var sources []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Img:
+				proxyImage(n, baseURL)
+			case atom.Source:
+				if n.Parent != nil && n.Parent.DataAtom == atom.Picture {
+					sources = append(sources, n)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(body)
+
+	for _, n := range sources {
+		n.Parent.RemoveChild(n)
+	}
+
+	var sb strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&sb, c); err != nil {
+			return content
+		}
+	}
+
+	return sb.String()
+}
+
+func proxyImage(n *html.Node, base *url.URL) {
+	src := strings.TrimSpace(attrValue(n, "src"))
+	if src == "" {
+		// Take the last, usually largest, candidate of a srcset-only image.
+		if candidates := strings.Split(attrValue(n, "srcset"), ","); len(candidates) > 0 {
+			if fields := strings.Fields(candidates[len(candidates)-1]); len(fields) > 0 {
+				src = fields[0]
+			}
+		}
+	}
+
+	ref, err := base.Parse(src)
+	if src == "" || err != nil || (ref.Scheme != "http" && ref.Scheme != "https") {
+		return
+	}
+	uri := ref.String()
+	hash := imageHash(uri)
+
+	if img, err := store.GetImage(hash); err != nil || img == nil {
+		if err := store.SetImage(hash, &cachedImage{URL: uri}); err != nil {
+			slog.Error("failed to record image", "url", uri, "err", err)
+			return
+		}
+	}
+
+	attrs := n.Attr[:0]
+	for _, attr := range n.Attr {
+		switch attr.Key {
+		case "src", "srcset", "sizes":
+		default:
+			attrs = append(attrs, attr)
+		}
+	}
+	n.Attr = append(attrs, html.Attribute{Key: "src", Val: "/img/" + hash})
+}
+
+// fetchImage downloads an image, scaled down to IMAGE_MAX_WIDTH.
+func fetchImage(img *cachedImage) error {
+	resp, err := fetchClient.Get(img.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	ct := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(ct, "image/") {
+		return fmt.Errorf("not an image: %s", ct)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, IMAGE_MAX_BYTES+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > IMAGE_MAX_BYTES {
+		return fmt.Errorf("image is larger than %d bytes", IMAGE_MAX_BYTES)
+	}
+
+	img.ContentType = ct
+	img.Data = data
+	img.FetchedAt = time.Now()
+
+	if IMAGE_MAX_WIDTH > 0 {
+		if resized, ct, err := resizeImage(img.Data, IMAGE_MAX_WIDTH); err == nil {
+			img.Data, img.ContentType = resized, ct
+		}
+	}
+
+	return nil
+}
+
+var errNoResize = errors.New("image needs no resizing")
+
+// resizeImage scales a JPEG or PNG down to width, keeping the aspect
+// ratio. Other formats, and images already narrow enough, give errNoResize.
+func resizeImage(data []byte, width int) ([]byte, string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if (format != "jpeg" && format != "png") || cfg.Width <= width {
+		return nil, "", errNoResize
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	height := cfg.Height * width / cfg.Width
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Over, nil)
+
+	var buf bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&buf, dst)
+	} else {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), "image/" + format, nil
+}
+
+// imageHandler serves /img/{hash}, fetching and caching the image on its
+// first request. ?w= scales it down further.
+func imageHandler(w http.ResponseWriter, r *http.Request) {
+	hash := mux.Vars(r)["hash"]
+
+	img, err := getImage(hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if img == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if len(img.Data) == 0 {
+		if err := fetchImage(img); err != nil {
+			slog.Error("failed to fetch image", "url", img.URL, "err", err)
+			http.Error(w, "failed to fetch the image", http.StatusBadGateway)
+			return
+		}
+		if err := setImage(hash, img); err != nil {
+			slog.Error("failed to cache image", "url", img.URL, "err", err)
+		}
+	}
+
+	data, ct := img.Data, img.ContentType
+	if width, err := strconv.Atoi(r.URL.Query().Get("w")); err == nil && width > 0 {
+		if resized, rct, err := resizeImage(data, width); err == nil {
+			data, ct = resized, rct
+		}
+	}
+
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Write(data)
+}
@@ -0,0 +1,647 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	IMPORT_WORKERS  = envInt("IMPORT_WORKERS", 4)
+	IMPORT_INTERVAL = envDuration("IMPORT_INTERVAL", time.Second)
+
+	POCKET_CONSUMER_KEY = envOr("POCKET_CONSUMER_KEY", "")
+
+	pocketClient = &http.Client{Timeout: time.Minute}
+
+	importJobs sync.Map
+)
+
+// importJob extracts a list of URLs in the background, IMPORT_WORKERS at a
+// time and at most one every IMPORT_INTERVAL, so big imports don't hammer
+// the origins. An IMPORT_INTERVAL of 0 doesn't wait between them.
+type importJob struct {
+	mu     sync.Mutex
+	status importStatus
+}
+
+type importStatus struct {
+	ID       string    `json:"id"`
+	Source   string    `json:"source"`
+	Total    int       `json:"total"`
+	Done     int       `json:"done"`
+	Failed   int       `json:"failed"`
+	Errors   []string  `json:"errors"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitempty"`
+}
+
+const importMaxErrors = 50
+
+func startImport(lib *library, source string, urls []string) *importJob {
+	job := &importJob{status: importStatus{
+		ID:      randomID(8),
+		Source:  source,
+		Total:   len(urls),
+		Errors:  []string{},
+		Started: time.Now(),
+	}}
+	importJobs.Store(job.status.ID, job)
+
+	go job.run(lib, urls)
+
+	return job
+}
+
+func (job *importJob) run(lib *library, urls []string) {
+	queue := make(chan string)
+	var tick <-chan time.Time
+	if IMPORT_INTERVAL > 0 {
+		ticker := time.NewTicker(IMPORT_INTERVAL)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < IMPORT_WORKERS; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for uri := range queue {
+				art := readabyFormURL(uri, readOptions{lib: lib})
+				job.record(uri, art.ErrMsg)
+			}
+		}()
+	}
+
+	for _, uri := range urls {
+		if tick != nil {
+			<-tick
+		}
+		queue <- uri
+	}
+	close(queue)
+	wg.Wait()
+
+	job.mu.Lock()
+	job.status.Finished = time.Now()
+	job.mu.Unlock()
+}
+
+func (job *importJob) record(uri, errMsg string) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	job.status.Done++
+	if errMsg != "" {
+		job.status.Failed++
+		if len(job.status.Errors) < importMaxErrors {
+			job.status.Errors = append(job.status.Errors, fmt.Sprintf("%s: %s", uri, errMsg))
+		}
+	}
+}
+
+// Status returns a copy safe to render while the job is running.
+func (job *importJob) Status() importStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	status := job.status
+	status.Errors = append([]string{}, job.status.Errors...)
+
+	return status
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// uniqueURLs drops duplicates and anything that isn't an http(s) URL.
+func uniqueURLs(urls []string) []string {
+	seen := make(map[string]bool, len(urls))
+	out := make([]string, 0, len(urls))
+
+	for _, uri := range urls {
+		uri = strings.TrimSpace(uri)
+		u, err := url.Parse(uri)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || seen[uri] {
+			continue
+		}
+		seen[uri] = true
+		out = append(out, uri)
+	}
+
+	return out
+}
+
+// pocketExportURLs reads the links out of the HTML file Pocket exports,
+// a plain list of <a href> elements.
+func pocketExportURLs(r io.Reader) ([]string, error) {
+	urls, err := htmlLinks(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pocket lists newest first, import oldest first so recents keep its order.
+	for i, j := 0, len(urls)-1; i < j; i, j = i+1, j-1 {
+		urls[i], urls[j] = urls[j], urls[i]
+	}
+
+	return urls, nil
+}
+
+// bookmarksURLs reads a browser bookmarks export (Netscape bookmark HTML)
+// or an OPML outline.
+func bookmarksURLs(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	head := data
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	if bytes.Contains(bytes.ToLower(head), []byte("<opml")) {
+		return opmlURLs(bytes.NewReader(data))
+	}
+
+	return htmlLinks(bytes.NewReader(data))
+}
+
+// opmlURLs collects the page URL of every outline, the url attribute of
+// link outlines or htmlUrl of feed subscriptions.
+func opmlURLs(r io.Reader) ([]string, error) {
+	var urls []string
+
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return urls, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		el, ok := tok.(xml.StartElement)
+		if !ok || !strings.EqualFold(el.Name.Local, "outline") {
+			continue
+		}
+
+		attrs := make(map[string]string, len(el.Attr))
+		for _, attr := range el.Attr {
+			attrs[strings.ToLower(attr.Name.Local)] = attr.Value
+		}
+		if uri := attrs["url"]; uri != "" {
+			urls = append(urls, uri)
+		} else if uri := attrs["htmlurl"]; uri != "" {
+			urls = append(urls, uri)
+		}
+	}
+}
+
+// htmlLinks returns the href of every <a> in the document.
+func htmlLinks(r io.Reader) ([]string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var urls []string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.A {
+			for _, attr := range n.Attr {
+				if attr.Key == "href" {
+					urls = append(urls, attr.Val)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	return urls, nil
+}
+
+// pocketAPIURLs lists every saved item through the Pocket v3 API.
+func pocketAPIURLs(token string) ([]string, error) {
+	if POCKET_CONSUMER_KEY == "" {
+		return nil, fmt.Errorf("POCKET_CONSUMER_KEY is not set")
+	}
+
+	resp, err := pocketClient.PostForm("https://getpocket.com/v3/get", url.Values{
+		"consumer_key": {POCKET_CONSUMER_KEY},
+		"access_token": {token},
+		"state":        {"all"},
+		"detailType":   {"simple"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("pocket api: %s, %s", resp.Status, resp.Header.Get("X-Error"))
+	}
+
+	var data struct {
+		List map[string]struct {
+			GivenURL    string `json:"given_url"`
+			ResolvedURL string `json:"resolved_url"`
+			TimeAdded   string `json:"time_added"`
+		} `json:"list"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	type item struct {
+		url   string
+		added int64
+	}
+	items := make([]item, 0, len(data.List))
+	for _, it := range data.List {
+		uri := it.ResolvedURL
+		if uri == "" {
+			uri = it.GivenURL
+		}
+		added, _ := strconv.ParseInt(it.TimeAdded, 10, 64)
+		items = append(items, item{uri, added})
+	}
+
+	// Oldest first, so the recents list ends up in Pocket's order.
+	sort.Slice(items, func(i, j int) bool { return items[i].added < items[j].added })
+
+	urls := make([]string, 0, len(items))
+	for _, it := range items {
+		urls = append(urls, it.url)
+	}
+
+	return urls, nil
+}
+
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderImport(w, r, nil, "")
+		return
+	}
+
+	var urls []string
+	var err error
+
+	source := r.FormValue("source")
+	switch source {
+	case "pocket":
+		if token := r.FormValue("token"); token != "" {
+			urls, err = pocketAPIURLs(token)
+			break
+		}
+
+		file, _, ferr := r.FormFile("file")
+		if ferr != nil {
+			err = fmt.Errorf("upload a Pocket export or enter an access token")
+			break
+		}
+		defer file.Close()
+		urls, err = pocketExportURLs(file)
+	case "readability":
+		// It replaces articles, only the library's owner may.
+		if !signedIn(r) {
+			http.Error(w, "sign in to import a library export", http.StatusForbidden)
+			return
+		}
+		file, _, ferr := r.FormFile("file")
+		if ferr != nil {
+			renderImport(w, r, nil, "upload a Readability export")
+			return
+		}
+		defer file.Close()
+
+		// Nothing to fetch, it's imported at once.
+		status, err := libraryFor(r).importArticles(file)
+		if err != nil {
+			renderImport(w, r, nil, err.Error())
+			return
+		}
+		storeImport(status)
+		http.Redirect(w, r, "/import/"+status.ID, http.StatusSeeOther)
+		return
+	case "bookmarks":
+		file, _, ferr := r.FormFile("file")
+		if ferr != nil {
+			err = fmt.Errorf("upload a bookmarks or OPML file")
+			break
+		}
+		defer file.Close()
+		urls, err = bookmarksURLs(file)
+	default:
+		err = fmt.Errorf("unknown import source: %s", source)
+	}
+
+	if err == nil {
+		if urls = uniqueURLs(urls); len(urls) == 0 {
+			err = fmt.Errorf("no URLs found")
+		}
+	}
+
+	if err != nil {
+		renderImport(w, r, nil, err.Error())
+		return
+	}
+	// abuseGuard counted the first.
+	if !chargeReads(w, r, len(urls)-1) {
+		return
+	}
+
+	job := startImport(libraryFor(r), source, urls)
+	http.Redirect(w, r, "/import/"+job.status.ID, http.StatusSeeOther)
+}
+
+func importStatusHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := importJobs.Load(mux.Vars(r)["id"])
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	status := job.(*importJob).Status()
+	renderImport(w, r, &status, "")
+}
+
+var apiImportStatusOp = apiOperation{
+	Summary:  "Get an import's progress",
+	Tag:      "jobs",
+	Response: importStatus{},
+	Errors:   []int{http.StatusNotFound},
+}
+
+func apiImportStatusHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := importJobs.Load(mux.Vars(r)["id"])
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "import not found"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job.(*importJob).Status())
+}
+
+// recentImports lists every import since startup, newest first.
+func recentImports() []importStatus {
+	var jobs []importStatus
+	importJobs.Range(func(_, job interface{}) bool {
+		jobs = append(jobs, job.(*importJob).Status())
+		return true
+	})
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Started.After(jobs[j].Started) })
+
+	return jobs
+}
+
+func renderImport(w http.ResponseWriter, r *http.Request, job *importStatus, errMsg string) {
+	executePage(w, r, "import.html", map[string]interface{}{
+		"Job":      job,
+		"Imports":  recentImports(),
+		"Error":    errMsg,
+		"SignedIn": signedIn(r),
+	})
+}
+
+// importExportMax bounds a line of a library export, an article with its
+// content.
+const importExportMax = 64 << 20
+
+// importArticles stores the articles of a library export, see
+// exportArticles, replacing those saved already. Lines that can't be read
+// are counted as failed.
+func (lib *library) importArticles(r io.Reader) (importStatus, error) {
+	status := importStatus{ID: randomID(8), Source: "readability", Errors: []string{}, Started: time.Now()}
+
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return status, err
+		}
+		defer zr.Close()
+		br = bufio.NewReader(zr)
+	}
+
+	sc := bufio.NewScanner(br)
+	sc.Buffer(make([]byte, 0, 1<<20), importExportMax)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		status.Total++
+
+		var rec exportedArticle
+		err := json.Unmarshal(sc.Bytes(), &rec)
+		if err == nil {
+			err = lib.importArticle(&rec)
+		}
+		status.Done++
+		if err != nil {
+			status.Failed++
+			if len(status.Errors) < importMaxErrors {
+				status.Errors = append(status.Errors, fmt.Sprintf("line %d: %s", line, err))
+			}
+		}
+	}
+	status.Finished = time.Now()
+
+	return status, sc.Err()
+}
+
+// importArticle stores an article of a library export, then what's
+// attached to it, through the storage's own methods so it's indexed.
+func (lib *library) importArticle(rec *exportedArticle) error {
+	u, err := url.Parse(rec.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("not an http(s) URL: %q", rec.URL)
+	}
+
+	art := &article{
+		URL:          rec.URL,
+		Title:        rec.Title,
+		Content:      sanitizeContent(rec.Content),
+		CreatedAt:    rec.CreatedAt,
+		Progress:     rec.Progress,
+		Byline:       rec.Byline,
+		SiteName:     rec.SiteName,
+		Excerpt:      rec.Excerpt,
+		Image:        rec.Image,
+		Language:     rec.Language,
+		Extractor:    rec.Extractor,
+		FinalURL:     rec.FinalURL,
+		Canonical:    rec.Canonical,
+		ArchivedFrom: rec.ArchivedFrom,
+		Notes:        rec.Notes,
+		Highlights:   rec.Highlights,
+		Slug:         rec.Slug,
+	}
+	if rec.PublishedAt != nil {
+		art.PublishedAt = *rec.PublishedAt
+	}
+	if rec.ArchivedAt != nil {
+		art.ArchivedAt = *rec.ArchivedAt
+	}
+	if rec.RefreshedAt != nil {
+		art.RefreshedAt = *rec.RefreshedAt
+	}
+	if art.CreatedAt.IsZero() {
+		art.CreatedAt = time.Now()
+	}
+	art.ContentHash = contentHash([]byte(art.Content))
+	art.countWords()
+	if CACHE_TTL > 0 {
+		art.ExpiresAt = time.Now().Add(CACHE_TTL)
+	}
+
+	key := articleKey(art.URL)
+	if old, err := lib.store.GetArticle(key); err != nil {
+		return err
+	} else if old != nil {
+		// Replaced, but by the same storage calls as if new.
+		if err := lib.store.DeleteArticle(key); err != nil {
+			return err
+		}
+	}
+	if art.Slug != "" {
+		if owner, err := lib.store.SlugKey(art.Slug); err != nil {
+			return err
+		} else if owner != "" && owner != key {
+			art.Slug = ""
+		}
+	}
+	if art.Slug == "" {
+		art.Slug = lib.newSlug(key)
+	}
+
+	if err := lib.store.SetArticle(key, art); err != nil {
+		return err
+	}
+	if err := lib.store.SetSlug(art.Slug, key); err != nil {
+		return err
+	}
+	// The URL redirected from still finds it.
+	if art.FinalURL != "" && art.FinalURL != art.URL {
+		if err := lib.store.SetAlias(articleKey(art.FinalURL), key); err != nil {
+			return err
+		}
+	}
+	if len(rec.Tags) > 0 {
+		if err := lib.store.SetTags(key, rec.Tags); err != nil {
+			return err
+		}
+		art.Tags = rec.Tags
+	}
+	if rec.Starred {
+		if err := lib.store.SetStarred(key, true); err != nil {
+			return err
+		}
+		art.Starred = true
+	}
+	if rec.Watched {
+		if err := lib.store.SetWatched(key, true); err != nil {
+			return err
+		}
+	}
+	if rec.Private {
+		if err := lib.store.SetPrivate(key, true); err != nil {
+			return err
+		}
+		art.Private = true
+	}
+	lib.index.Add(key, art)
+
+	return nil
+}
+
+var apiImportOp = apiOperation{
+	Summary:     "Import a library export",
+	Description: "Stores the articles of a library export, as /api/v1/export writes it, gzipped or not, replacing those saved already. Nothing is fetched. Needs an API token or a session.",
+	Tag:         "library",
+	Body:        exportedArticle{},
+	Response:    importStatus{},
+	Errors:      []int{http.StatusBadRequest, http.StatusUnauthorized},
+}
+
+func apiImportHandler(w http.ResponseWriter, r *http.Request) {
+	if !signedIn(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "sign in or use an API token to import a library export"})
+		return
+	}
+	status, err := libraryFor(r).importArticles(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	storeImport(status)
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// storeImport keeps a finished import with the others, for /import.
+func storeImport(status importStatus) {
+	importJobs.Store(status.ID, &importJob{status: status})
+}
+
+// importCommand imports a library export from a file, or stdin:
+//
+//	readability import [-user name] [file]
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	name := fs.String("user", "", "the user whose library to import into, the instance's when empty")
+	fs.Parse(args)
+
+	lib, err := commandLibrary(*name)
+	if err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if path := fs.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	status, err := lib.importArticles(in)
+	fmt.Fprintf(os.Stderr, "imported %d articles, %d failed\n", status.Done-status.Failed, status.Failed)
+	for _, e := range status.Errors {
+		fmt.Fprintln(os.Stderr, e)
+	}
+
+	return err
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// SLACK_SIGNING_SECRET enables /integrations/slack for a slash
+	// command, DISCORD_PUBLIC_KEY /integrations/discord as the
+	// interactions endpoint of a Discord application.
+	SLACK_SIGNING_SECRET = envOr("SLACK_SIGNING_SECRET", "")
+	DISCORD_PUBLIC_KEY   = envOr("DISCORD_PUBLIC_KEY", "")
+	DISCORD_API_URL      = envOr("DISCORD_API_URL", "https://discord.com/api/v10")
+
+	// Replies go to Slack and Discord, not to the pages being read.
+	integrationClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// slackMaxAge is how old a signed Slack request may be, to stop replays.
+const slackMaxAge = 5 * time.Minute
+
+// permalink is the absolute link to a saved article on base.
+func permalink(base string, art *article) string {
+	if art.Slug != "" {
+		return base + "/a/" + art.Slug
+	}
+
+	return base + articlePath("read", art.URL)
+}
+
+// articleSummary is the title, byline, reading time and excerpt of art,
+// with title formatting the linked title in the chat's markup.
+func articleSummary(art *article, link string, title func(text, link string) string) string {
+	name := art.Title
+	if name == "" {
+		name = art.URL
+	}
+
+	lines := []string{title(name, link)}
+
+	var about []string
+	if art.Byline != "" {
+		about = append(about, art.Byline)
+	}
+	if art.SiteName != "" {
+		about = append(about, art.SiteName)
+	}
+	if art.ReadingTime > 0 {
+		about = append(about, fmt.Sprintf("%d min read", art.ReadingTime))
+	}
+	if len(about) > 0 {
+		lines = append(lines, strings.Join(about, " · "))
+	}
+
+	text := art.Excerpt
+	if text == "" {
+		text = excerpt(htmlText(art.Content), 200)
+	}
+	if text != "" {
+		lines = append(lines, text)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// readForChat reads the first URL in text, returning the summary or why
+// it couldn't be read.
+func readForChat(text, base string, title func(text, link string) string) string {
+	uri := messageURL.FindString(text)
+	if uri == "" {
+		return "Give me a link to read."
+	}
+
+	art := readabyFormURL(uri, readOptions{})
+	if art.ErrMsg != "" {
+		return fmt.Sprintf("Couldn't read %s: %s", uri, art.ErrMsg)
+	}
+
+	return articleSummary(art, permalink(base, art), title)
+}
+
+// readSignedBody reads the body of an integration request, limited so
+// signing can't be made to hash anything large.
+func readSignedBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	return io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+}
+
+func verifySlack(r *http.Request, body []byte) bool {
+	ts, err := strconv.ParseInt(r.Header.Get("X-Slack-Request-Timestamp"), 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > slackMaxAge || age < -slackMaxAge {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(SLACK_SIGNING_SECRET))
+	fmt.Fprintf(mac, "v0:%d:%s", ts, body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature")))
+}
+
+// slackHandler answers a slash command right away, as Slack waits only
+// three seconds, and posts the summary to its response_url once read.
+func slackHandler(w http.ResponseWriter, r *http.Request) {
+	if SLACK_SIGNING_SECRET == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := readSignedBody(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !verifySlack(r, body) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	text, responseURL, base := form.Get("text"), form.Get("response_url"), baseURL(r)
+	go func() {
+		summary := readForChat(text, base, func(text, link string) string {
+			return fmt.Sprintf("*<%s|%s>*", link, slackEscape(text))
+		})
+		postJSON(responseURL, http.MethodPost, map[string]string{
+			"response_type": "in_channel",
+			"text":          summary,
+		})
+	}()
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"response_type": "ephemeral",
+		"text":          "Reading…",
+	})
+}
+
+// slackEscape escapes the characters Slack's mrkdwn gives a meaning.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func verifyDiscord(r *http.Request, body []byte) bool {
+	key, err := hex.DecodeString(DISCORD_PUBLIC_KEY)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		slog.Warn("DISCORD_PUBLIC_KEY is not a hex ed25519 public key")
+		return false
+	}
+	sig, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	if err != nil {
+		return false
+	}
+
+	msg := append([]byte(r.Header.Get("X-Signature-Timestamp")), body...)
+
+	return ed25519.Verify(ed25519.PublicKey(key), msg, sig)
+}
+
+// Discord interaction and response types.
+const (
+	discordPing               = 1
+	discordCommand            = 2
+	discordPong               = 1
+	discordDeferredWithSource = 5
+)
+
+type discordInteraction struct {
+	Type          int    `json:"type"`
+	Token         string `json:"token"`
+	ApplicationID string `json:"application_id"`
+	Data          struct {
+		Options []struct {
+			Value interface{} `json:"value"`
+		} `json:"options"`
+	} `json:"data"`
+}
+
+// discordHandler answers a command with a deferred response, Discord waits
+// three seconds too, and edits it to the summary once read.
+func discordHandler(w http.ResponseWriter, r *http.Request) {
+	if DISCORD_PUBLIC_KEY == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := readSignedBody(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !verifyDiscord(r, body) {
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return
+	}
+
+	var in discordInteraction
+	if err := json.Unmarshal(body, &in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch in.Type {
+	case discordPing:
+		writeJSON(w, http.StatusOK, map[string]int{"type": discordPong})
+	case discordCommand:
+		var text string
+		for _, opt := range in.Data.Options {
+			if s, ok := opt.Value.(string); ok {
+				text += " " + s
+			}
+		}
+
+		base := baseURL(r)
+		go func() {
+			summary := readForChat(text, base, func(text, link string) string {
+				return fmt.Sprintf("**[%s](%s)**", text, link)
+			})
+			postJSON(fmt.Sprintf("%s/webhooks/%s/%s/messages/@original", DISCORD_API_URL, in.ApplicationID, in.Token),
+				http.MethodPatch, map[string]string{"content": summary})
+		}()
+
+		writeJSON(w, http.StatusOK, map[string]int{"type": discordDeferredWithSource})
+	default:
+		http.Error(w, "unsupported interaction", http.StatusBadRequest)
+	}
+}
+
+// postJSON sends v to uri, logging when it fails.
+func postJSON(uri, method string, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("failed to encode reply", "err", err)
+		return
+	}
+
+	req, err := http.NewRequest(method, uri, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("failed to reply", "url", uri, "err", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := integrationClient.Do(req)
+	if err != nil {
+		slog.Error("failed to reply", "url", uri, "err", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		slog.Error("failed to reply", "url", uri, "status", resp.StatusCode)
+	}
+}
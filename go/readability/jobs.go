@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	// JOB_TTL is how long a finished extraction job can still be looked
+	// up.
+	JOB_TTL = envDuration("JOB_TTL", time.Hour)
+
+	extractJobs sync.Map
+)
+
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// extractJob reads one URL in the background for POST /api/v1/jobs, so
+// slow pages don't hold a request open.
+type extractJob struct {
+	mu     sync.Mutex
+	status jobStatus
+	// changed is closed, and replaced, whenever the status changes.
+	changed chan struct{}
+}
+
+type jobStatus struct {
+	ID    string `json:"id"`
+	URL   string `json:"url"`
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+	// Article is the path of the article once the job is done.
+	Article  string     `json:"article,omitempty"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+func startExtractJob(uri string, opts readOptions) *extractJob {
+	pruneExtractJobs()
+
+	job := &extractJob{status: jobStatus{
+		ID:      randomID(8),
+		URL:     uri,
+		State:   jobQueued,
+		Created: time.Now(),
+	}, changed: make(chan struct{})}
+	extractJobs.Store(job.status.ID, job)
+
+	// The job outlives the request, its logs keep the request ID.
+	opts.ctx = context.WithoutCancel(opts.context())
+	go job.run(opts)
+
+	return job
+}
+
+func (job *extractJob) run(opts readOptions) {
+	job.mu.Lock()
+	job.status.State = jobRunning
+	uri := job.status.URL
+	job.notify()
+	job.mu.Unlock()
+
+	art := readabyFormURL(uri, opts)
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	defer job.notify()
+
+	finished := time.Now()
+	job.status.Finished = &finished
+	if art.ErrMsg != "" {
+		job.status.State, job.status.Error = jobFailed, art.ErrMsg
+		return
+	}
+	job.status.State, job.status.Article = jobDone, articlePath("read", art.URL)
+}
+
+// notify wakes up whoever waits on the job, job.mu must be held.
+func (job *extractJob) notify() {
+	close(job.changed)
+	job.changed = make(chan struct{})
+}
+
+// Watch returns the status with a channel closed on its next change.
+func (job *extractJob) Watch() (jobStatus, <-chan struct{}) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	return job.status, job.changed
+}
+
+// Over reports whether the job is done or failed.
+func (s jobStatus) Over() bool {
+	return s.State == jobDone || s.State == jobFailed
+}
+
+// Status returns a copy safe to use while the job is running.
+func (job *extractJob) Status() jobStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	return job.status
+}
+
+// pruneExtractJobs forgets the jobs finished over JOB_TTL ago.
+func pruneExtractJobs() {
+	extractJobs.Range(func(id, job interface{}) bool {
+		status := job.(*extractJob).Status()
+		if status.Finished != nil && time.Since(*status.Finished) > JOB_TTL {
+			extractJobs.Delete(id)
+		}
+		return true
+	})
+}
+
+type jobRequest struct {
+	URL     string `json:"url"`
+	Refresh bool   `json:"refresh,omitempty"`
+}
+
+var (
+	apiCreateJobOp = apiOperation{
+		Summary:     "Read an article in the background",
+		Description: "Queues a job reading url and answers right away with it, and its Location to poll. The body may be a form with the same fields too.",
+		Tag:         "jobs",
+		Body:        jobRequest{},
+		Status:      http.StatusAccepted,
+		Response:    jobStatus{},
+		Errors:      []int{http.StatusBadRequest},
+	}
+	apiJobOp = apiOperation{
+		Summary:  "Get a job",
+		Tag:      "jobs",
+		Query:    []apiParam{{Name: "redirect", Description: "1 redirects to the article once the job is done."}},
+		Response: jobStatus{},
+		Errors:   []int{http.StatusNotFound},
+	}
+)
+
+// apiCreateJobHandler takes the URL to read as JSON, {"url": ..., "refresh":
+// true}, or a form and answers 202 with the job to poll.
+func apiCreateJobHandler(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	} else {
+		req.URL = r.FormValue("url")
+		req.Refresh = r.FormValue("refresh") == "1" || r.FormValue("refresh") == "true"
+	}
+
+	uri := normalizeURL(req.URL)
+	if err := checkURL(uri); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	job := startExtractJob(uri, readOptions{Refresh: req.Refresh, lib: libraryFor(r), ctx: r.Context()})
+
+	w.Header().Set("Location", "/api/v1/jobs/"+job.status.ID)
+	writeJSON(w, http.StatusAccepted, job.Status())
+}
+
+// apiJobHandler reports a job. With ?redirect=1 a finished job redirects
+// to its article instead.
+func apiJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := extractJobs.Load(mux.Vars(r)["id"])
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+		return
+	}
+
+	status := job.(*extractJob).Status()
+	if status.State == jobDone && r.URL.Query().Get("redirect") == "1" {
+		http.Redirect(w, r, status.Article, http.StatusSeeOther)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// BATCH_MAX_URLS caps the URLs of one POST /api/v1/batch.
+var BATCH_MAX_URLS = envInt("BATCH_MAX_URLS", 500)
+
+var batches sync.Map
+
+// batchStatus is a batch of jobs in the order of its URLs, those that
+// couldn't be queued as failed jobs without an ID.
+type batchStatus struct {
+	ID   string      `json:"id"`
+	Jobs []jobStatus `json:"jobs"`
+}
+
+type batch struct {
+	id      string
+	created time.Time
+	// jobs has a nil job for the URLs refused before they got one, with
+	// their status in rejected.
+	jobs     []*extractJob
+	rejected map[int]jobStatus
+}
+
+func (b *batch) Status() batchStatus {
+	status := batchStatus{ID: b.id, Jobs: make([]jobStatus, 0, len(b.jobs))}
+	for i, job := range b.jobs {
+		if job == nil {
+			status.Jobs = append(status.Jobs, b.rejected[i])
+			continue
+		}
+		status.Jobs = append(status.Jobs, job.Status())
+	}
+
+	return status
+}
+
+type batchRequest struct {
+	URLs    []string `json:"urls"`
+	Refresh bool     `json:"refresh,omitempty"`
+}
+
+var (
+	apiBatchOp = apiOperation{
+		Summary:     "Read articles in the background",
+		Description: "Queues a job for every URL, those that can't be read failing right away.",
+		Tag:         "jobs",
+		Body:        batchRequest{},
+		Status:      http.StatusAccepted,
+		Response:    batchStatus{},
+		Errors:      []int{http.StatusBadRequest},
+	}
+	apiBatchStatusOp = apiOperation{
+		Summary:  "Get the jobs of a batch",
+		Tag:      "jobs",
+		Response: batchStatus{},
+		Errors:   []int{http.StatusNotFound},
+	}
+)
+
+// apiBatchHandler queues a job for every URL of {"urls": [...], "refresh":
+// true} and answers 202 with their statuses, GET /api/v1/batch/{id} has
+// them as they go.
+func apiBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if len(req.URLs) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "urls is empty"})
+		return
+	}
+	if len(req.URLs) > BATCH_MAX_URLS {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("at most %d urls can be sent at once", BATCH_MAX_URLS)})
+		return
+	}
+	// abuseGuard counted the first.
+	if !chargeReads(w, r, len(req.URLs)-1) {
+		return
+	}
+
+	b := &batch{id: randomID(8), created: time.Now(), rejected: make(map[int]jobStatus)}
+	seen := make(map[string]bool, len(req.URLs))
+	for _, raw := range req.URLs {
+		uri := normalizeURL(raw)
+		if seen[uri] {
+			continue
+		}
+		seen[uri] = true
+
+		if err := checkURL(uri); err != nil {
+			b.rejected[len(b.jobs)] = jobStatus{URL: raw, State: jobFailed, Error: err.Error(), Created: b.created}
+			b.jobs = append(b.jobs, nil)
+			continue
+		}
+		b.jobs = append(b.jobs, startExtractJob(uri, readOptions{Refresh: req.Refresh, lib: libraryFor(r), ctx: r.Context()}))
+	}
+
+	pruneBatches()
+	batches.Store(b.id, b)
+
+	w.Header().Set("Location", "/api/v1/batch/"+b.id)
+	writeJSON(w, http.StatusAccepted, b.Status())
+}
+
+func apiBatchStatusHandler(w http.ResponseWriter, r *http.Request) {
+	b, ok := batches.Load(mux.Vars(r)["id"])
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "batch not found"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, b.(*batch).Status())
+}
+
+// pruneBatches forgets the batches created over JOB_TTL ago whose jobs are
+// all over.
+func pruneBatches() {
+	batches.Range(func(id, b interface{}) bool {
+		if time.Since(b.(*batch).created) < JOB_TTL {
+			return true
+		}
+		for _, job := range b.(*batch).jobs {
+			if job != nil && !job.Status().Over() {
+				return true
+			}
+		}
+		batches.Delete(id)
+		return true
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+// articleKey is the storage key of the article at uri, the SHA-256 of its
+// normalized form, so keys have a fixed size and charset whatever the URL
+// contains.
+func articleKey(uri string) string {
+	sum := sha256.Sum256([]byte(normalizeURL(uri)))
+	return hex.EncodeToString(sum[:])
+}
+
+func isArticleKey(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// resolveKey returns the key the article at uri is stored under, following
+// the alias left when it was saved under its canonical URL.
+func (lib *library) resolveKey(uri string) string {
+	key := articleKey(uri)
+
+	alias, err := lib.store.Alias(key)
+	if err != nil {
+		slog.Error("failed to get alias", "url", uri, "err", err)
+	}
+	if alias != "" {
+		return alias
+	}
+
+	return key
+}
+
+// articleURL returns the URL stored under key. Articles saved before keys
+// were hashed are stored under their URL.
+func (lib *library) articleURL(key string) string {
+	uri, err := lib.store.ArticleURL(key)
+	if err != nil {
+		slog.Error("failed to get article url", "key", key, "err", err)
+	}
+	if uri == "" && !isArticleKey(key) {
+		return key
+	}
+
+	return uri
+}
+
+// articlePath links to the article at uri under prefix, e.g. /read/, with
+// the URL base64 encoded into a single path segment.
+func articlePath(prefix, uri string) string {
+	return "/" + prefix + "/" + base64.RawURLEncoding.EncodeToString([]byte(uri))
+}
+
+// decodePathURL reverses articlePath for a path segment, ok is false when
+// it isn't a base64 encoded http(s) URL.
+func decodePathURL(segment string) (string, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return "", false
+	}
+
+	u, err := url.Parse(string(data))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+
+	return string(data), true
+}
+
+// migrateLegacyArticle moves an article stored under its URL, as keys were
+// before they were hashed, to key. It returns nil when there is none.
+func (lib *library) migrateLegacyArticle(ctx context.Context, uri, key string) *article {
+	art, err := lib.store.GetArticle(uri)
+	if err != nil || art == nil {
+		return nil
+	}
+
+	if err := lib.setArticleToCache(ctx, key, art); err != nil {
+		return art
+	}
+	if len(art.Tags) > 0 {
+		lib.store.SetTags(key, art.Tags)
+	}
+	if art.Starred {
+		lib.setStarred(key, true)
+	}
+	lib.deleteArticle(uri)
+
+	slog.InfoContext(ctx, "migrated article", "url", uri, "key", key)
+
+	return art
+}
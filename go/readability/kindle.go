@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	SMTP_HOST     = envOr("SMTP_HOST", "")
+	SMTP_PORT     = envOr("SMTP_PORT", "587")
+	SMTP_USER     = envOr("SMTP_USER", "")
+	SMTP_PASSWORD = envOr("SMTP_PASSWORD", "")
+	SMTP_FROM     = envOr("SMTP_FROM", SMTP_USER)
+	KINDLE_EMAIL  = envOr("KINDLE_EMAIL", "")
+	// KINDLE_RATE is how many articles an hour one client may send to a
+	// Kindle, signed in or not, 0 doesn't limit them.
+	KINDLE_RATE = envFloat("KINDLE_RATE", 10)
+
+	kindleLimitersMu sync.Mutex
+	kindleLimiters   = make(map[string]*rate.Limiter)
+)
+
+const kindleCookie = "kindle_email"
+
+// kindleEmail returns the Kindle address remembered for this visitor.
+func kindleEmail(r *http.Request) string {
+	if c, err := r.Cookie(kindleCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+
+	return KINDLE_EMAIL
+}
+
+func kindleLimiter(key string) *rate.Limiter {
+	kindleLimitersMu.Lock()
+	defer kindleLimitersMu.Unlock()
+
+	l, ok := kindleLimiters[key]
+	if !ok {
+		if len(kindleLimiters) >= maxHostLimiters {
+			kindleLimiters = make(map[string]*rate.Limiter)
+		}
+		l = rate.NewLimiter(rate.Limit(KINDLE_RATE/3600), max(int(KINDLE_RATE), 1))
+		kindleLimiters[key] = l
+	}
+
+	return l
+}
+
+// kindleHandler converts the article to EPUB and mails it to the Kindle
+// address from the form, remembering the address for next time. Only
+// those signed in may send, so the instance doesn't mail anyone for
+// anyone, abuseGuard limits how often.
+func kindleHandler(w http.ResponseWriter, r *http.Request) {
+	if !signedIn(r) {
+		http.Error(w, "sign in to send articles to a Kindle", http.StatusForbidden)
+		return
+	}
+
+	uri := r.FormValue("url")
+	if uri == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	art := readabyFormURL(uri, readOptions{lib: libraryFor(r), ctx: r.Context()})
+	page := &articlePage{article: art}
+
+	addr, err := mail.ParseAddress(r.FormValue("email"))
+	if err != nil {
+		page.Notice = "Invalid Kindle address: " + err.Error()
+		render(w, r, page)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     kindleCookie,
+		Value:    addr.Address,
+		Path:     "/",
+		Expires:  time.Now().AddDate(1, 0, 0),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if err := sendToKindle(art, addr.Address); err != nil {
+		page.Notice = "Failed to send to Kindle: " + err.Error()
+	} else {
+		page.Notice = "Sent to " + addr.Address
+	}
+
+	render(w, r, page)
+}
+
+func sendToKindle(art *article, to string) error {
+	if art.ErrMsg != "" {
+		return fmt.Errorf("%s", art.ErrMsg)
+	}
+
+	if err := smtpConfigured(); err != nil {
+		return err
+	}
+
+	book := newEpubBook(art.Title)
+	if err := book.AddArticle(art); err != nil {
+		return err
+	}
+
+	var epub bytes.Buffer
+	if err := book.Write(&epub); err != nil {
+		return err
+	}
+
+	msg, err := mailWithAttachment(SMTP_FROM, to, art.Title, art.URL, attachment(art.Title, ".epub"), "application/epub+zip", epub.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return sendMail([]string{to}, msg)
+}
+
+func smtpConfigured() error {
+	if SMTP_HOST == "" || SMTP_FROM == "" {
+		return fmt.Errorf("smtp is not configured, set SMTP_HOST and SMTP_FROM")
+	}
+
+	return nil
+}
+
+// sendMail sends msg from SMTP_FROM through SMTP_HOST.
+func sendMail(to []string, msg []byte) error {
+	var auth smtp.Auth
+	if SMTP_USER != "" {
+		auth = smtp.PlainAuth("", SMTP_USER, SMTP_PASSWORD, SMTP_HOST)
+	}
+
+	return smtp.SendMail(SMTP_HOST+":"+SMTP_PORT, auth, SMTP_FROM, to, msg)
+}
+
+func mailWithAttachment(from, to, subject, text, disposition, contentType string, data []byte) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	part.Write([]byte(text))
+
+	part, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Disposition":       {disposition},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		part.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	part.Write([]byte(encoded))
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	header := []string{
+		"From: " + from,
+		"To: " + to,
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary=" + mw.Boundary(),
+	}
+	msg.WriteString(strings.Join(header, "\r\n") + "\r\n\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
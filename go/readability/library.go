@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// library is the articles someone saved, with their recents, tags, stars
+// and views, and the search index over them. The instance's library is
+// the only one without MULTI_USER, and the one the integrations save to.
+type library struct {
+	// User is the ID of the library's owner, "" for the instance's.
+	User  string
+	store Storage
+	index *searchIndex
+	// public is set on the view of visitors not signed in, see
+	// publicView.
+	public bool
+}
+
+var (
+	instanceLib *library
+
+	librariesMu sync.Mutex
+	libraries   = make(map[string]*library)
+)
+
+type libraryContextKey struct{}
+
+// userLibrary opens the library of the user id, keeping it open.
+func userLibrary(id string) (*library, error) {
+	librariesMu.Lock()
+	defer librariesMu.Unlock()
+
+	if lib, ok := libraries[id]; ok {
+		return lib, nil
+	}
+
+	s, err := newUserStorage(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the library of %s: %w", id, err)
+	}
+
+	lib := &library{User: id, store: s, index: newSearchIndex()}
+	libraries[id] = lib
+	go lib.buildSearchIndex()
+
+	return lib, nil
+}
+
+// allLibraries returns the instance's library and every user's, for the
+// background jobs going through all of them.
+func allLibraries() []*library {
+	libs := []*library{instanceLib}
+
+	users, err := store.Users()
+	if err != nil {
+		slog.Error("failed to list users", "err", err)
+		return libs
+	}
+
+	for _, u := range users {
+		lib, err := userLibrary(u.ID)
+		if err != nil {
+			slog.Error("failed to open library", "user", u.ID, "err", err)
+			continue
+		}
+		libs = append(libs, lib)
+	}
+
+	return libs
+}
+
+// closeLibraries closes the storage of the libraries open, the instance's
+// last.
+func closeLibraries() {
+	librariesMu.Lock()
+	defer librariesMu.Unlock()
+
+	for id, lib := range libraries {
+		if err := lib.store.Close(); err != nil {
+			slog.Error("failed to close library", "user", id, "err", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		slog.Error("failed to close storage", "err", err)
+	}
+}
+
+// newUserStorage opens the storage of a user's library, next to the
+// instance's: a namespace of the same Redis, a database beside
+// SQLITE_PATH, a schema of the same Postgres database or a separate memory
+// cache.
+func newUserStorage(id string) (Storage, error) {
+	return userStorage(store, id)
+}
+
+// userStorage opens the storage of the library of the user id next to
+// base, the instance's storage or the one migrated to.
+func userStorage(base Storage, id string) (Storage, error) {
+	switch s := base.(type) {
+	case *redisStorage:
+		us := *s
+		us.ns = "readability-u:" + id + ":"
+		if err := us.migrateRecents(); err != nil {
+			return nil, err
+		}
+		return &us, nil
+	case *sqliteStorage:
+		ext := filepath.Ext(s.path)
+		return newSQLiteStorage(strings.TrimSuffix(s.path, ext) + "-" + id + ext)
+	case *postgresStorage:
+		return newPostgresStorage(s.dsn, postgresUserSchema(id))
+	case *memoryStorage:
+		return newMemoryStorage(MEMORY_CACHE_SIZE), nil
+	}
+
+	return nil, fmt.Errorf("storage %T has no user libraries", base)
+}
+
+func withLibrary(r *http.Request, lib *library) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), libraryContextKey{}, lib))
+}
+
+// libraryFor returns the library of whoever made r, the instance's without
+// MULTI_USER.
+func libraryFor(r *http.Request) *library {
+	if lib, ok := r.Context().Value(libraryContextKey{}).(*library); ok {
+		return lib
+	}
+	if !signedIn(r) {
+		return instanceLib.publicView()
+	}
+
+	return instanceLib
+}
+
+// library is the library opts read into, the instance's unless set.
+func (opts readOptions) library() *library {
+	if opts.lib != nil {
+		return opts.lib
+	}
+
+	return instanceLib
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// LLM_URL is the base URL of an OpenAI compatible chat completions API,
+	// https://api.openai.com/v1 or a local server's like Ollama's
+	// http://localhost:11434/v1, the features asking a language model are
+	// off without it. LLM_MODEL is the model asked, with LLM_API_KEY.
+	LLM_URL     = strings.TrimSuffix(envOr("LLM_URL", ""), "/")
+	LLM_MODEL   = envOr("LLM_MODEL", "gpt-4o-mini")
+	LLM_API_KEY = envOr("LLM_API_KEY", "")
+	// LLM_TIMEOUT bounds an answer, they take a while for long articles.
+	LLM_TIMEOUT = envDuration("LLM_TIMEOUT", 2*time.Minute)
+
+	llmClient = &http.Client{Timeout: LLM_TIMEOUT}
+)
+
+var errLLMOff = errors.New("no language model, see LLM_URL")
+
+func llmEnabled() bool {
+	return LLM_URL != ""
+}
+
+type llmMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// llmComplete asks the model to answer prompt following the instructions
+// of system.
+func llmComplete(ctx context.Context, system, prompt string) (string, error) {
+	if !llmEnabled() {
+		return "", errLLMOff
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"model": LLM_MODEL,
+		"messages": []llmMessage{
+			{Role: "system", Content: system},
+			{Role: "user", Content: prompt},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, LLM_URL+"/chat/completions", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if LLM_API_KEY != "" {
+		req.Header.Set("Authorization", "Bearer "+LLM_API_KEY)
+	}
+
+	resp, err := llmClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Choices []struct {
+			Message llmMessage `json:"message"`
+		} `json:"choices"`
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil && resp.StatusCode == http.StatusOK {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if msg := errorMessage(out.Error); msg != "" {
+			return "", fmt.Errorf("llm: %s: %s", resp.Status, msg)
+		}
+		return "", fmt.Errorf("llm: %s", resp.Status)
+	}
+	if len(out.Choices) == 0 || strings.TrimSpace(out.Choices[0].Message.Content) == "" {
+		return "", errors.New("llm: empty answer")
+	}
+
+	return strings.TrimSpace(out.Choices[0].Message.Content), nil
+}
+
+// errorMessage is the message of the error of an API's answer, either
+// "error": "message" or "error": {"message": "message"}.
+func errorMessage(raw json.RawMessage) string {
+	var msg string
+	if json.Unmarshal(raw, &msg) == nil {
+		return msg
+	}
+	var obj struct {
+		Message string `json:"message"`
+	}
+	json.Unmarshal(raw, &obj)
+
+	return obj.Message
+}
+
+// truncateRunes is s cut to n runes.
+func truncateRunes(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+
+	return s
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// LOG_FORMAT is text, key=value pairs, or json, one object a line.
+	LOG_FORMAT = envOr("LOG_FORMAT", "text")
+	// LOG_LEVEL is the least level logged, debug, info, warn or error.
+	LOG_LEVEL = envOr("LOG_LEVEL", "info")
+
+	// The logger is set up with the package variables, before the init
+	// functions log.
+	_ = setupLogging()
+)
+
+// requestIDHeader is the header a request ID is taken from when a proxy
+// in front sets one, and sent back in.
+const requestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[0-9A-Za-z._-]{1,64}$`)
+
+type requestIDContextKey struct{}
+
+// quietPaths are polled by probes and scrapers, their requests are logged
+// at debug level.
+var quietPaths = []string{"/healthz", "/readyz", "/metrics"}
+
+func setupLogging() bool {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(LOG_LEVEL)); err != nil {
+		level = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if strings.EqualFold(LOG_FORMAT, "json") {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(contextHandler{h}))
+
+	return true
+}
+
+// contextHandler adds the ID of the request a line is logged for, and its
+// trace, when it is logged with the request's context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// requestID is the ID of the request ctx belongs to, empty outside one.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// context is the context opts are read with, the request's for its logs
+// to carry the request ID.
+func (opts readOptions) context() context.Context {
+	if opts.ctx != nil {
+		return opts.ctx
+	}
+
+	return context.Background()
+}
+
+// statusRecorder keeps the status a handler answered with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// Flush keeps the event streams flowing.
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// requestLogger gives every request an ID, the X-Request-ID it came with
+// or a new one, sends it back and logs the request once it is served.
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = randomID(8)
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDContextKey{}, id)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		for _, path := range quietPaths {
+			if r.URL.Path == path {
+				level = slog.LevelDebug
+			}
+		}
+		slog.Log(ctx, level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.size,
+			"duration", time.Since(start))
+	})
+}
@@ -3,21 +3,21 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"embed"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"html/template"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/go-redis/redis"
-	readability "github.com/go-shiori/go-readability"
 	"github.com/gorilla/mux"
 	"github.com/yuin/goldmark"
 	highlighting "github.com/yuin/goldmark-highlighting"
@@ -25,33 +25,120 @@ import (
 	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer/html"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type article struct {
 	URL     string
 	Title   string
 	Content string
-	ErrMsg  string
+	// ContentHash is the hash of Content, the ETags of the article derive
+	// from it.
+	ContentHash string
+	ErrMsg      string
+	CreatedAt   time.Time
+	Tags        []string
+	Starred     bool
+	// Slug is the short /a/{slug} permalink ID.
+	Slug string
+	// RefreshedAt is when the article was last re-extracted, CreatedAt
+	// stays the time it was first saved.
+	RefreshedAt time.Time
+	// ExpiresAt is when the cached copy goes stale, zero keeps it forever.
+	ExpiresAt time.Time
+	// Progress is how far the article has been read, from 0 to 1.
+	Progress float64
+	// WordCount and ReadingTime, in minutes, are counted on extraction.
+	WordCount   int
+	ReadingTime int
+	// Byline, SiteName, Excerpt, Image, Language and PublishedAt are what
+	// the page says about itself, any of them may be empty.
+	Byline      string
+	SiteName    string
+	Excerpt     string
+	Image       string
+	Language    string
+	PublishedAt time.Time
+	// HasRaw is set when the page as downloaded is stored, raw holds it
+	// until the article is saved.
+	HasRaw bool
+	raw    []byte
+	// Extractor is the extractor the content came from, see EXTRACTORS.
+	Extractor string
+	// FinalURL is where the redirects of the URL read ended, Canonical the
+	// URL the page calls canonical. URL is the latter, or the former
+	// without one, and the others are aliases of it.
+	FinalURL  string
+	Canonical string
+	// ArchivedFrom is the Wayback Machine snapshot the article was read
+	// from because the page was gone, ArchivedAt when it was taken.
+	ArchivedFrom string
+	ArchivedAt   time.Time
+	// Watched articles are re-fetched every WATCH_INTERVAL, Changed is set
+	// when that found new content, at ChangedAt, until it's read.
+	Watched   bool
+	Changed   bool
+	ChangedAt time.Time
+	// Related are the articles related to this one when it was saved, see
+	// RELATED_ARTICLES.
+	Related []relatedArticle
+	// Embedding is the article's embedding, normalized, by EmbeddingModel,
+	// see EMBEDDINGS.
+	Embedding      []float32
+	EmbeddingModel string
+	// Summary is the model's summary of the article, of the content whose
+	// hash is SummaryHash, see LLM_URL.
+	Summary     string
+	SummaryHash string
+	// Audio is the article's narration, see TTS.
+	Audio *articleAudio
+	// Highlights are the passages the reader highlighted, in the order
+	// they were made.
+	Highlights []highlight
+	// Notes are the reader's own, on why the article was saved say.
+	Notes string
+	// Private articles are only listed for visitors signed in.
+	Private bool
+	// retryAfter is set when the article couldn't be fetched for now
+	// because of FETCH_RATE.
+	retryAfter time.Duration
 }
 
 var (
 	//go:embed *.html
 	tmplFiles embed.FS
 
-	//go:embed style.css
+	//go:embed style.css dark.css page.js
 	cssFile embed.FS
 
 	funcMap = template.FuncMap{
 		"safeHTML": func(content string) template.HTML {
 			return template.HTML(content)
 		},
+		"join":        strings.Join,
+		"articlePath": articlePath,
+		"static":      staticPath,
+		"themePath":   themePath,
+		"hostname":    hostname,
+		"favicon":     faviconPath,
 	}
 
-	tmpl = template.Must(template.New("article.html").Funcs(funcMap).ParseFS(tmplFiles, "article.html", "index.html"))
+	tmpl = template.Must(template.New("article.html").Funcs(funcMap).ParseFS(tmplFiles, "*.html"))
 
-	REDIS_URL = os.Getenv("REDIS_URL")
+	// CACHE_TTL is how long extracted articles stay cached, 0 keeps them
+	// forever. A ttl=<duration> read option overrides it per request.
+	CACHE_TTL = envDuration("CACHE_TTL", 0)
 
-	redisclient *redis.Client
+	// PORT is the port served on, 8080, or 443 for ACME certificates.
+	PORT = envOr("PORT", "")
+
+	REDIS_URL   = envOr("REDIS_URL", "")
+	STORAGE     = envOr("STORAGE", "")
+	SQLITE_PATH = envOr("SQLITE_PATH", "")
+
+	store Storage
 
 	mdparser = goldmark.New(
 		goldmark.WithExtensions(
@@ -66,165 +153,587 @@ var (
 )
 
 func init() {
-	opt, _ := redis.ParseURL(REDIS_URL)
-	redisclient = redis.NewClient(opt)
+	if SQLITE_PATH == "" {
+		SQLITE_PATH = "readability.db"
+	}
+
+	flag.StringVar(&STORAGE, "storage", STORAGE, "storage backend, redis, sqlite, postgres or memory")
+	flag.StringVar(&SQLITE_PATH, "sqlite", SQLITE_PATH, "path to the sqlite database")
+	flag.StringVar(&TLS_CERT, "tls-cert", TLS_CERT, "certificate file to serve HTTPS with")
+	flag.StringVar(&TLS_KEY, "tls-key", TLS_KEY, "key file of the certificate")
+	flag.StringVar(&ACME_DOMAINS, "acme-domains", ACME_DOMAINS, "domains to serve HTTPS for with Let's Encrypt certificates, comma separated")
+	// Read by loadConfig, before the flags are parsed.
+	flag.String("config", config.path, "YAML, JSON or TOML configuration file, or CONFIG_FILE")
+	flag.Func("set", "set a setting, KEY=value, over the environment and the file (repeatable)", func(string) error { return nil })
+}
+
+// setup parses the flags, checks the settings, opens the storage and
+// starts the background jobs, for main to serve or run a command.
+func setup() {
+	flag.Parse()
+
+	if err := validateConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	var err error
+	if store, err = newStorage(STORAGE); err != nil {
+		slog.Error("failed to open storage", "err", err)
+		os.Exit(1)
+	}
+
+	instanceLib = &library{store: store, index: searchidx}
 
-	if err := redisclient.Ping().Err(); err != nil {
-		log.Fatalf("Failed to connect to redis, URL: %s, error: %s", REDIS_URL, err.Error())
+	if err := setupTracing(); err != nil {
+		slog.Error("failed to set up tracing", "err", err)
 	}
+
+	loadCredentials()
+	loadAPITokens()
+
+	go instanceLib.buildSearchIndex()
+	go cacheJanitor()
+	go articleWatcher()
+	go telegramBot()
+	go digestScheduler()
 }
 
 func main() {
+	setup()
+
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	r := mux.NewRouter()
 	r.SkipClean(true)
+	r.Use(routeSpanName)
+	api := documentedRoutes(r, "")
 
-	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.FS(cssFile))))
+	r.PathPrefix("/static/").Handler(staticHandler())
+	if KATEX_DIR != "" {
+		r.PathPrefix("/katex/").Handler(katexHandler())
+	}
 
 	r.HandleFunc("/", indexHandler)
+	r.HandleFunc("/read/{url:[0-9A-Za-z_-]+}/qr.png", qrHandler)
+	r.HandleFunc("/read/{url:[0-9A-Za-z_-]+}/audio", audioHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/img/{hash:[0-9a-f]{64}}", imageHandler)
+	r.HandleFunc("/favicon/{host:[0-9a-z.:\\[\\]-]+}", faviconHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/snapshot", snapshotCreateHandler).Methods("POST")
+	r.HandleFunc("/snapshot/{id:[0-9a-f]+}", snapshotHandler)
 	r.PathPrefix("/read/").HandlerFunc(readHandler)
-	r.PathPrefix("/read").Methods("POST").HandlerFunc(readRedirectHandler)
+	r.HandleFunc("/read", readRedirectHandler).Methods("POST")
+	r.HandleFunc("/pow", powHandler).Methods("POST")
+	r.HandleFunc("/read", readHandler).Queries("url", "")
+	r.HandleFunc("/a/{slug:[0-9A-Za-z]+}", slugHandler)
+	r.HandleFunc("/s/{token}", sharedHandler)
+	r.HandleFunc("/share", shareHandler).Methods("POST")
+	api.handle("POST", "/api/v1/share", apiShareHandler, apiShareOp)
+	r.HandleFunc("/save", saveHandler).Methods("GET")
+	r.HandleFunc("/bookmarklet", bookmarkletHandler)
 	r.PathPrefix("/delete/").HandlerFunc(deleteHandler)
+	r.PathPrefix("/export/md/").HandlerFunc(exportMarkdownHandler)
+	r.PathPrefix("/export/epub/").HandlerFunc(exportEpubHandler)
+	r.HandleFunc("/kindle", kindleHandler).Methods("POST")
+	r.HandleFunc("/settings", settingsHandler).Methods("POST")
+	r.HandleFunc("/theme", themeHandler).Methods("POST")
+	r.HandleFunc("/credentials", credentialsHandler).Methods("GET", "POST")
+	r.HandleFunc("/tokens", tokensHandler).Methods("GET", "POST")
+	r.HandleFunc("/admin", adminHandler).Methods("GET", "POST")
+	r.Handle("/metrics", metricsHandler())
+	r.HandleFunc("/healthz", healthzHandler)
+	r.HandleFunc("/readyz", readyzHandler)
+	r.HandleFunc("/login", loginHandler).Methods("GET", "POST")
+	r.HandleFunc("/signup", signupHandler).Methods("GET", "POST")
+	r.HandleFunc("/logout", logoutHandler).Methods("POST")
+	r.HandleFunc("/auth/{provider}", oauthLoginHandler)
+	r.HandleFunc("/auth/{provider}/callback", oauthCallbackHandler)
+	r.HandleFunc("/themes/{name}.css", themeCSSHandler)
+	r.HandleFunc("/tags", tagsHandler).Methods("POST")
+	r.HandleFunc("/tag/{name}", tagHandler)
+	api.handle("GET", "/api/v1/tags", apiTagsHandler, apiTagsOp)
+	r.HandleFunc("/star", starHandler).Methods("POST")
+	r.HandleFunc("/watch", watchHandler).Methods("POST")
+	r.HandleFunc("/private", privateHandler).Methods("POST")
+	api.handle("POST", "/api/v1/private", apiSetPrivateHandler, apiSetPrivateOp)
+	r.HandleFunc("/versions/{url:[0-9A-Za-z_-]+}/{n:[0-9]+}", versionHandler)
+	r.HandleFunc("/favorites", favoritesHandler)
+	api.handle("GET", "/api/v1/favorites", apiFavoritesHandler, apiFavoritesOp)
+	api.handle("GET", "/api/v1/article", apiArticleHandler, apiArticleOp)
+	api.handle("DELETE", "/api/v1/article", apiDeleteArticleHandler, apiDeleteArticleOp)
+	api.handle("GET", "/api/v1/progress", apiProgressHandler, apiProgressOp)
+	api.handle("POST", "/api/v1/progress", apiProgressHandler, apiSetProgressOp)
+	api.handle("POST", "/api/v1/summarize", apiSummarizeHandler, apiSummarizeOp)
+	api.handle("POST", "/api/v1/translate", apiTranslateHandler, apiTranslateOp)
+	r.HandleFunc("/notes", notesHandler).Methods("POST")
+	api.handle("GET", "/api/v1/notes", apiNotesHandler, apiNotesOp)
+	api.handle("POST", "/api/v1/notes", apiNotesHandler, apiSetNotesOp)
+	api.handle("GET", "/api/v1/highlights", apiHighlightsHandler, apiHighlightsOp)
+	api.handle("POST", "/api/v1/highlights", apiAddHighlightHandler, apiAddHighlightOp)
+	api.handle("PUT", "/api/v1/highlights/{id}", apiUpdateHighlightHandler, apiUpdateHighlightOp)
+	api.handle("DELETE", "/api/v1/highlights/{id}", apiDeleteHighlightHandler, apiDeleteHighlightOp)
+	r.HandleFunc("/archive", archiveHandler)
+	r.HandleFunc("/digest", digestHandler)
+	r.HandleFunc("/trending", trendingHandler)
+	api.handle("GET", "/api/v1/trending", apiTrendingHandler, apiTrendingOp)
+	r.HandleFunc("/export", libraryExportHandler).Methods("GET", "HEAD")
+	api.handle("GET", "/api/v1/export", libraryExportHandler, apiExportOp)
+	api.handle("POST", "/api/v1/import", apiImportHandler, apiImportOp)
+	r.HandleFunc("/import", importHandler)
+	r.HandleFunc("/import/{id}", importStatusHandler)
+	api.handle("GET", "/api/v1/import/{id}", apiImportStatusHandler, apiImportStatusOp)
+	api.handle("POST", "/api/v1/jobs", apiCreateJobHandler, apiCreateJobOp)
+	api.handle("GET", "/api/v1/jobs/{id}", apiJobHandler, apiJobOp)
+	api.handle("POST", "/api/v1/batch", apiBatchHandler, apiBatchOp)
+	api.handle("GET", "/api/v1/batch/{id}", apiBatchStatusHandler, apiBatchStatusOp)
+	r.HandleFunc("/events/{job}", eventsHandler)
+	r.HandleFunc("/integrations/slack", slackHandler).Methods("POST")
+	r.HandleFunc("/integrations/discord", discordHandler).Methods("POST")
+	r.HandleFunc("/integrations/email", emailHandler).Methods("POST")
+
+	api.handle("GET", "/api/v1/search", apiSearchHandler, apiSearchOp)
+	api.handle("GET", "/api/v1/similar", apiSimilarHandler, apiSimilarOp)
+	r.HandleFunc("/api/openapi.json", openAPIHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/api/docs", apiDocsHandler).Methods("GET", "HEAD")
+
+	wallabagRoutes(r)
+	r.HandleFunc("/search", searchHandler)
+	r.HandleFunc("/highlights", highlightsHandler)
+	r.HandleFunc("/similar", similarHandler)
+	r.HandleFunc("/feed.xml", rssHandler)
+	r.HandleFunc("/feed.atom", atomHandler)
+	r.HandleFunc("/podcast.xml", podcastHandler)
+	r.HandleFunc("/robots.txt", robotsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/sitemap.xml", sitemapHandler).Methods("GET", "HEAD")
+
+	srv := newServer(traceHTTP(requestLogger(instrumentHTTP(securityHeaders(compressHTTP(apiAccess(userAccess(abuseGuard(r)))))))))
+	if err := serve(srv); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("failed to serve", "err", err)
+		os.Exit(1)
+	}
+}
+
+// runCommand runs a command given on the command line instead of serving.
+func runCommand(args []string) error {
+	switch args[0] {
+	case "tokens":
+		return tokensCommand(args[1:])
+	case "users":
+		return usersCommand(args[1:])
+	case "config":
+		return configCommand(args[1:])
+	case "export":
+		return exportCommand(args[1:])
+	case "import":
+		return importCommand(args[1:])
+	case "backup":
+		return backupCommand(args[1:])
+	case "restore":
+		return restoreCommand(args[1:])
+	case "migrate":
+		return migrateCommand(args[1:])
+	case "offload":
+		return offloadCommand(args[1:])
+	}
 
-	log.Fatal(http.ListenAndServe(port(), r))
+	return fmt.Errorf("unknown command: %s", args[0])
 }
 
 func port() string {
-	if port := os.Getenv("PORT"); port != "" {
-		log.Println("Listening on address, http://localhost:" + port)
-		return ":" + port
+	scheme, port := "http", "8080"
+	if tlsEnabled() {
+		scheme = "https"
+	}
+	// ACME certificates are for the domains' standard port.
+	if acmeEnabled() {
+		port = "443"
+	}
+	if PORT != "" {
+		port = PORT
 	}
 
-	log.Println("Listening on address, http://localhost:8080")
-	return ":8080"
+	slog.Info("listening", "address", scheme+"://localhost:"+port)
+	return ":" + port
 }
 
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-	last10arts, err := getLastNArticles(10)
+// envOr is the setting key, from the flags, the environment or the
+// configuration file, def when it isn't set.
+func envOr(key, def string) string {
+	if v := config.lookup(key, def); v != "" {
+		return v
+	}
+
+	return def
+}
+
+func envInt(key string, def int) int {
+	v := config.lookup(key, strconv.Itoa(def))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		config.invalid(key, v, "an integer")
+		return def
 	}
 
-	err = tmpl.ExecuteTemplate(w, "index.html", map[string]interface{}{
-		"Recents": last10arts,
-	})
+	return n
+}
 
+func envFloat(key string, def float64) float64 {
+	v := config.lookup(key, strconv.FormatFloat(def, 'g', -1, 64))
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		config.invalid(key, v, "a number")
+		return def
+	}
+
+	return f
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v := config.lookup(key, def.String())
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		config.invalid(key, v, "a duration, e.g. 30s, 10m or 24h")
+		return def
+	}
+
+	return d
+}
+
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	lib := libraryFor(r)
+	tag := r.URL.Query().Get("tag")
+
+	var last10arts []string
+	var err error
+	if tag != "" {
+		last10arts, err = lib.store.TaggedArticles(tag)
+		if len(last10arts) > 10 {
+			last10arts = last10arts[:10]
+		}
+	} else {
+		last10arts, err = lib.getLastNArticles(10)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recents := make([]archiveEntry, 0, len(last10arts))
+	for _, key := range last10arts {
+		art, err := lib.store.GetArticle(key)
+		if err != nil || art == nil {
+			continue
+		}
+		recents = append(recents, lib.newArchiveEntry(key, art))
 	}
+
+	s, _ := requestSession(r)
+	executePage(w, r, "index.html", map[string]interface{}{
+		"Recents": recents,
+		"Tag":     tag,
+		"Tags":    lib.sortedTags(),
+		"Changed": lib.changedArticles(),
+		"User":    s.Name,
+		"SignIn":  !MULTI_USER && ADMIN_PASSWORD != "",
+		"Podcast": narrator != nil,
+	})
 }
 
 func readRedirectHandler(w http.ResponseWriter, r *http.Request) {
 	uri := r.FormValue("url")
-	http.Redirect(w, r, "/read/"+escape(uri), http.StatusTemporaryRedirect)
+	http.Redirect(w, r, articlePath("read", uri), http.StatusSeeOther)
 }
 
 func deleteHandler(w http.ResponseWriter, r *http.Request) {
-	uri, _, _ := parseURL(r.URL, len("/delete/"))
+	uri, _ := parseURL(r.URL, len("/delete/"))
 
 	if uri == "" {
 		http.NotFound(w, r)
 		return
 	}
 
-	if err := deleteArticle(uri); err != nil {
+	lib := libraryFor(r)
+	if err := lib.deleteArticle(lib.resolveKey(uri)); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func escape(s string) string {
-	replacer := strings.NewReplacer(
-		"/", "%2F",
-	)
-
-	return replacer.Replace(s)
+var apiDeleteArticleOp = apiOperation{
+	Summary:  "Delete an article",
+	Tag:      "articles",
+	Query:    []apiParam{{Name: "url", Description: "The article's URL.", Required: true}},
+	Response: map[string]string{"deleted": ""},
+	Errors:   []int{http.StatusNotFound, http.StatusInternalServerError},
 }
 
-func unescape(s string) string {
-	replacer := strings.NewReplacer(
-		"%2F", "/",
-	)
+func apiDeleteArticleHandler(w http.ResponseWriter, r *http.Request) {
+	lib := libraryFor(r)
+	uri := r.URL.Query().Get("url")
+	key := lib.resolveKey(uri)
+
+	art, err := lib.store.GetArticle(key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if art == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "article not found"})
+		return
+	}
 
-	return replacer.Replace(s)
+	if err := lib.deleteArticle(key); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"deleted": uri})
 }
 
 func readHandler(w http.ResponseWriter, r *http.Request) {
-	uri, nocache, md := parseURL(r.URL, len("/read/"))
+	uri, opts := parseURL(r.URL, len("/read/"))
+	opts.lib = libraryFor(r)
+	opts.ctx = r.Context()
 
 	if uri == "" {
 		http.NotFound(w, r)
 		return
 	}
+	if readAsync(w, r, uri, opts) {
+		return
+	}
 
-	uri = unescape(uri)
-
-	render(w, readabyFormURL(uri, nocache, md))
+	writeArticle(w, r, readabyFormURL(uri, opts), opts)
 }
 
-func parseURL(u *url.URL, trimlen int) (string, bool, bool) {
-	nocache, md := false, false
+func writeArticle(w http.ResponseWriter, r *http.Request, art *article, opts readOptions) {
+	if art.retryAfter > 0 {
+		writeRateLimited(w, art, opts.Format == "json")
+		return
+	}
 
-	query := u.RawQuery
-	if u.Query().Get("nocache") == "true" {
-		nocache = true
+	// The page depends on the visitor too, render checks it. The JSON has
+	// the tags and star, its Last-Modified would be the content's only.
+	if art.ErrMsg == "" && opts.Format != "" {
+		modified := art.modifiedAt()
+		if opts.Format == "json" {
+			modified = time.Time{}
+		}
+		if notModified(w, r, articleETag(art, opts.Format, pageVersion()), modified) {
+			return
+		}
+	}
 
-		query = strings.ReplaceAll(query, "&nocache=true", "")
+	switch opts.Format {
+	case "md":
+		writeMarkdown(w, art)
+	case "pdf":
+		writePDF(w, r, art)
+	case "json":
+		writeArticleJSON(w, art)
+	case "raw":
+		writeRaw(w, r, art)
+	default:
+		render(w, r, &articlePage{article: art, NoTOC: opts.NoTOC})
 	}
+}
+
+// readOptions are the flags this service accepts in the query string, e.g.
+// /read/{base64 URL}?nocache=true&format=md.
+type readOptions struct {
+	NoCache bool
+	// Refresh re-extracts the article and replaces the cached copy.
+	Refresh bool
+	MD      bool
+	Format  string
+	// TTL overrides CACHE_TTL for the article when HasTTL is set.
+	TTL    time.Duration
+	HasTTL bool
+	// NoTOC leaves the table of contents out of the article page.
+	NoTOC bool
+	// lib is the library the article is read from and saved to.
+	lib *library
+	// ctx is the context of the request reading the article.
+	ctx context.Context
+}
 
-	if u.Query().Get("md") == "true" {
-		md = true
-		query = strings.ReplaceAll(query, "&md=true", "")
+// set applies the option key, reporting false when key isn't one.
+func (opts *readOptions) set(key, value string) bool {
+	switch {
+	case key == "nocache" && value == "true":
+		opts.NoCache = true
+	case key == "refresh" && (value == "1" || value == "true"):
+		opts.Refresh = true
+	case key == "md" && value == "true":
+		opts.MD = true
+	case key == "ttl":
+		if ttl, err := time.ParseDuration(value); err == nil && ttl >= 0 {
+			opts.TTL, opts.HasTTL = ttl, true
+		}
+	case key == "format":
+		opts.Format = value
+	case key == "toc" && (value == "0" || value == "false"):
+		opts.NoTOC = true
+	default:
+		return false
 	}
 
-	uri := u.EscapedPath()[trimlen:]
+	return true
+}
 
-	if query != "" {
-		uri = fmt.Sprintf("%s?%s", uri, query)
+// parseURL reads the article URL and options of a request to an article
+// route. The URL is either the url query parameter, a base64 path segment
+// or, for links from before keys were hashed, the URL itself with its
+// slashes escaped and the options mixed into its query string.
+func parseURL(u *url.URL, trimlen int) (string, readOptions) {
+	var opts readOptions
+	var query []string
+	var param string
+
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		key, value, _ := strings.Cut(pair, "=")
+
+		switch {
+		case key == "url" && param == "":
+			param, _ = url.QueryUnescape(value)
+			param = cleanURL(param)
+		case opts.set(key, value):
+		case pair != "":
+			query = append(query, pair)
+		}
 	}
 
-	log.Printf("url: %s, nocache: %v, md: %v", uri, nocache, md)
+	var uri string
+	if path := u.EscapedPath(); len(path) > trimlen {
+		uri = path[trimlen:]
+	}
 
-	return uri, nocache, md
+	switch decoded, ok := decodePathURL(uri); {
+	case uri == "":
+		uri = param
+	case ok:
+		uri = decoded
+	default:
+		uri = cleanURL(strings.ReplaceAll(uri, "%2F", "/"))
+		if param != "" {
+			query = append(query, "url="+url.QueryEscape(param))
+		}
+		if len(query) != 0 {
+			uri = fmt.Sprintf("%s?%s", uri, strings.Join(query, "&"))
+		}
+	}
+
+	slog.Debug("parsed article url", "url", uri)
+
+	return uri, opts
 }
 
-func readabyFormURL(uri string, nocache, md bool) *article {
-	var art *article
+func readabyFormURL(uri string, opts readOptions) (art *article) {
 	var err error
 	var fromcache bool
 
+	nocache, md := opts.NoCache, opts.MD
+	uri = normalizeURL(uri)
+	start := time.Now()
+
+	ctx, span := tracer.Start(opts.context(), "read article", trace.WithAttributes(attribute.String("article.url", uri)))
+	defer span.End()
+	lib := opts.library().withContext(ctx)
+
 	defer func() {
-		log.Printf("defer readFormURL, article == nil: %v, err == nil: %v, nocache: %v", art == nil, err == nil, nocache)
+		if !fromcache {
+			recordExtraction(uri, art)
+			switch {
+			case art == nil:
+			case art.ErrMsg != "":
+				span.SetStatus(codes.Error, art.ErrMsg)
+				slog.WarnContext(ctx, "failed to read article", "url", uri, "err", art.ErrMsg, "duration", time.Since(start))
+			default:
+				slog.InfoContext(ctx, "read article", "url", uri, "extractor", art.Extractor, "words", art.WordCount, "duration", time.Since(start))
+			}
+		}
 		if err == nil && !fromcache && !nocache && art != nil && art.Content != "" {
-			setArticleToCache(uri, art)
+			lib.setArticleToCache(ctx, articleKey(uri), art)
 		}
 	}()
 
-	if !nocache {
-		art, err = getArticleFromCache(uri)
+	if !nocache && !opts.Refresh {
+		key := lib.resolveKey(uri)
+		art, err = lib.getArticleFromCache(ctx, key)
+		if err == nil && art == nil {
+			art = lib.migrateLegacyArticle(ctx, uri, key)
+		}
+		span.SetAttributes(attribute.Bool("article.cached", art != nil))
+		if art != nil {
+			cacheRequestsTotal.WithLabelValues("hit").Inc()
+		} else {
+			cacheRequestsTotal.WithLabelValues("miss").Inc()
+		}
 		if err != nil || art != nil {
 			fromcache = true
 			return art
 		}
 	}
 
+	if err = checkURL(uri); err != nil {
+		return &article{URL: uri, ErrMsg: err.Error()}
+	}
+	if err = checkDomain(uri); err != nil {
+		return &article{URL: uri, ErrMsg: err.Error()}
+	}
+
 	title, content := "", ""
+	var page fetchedPage
 
 	if !md {
-		var fromdata readability.Article
-		fromdata, err = readability.FromURL(uri, 30*time.Second)
+		page, err = fetchReadableShared(ctx, uri)
 		if err != nil {
-			return &article{URL: uri, ErrMsg: err.Error()}
+			return fetchFailed(uri, err)
 		}
 
-		title = fromdata.Title
-		content = fromdata.Content
+		// Cache under the URL the page calls canonical, or where its
+		// redirects ended, remembering the submitted one and the one
+		// redirected to so they find the same entry next time. Only a
+		// canonical on the page's own site is taken, one elsewhere would
+		// replace that site's article.
+		target := canonicalTarget(page.Canonical, firstNonEmpty(page.FinalURL, uri))
+		if !nocache {
+			for _, alias := range []string{uri, page.FinalURL} {
+				if alias == "" || alias == target {
+					continue
+				}
+				if err := lib.store.SetAlias(articleKey(alias), articleKey(target)); err != nil {
+					slog.ErrorContext(ctx, "failed to set alias", "url", alias, "err", err)
+				}
+			}
+		}
+		uri = target
+
+		title = page.Title
+		content = page.Content
 	} else {
-		log.Printf("read markdown: %s", uri)
+		slog.DebugContext(ctx, "reading markdown", "url", uri)
 		var data []byte
-		data, err = getDataFromURL(uri)
+		data, err = getDataFromURLShared(ctx, uri)
 		if err != nil {
-			return &article{URL: uri, ErrMsg: err.Error()}
+			return fetchFailed(uri, err)
 		}
 		var buf bytes.Buffer
 		context := parser.NewContext()
@@ -237,112 +746,348 @@ func readabyFormURL(uri string, nocache, md bool) *article {
 		content = buf.String()
 	}
 
-	art = &article{URL: uri, Title: title, Content: content}
+	// The links of the content are relative to where the page was.
+	content = footnotes(sanitizeContent(content), firstNonEmpty(page.FinalURL, uri))
+	if IMAGE_PROXY && !nocache {
+		content = proxyImages(content, uri)
+	}
+
+	art = &article{
+		URL:         uri,
+		Title:       title,
+		Content:     content,
+		CreatedAt:   time.Now(),
+		Byline:      page.Byline,
+		SiteName:    page.SiteName,
+		Excerpt:     page.Excerpt,
+		Image:       page.Image,
+		Language:    page.Language,
+		PublishedAt: page.Published,
+		raw:         page.Raw,
+		Extractor:   page.Extractor,
+
+		FinalURL:     page.FinalURL,
+		Canonical:    page.Canonical,
+		ArchivedFrom: page.ArchivedFrom,
+		ArchivedAt:   page.ArchivedAt,
+	}
+	art.ContentHash = contentHash([]byte(art.Content))
+	art.countWords()
+
+	ttl := CACHE_TTL
+	if opts.HasTTL {
+		ttl = opts.TTL
+	}
+	if ttl > 0 {
+		art.ExpiresAt = art.CreatedAt.Add(ttl)
+	}
 
 	return art
 }
 
-func render(w http.ResponseWriter, data *article) {
+// articlePage is the data article.html is rendered with, the article plus
+// whatever depends on the visitor.
+type articlePage struct {
+	*article
+	KindleEmail string
+	Notice      string
+	// SignedIn is set for the visitors who see the private articles, and
+	// may make an article private.
+	SignedIn bool
+	// Permalink is the absolute /a/{slug} link of a saved article.
+	Permalink string
+	// OpenGraph describes the article to the sites links to it are shared
+	// on.
+	OpenGraph openGraph
+	Settings  readerSettings
+	Theme     string
+	NoTOC     bool
+	TOC       []tocEntry
+	// Pagination is set on the articles long enough to be split into
+	// pages, Content is then the page shown.
+	Pagination pagination
+	Math       mathMarkup
+	KaTeXURL   string
+	// KaTeXIntegrity are the integrity attributes of KaTeX's files.
+	KaTeXIntegrity map[string]string
+	// Snapshots are the IDs of the article's snapshots, newest first.
+	Snapshots []string
+	// Versions are what a watched article was before it changed, newest
+	// first.
+	Versions []*articleVersion
+	// Related are the article's related articles still saved.
+	Related []relatedArticle
+	// Similar links the articles like this one, with EMBEDDINGS.
+	Similar bool
+	// Summarize shows the article's summary, asked for when it's opened,
+	// with LLM_URL.
+	Summarize bool
+	// Narrate shows a player of the article's narration, with TTS.
+	Narrate bool
+	// Languages are those the article may be translated into, with
+	// TRANSLATE, Lang the one it's shown in, "" for the original.
+	Languages []string
+	Lang      string
+	// ShareLink is the share link just made, expiring at ShareExpires,
+	// never when zero.
+	ShareLink    string
+	ShareExpires time.Time
+	Style        string
+	Styles       []styleOption
+	// Back is the path of the page, for forms that return to it.
+	Back string
+}
+
+func render(w http.ResponseWriter, r *http.Request, data *articlePage) {
+	data.SignedIn = signedIn(r)
+	if data.SignedIn {
+		data.KindleEmail = kindleEmail(r)
+	}
+	data.Settings = readerSettingsFromRequest(r)
+	data.Theme = colorScheme(r)
+	data.Style = visitorStyle(r)
+	data.Styles = styleOptions()
+	data.Back = r.URL.RequestURI()
+
+	if data.ErrMsg == "" && data.URL != "" {
+		lib := libraryFor(r)
+		key := lib.resolveKey(data.URL)
+		lib.ensureSlug(key, data.article)
+
+		snaps, err := lib.store.Snapshots(key)
+		if err != nil {
+			slog.Error("failed to list snapshots", "key", key, "err", err)
+		}
+		data.Snapshots = snaps
+
+		versions, err := lib.store.Versions(key)
+		if err != nil {
+			slog.Error("failed to list versions", "key", key, "err", err)
+		}
+		data.Versions = versions
+		data.Related = lib.relatedArticles(data.article)
+		data.Similar = embedder != nil
+		data.Summarize = llmEnabled()
+		data.Narrate = narrator != nil
+		if translator != nil {
+			data.Languages = TRANSLATE_LANGUAGES
+		}
+		if lang := r.URL.Query().Get("lang"); lang != "" {
+			tr, err := lib.translate(r.Context(), key, data.article, lang)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "failed to translate article", "key", key, "lang", lang, "err", err)
+				data.Notice = "Couldn't translate the article: " + err.Error()
+			} else {
+				// Work on a copy, the article may be the cached one.
+				art := *data.article
+				art.Title, art.Content = tr.Title, tr.Content
+				data.article = &art
+				data.Lang = tr.Lang
+			}
+		}
+
+		// Just made on the page, see shareHandler.
+		if token := r.URL.Query().Get("shared"); token != "" {
+			user, shareKey, expires, err := parseShareToken(token)
+			if err == nil && user == lib.User && shareKey == key {
+				data.ShareLink = baseURL(r) + "/s/" + token
+				data.ShareExpires = expires
+			}
+		}
+
+		// Shown once, the change is read now.
+		if data.Changed {
+			if err := lib.store.UpdateArticle(key, func(art *article) { art.Changed = false }); err != nil {
+				slog.Error("failed to clear changed", "key", key, "err", err)
+			}
+		}
+
+		// The cookies hold the theme, settings and who signed in.
+		w.Header().Add("Vary", "Cookie")
+		etag := articleETag(data.article, "html", r.Header.Get("Cookie"), strings.Join(snaps, ","),
+			strconv.Itoa(len(versions)), strconv.Itoa(len(data.Related)), strconv.FormatBool(data.Similar), strconv.FormatBool(data.Summarize), strconv.FormatBool(data.Narrate), data.Summary, data.Notes, data.ShareLink, data.Lang, strconv.FormatBool(data.NoTOC), r.URL.Query().Get("page"), data.Notice, pageVersion())
+		if notModified(w, r, etag, time.Time{}) {
+			return
+		}
+	}
+	if data.Slug != "" {
+		data.Permalink = baseURL(r) + "/a/" + data.Slug
+	}
+
+	if data.ErrMsg == "" {
+		link := data.Permalink
+		if link == "" && data.URL != "" {
+			link = baseURL(r) + articlePath("read", data.URL)
+		}
+		data.OpenGraph = newOpenGraph(data.article, link, baseURL(r))
+
+		// Work on a copy, the article may be the cached one.
+		art := *data.article
+		// Sanitized again, and its footnotes found, for the articles saved
+		// before it was.
+		art.Content = highlighted(footnotes(sanitizeContent(art.Content), art.URL))
+		if !data.NoTOC {
+			data.TOC, art.Content = tableOfContents(art.Content)
+		}
+		data.article = &art
+		paginateArticle(r, data)
+
+		data.Math = detectMath(art.Content)
+		data.KaTeXURL = katexURL()
+		data.KaTeXIntegrity = katexIntegrity()
+		if hasRemoteMedia(art.Content) {
+			w.Header().Set("Content-Security-Policy", contentSecurityPolicy(true))
+		}
+	}
+
 	err := tmpl.ExecuteTemplate(w, "article.html", data)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
-func setArticleToCache(key string, art *article) error {
-	data, err := json.Marshal(art)
-	if err != nil {
-		log.Printf("failed to marshal article to json: %s", err.Error())
-		return err
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode json response", "err", err)
 	}
+}
 
-	defer func() {
-		if err := lpushToRedis(key); err != nil {
-			log.Printf("failed to push article to redis queue: %s", err.Error())
-			return
+func (lib *library) setArticleToCache(ctx context.Context, key string, art *article) error {
+	// Re-extracting an article must not lose what was attached to it.
+	old, err := lib.store.GetArticle(key)
+	isNew := err == nil && old == nil
+	if err == nil && old != nil {
+		if !old.CreatedAt.IsZero() {
+			art.CreatedAt = old.CreatedAt
 		}
-	}()
+		art.RefreshedAt = time.Now()
+		art.Tags = old.Tags
+		art.Highlights = old.Highlights
+		art.Notes = old.Notes
+		art.Private = old.Private
+		art.Starred = old.Starred
+		art.Progress = old.Progress
+		art.Slug = old.Slug
+		art.Watched = old.Watched
+		art.Changed = old.Changed
+		art.ChangedAt = old.ChangedAt
+		if old.SummaryHash == art.hash() {
+			art.Summary, art.SummaryHash = old.Summary, old.SummaryHash
+		}
+		if old.Audio != nil && old.Audio.SourceHash == art.hash() {
+			art.Audio = old.Audio
+		}
+	}
+	if art.Slug == "" {
+		art.Slug = lib.newSlug(key)
+	}
+	art.Related = lib.index.Related(key, art, RELATED_ARTICLES)
+
+	// The downloaded page is stored on its own, it's only read on request.
+	raw := art.raw
+	art.raw = nil
+	art.HasRaw = raw != nil
 
-	if err := redisclient.Set(key, compress(data), 0).Err(); err != nil {
-		log.Printf("failed to set article to redis cache: %s", err.Error())
+	if err := lib.store.SetArticle(key, art); err != nil {
+		slog.ErrorContext(ctx, "failed to set article to cache", "key", key, "err", err)
+		return err
+	}
+	if raw != nil {
+		if err := lib.store.SetRaw(key, compress(raw)); err != nil {
+			slog.ErrorContext(ctx, "failed to store original page", "key", key, "err", err)
+			return err
+		}
+	}
+	if err := lib.store.SetSlug(art.Slug, key); err != nil {
+		slog.ErrorContext(ctx, "failed to set slug", "slug", art.Slug, "err", err)
 		return err
 	}
 
-	return nil
-}
+	lib.index.Add(key, art)
+	lib.evictArticles()
 
-func getArticleFromCache(key string) (*article, error) {
-	var data []byte
+	if embedder != nil {
+		go func() {
+			if err := lib.embedArticle(context.WithoutCancel(ctx), key, art); err != nil {
+				slog.ErrorContext(ctx, "failed to embed article", "key", key, "err", err)
+			}
+		}()
+	}
 
-	if err := redisclient.Get(key).Scan(&data); err != nil {
-		if err == redis.Nil {
-			return nil, nil
-		}
+	if narrator != nil && TTS_ON_SAVE && art.Audio == nil {
+		go func() {
+			if _, _, err := lib.narration(context.WithoutCancel(ctx), key, art); err != nil {
+				slog.ErrorContext(ctx, "failed to narrate article", "key", key, "err", err)
+			}
+		}()
+	}
 
+	if isNew {
+		notifySaved(art)
+	}
+
+	return nil
+}
+
+func (lib *library) getArticleFromCache(ctx context.Context, key string) (*article, error) {
+	art, err := lib.store.GetArticle(key)
+	if err != nil {
 		return &article{URL: key, ErrMsg: err.Error()}, errors.New("failed to get article from cache")
 	}
 
-	var art article
-	if err := json.Unmarshal(uncompress(data), &art); err != nil {
-		return &article{URL: key, ErrMsg: err.Error()}, errors.New("failed to unmarshal article from json")
+	if art == nil || art.Expired() {
+		return nil, nil
 	}
 
-	log.Printf("get article from cache: %s", key)
-	defer incrViewCount(key)
+	// Saved before word counts were stored.
+	if art.WordCount == 0 && art.Content != "" {
+		art.countWords()
+	}
 
-	return &art, nil
-}
+	slog.DebugContext(ctx, "article from cache", "key", key)
+	if err := lib.incrViewCount(key); err != nil {
+		slog.ErrorContext(ctx, "failed to count view", "key", key, "err", err)
+	}
 
-func incrViewCount(key string) error {
-	return redisclient.ZIncrBy("readability-viewcount", 1, key).Err()
+	return art, nil
 }
 
-func lpushToRedis(key string) error {
-	return redisclient.LPush("readability-timequeue", key).Err()
+func (lib *library) incrViewCount(key string) error {
+	return lib.store.IncrViewCount(key)
 }
 
-func getLastNArticles(n int) ([]string, error) {
-	records := make([]string, 0, n)
-
-	if err := redisclient.LRange("readability-timequeue", 0, int64(n)).ScanSlice(&records); err != nil {
-		log.Printf("failed to get last %d articles from redis: %s", n, err.Error())
+func (lib *library) getLastNArticles(n int) ([]string, error) {
+	records, err := lib.store.LastNArticles(n)
+	if err != nil {
+		slog.Error("failed to get last articles", "n", n, "err", err)
 		return nil, err
 	}
 
 	return records, nil
 }
 
-func deleteArticle(uri string) error {
-	redisclient.TxPipelined(func(pipe redis.Pipeliner) error {
-		if err := pipe.Del(uri).Err(); err != nil {
-			log.Printf("redis del failed: %s", err.Error())
-			return err
-		}
-
-		if err := pipe.LRem("readability-timequeue", 0, uri).Err(); err != nil {
-			log.Printf("lrem failed: %s", err.Error())
-			return err
-		}
-
-		if err := pipe.ZRem("readability-viewcount", uri).Err(); err != nil {
-			log.Printf("zrem failed: %s", err.Error())
-			return err
-		}
+func (lib *library) deleteArticle(uri string) error {
+	if err := lib.store.DeleteArticle(uri); err != nil {
+		slog.Error("failed to delete article", "key", uri, "err", err)
+		return err
+	}
 
-		return nil
-	})
+	lib.index.Remove(uri)
 
 	return nil
 }
 
-func getDataFromURL(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+func getDataFromURL(ctx context.Context, url string) ([]byte, error) {
+	resp, err := getWithRetry(ctx, url)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	return io.ReadAll(resp.Body)
+	return readLimited(resp)
 }
 
 func compress(data []byte) []byte {
@@ -350,12 +1095,12 @@ func compress(data []byte) []byte {
 	gw := gzip.NewWriter(&cp)
 	_, err := gw.Write(data)
 	if err != nil {
-		log.Printf("failed to compress data: %s", err.Error())
+		slog.Error("failed to compress data", "err", err)
 		return nil
 	}
 	err = gw.Close()
 	if err != nil {
-		log.Printf("failed to close gzip writer: %s", err.Error())
+		slog.Error("failed to close gzip writer", "err", err)
 		return nil
 	}
 
@@ -365,7 +1110,7 @@ func compress(data []byte) []byte {
 func uncompress(data []byte) []byte {
 	gr, err := gzip.NewReader(bytes.NewReader(data))
 	if err != nil {
-		log.Printf("failed to uncompress data: %s", err.Error())
+		slog.Error("failed to uncompress data", "err", err)
 		return nil
 	}
 	defer gr.Close()
@@ -373,7 +1118,7 @@ func uncompress(data []byte) []byte {
 	var cp bytes.Buffer
 	_, err = cp.ReadFrom(gr)
 	if err != nil {
-		log.Printf("failed to read uncompressed data: %s", err.Error())
+		slog.Error("failed to read uncompressed data", "err", err)
 		return nil
 	}
 
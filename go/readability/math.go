@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var (
+	// KATEX_URL is where the KaTeX stylesheet and scripts are loaded from,
+	// KATEX_DIR serves a local copy of KaTeX's dist directory instead.
+	KATEX_URL = envOr("KATEX_URL", katexCDN)
+	KATEX_DIR = envOr("KATEX_DIR", "")
+
+	// katexSRI are the Subresource Integrity hashes of katexCDN's files,
+	// for the browser to run nothing else the CDN would serve.
+	katexSRI = map[string]string{
+		"katex.min.css":              "sha384-n8MVd4RsNIU0tAv4ct0nTaAbDJwPJzDEaqSD1odI+WdtXRGWt2kTvGFasHpSy3SV",
+		"katex.min.js":               "sha384-XjKyOOlGwcjNTAIQHIpgOno0Hl1YQqzUOEleOLALmuqehneUG+vnGctmUb0ZY0l8",
+		"contrib/auto-render.min.js": "sha384-+VBxd3r6XgURycqtZ117nYw44OOcIax56Z4dCRWbxyPt0Koah1uHoK0o4+/RRE05",
+	}
+
+	// Display math, \(..\) and \[..\] are unambiguous; $..$ only counts
+	// when it holds something TeX-like, so prices don't turn into math.
+	displayMathRe = regexp.MustCompile(`\$\$[^$]+\$\$|\\\([^)]+\\\)|\\\[[^\]]+\\\]`)
+	inlineMathRe  = regexp.MustCompile(`\$[^\s$][^$\n]{0,200}?[\\^_{][^$\n]{0,200}?[^\s$]\$`)
+)
+
+// katexCDN is the KaTeX release loaded by default.
+const katexCDN = "https://cdn.jsdelivr.net/npm/katex@0.16.9/dist"
+
+// mathMarkup is what math an article has, for article.html to load KaTeX.
+type mathMarkup struct {
+	TeX bool
+	// Dollars is set when the TeX uses $..$ for inline math.
+	Dollars bool
+}
+
+func detectMath(content string) mathMarkup {
+	text := htmlText(content)
+
+	var m mathMarkup
+	m.Dollars = inlineMathRe.MatchString(text)
+	m.TeX = m.Dollars || displayMathRe.MatchString(text)
+
+	return m
+}
+
+// katexURL is the base URL of KaTeX's assets.
+func katexURL() string {
+	if KATEX_DIR != "" {
+		return "/katex"
+	}
+
+	return strings.TrimSuffix(KATEX_URL, "/")
+}
+
+// katexIntegrity are the integrity attributes of KaTeX's files by name,
+// none but for katexCDN's, whose hashes are known.
+func katexIntegrity() map[string]string {
+	if katexURL() != katexCDN {
+		return nil
+	}
+
+	return katexSRI
+}
+
+func katexHandler() http.Handler {
+	return http.StripPrefix("/katex/", http.FileServer(http.Dir(KATEX_DIR)))
+}
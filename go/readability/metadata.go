@@ -0,0 +1,240 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// publishedTimeMeta are the <meta> names and properties pages put their
+// publish date in, most specific first.
+var publishedTimeMeta = []string{
+	"article:published_time",
+	"datepublished",
+	"og:published_time",
+	"pubdate",
+	"publish-date",
+	"dc.date.issued",
+	"dc.date",
+	"date",
+}
+
+var publishedTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// metaTags maps the lowercased name, property or itemprop of the page's
+// <meta> tags to their content, the first one winning.
+func metaTags(doc *html.Node) map[string]string {
+	metas := make(map[string]string)
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Meta {
+			var name, content string
+			for _, attr := range n.Attr {
+				switch attr.Key {
+				case "name", "property", "itemprop":
+					name = strings.ToLower(strings.TrimSpace(attr.Val))
+				case "content":
+					content = strings.TrimSpace(attr.Val)
+				}
+			}
+			if name != "" && content != "" && metas[name] == "" {
+				metas[name] = content
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	return metas
+}
+
+// publishedTime reads the publish date out of the page's <meta> tags,
+// falling back to the first <time datetime> in the page.
+func publishedTime(doc *html.Node) time.Time {
+	metas := metaTags(doc)
+
+	var datetime string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Time && datetime == "" {
+			datetime = strings.TrimSpace(attrValue(n, "datetime"))
+		}
+		for c := n.FirstChild; c != nil && datetime == ""; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	for _, name := range publishedTimeMeta {
+		if t := parsePublishedTime(metas[name]); !t.IsZero() {
+			return t
+		}
+	}
+
+	return parsePublishedTime(datetime)
+}
+
+func parsePublishedTime(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+
+	for _, layout := range publishedTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// openGraph is what the Open Graph and Twitter Card tags of an article
+// page say, for links to it to unfurl in chat apps and social media.
+type openGraph struct {
+	Title       string
+	Description string
+	// Image is the article's lead image, absolute, "" when it has none.
+	Image string
+	URL   string
+}
+
+// newOpenGraph describes art, linked to at link, its images relative to
+// base.
+func newOpenGraph(art *article, link, base string) openGraph {
+	og := openGraph{
+		Title:       art.Title,
+		Description: art.Excerpt,
+		Image:       art.Image,
+		URL:         link,
+	}
+	if og.Title == "" {
+		og.Title = art.URL
+	}
+	if og.Description == "" {
+		og.Description = htmlText(art.Content)
+	}
+	og.Description = excerpt(og.Description, 200)
+	if og.Image == "" {
+		og.Image = firstImage(art.Content)
+	}
+	if ref, err := url.Parse(og.Image); og.Image != "" && err == nil && !ref.IsAbs() {
+		if b, err := url.Parse(base + "/"); err == nil {
+			og.Image = b.ResolveReference(ref).String()
+		}
+	}
+
+	return og
+}
+
+// firstImage returns the src of the first image of content, which the
+// image proxy may have rewritten to a path of its own.
+func firstImage(content string) string {
+	z := html.NewTokenizer(strings.NewReader(content))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if atom.Lookup(name) != atom.Img {
+				continue
+			}
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if string(key) == "src" && len(val) > 0 && !strings.HasPrefix(string(val), "data:") {
+					return string(val)
+				}
+			}
+		}
+	}
+}
+
+// articleJSON is an article as ?format=json returns it.
+type articleJSON struct {
+	URL         string     `json:"url"`
+	Title       string     `json:"title"`
+	Byline      string     `json:"byline,omitempty"`
+	SiteName    string     `json:"site_name,omitempty"`
+	Excerpt     string     `json:"excerpt,omitempty"`
+	Image       string     `json:"image,omitempty"`
+	Language    string     `json:"language,omitempty"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	WordCount   int        `json:"word_count"`
+	ReadingTime int        `json:"reading_time"`
+	Tags        []string   `json:"tags"`
+	Starred     bool       `json:"starred"`
+	Private     bool       `json:"private,omitempty"`
+	Extractor   string     `json:"extractor,omitempty"`
+	// FinalURL is where the redirects of the URL read ended, Canonical the
+	// page's <link rel="canonical">.
+	FinalURL  string `json:"final_url,omitempty"`
+	Canonical string `json:"canonical,omitempty"`
+	// ArchivedFrom is the Wayback Machine snapshot of a page that is gone.
+	ArchivedFrom string      `json:"archived_from,omitempty"`
+	ArchivedAt   *time.Time  `json:"archived_at,omitempty"`
+	Notes        string      `json:"notes,omitempty"`
+	Highlights   []highlight `json:"highlights,omitempty"`
+	Content      string      `json:"content"`
+}
+
+func writeArticleJSON(w http.ResponseWriter, art *article) {
+	if art.ErrMsg != "" {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": art.ErrMsg})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newArticleJSON(art))
+}
+
+func newArticleJSON(art *article) articleJSON {
+	data := articleJSON{
+		URL:         art.URL,
+		Title:       art.Title,
+		Byline:      art.Byline,
+		SiteName:    art.SiteName,
+		Excerpt:     art.Excerpt,
+		Image:       art.Image,
+		Language:    art.Language,
+		CreatedAt:   art.CreatedAt,
+		WordCount:   art.WordCount,
+		ReadingTime: art.ReadingTime,
+		Tags:        art.Tags,
+		Starred:     art.Starred,
+		Private:     art.Private,
+		Extractor:   art.Extractor,
+		FinalURL:    art.FinalURL,
+		Canonical:   art.Canonical,
+		Content:     art.Content,
+		Notes:       art.Notes,
+		Highlights:  art.Highlights,
+	}
+	if !art.PublishedAt.IsZero() {
+		data.PublishedAt = &art.PublishedAt
+	}
+	if art.ArchivedFrom != "" {
+		data.ArchivedFrom = art.ArchivedFrom
+		data.ArchivedAt = &art.ArchivedAt
+	}
+	if data.Tags == nil {
+		data.Tags = []string{}
+	}
+
+	return data
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+)
+
+// METRICS_TOKEN, when set, is the bearer token Prometheus has to scrape
+// /metrics with.
+var METRICS_TOKEN = envOr("METRICS_TOKEN", "")
+
+var (
+	extractionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "readability_extractions_total",
+		Help: "Articles read from their page, by result.",
+	}, []string{"result"})
+	cacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "readability_cache_requests_total",
+		Help: "Articles looked up in the cache, by whether they were there.",
+	}, []string{"result"})
+	fetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "readability_fetch_duration_seconds",
+		Help:    "How long fetching a page took, each try, by status class.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"status"})
+	redisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "readability_redis_duration_seconds",
+		Help:    "How long Redis commands and pipelines took, by command.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, 1},
+	}, []string{"command"})
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "readability_http_requests_in_flight",
+		Help: "Requests being served.",
+	})
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "readability_http_requests_total",
+		Help: "Requests served, by method and status code.",
+	}, []string{"method", "code"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "readability_http_request_duration_seconds",
+		Help:    "How long serving a request took, by method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+	readsRejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "readability_reads_rejected_total",
+		Help: "Pages anonymous clients weren't let read, by reason, rate or pow.",
+	}, []string{"reason"})
+)
+
+// instrumentHTTP counts the requests to next, timing them and the ones in
+// flight. Unlike promhttp's, its writer unwraps, for handlers to reach the
+// connection with an http.ResponseController.
+func instrumentHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		method := strings.ToLower(r.Method)
+		httpRequestsTotal.WithLabelValues(method, strconv.Itoa(rec.status)).Inc()
+		httpDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusClass is the class of a fetch's status, 2xx, 4xx..., or error when
+// there is no response.
+func statusClass(resp *http.Response, err error) string {
+	if err != nil || resp == nil {
+		return "error"
+	}
+
+	return fmt.Sprintf("%dxx", resp.StatusCode/100)
+}
+
+// redisMetrics times the commands sent to Redis.
+type redisMetrics struct{}
+
+func (redisMetrics) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (redisMetrics) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		redisDuration.WithLabelValues(cmd.Name()).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+func (redisMetrics) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		redisDuration.WithLabelValues("pipeline").Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+func metricsHandler() http.Handler {
+	h := promhttp.Handler()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if METRICS_TOKEN != "" && !secureEqual(r.Header.Get("Authorization"), "Bearer "+METRICS_TOKEN) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// notesMax caps an article's notes, in runes.
+const notesMax = 20000
+
+type notesUpdate struct {
+	URL   string `json:"url"`
+	Notes string `json:"notes"`
+}
+
+var (
+	apiNotesOp = apiOperation{
+		Summary:  "Get the notes of an article",
+		Tag:      "articles",
+		Query:    []apiParam{{Name: "url", Description: "The article's URL.", Required: true}},
+		Response: notesUpdate{},
+		Errors:   []int{http.StatusNotFound, http.StatusInternalServerError},
+	}
+	apiSetNotesOp = apiOperation{
+		Summary:     "Set the notes of an article",
+		Description: "Replaces the article's notes, empty notes clear them. They're searched along with the article.",
+		Tag:         "articles",
+		Body:        notesUpdate{},
+		Response:    notesUpdate{},
+		Errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+	}
+)
+
+// setNotes replaces the notes of the article stored under key, and indexes
+// them for search.
+func (lib *library) setNotes(key, notes string) error {
+	notes = truncateRunes(strings.TrimSpace(notes), notesMax)
+	if err := lib.store.UpdateArticle(key, func(art *article) { art.Notes = notes }); err != nil {
+		return err
+	}
+
+	art, err := lib.store.GetArticle(key)
+	if err != nil {
+		return err
+	}
+	if art != nil && art.ErrMsg == "" {
+		lib.index.Add(key, art)
+	}
+
+	return nil
+}
+
+// notesHandler saves the notes posted by the article page's form.
+func notesHandler(w http.ResponseWriter, r *http.Request) {
+	uri := r.FormValue("url")
+	if uri == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	lib := libraryFor(r)
+	if err := lib.setNotes(lib.resolveKey(uri), r.FormValue("notes")); err != nil {
+		if err == errArticleNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, articlePath("read", uri), http.StatusSeeOther)
+}
+
+// apiNotesHandler returns the notes of ?url= on GET and replaces them with
+// the posted ones on POST.
+func apiNotesHandler(w http.ResponseWriter, r *http.Request) {
+	lib := libraryFor(r)
+
+	if r.Method == http.MethodGet {
+		uri := r.URL.Query().Get("url")
+		art, err := lib.store.GetArticle(lib.resolveKey(uri))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if art == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": errArticleNotFound.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, notesUpdate{URL: uri, Notes: art.Notes})
+		return
+	}
+
+	var update notesUpdate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 256<<10)).Decode(&update); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if update.URL == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "url is required"})
+		return
+	}
+
+	err := lib.setNotes(lib.resolveKey(update.URL), update.Notes)
+	if err == errArticleNotFound {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	update.Notes = truncateRunes(strings.TrimSpace(update.Notes), notesMax)
+	writeJSON(w, http.StatusOK, update)
+}
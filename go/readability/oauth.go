@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	// GITHUB_CLIENT_ID and GITHUB_CLIENT_SECRET let users sign in with
+	// GitHub, from an OAuth app whose callback is /auth/github/callback.
+	GITHUB_CLIENT_ID     = envOr("GITHUB_CLIENT_ID", "")
+	GITHUB_CLIENT_SECRET = envOr("GITHUB_CLIENT_SECRET", "")
+	// GOOGLE_CLIENT_ID and GOOGLE_CLIENT_SECRET let users sign in with
+	// Google, its callback is /auth/google/callback.
+	GOOGLE_CLIENT_ID     = envOr("GOOGLE_CLIENT_ID", "")
+	GOOGLE_CLIENT_SECRET = envOr("GOOGLE_CLIENT_SECRET", "")
+	// OIDC_ISSUER, OIDC_CLIENT_ID and OIDC_CLIENT_SECRET let users sign in
+	// with any OpenID Connect provider, named OIDC_NAME on the login page,
+	// its callback is /auth/oidc/callback.
+	OIDC_ISSUER        = strings.TrimSuffix(envOr("OIDC_ISSUER", ""), "/")
+	OIDC_CLIENT_ID     = envOr("OIDC_CLIENT_ID", "")
+	OIDC_CLIENT_SECRET = envOr("OIDC_CLIENT_SECRET", "")
+	OIDC_NAME          = envOr("OIDC_NAME", "OpenID Connect")
+	// OAUTH_ALLOW are the emails, or @domains, allowed to sign in with a
+	// provider, comma separated. Anyone may when empty, while SIGNUP is
+	// open.
+	OAUTH_ALLOW = splitList(envOr("OAUTH_ALLOW", ""))
+)
+
+const (
+	oauthStateCookie = "readability-oauth"
+	oauthStateMaxAge = 10 * time.Minute
+)
+
+var oauthClient = &http.Client{Timeout: 10 * time.Second}
+
+// oauthProvider is an identity provider users sign in with through the
+// authorization code flow.
+type oauthProvider struct {
+	ID, Name               string
+	ClientID, ClientSecret string
+	Scope                  string
+	// Issuer is discovered for the endpoints when they aren't set.
+	Issuer                         string
+	AuthURL, TokenURL, UserInfoURL string
+
+	identity     func(accessToken string) (oauthIdentity, error)
+	discoverOnce sync.Once
+	discoverErr  error
+}
+
+// oauthIdentity is who signed in with a provider. Subject is their ID
+// there, which doesn't change when their name or email does.
+type oauthIdentity struct {
+	Subject, Name, Email string
+}
+
+var oauthProviders = func() []*oauthProvider {
+	var providers []*oauthProvider
+
+	if GITHUB_CLIENT_ID != "" {
+		p := &oauthProvider{
+			ID:           "github",
+			Name:         "GitHub",
+			ClientID:     GITHUB_CLIENT_ID,
+			ClientSecret: GITHUB_CLIENT_SECRET,
+			Scope:        "read:user user:email",
+			AuthURL:      "https://github.com/login/oauth/authorize",
+			TokenURL:     "https://github.com/login/oauth/access_token",
+			UserInfoURL:  "https://api.github.com/user",
+		}
+		p.identity = p.githubIdentity
+		providers = append(providers, p)
+	}
+	if GOOGLE_CLIENT_ID != "" {
+		p := &oauthProvider{
+			ID:           "google",
+			Name:         "Google",
+			ClientID:     GOOGLE_CLIENT_ID,
+			ClientSecret: GOOGLE_CLIENT_SECRET,
+			Scope:        "openid email profile",
+			Issuer:       "https://accounts.google.com",
+		}
+		p.identity = p.oidcIdentity
+		providers = append(providers, p)
+	}
+	if OIDC_ISSUER != "" && OIDC_CLIENT_ID != "" {
+		p := &oauthProvider{
+			ID:           "oidc",
+			Name:         OIDC_NAME,
+			ClientID:     OIDC_CLIENT_ID,
+			ClientSecret: OIDC_CLIENT_SECRET,
+			Scope:        "openid email profile",
+			Issuer:       OIDC_ISSUER,
+		}
+		p.identity = p.oidcIdentity
+		providers = append(providers, p)
+	}
+
+	return providers
+}()
+
+func oauthConfigured() bool {
+	return GITHUB_CLIENT_ID != "" || GOOGLE_CLIENT_ID != "" || (OIDC_ISSUER != "" && OIDC_CLIENT_ID != "")
+}
+
+func lookupOAuthProvider(id string) *oauthProvider {
+	for _, p := range oauthProviders {
+		if p.ID == id {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// discover fills in the endpoints of an OpenID Connect provider from its
+// discovery document, once.
+func (p *oauthProvider) discover() error {
+	p.discoverOnce.Do(func() {
+		if p.Issuer == "" || p.AuthURL != "" {
+			return
+		}
+
+		var doc struct {
+			AuthorizationEndpoint string `json:"authorization_endpoint"`
+			TokenEndpoint         string `json:"token_endpoint"`
+			UserinfoEndpoint      string `json:"userinfo_endpoint"`
+		}
+		if p.discoverErr = oauthGet(p.Issuer+"/.well-known/openid-configuration", "", &doc); p.discoverErr != nil {
+			p.discoverErr = fmt.Errorf("failed to discover %s: %w", p.Issuer, p.discoverErr)
+			return
+		}
+		if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.UserinfoEndpoint == "" {
+			p.discoverErr = fmt.Errorf("failed to discover %s: endpoints missing", p.Issuer)
+			return
+		}
+
+		p.AuthURL, p.TokenURL, p.UserInfoURL = doc.AuthorizationEndpoint, doc.TokenEndpoint, doc.UserinfoEndpoint
+	})
+
+	return p.discoverErr
+}
+
+func oauthRedirectURL(r *http.Request, p *oauthProvider) string {
+	return baseURL(r) + "/auth/" + p.ID + "/callback"
+}
+
+// exchange trades the code the provider redirected back with for an
+// access token.
+func (p *oauthProvider) exchange(r *http.Request, code string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oauthRedirectURL(r, p)},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tok); err != nil {
+		return "", fmt.Errorf("%s answered %s", p.Name, resp.Status)
+	}
+	if tok.AccessToken == "" {
+		if tok.Description != "" {
+			return "", errors.New(tok.Description)
+		}
+		return "", fmt.Errorf("%s gave no access token: %s", p.Name, tok.Error)
+	}
+
+	return tok.AccessToken, nil
+}
+
+func oauthGet(uri, accessToken string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", uri, resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func (p *oauthProvider) oidcIdentity(accessToken string) (oauthIdentity, error) {
+	var info struct {
+		Sub               string `json:"sub"`
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+		Email             string `json:"email"`
+		EmailVerified     *bool  `json:"email_verified"`
+	}
+	if err := oauthGet(p.UserInfoURL, accessToken, &info); err != nil {
+		return oauthIdentity{}, err
+	}
+	if info.Sub == "" {
+		return oauthIdentity{}, fmt.Errorf("%s gave no subject", p.Name)
+	}
+
+	id := oauthIdentity{Subject: info.Sub, Name: info.PreferredUsername}
+	if info.EmailVerified == nil || *info.EmailVerified {
+		id.Email = info.Email
+	}
+	if id.Name == "" {
+		id.Name = info.Name
+	}
+	if id.Name == "" {
+		id.Name = id.Email
+	}
+
+	return id, nil
+}
+
+func (p *oauthProvider) githubIdentity(accessToken string) (oauthIdentity, error) {
+	var info struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+	}
+	if err := oauthGet(p.UserInfoURL, accessToken, &info); err != nil {
+		return oauthIdentity{}, err
+	}
+
+	// The email on the profile may be hidden, the primary one is listed
+	// with user:email.
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := oauthGet("https://api.github.com/user/emails", accessToken, &emails); err != nil {
+		return oauthIdentity{}, err
+	}
+
+	id := oauthIdentity{Subject: fmt.Sprint(info.ID), Name: info.Login}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			id.Email = e.Email
+		}
+	}
+
+	return id, nil
+}
+
+// oauthAllowed reports whether OAUTH_ALLOW lets email sign in.
+func oauthAllowed(email string) bool {
+	email = strings.ToLower(email)
+	if email == "" {
+		return false
+	}
+
+	for _, allowed := range OAUTH_ALLOW {
+		allowed = strings.ToLower(allowed)
+		if allowed == email || (strings.HasPrefix(allowed, "@") && strings.HasSuffix(email, allowed)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// oauthUser returns the account of who signed in with p, making it the
+// first time while signing up is open.
+func oauthUser(p *oauthProvider, id oauthIdentity) (*user, error) {
+	if len(OAUTH_ALLOW) > 0 && !oauthAllowed(id.Email) {
+		return nil, fmt.Errorf("%s isn't allowed to sign in", id.Name)
+	}
+
+	// Accounts of a provider are named after it and the subject, which
+	// a name chosen at /signup can't be.
+	name := p.ID + ":" + id.Subject
+	u, err := store.GetUser(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if u != nil && u.DisplayName == id.Name {
+		return u, nil
+	}
+	if u == nil {
+		if SIGNUP != "open" && len(OAUTH_ALLOW) == 0 {
+			return nil, errors.New("signing up is closed")
+		}
+		u = &user{ID: randomID(8), Name: name, CreatedAt: time.Now()}
+	}
+
+	u.DisplayName = id.Name
+	if err := store.SetUser(u); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// oauthLoginHandler sends the visitor to sign in with the provider, with
+// a state to check they come back from the same browser.
+func oauthLoginHandler(w http.ResponseWriter, r *http.Request) {
+	p := lookupOAuthProvider(mux.Vars(r)["provider"])
+	if p == nil || !MULTI_USER {
+		http.NotFound(w, r)
+		return
+	}
+	if err := p.discover(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	state := randomID(16)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state + "." + url.QueryEscape(safeBack(r.FormValue("next"))),
+		Path:     "/auth/",
+		MaxAge:   int(oauthStateMaxAge / time.Second),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(baseURL(r), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {oauthRedirectURL(r, p)},
+		"scope":         {p.Scope},
+		"state":         {state},
+	}
+	http.Redirect(w, r, p.AuthURL+"?"+q.Encode(), http.StatusSeeOther)
+}
+
+// oauthCallbackHandler signs in who the provider sent back.
+func oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	p := lookupOAuthProvider(mux.Vars(r)["provider"])
+	if p == nil || !MULTI_USER {
+		http.NotFound(w, r)
+		return
+	}
+
+	c, err := r.Cookie(oauthStateCookie)
+	if err != nil {
+		http.Error(w, "sign in again, the sign in expired", http.StatusBadRequest)
+		return
+	}
+	state, next, _ := strings.Cut(c.Value, ".")
+	next, _ = url.QueryUnescape(next)
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/auth/", MaxAge: -1})
+
+	if !secureEqual(state, r.FormValue("state")) {
+		http.Error(w, "sign in again, the state doesn't match", http.StatusBadRequest)
+		return
+	}
+	if e := r.FormValue("error"); e != "" {
+		http.Error(w, p.Name+" refused to sign in: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	if err := p.discover(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	token, err := p.exchange(r, r.FormValue("code"))
+	if err != nil {
+		http.Error(w, "failed to sign in with "+p.Name+": "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	id, err := p.identity(token)
+	if err != nil {
+		http.Error(w, "failed to sign in with "+p.Name+": "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	u, err := oauthUser(p, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	setSession(w, r, u)
+	http.Redirect(w, r, safeBack(next), http.StatusSeeOther)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SWAGGER_UI_URL is where /api/docs loads Swagger UI from.
+var SWAGGER_UI_URL = envOr("SWAGGER_UI_URL", "https://cdn.jsdelivr.net/npm/swagger-ui-dist@5.17.14")
+
+// apiOperation documents an API route. Its request and response schemas
+// are those of the Go values the handler decodes and encodes, so the
+// document changes with them.
+type apiOperation struct {
+	Summary     string
+	Description string
+	Tag         string
+	// Query are the query parameters, the path's are taken from the route.
+	Query []apiParam
+	// Body is a value of the JSON request body's type, Form the fields of
+	// a form body instead.
+	Body interface{}
+	Form []apiParam
+	// Status is the answer when it goes well, 200 when 0, with Response,
+	// a value of the type of its JSON body, nil when it has none.
+	Status   int
+	Response interface{}
+	// Errors are the error statuses the handler answers with.
+	Errors []int
+	// Security is the scheme the route is called with, "token" when empty.
+	Security string
+
+	// path are the variables of the route's path.
+	path []apiParam
+}
+
+type apiParam struct {
+	Name        string
+	Description string
+	Required    bool
+	// Type is the JSON schema type, "string" when empty.
+	Type string
+}
+
+// apiRoutes registers documented API routes on router, whose routes are
+// under prefix.
+type apiRoutes struct {
+	router *mux.Router
+	prefix string
+}
+
+var (
+	apiDocMu sync.Mutex
+	// apiDoc are the documented operations, by path and lowercase method.
+	apiDoc = make(map[string]map[string]apiOperation)
+)
+
+func documentedRoutes(router *mux.Router, prefix string) *apiRoutes {
+	return &apiRoutes{router: router, prefix: prefix}
+}
+
+// handle routes method requests to path to h and documents them as op.
+// GET routes take HEAD too.
+func (a *apiRoutes) handle(method, path string, h http.HandlerFunc, op apiOperation) *mux.Route {
+	methods := []string{method}
+	if method == http.MethodGet {
+		methods = append(methods, http.MethodHead)
+	}
+
+	apiDocMu.Lock()
+	full := routeVarRe.ReplaceAllString(a.prefix+path, "{$1}")
+	if apiDoc[full] == nil {
+		apiDoc[full] = make(map[string]apiOperation)
+	}
+	op.path = pathParams(a.prefix + path)
+	apiDoc[full][strings.ToLower(method)] = op
+	apiDocMu.Unlock()
+
+	return a.router.HandleFunc(path, h).Methods(methods...)
+}
+
+// routeVarRe finds the variables of a route template, {id:[0-9]+}.
+var routeVarRe = regexp.MustCompile(`\{([^}:]+)(?::([^}]*))?\}`)
+
+// pathParams are the variables of a route template, integers when their
+// pattern says so.
+func pathParams(template string) []apiParam {
+	var params []apiParam
+	for _, m := range routeVarRe.FindAllStringSubmatch(template, -1) {
+		typ := "string"
+		if m[2] == "[0-9]+" {
+			typ = "integer"
+		}
+		params = append(params, apiParam{Name: m[1], Required: true, Type: typ})
+	}
+
+	return params
+}
+
+// openAPIHandler serves the OpenAPI 3 document of the API.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPIDocument(baseURL(r)))
+}
+
+func openAPIDocument(server string) map[string]interface{} {
+	apiDocMu.Lock()
+	defer apiDocMu.Unlock()
+
+	schemas := make(map[string]interface{})
+	paths := make(map[string]interface{}, len(apiDoc))
+	for path, ops := range apiDoc {
+		item := make(map[string]interface{}, len(ops))
+		for method, op := range ops {
+			item[method] = op.document(schemas)
+		}
+		paths[path] = item
+	}
+	schemas["Error"] = map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"error": map[string]interface{}{"type": "string"}},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Readability",
+			"description": "Read, save and search articles. Calls need an API token, from /tokens, when the instance requires one or to reach a user's library.",
+			"version":     "1",
+		},
+		"servers": []map[string]string{{"url": server}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"token":    map[string]string{"type": "http", "scheme": "bearer", "description": "An API token, from /tokens."},
+				"wallabag": map[string]string{"type": "http", "scheme": "bearer", "description": "A token from /oauth/v2/token."},
+			},
+		},
+	}
+}
+
+func (op apiOperation) document(schemas map[string]interface{}) map[string]interface{} {
+	doc := map[string]interface{}{"summary": op.Summary}
+	if op.Description != "" {
+		doc["description"] = op.Description
+	}
+	if op.Tag != "" {
+		doc["tags"] = []string{op.Tag}
+	}
+
+	switch op.Security {
+	case "":
+		// The token is optional unless API_TOKEN_REQUIRED.
+		doc["security"] = []map[string][]string{{}, {"token": {}}}
+	case "none":
+		doc["security"] = []map[string][]string{}
+	default:
+		doc["security"] = []map[string][]string{{op.Security: {}}}
+	}
+
+	var params []interface{}
+	for _, p := range op.path {
+		params = append(params, p.document("path"))
+	}
+	for _, p := range op.Query {
+		params = append(params, p.document("query"))
+	}
+	if params != nil {
+		doc["parameters"] = params
+	}
+
+	switch {
+	case op.Body != nil:
+		doc["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaOf(reflect.ValueOf(op.Body), schemas)}},
+		}
+	case op.Form != nil:
+		props := make(map[string]interface{}, len(op.Form))
+		var required []string
+		for _, f := range op.Form {
+			props[f.Name] = map[string]string{"type": f.schemaType(), "description": f.Description}
+			if f.Required {
+				required = append(required, f.Name)
+			}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": props}
+		if required != nil {
+			schema["required"] = required
+		}
+		doc["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"application/x-www-form-urlencoded": map[string]interface{}{"schema": schema}},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	ok := map[string]interface{}{"description": http.StatusText(status)}
+	if op.Response != nil {
+		ok["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaOf(reflect.ValueOf(op.Response), schemas)}}
+	}
+	responses := map[string]interface{}{strconv.Itoa(status): ok}
+	for _, code := range op.Errors {
+		responses[strconv.Itoa(code)] = map[string]interface{}{
+			"description": http.StatusText(code),
+			"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]string{"$ref": "#/components/schemas/Error"}}},
+		}
+	}
+	doc["responses"] = responses
+
+	return doc
+}
+
+func (p apiParam) schemaType() string {
+	if p.Type == "" {
+		return "string"
+	}
+
+	return p.Type
+}
+
+func (p apiParam) document(in string) map[string]interface{} {
+	doc := map[string]interface{}{"name": p.Name, "in": in, "required": p.Required, "schema": map[string]string{"type": p.schemaType()}}
+	if p.Description != "" {
+		doc["description"] = p.Description
+	}
+
+	return doc
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf is the JSON schema of v's type, with named structs put in
+// schemas and referenced. The keys of a map value are its properties.
+func schemaOf(v reflect.Value, schemas map[string]interface{}) map[string]interface{} {
+	if v.Kind() == reflect.Map && v.Len() > 0 {
+		props := make(map[string]interface{}, v.Len())
+		for _, key := range v.MapKeys() {
+			props[key.String()] = schemaOf(v.MapIndex(key), schemas)
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	}
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		return schemaOf(v.Elem(), schemas)
+	}
+
+	return typeSchema(v.Type(), schemas)
+}
+
+func typeSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := typeSchema(t.Elem(), schemas)
+		if _, ref := schema["$ref"]; !ref {
+			schema["nullable"] = true
+		}
+		return schema
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		name := t.Name()
+		if name == "" {
+			return structSchema(t, schemas)
+		}
+		name = strings.ToUpper(name[:1]) + name[1:]
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = nil // for recursive types
+			schemas[name] = structSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	props := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = typeSchema(f.Type, schemas)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": props}
+	if required != nil {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+// apiDocsHandler serves Swagger UI on the OpenAPI document.
+func apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Security-Policy", contentSecurityPolicy(false, cspSource(SWAGGER_UI_URL)))
+	executePage(w, r, "apidocs.html", map[string]interface{}{
+		"SwaggerUI": strings.TrimSuffix(SWAGGER_UI_URL, "/"),
+	})
+}
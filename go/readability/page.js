@@ -0,0 +1,516 @@
+// The scripts of the pages, kept out of them for the Content-Security-Policy
+// to refuse inline scripts. Elements ask for behaviours with data-*
+// attributes.
+(function () {
+    "use strict";
+
+    // <select data-autosubmit> submits its form on change.
+    document.querySelectorAll("select[data-autosubmit]").forEach(function (el) {
+        el.addEventListener("change", function () {
+            el.form.submit();
+        });
+    });
+
+    // <form data-confirm="Sure?"> asks before submitting.
+    document.querySelectorAll("form[data-confirm]").forEach(function (el) {
+        el.addEventListener("submit", function (e) {
+            if (!confirm(el.dataset.confirm)) {
+                e.preventDefault();
+            }
+        });
+    });
+
+    // <input data-autoselect> selects its text on click, to copy it.
+    document.querySelectorAll("[data-autoselect]").forEach(function (el) {
+        el.addEventListener("click", function () {
+            el.select();
+        });
+    });
+
+    // <div class="content" data-math="tex|dollars"> has its math rendered
+    // with KaTeX, whose deferred scripts have run by DOMContentLoaded.
+    var math = document.querySelector(".content[data-math]");
+    if (math) {
+        document.addEventListener("DOMContentLoaded", function () {
+            if (!window.renderMathInElement) {
+                return;
+            }
+            var delimiters = [
+                { left: "$$", right: "$$", display: true },
+                { left: "\\[", right: "\\]", display: true },
+                { left: "\\(", right: "\\)", display: false }
+            ];
+            if (math.dataset.math === "dollars") {
+                delimiters.push({ left: "$", right: "$", display: false });
+            }
+            renderMathInElement(math, {
+                delimiters: delimiters,
+                ignoredTags: ["script", "noscript", "style", "textarea", "pre", "code"],
+                throwOnError: false
+            });
+        });
+    }
+
+    // <div class="content" data-url data-progress> scrolls back to where
+    // the article was left and reports how far it is read. A page of an
+    // article split into pages has data-page-start and data-page-end, the
+    // part of the article it is, the progress is of the whole article.
+    var content = document.querySelector(".content[data-url]");
+    if (content) {
+        var url = content.dataset.url;
+        var saved = parseFloat(content.dataset.progress) || 0;
+        var pageStart = parseFloat(content.dataset.pageStart) || 0;
+        var pageEnd = parseFloat(content.dataset.pageEnd) || 1;
+
+        var scrollable = function () {
+            return document.documentElement.scrollHeight - window.innerHeight;
+        };
+
+        window.addEventListener("load", function () {
+            if (saved > pageStart && saved < pageEnd && !location.hash) {
+                window.scrollTo(0, (saved - pageStart) / (pageEnd - pageStart) * scrollable());
+            }
+        });
+
+        var report = function (beacon) {
+            var max = scrollable();
+            if (max <= 0) {
+                return;
+            }
+            var progress = pageStart + (pageEnd - pageStart) * Math.min(1, Math.max(0, window.scrollY / max));
+            if (Math.abs(progress - saved) < 0.01) {
+                return;
+            }
+            saved = progress;
+
+            var body = JSON.stringify({ url: url, progress: progress });
+            if (beacon && navigator.sendBeacon) {
+                navigator.sendBeacon("/api/v1/progress", new Blob([body], { type: "application/json" }));
+            } else {
+                fetch("/api/v1/progress", { method: "POST", body: body, keepalive: true,
+                    headers: { "Content-Type": "application/json" } });
+            }
+        };
+
+        var timer;
+        window.addEventListener("scroll", function () {
+            clearTimeout(timer);
+            timer = setTimeout(report, 2000);
+        });
+        document.addEventListener("visibilitychange", function () {
+            if (document.visibilityState === "hidden") {
+                report(true);
+            }
+        });
+    }
+
+    // <div class="highlight-tools"> with the .content[data-url] marks the
+    // article's highlights, highlights the text selected in it, and edits
+    // the note of a highlight or removes it once clicked. Highlights are
+    // found again by their text, and the text around them when it's there
+    // more than once.
+    var tools = document.querySelector(".highlight-tools");
+    if (content && tools) {
+        var article = content.dataset.url;
+        var selected, clicked;
+
+        // textNodes are the text nodes of the content with where each
+        // starts in its text.
+        var textNodes = function () {
+            var nodes = [], offset = 0;
+            var walker = document.createTreeWalker(content, NodeFilter.SHOW_TEXT);
+            for (var node = walker.nextNode(); node; node = walker.nextNode()) {
+                nodes.push({ node: node, start: offset });
+                offset += node.data.length;
+            }
+            return nodes;
+        };
+
+        var locate = function (text, h) {
+            var best = -1, bestScore = -1;
+            for (var i = text.indexOf(h.text); i >= 0; i = text.indexOf(h.text, i + 1)) {
+                var score = 0;
+                if (h.prefix && text.slice(Math.max(0, i - h.prefix.length), i) === h.prefix) {
+                    score++;
+                }
+                if (h.suffix && text.substr(i + h.text.length, h.suffix.length) === h.suffix) {
+                    score++;
+                }
+                if (score > bestScore) {
+                    best = i;
+                    bestScore = score;
+                }
+            }
+            return best;
+        };
+
+        // mark wraps the text from start to end in <mark>s, one for each
+        // text node it spans.
+        var mark = function (start, end, h) {
+            textNodes().forEach(function (n) {
+                var from = Math.max(start, n.start), to = Math.min(end, n.start + n.node.data.length);
+                if (from >= to) {
+                    return;
+                }
+                var node = n.node;
+                if (to - n.start < node.data.length) {
+                    node.splitText(to - n.start);
+                }
+                if (from > n.start) {
+                    node = node.splitText(from - n.start);
+                }
+                var el = document.createElement("mark");
+                el.className = "highlight";
+                el.dataset.highlight = h.id;
+                if (h.note) {
+                    el.title = h.note;
+                }
+                node.parentNode.replaceChild(el, node);
+                el.appendChild(node);
+            });
+        };
+
+        var marks = function (id) {
+            return content.querySelectorAll("mark[data-highlight=\"" + id + "\"]");
+        };
+
+        var show = function (rect, actions) {
+            tools.querySelectorAll("button").forEach(function (b) {
+                b.hidden = actions.indexOf(b.dataset.action) < 0;
+            });
+            tools.style.top = (window.scrollY + rect.bottom + 4) + "px";
+            tools.style.left = (window.scrollX + rect.left) + "px";
+            tools.hidden = false;
+        };
+
+        var call = function (method, path, body) {
+            return fetch(path, { method: method, body: body && JSON.stringify(body),
+                headers: { "Content-Type": "application/json" } })
+                .then(function (resp) {
+                    return resp.json().then(function (data) {
+                        return resp.ok ? data : Promise.reject(data.error);
+                    });
+                })
+                .catch(function (err) {
+                    alert("Couldn't save the highlight: " + err);
+                    return Promise.reject(err);
+                });
+        };
+
+        call("GET", "/api/v1/highlights?url=" + encodeURIComponent(article)).then(function (arts) {
+            (arts[0] && arts[0].highlights || []).forEach(function (h) {
+                var text = content.textContent;
+                var start = locate(text, h);
+                if (start >= 0) {
+                    mark(start, start + h.text.length, h);
+                }
+            });
+        });
+
+        var onSelect = function () {
+            var sel = window.getSelection();
+            if (sel.isCollapsed || !sel.rangeCount || !content.contains(sel.getRangeAt(0).commonAncestorContainer)) {
+                selected = clicked = null;
+                tools.hidden = true;
+                return;
+            }
+            var range = sel.getRangeAt(0);
+            var before = document.createRange();
+            before.setStart(content, 0);
+            before.setEnd(range.startContainer, range.startOffset);
+            var start = before.toString().length;
+            selected = { start: start, end: start + range.toString().length };
+            clicked = null;
+            show(range.getBoundingClientRect(), ["highlight"]);
+        };
+        var onRelease = function (e) {
+            // Clicks on highlights and the tools are theirs.
+            if (e.target.closest && e.target.closest("mark.highlight, .highlight-tools")) {
+                return;
+            }
+            setTimeout(onSelect, 0);
+        };
+        document.addEventListener("mouseup", onRelease);
+        document.addEventListener("touchend", onRelease);
+
+        content.addEventListener("click", function (e) {
+            var el = e.target.closest("mark.highlight");
+            if (!el || !window.getSelection().isCollapsed) {
+                return;
+            }
+            clicked = el.dataset.highlight;
+            show(el.getBoundingClientRect(), ["note", "remove"]);
+        });
+
+        // The selection stays when the tools are clicked.
+        tools.addEventListener("mousedown", function (e) {
+            e.preventDefault();
+        });
+        tools.addEventListener("click", function (e) {
+            var action = e.target.dataset.action;
+            if (action === "highlight" && selected) {
+                var text = content.textContent, s = selected;
+                var h = {
+                    url: article,
+                    text: text.slice(s.start, s.end),
+                    prefix: text.slice(Math.max(0, s.start - 32), s.start),
+                    suffix: text.slice(s.end, s.end + 32)
+                };
+                var note = prompt("Note, optional:", "");
+                if (note === null || !h.text.trim()) {
+                    return;
+                }
+                h.note = note;
+                call("POST", "/api/v1/highlights", h).then(function (saved) {
+                    window.getSelection().removeAllRanges();
+                    mark(s.start, s.end, saved);
+                });
+            } else if (action === "note" && clicked) {
+                var id = clicked, els = marks(id);
+                var edited = prompt("Note:", els[0] ? els[0].title : "");
+                if (edited === null) {
+                    return;
+                }
+                call("PUT", "/api/v1/highlights/" + id, { url: article, note: edited }).then(function () {
+                    els.forEach(function (el) {
+                        el.title = edited;
+                    });
+                });
+            } else if (action === "remove" && clicked) {
+                var removed = clicked;
+                call("DELETE", "/api/v1/highlights/" + removed + "?url=" + encodeURIComponent(article)).then(function () {
+                    marks(removed).forEach(function (el) {
+                        el.replaceWith.apply(el, Array.prototype.slice.call(el.childNodes));
+                    });
+                    content.normalize();
+                });
+            }
+            tools.hidden = true;
+            selected = clicked = null;
+        });
+    }
+
+    // <details data-summarize="{URL}"> asks for the article's summary the
+    // first time it's opened, unless it holds it already.
+    var summary = document.querySelector("details[data-summarize]");
+    if (summary && !summary.open) {
+        summary.addEventListener("toggle", function ask() {
+            summary.removeEventListener("toggle", ask);
+            var text = summary.querySelector("p");
+            text.textContent = "Summarizing…";
+            fetch("/api/v1/summarize?url=" + encodeURIComponent(summary.dataset.summarize), { method: "POST" })
+                .then(function (resp) {
+                    return resp.json();
+                })
+                .then(function (body) {
+                    text.textContent = body.summary || "Couldn't summarize: " + body.error;
+                })
+                .catch(function (err) {
+                    text.textContent = "Couldn't summarize: " + err;
+                });
+        });
+    }
+
+    // <form data-notes> saves the article's notes through the API, staying
+    // on the page.
+    var notes = document.querySelector("form[data-notes]");
+    if (notes) {
+        var noteStatus = notes.querySelector(".status");
+        notes.addEventListener("submit", function (e) {
+            e.preventDefault();
+            noteStatus.textContent = "Saving…";
+            fetch("/api/v1/notes", {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({ url: notes.elements.url.value, notes: notes.elements.notes.value })
+            })
+                .then(function (resp) {
+                    return resp.json();
+                })
+                .then(function (body) {
+                    noteStatus.textContent = body.error ? "Couldn't save: " + body.error : "Saved.";
+                })
+                .catch(function (err) {
+                    noteStatus.textContent = "Couldn't save: " + err;
+                });
+        });
+        notes.elements.notes.addEventListener("input", function () {
+            noteStatus.textContent = "";
+        });
+    }
+
+    // <p data-job data-next> follows an extraction job, opening next once
+    // it's done.
+    var state = document.querySelector("[data-job]");
+    if (state) {
+        var next = state.dataset.next;
+        var labels = {
+            queued: "Waiting for a worker…",
+            running: "Fetching and extracting the page…",
+            done: "Done, opening the article…"
+        };
+
+        var events = new EventSource("/events/" + encodeURIComponent(state.dataset.job));
+        events.addEventListener("state", function (e) {
+            var job = JSON.parse(e.data);
+            if (job.state === "failed") {
+                events.close();
+                state.textContent = "Failed: " + job.error;
+                return;
+            }
+            state.textContent = labels[job.state] || job.state;
+            if (job.state === "done") {
+                events.close();
+                location.replace(next);
+            }
+        });
+        events.onerror = function () {
+            events.close();
+            location.replace(next);
+        };
+    }
+    // <form data-pow data-bits> looks for the nonce making the SHA-256 of
+    // its challenge start with data-bits zero bits, then submits it. The
+    // hashing is done here, crypto.subtle is only there over HTTPS.
+    var pow = document.querySelector("form[data-pow]");
+    if (pow) {
+        var K = [], H0 = [];
+        var frac = function (x) {
+            return ((x - Math.floor(x)) * 4294967296) | 0;
+        };
+        for (var n = 2; K.length < 64; n++) {
+            var prime = true;
+            for (var m = 2; m * m <= n; m++) {
+                if (n % m === 0) {
+                    prime = false;
+                    break;
+                }
+            }
+            if (prime) {
+                if (H0.length < 8) {
+                    H0.push(frac(Math.pow(n, 1 / 2)));
+                }
+                K.push(frac(Math.pow(n, 1 / 3)));
+            }
+        }
+        var rotr = function (x, n) {
+            return (x >>> n) | (x << (32 - n));
+        };
+
+        // zeroBits is how many zero bits the SHA-256 of the ASCII text
+        // starts with.
+        var zeroBits = function (text) {
+            var bytes = [];
+            for (var i = 0; i < text.length; i++) {
+                bytes.push(text.charCodeAt(i) & 0xff);
+            }
+            var length = text.length * 8;
+            bytes.push(0x80);
+            while (bytes.length % 64 !== 56) {
+                bytes.push(0);
+            }
+            bytes.push(0, 0, 0, 0, (length >>> 24) & 0xff, (length >>> 16) & 0xff, (length >>> 8) & 0xff, length & 0xff);
+
+            var h = H0.slice(), w = [];
+            for (var o = 0; o < bytes.length; o += 64) {
+                for (var j = 0; j < 64; j++) {
+                    if (j < 16) {
+                        w[j] = (bytes[o + 4 * j] << 24) | (bytes[o + 4 * j + 1] << 16) | (bytes[o + 4 * j + 2] << 8) | bytes[o + 4 * j + 3];
+                    } else {
+                        var s0 = rotr(w[j - 15], 7) ^ rotr(w[j - 15], 18) ^ (w[j - 15] >>> 3);
+                        var s1 = rotr(w[j - 2], 17) ^ rotr(w[j - 2], 19) ^ (w[j - 2] >>> 10);
+                        w[j] = (w[j - 16] + s0 + w[j - 7] + s1) | 0;
+                    }
+                }
+                var a = h[0], b = h[1], c = h[2], d = h[3], e = h[4], f = h[5], g = h[6], hh = h[7];
+                for (j = 0; j < 64; j++) {
+                    var t1 = (hh + (rotr(e, 6) ^ rotr(e, 11) ^ rotr(e, 25)) + ((e & f) ^ (~e & g)) + K[j] + w[j]) | 0;
+                    var t2 = ((rotr(a, 2) ^ rotr(a, 13) ^ rotr(a, 22)) + ((a & b) ^ (a & c) ^ (b & c))) | 0;
+                    hh = g;
+                    g = f;
+                    f = e;
+                    e = (d + t1) | 0;
+                    d = c;
+                    c = b;
+                    b = a;
+                    a = (t1 + t2) | 0;
+                }
+                h = [(h[0] + a) | 0, (h[1] + b) | 0, (h[2] + c) | 0, (h[3] + d) | 0,
+                    (h[4] + e) | 0, (h[5] + f) | 0, (h[6] + g) | 0, (h[7] + hh) | 0];
+            }
+
+            var zeros = 0;
+            for (i = 0; i < 8; i++) {
+                zeros += Math.clz32(h[i]);
+                if (h[i] !== 0) {
+                    break;
+                }
+            }
+            return zeros;
+        };
+
+        var bits = parseInt(pow.dataset.bits, 10);
+        var challenge = pow.elements.challenge.value + ":";
+        var nonce = 0;
+        var work = function () {
+            for (var end = nonce + 20000; nonce < end; nonce++) {
+                if (zeroBits(challenge + nonce) >= bits) {
+                    pow.elements.nonce.value = nonce;
+                    pow.submit();
+                    return;
+                }
+            }
+            setTimeout(work, 0);
+        };
+        work();
+    }
+    // <a class="footnote-ref"> shows its note under the paragraph when
+    // clicked, rather than jumping to it, the note's text as it was found
+    // when it's on another page. Clicked again it hides it.
+    document.querySelectorAll("a.footnote-ref").forEach(function (ref) {
+        ref.addEventListener("click", function (e) {
+            if (e.ctrlKey || e.metaKey || e.shiftKey) {
+                return;
+            }
+            e.preventDefault();
+
+            var block = ref.closest("p, li, blockquote, td, dd") || ref;
+            var open = block.nextElementSibling;
+            if (open && open.classList.contains("footnote-popup")) {
+                open.remove();
+                if (open.dataset.ref === ref.hash) {
+                    return;
+                }
+            }
+
+            var popup = document.createElement("aside");
+            popup.className = "footnote-popup";
+            popup.dataset.ref = ref.hash;
+            var note = ref.hash && document.getElementById(decodeURIComponent(ref.hash.slice(1)));
+            if (note && note.closest(".content")) {
+                popup.innerHTML = (note.closest("li") || note).innerHTML;
+                popup.querySelectorAll(".footnote-back, [id]").forEach(function (el) {
+                    if (el.classList.contains("footnote-back")) {
+                        el.remove();
+                    } else {
+                        el.removeAttribute("id");
+                    }
+                });
+            } else {
+                popup.textContent = ref.title;
+            }
+            block.after(popup);
+        });
+    });
+
+    // <div id="swagger-ui" data-spec> shows the API's document with
+    // Swagger UI, whose deferred script has run by DOMContentLoaded.
+    var swagger = document.querySelector("#swagger-ui[data-spec]");
+    if (swagger) {
+        document.addEventListener("DOMContentLoaded", function () {
+            if (window.SwaggerUIBundle) {
+                SwaggerUIBundle({ url: swagger.dataset.spec, domNode: swagger });
+            }
+        });
+    }
+})();
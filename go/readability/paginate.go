@@ -0,0 +1,234 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// PAGE_WORDS is about how many words a page of a long article holds, longer
+// articles are split into pages, at a heading when one comes after half a
+// page. 0 keeps every article on a single page.
+var PAGE_WORDS = envInt("PAGE_WORDS", 4000)
+
+// pagination is where an article split into pages is at, ?page=N, 1 to
+// Pages, and the links to the pages around it and to the whole article on
+// a single page, ?page=all.
+type pagination struct {
+	Page   int
+	Pages  int
+	Prev   string
+	Next   string
+	Links  []pageNav
+	Single string
+	// Start and End are how far into the article the page starts and ends,
+	// in words from 0 to 1, for the reading progress of the whole article.
+	Start float64
+	End   float64
+}
+
+// pageNav links to page N of an article.
+type pageNav struct {
+	N    int
+	Link string
+}
+
+// pageLink is the link to the article page r shows, on page, "all" for the
+// single page.
+func pageLink(r *http.Request, page string) string {
+	q := r.URL.Query()
+	q.Set("page", page)
+
+	return r.URL.Path + "?" + q.Encode()
+}
+
+// paginate splits content into pages of about words words, unless it's
+// shorter than a page and a half. The links to anchors of another page go
+// to that page, link returns the link to a page from 0. It returns the
+// pages with how many words are before each, and in all after the last,
+// and the page of every anchor.
+func paginate(content string, words int, link func(page int) string) ([]string, []int, map[string]int) {
+	if words <= 0 {
+		return []string{content}, nil, nil
+	}
+
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(content), body)
+	if err != nil {
+		return []string{content}, nil, nil
+	}
+	// Extractors often wrap the whole article in a <div>, what's split is
+	// what's inside.
+	for {
+		wrapper := onlyElement(nodes)
+		if wrapper == nil {
+			break
+		}
+		switch wrapper.DataAtom {
+		case atom.Div, atom.Article, atom.Section, atom.Main:
+		default:
+			wrapper = nil
+		}
+		if wrapper == nil {
+			break
+		}
+		nodes = nodes[:0]
+		for c := wrapper.FirstChild; c != nil; c = c.NextSibling {
+			nodes = append(nodes, c)
+		}
+	}
+
+	var pages [][]*html.Node
+	var counts []int
+	total, current := 0, 0
+	for _, n := range nodes {
+		count := len(strings.Fields(nodeText(n)))
+		heading := n.Type == html.ElementNode && (n.DataAtom == atom.H1 || n.DataAtom == atom.H2 || n.DataAtom == atom.H3)
+		if len(pages) == 0 || current > 0 && (current >= words || heading && current >= words/2) {
+			pages = append(pages, nil)
+			counts = append(counts, 0)
+			current = 0
+		}
+		pages[len(pages)-1] = append(pages[len(pages)-1], n)
+		counts[len(counts)-1] += count
+		current += count
+		total += count
+	}
+	if total < words+words/2 || len(pages) < 2 {
+		return []string{content}, nil, nil
+	}
+	// A last page of a few paragraphs goes with the one before.
+	if last := len(pages) - 1; counts[last] < words/4 {
+		pages[last-1] = append(pages[last-1], pages[last]...)
+		counts[last-1] += counts[last]
+		pages, counts = pages[:last], counts[:last]
+	}
+
+	anchors := make(map[string]int)
+	for i, page := range pages {
+		for _, n := range page {
+			walkElements(n, func(n *html.Node) {
+				if id := attrValue(n, "id"); id != "" {
+					anchors[id] = i
+				}
+				if n.DataAtom == atom.A {
+					if name := attrValue(n, "name"); name != "" {
+						anchors[name] = i
+					}
+				}
+			})
+		}
+	}
+
+	rendered := make([]string, len(pages))
+	starts := make([]int, len(pages)+1)
+	before := 0
+	for i, page := range pages {
+		starts[i] = before
+		before += counts[i]
+
+		var sb strings.Builder
+		for _, n := range page {
+			walkElements(n, func(n *html.Node) {
+				if n.DataAtom != atom.A {
+					return
+				}
+				for j, attr := range n.Attr {
+					if attr.Key != "href" || !strings.HasPrefix(attr.Val, "#") {
+						continue
+					}
+					if p, ok := anchors[attr.Val[1:]]; ok && p != i {
+						n.Attr[j].Val = link(p) + attr.Val
+					}
+				}
+			})
+			if err := html.Render(&sb, n); err != nil {
+				return []string{content}, nil, nil
+			}
+		}
+		rendered[i] = sb.String()
+	}
+	starts[len(pages)] = before
+
+	return rendered, starts, anchors
+}
+
+// onlyElement returns the single element of nodes, nil when there are
+// more, or text besides whitespace.
+func onlyElement(nodes []*html.Node) *html.Node {
+	var only *html.Node
+	for _, n := range nodes {
+		switch {
+		case n.Type == html.TextNode && strings.TrimSpace(n.Data) == "":
+		case n.Type == html.CommentNode:
+		case n.Type == html.ElementNode && only == nil:
+			only = n
+		default:
+			return nil
+		}
+	}
+
+	return only
+}
+
+// walkElements calls fn with n and every element under it.
+func walkElements(n *html.Node, fn func(n *html.Node)) {
+	if n.Type == html.ElementNode {
+		fn(n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walkElements(c, fn)
+	}
+}
+
+// paginateArticle puts on data the page of its article r asks for, when
+// it's long enough to be split, and the links around it.
+func paginateArticle(r *http.Request, data *articlePage) {
+	param := r.URL.Query().Get("page")
+	pages, starts, anchors := paginate(data.Content, PAGE_WORDS, func(page int) string {
+		return pageLink(r, strconv.Itoa(page+1))
+	})
+	if len(pages) < 2 {
+		return
+	}
+	// Back to the pages from the single one.
+	if param == "all" {
+		data.Pagination = pagination{Pages: len(pages), Single: pageLink(r, "1")}
+		return
+	}
+
+	total := float64(max(starts[len(pages)], 1))
+	page, _ := strconv.Atoi(param)
+	// Opened where it was left.
+	if param == "" {
+		for i := range pages {
+			if float64(starts[i])/total <= data.Progress {
+				page = i + 1
+			}
+		}
+	}
+	page = min(max(page, 1), len(pages))
+	p := pagination{Page: page, Pages: len(pages), Single: pageLink(r, "all")}
+	for i := range pages {
+		p.Links = append(p.Links, pageNav{N: i + 1, Link: pageLink(r, strconv.Itoa(i+1))})
+	}
+	if page > 1 {
+		p.Prev = p.Links[page-2].Link
+	}
+	if page < len(pages) {
+		p.Next = p.Links[page].Link
+	}
+	p.Start = float64(starts[page-1]) / total
+	p.End = float64(starts[page]) / total
+	for i, entry := range data.TOC {
+		if at, ok := anchors[entry.ID]; ok && at != page-1 {
+			data.TOC[i].Href = p.Links[at].Link + "#" + entry.ID
+		}
+	}
+
+	data.Content = pages[page-1]
+	data.Pagination = p
+}
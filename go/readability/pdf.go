@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	WKHTMLTOPDF   = envOr("WKHTMLTOPDF", "wkhtmltopdf")
+	PDF_PAGE_SIZE = envOr("PDF_PAGE_SIZE", "A4")
+	PDF_MARGIN    = envOr("PDF_MARGIN", "15mm")
+
+	pageSizeRe = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+	marginRe   = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(mm|cm|in)?$`)
+)
+
+// writePDF renders the article with the site stylesheet and its images
+// inlined and converts it with wkhtmltopdf, which loads nothing else.
+func writePDF(w http.ResponseWriter, r *http.Request, art *article) {
+	if art.ErrMsg != "" {
+		http.Error(w, art.ErrMsg, http.StatusBadGateway)
+		return
+	}
+
+	// Not in the Docker image, say so rather than fail each time.
+	if _, err := exec.LookPath(WKHTMLTOPDF); err != nil {
+		http.Error(w, "PDF export needs wkhtmltopdf, not installed here", http.StatusNotImplemented)
+		return
+	}
+
+	if !pageSizeRe.MatchString(PDF_PAGE_SIZE) || !marginRe.MatchString(PDF_MARGIN) {
+		http.Error(w, fmt.Sprintf("invalid pdf page size %q or margin %q", PDF_PAGE_SIZE, PDF_MARGIN), http.StatusInternalServerError)
+		return
+	}
+
+	css, err := cssFile.ReadFile("style.css")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var page bytes.Buffer
+	err = tmpl.ExecuteTemplate(&page, "pdf.html", map[string]interface{}{
+		"Article": art,
+		"Content": template.HTML(pdfContent(art.Content)),
+		"Style":   template.CSS(css),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	var out, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, WKHTMLTOPDF,
+		"--quiet",
+		"--disable-local-file-access",
+		"--disable-javascript",
+		"--disable-external-links",
+		"--encoding", "utf-8",
+		"--page-size", PDF_PAGE_SIZE,
+		"--margin-top", PDF_MARGIN,
+		"--margin-bottom", PDF_MARGIN,
+		"--margin-left", PDF_MARGIN,
+		"--margin-right", PDF_MARGIN,
+		"--title", art.Title,
+		"-", "-")
+	cmd.Stdin = &page
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		slog.Error("failed to convert article to pdf", "err", err, "stderr", stderr.String())
+		http.Error(w, "failed to convert article to pdf: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", attachment(art.Title, ".pdf"))
+	w.Write(out.Bytes())
+}
+
+// pdfContent is content with its images inlined, and the sources of those
+// that couldn't be dropped, for wkhtmltopdf not to fetch them itself.
+func pdfContent(content string) string {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(inlineImages(content)), body)
+	if err != nil {
+		return ""
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+
+	walkElements(body, func(n *html.Node) {
+		attrs := n.Attr[:0]
+		for _, attr := range n.Attr {
+			switch attr.Key {
+			case "src", "srcset", "poster", "background":
+				if !strings.HasPrefix(attr.Val, "data:") {
+					continue
+				}
+			}
+			attrs = append(attrs, attr)
+		}
+		n.Attr = attrs
+	})
+
+	var sb strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&sb, c); err != nil {
+			return ""
+		}
+	}
+
+	return sb.String()
+}
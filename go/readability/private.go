@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// publicStorage is a library's storage as seen by visitors not signed in:
+// the queries listing articles leave the private ones out. Reading an
+// article by its URL still works, like a link shared.
+type publicStorage struct {
+	Storage
+}
+
+// private returns the keys of the private articles.
+func (s publicStorage) private() (map[string]bool, error) {
+	keys, err := s.Storage.PrivateArticles()
+	if err != nil {
+		return nil, err
+	}
+
+	private := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		private[key] = true
+	}
+
+	return private, nil
+}
+
+// listed runs query for n more keys than wanted, as many as may be private,
+// and returns up to n of those that aren't, all of them when n is 0.
+func (s publicStorage) listed(n int, query func(n int) ([]string, error)) ([]string, error) {
+	private, err := s.private()
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := query(n + len(private))
+	if err != nil || len(private) == 0 {
+		return keys, err
+	}
+
+	listed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if n > 0 && len(listed) == n {
+			break
+		}
+		if !private[key] {
+			listed = append(listed, key)
+		}
+	}
+
+	return listed, nil
+}
+
+func (s publicStorage) LastNArticles(n int) ([]string, error) {
+	return s.listed(n, s.Storage.LastNArticles)
+}
+
+func (s publicStorage) TrendingArticles(n int, weights []float64) ([]string, error) {
+	return s.listed(n, func(n int) ([]string, error) {
+		return s.Storage.TrendingArticles(n, weights)
+	})
+}
+
+func (s publicStorage) Keys() ([]string, error) {
+	return s.listed(0, func(int) ([]string, error) { return s.Storage.Keys() })
+}
+
+func (s publicStorage) TaggedArticles(tag string) ([]string, error) {
+	return s.listed(0, func(int) ([]string, error) { return s.Storage.TaggedArticles(tag) })
+}
+
+func (s publicStorage) StarredArticles() ([]string, error) {
+	return s.listed(0, func(int) ([]string, error) { return s.Storage.StarredArticles() })
+}
+
+func (s publicStorage) WatchedArticles() ([]string, error) {
+	return s.listed(0, func(int) ([]string, error) { return s.Storage.WatchedArticles() })
+}
+
+// baseStorage is s without the publicStorage around it, for what it does
+// besides Storage.
+func baseStorage(s Storage) Storage {
+	if ps, ok := s.(publicStorage); ok {
+		return ps.Storage
+	}
+
+	return s
+}
+
+// publicView is lib for visitors not signed in, without its private
+// articles.
+func (lib *library) publicView() *library {
+	return &library{User: lib.User, store: publicStorage{lib.store}, index: lib.index, public: true}
+}
+
+// signedIn reports whether r was made by someone signed in, or with an API
+// token, who sees the private articles.
+func signedIn(r *http.Request) bool {
+	if _, ok := r.Context().Value(libraryContextKey{}).(*library); ok {
+		return true
+	}
+	_, ok := requestSession(r)
+
+	return ok
+}
+
+// setPrivate marks the article stored under key private, or lists it again.
+func (lib *library) setPrivate(key string, private bool) error {
+	if err := lib.store.SetPrivate(key, private); err != nil {
+		return err
+	}
+	lib.index.SetPrivate(key, private)
+
+	return nil
+}
+
+// privateHandler makes the article in the url form field private, or
+// lists it again when private is 0, for those who see the private
+// articles.
+func privateHandler(w http.ResponseWriter, r *http.Request) {
+	if !signedIn(r) {
+		http.Error(w, "sign in to change what's private", http.StatusForbidden)
+		return
+	}
+
+	uri := r.FormValue("url")
+	if uri == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	lib := libraryFor(r)
+	if err := lib.setPrivate(lib.resolveKey(uri), r.FormValue("private") != "0"); err != nil {
+		if err == errArticleNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, articlePath("read", uri), http.StatusSeeOther)
+}
+
+type privateUpdate struct {
+	URL     string `json:"url"`
+	Private bool   `json:"private"`
+}
+
+var apiSetPrivateOp = apiOperation{
+	Summary:     "Make an article private",
+	Description: "Private articles are left out of the recents, feeds, trending, tags, favorites and search for visitors not signed in. private=false lists the article again.",
+	Tag:         "articles",
+	Body:        privateUpdate{},
+	Response:    privateUpdate{},
+	Errors:      []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusInternalServerError},
+}
+
+func apiSetPrivateHandler(w http.ResponseWriter, r *http.Request) {
+	if !signedIn(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "sign in or use an API token"})
+		return
+	}
+
+	var update privateUpdate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&update); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if update.URL == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "url is required"})
+		return
+	}
+
+	lib := libraryFor(r)
+	err := lib.setPrivate(lib.resolveKey(update.URL), update.Private)
+	if err == errArticleNotFound {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, update)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type progressUpdate struct {
+	URL      string  `json:"url"`
+	Progress float64 `json:"progress"`
+}
+
+var (
+	apiProgressOp = apiOperation{
+		Summary:  "Get the reading position of an article",
+		Tag:      "articles",
+		Query:    []apiParam{{Name: "url", Description: "The article's URL.", Required: true}},
+		Response: progressUpdate{},
+		Errors:   []int{http.StatusNotFound, http.StatusInternalServerError},
+	}
+	apiSetProgressOp = apiOperation{
+		Summary:  "Set the reading position of an article",
+		Tag:      "articles",
+		Body:     progressUpdate{},
+		Response: progressUpdate{},
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+	}
+)
+
+// apiProgressHandler returns the reading position of ?url= on GET and
+// stores the one posted by the article page on POST.
+func apiProgressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		lib := libraryFor(r)
+		uri := r.URL.Query().Get("url")
+		art, err := lib.store.GetArticle(lib.resolveKey(uri))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if art == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "article not found"})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, progressUpdate{URL: uri, Progress: art.Progress})
+		return
+	}
+
+	var update progressUpdate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&update); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if update.URL == "" || update.Progress < 0 || update.Progress > 1 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "url and a progress between 0 and 1 are required"})
+		return
+	}
+
+	lib := libraryFor(r)
+	err := lib.store.UpdateArticle(lib.resolveKey(update.URL), func(art *article) {
+		art.Progress = update.Progress
+	})
+	if err == errArticleNotFound {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, update)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const qrSize = 256
+
+// qrHandler renders a QR code of the article's permalink, or of its read
+// link when it isn't saved, to open the article on a phone.
+func qrHandler(w http.ResponseWriter, r *http.Request) {
+	uri, ok := decodePathURL(mux.Vars(r)["url"])
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	link := baseURL(r) + articlePath("read", uri)
+
+	lib := libraryFor(r)
+	key := lib.resolveKey(uri)
+	if art, err := lib.store.GetArticle(key); err == nil && art != nil {
+		lib.ensureSlug(key, art)
+		if art.Slug != "" {
+			link = baseURL(r) + "/a/" + art.Slug
+		}
+	}
+
+	png, err := qrcode.Encode(link, qrcode.Medium, qrSize)
+	if err != nil {
+		slog.Error("failed to encode qr code", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(png)
+}
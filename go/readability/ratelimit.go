@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	// FETCH_RATE is how many requests a second are sent to one host, in
+	// bursts of up to FETCH_BURST, 0 doesn't limit them.
+	FETCH_RATE  = envFloat("FETCH_RATE", 2)
+	FETCH_BURST = envInt("FETCH_BURST", 5)
+	// FETCH_RATE_WAIT is how long a request queues for its host before
+	// giving up with a rate limit error.
+	FETCH_RATE_WAIT = envDuration("FETCH_RATE_WAIT", 10*time.Second)
+
+	hostLimitersMu sync.Mutex
+	hostLimiters   = make(map[string]*rate.Limiter)
+)
+
+// maxHostLimiters bounds hostLimiters, they are all dropped past it and
+// start again with a full burst.
+const maxHostLimiters = 10000
+
+// rateLimitError is returned when a host is asked for more than FETCH_RATE
+// allows and the wait would be longer than FETCH_RATE_WAIT.
+type rateLimitError struct {
+	host  string
+	after time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("too many requests to %s, try again in %s", e.host, e.after.Round(time.Second))
+}
+
+func hostLimiter(host string) *rate.Limiter {
+	hostLimitersMu.Lock()
+	defer hostLimitersMu.Unlock()
+
+	l, ok := hostLimiters[host]
+	if !ok {
+		if len(hostLimiters) >= maxHostLimiters {
+			hostLimiters = make(map[string]*rate.Limiter)
+		}
+		l = rate.NewLimiter(rate.Limit(FETCH_RATE), FETCH_BURST)
+		hostLimiters[host] = l
+	}
+
+	return l
+}
+
+// waitForHost queues a request to host until FETCH_RATE lets it through,
+// failing right away when that would take over FETCH_RATE_WAIT.
+func waitForHost(ctx context.Context, host string) error {
+	if FETCH_RATE <= 0 {
+		return nil
+	}
+
+	host = strings.ToLower(host)
+	r := hostLimiter(host).Reserve()
+	delay := r.Delay()
+	if !r.OK() || delay > FETCH_RATE_WAIT {
+		r.Cancel()
+		return &rateLimitError{host: host, after: delay}
+	}
+	if delay == 0 {
+		return nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+
+// fetchFailed is the article of a page that couldn't be fetched, saying
+// when to try again if it was rate limited.
+func fetchFailed(uri string, err error) *article {
+	art := &article{URL: uri, ErrMsg: err.Error()}
+
+	var limited *rateLimitError
+	var private *privateAddrError
+	switch {
+	case errors.As(err, &limited):
+		art.ErrMsg, art.retryAfter = limited.Error(), limited.after
+	case errors.As(err, &private):
+		art.ErrMsg = private.Error()
+	}
+
+	return art
+}
+
+// writeRateLimited answers 429 with a Retry-After for an article rate
+// limiting kept from being fetched.
+func writeRateLimited(w http.ResponseWriter, art *article, asJSON bool) {
+	writeTooManyRequests(w, art.ErrMsg, art.retryAfter, asJSON)
+}
+
+func writeTooManyRequests(w http.ResponseWriter, msg string, after time.Duration, asJSON bool) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(after.Seconds()))))
+
+	if asJSON {
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": msg})
+		return
+	}
+
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"regexp"
+)
+
+var headTagRe = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+
+// writeRaw serves the page art was extracted from, as it was downloaded.
+// A <base> keeps its relative links pointing at the origin, and the
+// sandbox keeps its scripts from running on this origin.
+func writeRaw(w http.ResponseWriter, r *http.Request, art *article) {
+	if art.ErrMsg != "" {
+		http.Error(w, art.ErrMsg, http.StatusBadGateway)
+		return
+	}
+
+	lib := libraryFor(r)
+	data, err := lib.store.Raw(lib.resolveKey(art.URL))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data == nil {
+		http.Error(w, "the original page of this article isn't stored, refresh it to keep it", http.StatusNotFound)
+		return
+	}
+
+	page := uncompress(data)
+	base := []byte(`<base href="` + html.EscapeString(art.URL) + `">`)
+	if loc := headTagRe.FindIndex(page); loc != nil {
+		page = append(page[:loc[1]:loc[1]], append(base, page[loc[1]:]...)...)
+	} else {
+		page = append(base, page...)
+	}
+
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("Content-Type", "text/html")
+	w.Write(page)
+}
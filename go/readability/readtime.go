@@ -0,0 +1,21 @@
+package main
+
+import "strings"
+
+// WORDS_PER_MINUTE is the reading speed the reading time is estimated with.
+var WORDS_PER_MINUTE = envInt("WORDS_PER_MINUTE", 230)
+
+// countWords sets the word count and reading time of art from its content.
+func (art *article) countWords() {
+	art.WordCount = len(strings.Fields(htmlText(art.Content)))
+	art.ReadingTime = readingMinutes(art.WordCount)
+}
+
+// readingMinutes rounds up, anything with words in it takes a minute.
+func readingMinutes(words int) int {
+	if words <= 0 || WORDS_PER_MINUTE <= 0 {
+		return 0
+	}
+
+	return (words + WORDS_PER_MINUTE - 1) / WORDS_PER_MINUTE
+}
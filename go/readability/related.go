@@ -0,0 +1,154 @@
+package main
+
+import (
+	"math"
+	"net/url"
+	"sort"
+	"unicode/utf8"
+)
+
+// RELATED_ARTICLES is how many related articles are kept with an article
+// when it's saved, 0 turns them off.
+var RELATED_ARTICLES = envInt("RELATED_ARTICLES", 5)
+
+const (
+	// relatedKeywords is how many of its most distinctive terms an article
+	// is compared with the others by, and relatedMinShared how many of
+	// them another article must have to be related, unless it's from the
+	// same domain.
+	relatedKeywords  = 20
+	relatedMinShared = 3
+)
+
+// relatedArticle is an article related to another, stored with it.
+type relatedArticle struct {
+	Key   string
+	URL   string
+	Title string
+}
+
+type relatedKeyword struct {
+	term   string
+	weight float64
+}
+
+// Related are up to n articles of the index related to art, stored under
+// key: those from the same domain and those sharing its most distinctive
+// terms, weighted by tf-idf, best first.
+func (idx *searchIndex) Related(key string, art *article, n int) []relatedArticle {
+	if n <= 0 {
+		return nil
+	}
+	freqs := termFrequencies(art.Title, htmlText(art.Content))
+	domain := hostname(art.URL)
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	total := len(idx.docs)
+	if _, ok := idx.docs[key]; !ok {
+		total++
+	}
+
+	var keywords []relatedKeyword
+	for term, freq := range freqs {
+		// Short terms are mostly stop words.
+		if utf8.RuneCountInString(term) < 4 {
+			continue
+		}
+		others := len(idx.terms[term])
+		if _, ok := idx.terms[term][key]; ok {
+			others--
+		}
+		if others == 0 {
+			continue
+		}
+		if idf := math.Log(float64(total) / float64(others+1)); idf > 0 {
+			keywords = append(keywords, relatedKeyword{term: term, weight: float64(freq) * idf})
+		}
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if keywords[i].weight != keywords[j].weight {
+			return keywords[i].weight > keywords[j].weight
+		}
+		return keywords[i].term < keywords[j].term
+	})
+	if len(keywords) > relatedKeywords {
+		keywords = keywords[:relatedKeywords]
+	}
+
+	scores := make(map[string]float64)
+	shared := make(map[string]int)
+	for _, kw := range keywords {
+		for doc := range idx.terms[kw.term] {
+			if doc != key {
+				scores[doc] += kw.weight
+				shared[doc]++
+			}
+		}
+	}
+
+	// An article from the same domain counts as much as sharing the most
+	// distinctive term.
+	bonus := 1.0
+	if len(keywords) > 0 {
+		bonus = keywords[0].weight
+	}
+	var related []relatedArticle
+	for doc, res := range idx.docs {
+		if doc == key {
+			continue
+		}
+		if domain != "" && hostname(res.URL) == domain {
+			scores[doc] += bonus
+		} else if shared[doc] < relatedMinShared {
+			delete(scores, doc)
+			continue
+		}
+		related = append(related, relatedArticle{Key: doc, URL: res.URL, Title: res.Title})
+	}
+
+	sort.Slice(related, func(i, j int) bool {
+		si, sj := scores[related[i].Key], scores[related[j].Key]
+		if si != sj {
+			return si > sj
+		}
+		return related[i].URL < related[j].URL
+	})
+	if len(related) > n {
+		related = related[:n]
+	}
+
+	return related
+}
+
+// Has reports whether the article stored under key is indexed.
+func (idx *searchIndex) Has(key string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	_, ok := idx.docs[key]
+	return ok
+}
+
+// relatedArticles are the articles related to art still saved, those
+// deleted since it was are left out.
+func (lib *library) relatedArticles(art *article) []relatedArticle {
+	related := make([]relatedArticle, 0, len(art.Related))
+	for _, rel := range art.Related {
+		if lib.index.Has(rel.Key) {
+			related = append(related, rel)
+		}
+	}
+
+	return related
+}
+
+func hostname(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+
+	return u.Hostname()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// FETCH_RETRIES is how many times a page is tried before giving up,
+	// waiting FETCH_BACKOFF before the first retry and twice as long before
+	// every next one, up to FETCH_BACKOFF_MAX.
+	FETCH_RETRIES     = envInt("FETCH_RETRIES", 3)
+	FETCH_BACKOFF     = envDuration("FETCH_BACKOFF", 500*time.Millisecond)
+	FETCH_BACKOFF_MAX = envDuration("FETCH_BACKOFF_MAX", 10*time.Second)
+	// FETCH_RETRY_STATUS are the response codes worth another try.
+	FETCH_RETRY_STATUS = retryStatuses(envOr("FETCH_RETRY_STATUS", "408,425,429,500,502,503,504"))
+)
+
+func retryStatuses(s string) map[int]bool {
+	codes := make(map[int]bool)
+	for _, f := range strings.Split(s, ",") {
+		if code, err := strconv.Atoi(strings.TrimSpace(f)); err == nil {
+			codes[code] = true
+		}
+	}
+
+	return codes
+}
+
+// getWithRetry GETs uri, retrying on network errors and FETCH_RETRY_STATUS
+// responses with exponential backoff. A Retry-After the server sends is
+// honoured up to FETCH_BACKOFF_MAX. The retries are logged and traced
+// with ctx, its cancellation is left out, other requests may be waiting on
+// the fetch.
+func getWithRetry(ctx context.Context, uri string) (*http.Response, error) {
+	backoff := FETCH_BACKOFF
+	ctx = context.WithoutCancel(ctx)
+
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+		resp, err := fetchClient.Do(req)
+		fetchDuration.WithLabelValues(statusClass(resp, err)).Observe(time.Since(start).Seconds())
+		if err == nil && !FETCH_RETRY_STATUS[resp.StatusCode] {
+			return resp, nil
+		}
+		if attempt >= FETCH_RETRIES || permanentFetchError(err) {
+			return resp, err
+		}
+
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+		if err == nil {
+			if after := retryAfter(resp.Header.Get("Retry-After")); after > 0 {
+				wait = after
+			}
+		}
+		if wait > FETCH_BACKOFF_MAX {
+			wait = FETCH_BACKOFF_MAX
+		}
+
+		if err == nil {
+			resp.Body.Close()
+			slog.WarnContext(ctx, "fetch got a retryable status", "url", uri, "status", resp.StatusCode, "retry_in", wait)
+		} else {
+			slog.WarnContext(ctx, "failed to fetch, retrying", "url", uri, "err", err, "retry_in", wait)
+		}
+		time.Sleep(wait)
+
+		if backoff *= 2; backoff > FETCH_BACKOFF_MAX {
+			backoff = FETCH_BACKOFF_MAX
+		}
+	}
+}
+
+// retryAfter parses a Retry-After header, in seconds or as a date.
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+
+	return 0
+}
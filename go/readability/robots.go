@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// sitemapSize is the most URLs a sitemap may list.
+const sitemapSize = 50000
+
+var (
+	// ROBOTS_DISALLOW are the paths crawlers are asked to keep out of, the
+	// articles read by URL by default, which are fetched on request.
+	ROBOTS_DISALLOW = splitList(envOr("ROBOTS_DISALLOW", "/read/"))
+	// ROBOTS_TXT is the path of a robots.txt served as is instead.
+	ROBOTS_TXT = envOr("ROBOTS_TXT", "")
+)
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// robotsHandler serves robots.txt, ROBOTS_TXT when set, or one disallowing
+// ROBOTS_DISALLOW and pointing to the sitemap.
+func robotsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if ROBOTS_TXT != "" {
+		data, err := os.ReadFile(ROBOTS_TXT)
+		if err != nil {
+			slog.Error("failed to read robots.txt", "path", ROBOTS_TXT, "err", err)
+			http.Error(w, "failed to read robots.txt", http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("User-agent: *\n")
+	for _, path := range ROBOTS_DISALLOW {
+		fmt.Fprintf(&sb, "Disallow: %s\n", path)
+	}
+	fmt.Fprintf(&sb, "\nSitemap: %s/sitemap.xml\n", baseURL(r))
+
+	w.Write([]byte(sb.String()))
+}
+
+// sitemapHandler lists the permalinks of the instance's articles, those
+// visitors not signed in see, signed in or not.
+func sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	lib := instanceLib.publicView()
+	keys, err := lib.store.Keys()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	base := baseURL(r)
+	set := sitemapURLSet{}
+	for _, key := range keys {
+		if len(set.URLs) == sitemapSize {
+			break
+		}
+		art, err := lib.store.GetArticle(key)
+		if err != nil || art == nil || art.ErrMsg != "" {
+			continue
+		}
+		// Saved before slugs, they get theirs now.
+		lib.ensureSlug(key, art)
+		if art.Slug == "" {
+			continue
+		}
+
+		u := sitemapURL{Loc: permalink(base, art)}
+		modified := art.RefreshedAt
+		if modified.IsZero() {
+			modified = art.CreatedAt
+		}
+		if !modified.IsZero() {
+			u.LastMod = modified.UTC().Format(time.RFC3339)
+		}
+		set.URLs = append(set.URLs, u)
+	}
+
+	writeXML(w, "application/xml", set)
+}
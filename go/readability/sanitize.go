@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+var (
+	// SANITIZE_POLICY is what is kept of the extracted content: "reader",
+	// the default, keeps the markup an article is made of, with classes for
+	// the code's language and image sources for the proxy; "ugc" is
+	// bluemonday's policy for user content, stricter; "off" keeps it all.
+	// Scripts, event handlers, stylesheets, forms and iframes never make it
+	// through the first two.
+	SANITIZE_POLICY = envOr("SANITIZE_POLICY", "reader")
+	// SANITIZE_ALLOW_ELEMENTS are more elements kept, e.g. "iframe,math".
+	// Their attributes are still dropped, but for the global ones.
+	SANITIZE_ALLOW_ELEMENTS = splitList(envOr("SANITIZE_ALLOW_ELEMENTS", ""))
+
+	contentPolicy = newContentPolicy(SANITIZE_POLICY)
+)
+
+func newContentPolicy(name string) *bluemonday.Policy {
+	var p *bluemonday.Policy
+	switch name {
+	case "off":
+		return nil
+	case "ugc":
+		p = bluemonday.UGCPolicy()
+	case "reader":
+		p = readerPolicy()
+	default:
+		slog.Warn("unknown sanitize policy, using reader", "policy", name)
+		p = readerPolicy()
+	}
+	if len(SANITIZE_ALLOW_ELEMENTS) > 0 {
+		p.AllowElements(SANITIZE_ALLOW_ELEMENTS...)
+	}
+
+	return p
+}
+
+// readerPolicy is bluemonday's UGC policy with what articles need more:
+// classes, responsive images, media and highlighted code.
+func readerPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowStyling()
+	p.AllowDataURIImages()
+	p.RequireNoFollowOnLinks(false)
+
+	p.AllowAttrs("srcset", "sizes").OnElements("img", "source")
+	p.AllowAttrs("loading").Matching(regexp.MustCompile(`^(lazy|eager)$`)).OnElements("img")
+	p.AllowElements("picture")
+	p.AllowAttrs("src", "type", "media").OnElements("source")
+	p.AllowAttrs("src", "poster", "width", "height").OnElements("video")
+	p.AllowAttrs("src").OnElements("audio", "track")
+	p.AllowAttrs("controls", "loop", "muted", "playsinline").OnElements("video", "audio")
+	p.AllowAttrs("kind", "srclang", "label").OnElements("track")
+	// The colors of the code highlighted in Markdown pages.
+	p.AllowStyles("color", "background-color", "font-weight", "font-style", "text-decoration").OnElements("pre", "span")
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("reversed").OnElements("ol")
+
+	return p
+}
+
+// sanitizeContent strips from content what a malicious page could run in
+// the reader's pages: scripts, event handlers, javascript: links, iframes...
+func sanitizeContent(content string) string {
+	if contentPolicy == nil || strings.TrimSpace(content) == "" {
+		return content
+	}
+
+	return contentPolicy.Sanitize(content)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// searchIndex is an in-process inverted index over the title and text of
+// every stored article, rebuilt on startup and kept in sync on write.
+type searchIndex struct {
+	mu    sync.RWMutex
+	terms map[string]map[string]int
+	docs  map[string]searchResult
+	// docTerms are the terms of each article, for removing it from their
+	// postings only.
+	docTerms map[string][]string
+	// vectors are the embeddings of the articles, those of embedder's model.
+	vectors map[string][]float32
+}
+
+type searchResult struct {
+	URL     string `json:"url"`
+	Title   string `json:"title"`
+	Excerpt string `json:"excerpt"`
+	Starred bool   `json:"starred"`
+	Score   int    `json:"-"`
+	// private results are left out for visitors not signed in.
+	private bool
+	// Similarity is the cosine similarity of the article's embedding to
+	// what's looked for, by semantic search.
+	Similarity float64 `json:"similarity,omitempty"`
+}
+
+// SimilarityPercent is Similarity in percent, rounded.
+func (res searchResult) SimilarityPercent() int {
+	return int(math.Round(res.Similarity * 100))
+}
+
+const titleWeight = 5
+
+var searchidx = newSearchIndex()
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		terms:    make(map[string]map[string]int),
+		docs:     make(map[string]searchResult),
+		docTerms: make(map[string][]string),
+		vectors:  make(map[string][]float32),
+	}
+}
+
+func (idx *searchIndex) Add(key string, art *article) {
+	text := htmlText(art.Content)
+	// The reader's notes are searched along with the text.
+	freqs := termFrequencies(art.Title, text+"\n"+art.Notes)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(key)
+	terms := make([]string, 0, len(freqs))
+	for term, freq := range freqs {
+		if idx.terms[term] == nil {
+			idx.terms[term] = make(map[string]int)
+		}
+		idx.terms[term][key] = freq
+		terms = append(terms, term)
+	}
+	idx.docTerms[key] = terms
+	idx.docs[key] = searchResult{URL: art.URL, Title: art.Title, Excerpt: excerpt(text, 200), Starred: art.Starred, private: art.Private}
+	if embedder != nil && len(art.Embedding) > 0 && art.EmbeddingModel == embedder.Model() {
+		idx.vectors[key] = art.Embedding
+	}
+}
+
+// Reset empties the index, to be built again.
+func (idx *searchIndex) Reset() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.terms = make(map[string]map[string]int)
+	idx.docs = make(map[string]searchResult)
+	idx.docTerms = make(map[string][]string)
+	idx.vectors = make(map[string][]float32)
+}
+
+func (idx *searchIndex) Remove(key string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(key)
+}
+
+// SetStarred updates the starred flag returned with key's results.
+func (idx *searchIndex) SetStarred(key string, starred bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if doc, ok := idx.docs[key]; ok {
+		doc.Starred = starred
+		idx.docs[key] = doc
+	}
+}
+
+// SetPrivate updates whether key's results are left out for visitors not
+// signed in.
+func (idx *searchIndex) SetPrivate(key string, private bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if doc, ok := idx.docs[key]; ok {
+		doc.private = private
+		idx.docs[key] = doc
+	}
+}
+
+// remove drops key from the index, callers must hold mu.
+func (idx *searchIndex) remove(key string) {
+	if _, ok := idx.docs[key]; !ok {
+		return
+	}
+
+	for _, term := range idx.docTerms[key] {
+		delete(idx.terms[term], key)
+		if len(idx.terms[term]) == 0 {
+			delete(idx.terms, term)
+		}
+	}
+	delete(idx.docTerms, key)
+	delete(idx.docs, key)
+	delete(idx.vectors, key)
+}
+
+// Search returns up to n articles containing every term of q, best match
+// first, but for the private ones when public is set.
+func (idx *searchIndex) Search(q string, n int, public bool) []searchResult {
+	terms := tokenize(q)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	scores := make(map[string]int)
+	for i, term := range terms {
+		postings := idx.terms[term]
+		for key := range scores {
+			if _, ok := postings[key]; !ok {
+				delete(scores, key)
+			}
+		}
+		for key, freq := range postings {
+			if i == 0 {
+				scores[key] = freq
+			} else if _, ok := scores[key]; ok {
+				scores[key] += freq
+			}
+		}
+	}
+
+	results := make([]searchResult, 0, len(scores))
+	for key, score := range scores {
+		res := idx.docs[key]
+		if public && res.private {
+			continue
+		}
+		res.Score = score
+		results = append(results, res)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].URL < results[j].URL
+	})
+
+	if len(results) > n {
+		results = results[:n]
+	}
+
+	return results
+}
+
+func (lib *library) buildSearchIndex() {
+	keys, err := lib.store.Keys()
+	if err != nil {
+		slog.Error("failed to list articles for search index", "err", err)
+		return
+	}
+
+	for _, key := range keys {
+		art, err := lib.store.GetArticle(key)
+		if err != nil || art == nil {
+			continue
+		}
+		lib.index.Add(key, art)
+	}
+
+	slog.Info("search index built", "articles", len(keys))
+
+	if embedder != nil {
+		lib.embedMissing()
+	}
+}
+
+// termFrequencies counts the terms of an article, those of its title count
+// titleWeight times.
+func termFrequencies(title, text string) map[string]int {
+	freqs := make(map[string]int)
+	for _, term := range tokenize(title) {
+		freqs[term] += titleWeight
+	}
+	for _, term := range tokenize(text) {
+		freqs[term]++
+	}
+
+	return freqs
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func htmlText(content string) string {
+	var sb strings.Builder
+
+	z := html.NewTokenizer(strings.NewReader(content))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(sb.String()), " ")
+		case html.TextToken:
+			sb.Write(z.Text())
+			sb.WriteByte(' ')
+		}
+	}
+}
+
+func excerpt(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+
+	return string(runes[:n]) + "..."
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	mode := r.URL.Query().Get("mode")
+
+	results, err := libraryFor(r).search(r.Context(), q, mode, 50)
+	if err != nil {
+		http.Error(w, err.Error(), searchErrorStatus(err))
+		return
+	}
+
+	executePage(w, r, "search.html", map[string]interface{}{
+		"Query":    q,
+		"Mode":     mode,
+		"Semantic": embedder != nil,
+		"Results":  results,
+	})
+}
+
+// search returns up to n articles matching q, by their words, or with mode
+// semantic, by their embeddings.
+func (lib *library) search(ctx context.Context, q, mode string, n int) ([]searchResult, error) {
+	switch mode {
+	case "", "words":
+		if fts, ok := baseStorage(lib.store).(fullTextSearcher); ok {
+			if len(tokenize(q)) == 0 {
+				return nil, nil
+			}
+			return fts.SearchArticles(q, n, lib.public)
+		}
+		return lib.index.Search(q, n, lib.public), nil
+	case "semantic":
+		if q == "" {
+			return nil, nil
+		}
+		return lib.semanticSearch(ctx, q, n)
+	}
+
+	return nil, errSearchMode
+}
+
+var errSearchMode = errors.New("mode is words or semantic")
+
+func searchErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errSearchMode):
+		return http.StatusBadRequest
+	case errors.Is(err, errEmbeddingsOff):
+		return http.StatusNotImplemented
+	}
+
+	return http.StatusBadGateway
+}
+
+type searchResponse struct {
+	Query   string         `json:"query"`
+	Results []searchResult `json:"results"`
+}
+
+var apiSearchOp = apiOperation{
+	Summary:     "Search the articles",
+	Description: "The articles containing every word of q, or with mode=semantic those closest in meaning to q by their embeddings, which needs EMBEDDINGS.",
+	Tag:         "library",
+	Query: []apiParam{
+		{Name: "q", Description: "The words to look for.", Required: true},
+		{Name: "mode", Description: "words, the default, or semantic."},
+	},
+	Response: searchResponse{},
+	Errors:   []int{http.StatusBadRequest, http.StatusNotImplemented, http.StatusBadGateway},
+}
+
+func apiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing q"})
+		return
+	}
+
+	results, err := libraryFor(r).search(r.Context(), q, r.URL.Query().Get("mode"), 50)
+	if err != nil {
+		writeJSON(w, searchErrorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	if results == nil {
+		results = []searchResult{}
+	}
+
+	writeJSON(w, http.StatusOK, searchResponse{Query: q, Results: results})
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestSearchIndexRemove(t *testing.T) {
+	idx := newSearchIndex()
+	idx.Add("a", &article{URL: "a", Title: "Gophers", Content: "<p>pipelines and channels</p>"})
+	idx.Add("b", &article{URL: "b", Title: "Crabs", Content: "<p>ownership and channels</p>"})
+	// Added again, its old terms go.
+	idx.Add("a", &article{URL: "a", Title: "Gophers", Content: "<p>goroutines</p>"})
+
+	if res := idx.Search("pipelines", 10, false); len(res) != 0 {
+		t.Errorf("pipelines found %v after being replaced", res)
+	}
+	if res := idx.Search("channels", 10, false); len(res) != 1 || res[0].URL != "b" {
+		t.Errorf("channels found %v, want b", res)
+	}
+
+	idx.Remove("b")
+	if res := idx.Search("channels", 10, false); len(res) != 0 {
+		t.Errorf("channels found %v after b was removed", res)
+	}
+	for term, postings := range idx.terms {
+		if _, ok := postings["b"]; ok {
+			t.Errorf("%q still lists b", term)
+		}
+	}
+	if res := idx.Search("goroutines", 10, false); len(res) != 1 || res[0].URL != "a" {
+		t.Errorf("goroutines found %v, want a", res)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// CONTENT_SECURITY_POLICY replaces the policy the pages are sent with.
+	CONTENT_SECURITY_POLICY = envOr("CONTENT_SECURITY_POLICY", "")
+	// HSTS_MAX_AGE is the Strict-Transport-Security sent over HTTPS, 0
+	// leaves it out.
+	HSTS_MAX_AGE = envDuration("HSTS_MAX_AGE", 365*24*time.Hour)
+
+	// remoteMediaRe finds images and media an article loads from elsewhere,
+	// those saved with nocache or before the image proxy.
+	remoteMediaRe = regexp.MustCompile(`(?i)<(?:img|source|video|audio)\b[^>]*\s(?:src|srcset|poster)\s*=\s*["']?\s*(?:https?:)?//`)
+)
+
+// contentSecurityPolicy is the policy of the pages: scripts only from the
+// app, KaTeX's directory and the sources a page adds, no inline ones, and images from
+// the app, where the image proxy serves them, unless remoteMedia or the
+// proxy is off. Inline styles stay allowed for the reader's CSS and the
+// highlighted code.
+func contentSecurityPolicy(remoteMedia bool, sources ...string) string {
+	if CONTENT_SECURITY_POLICY != "" {
+		return CONTENT_SECURITY_POLICY
+	}
+
+	if KATEX_DIR == "" {
+		sources = append(sources, cspSource(KATEX_URL))
+	}
+	extra := ""
+	for i, source := range sources {
+		if source != "" && !slices.Contains(sources[:i], source) {
+			extra += " " + source
+		}
+	}
+	media := "'self' data:"
+	if remoteMedia || !IMAGE_PROXY {
+		media += " https: http:"
+	}
+
+	return strings.Join([]string{
+		"default-src 'self'",
+		"script-src 'self'" + extra,
+		"style-src 'self' 'unsafe-inline'" + extra,
+		"font-src 'self' data:" + extra,
+		"img-src " + media,
+		"media-src " + media,
+		"connect-src 'self'",
+		"object-src 'none'",
+		"base-uri 'none'",
+		"form-action 'self'",
+		"frame-ancestors 'none'",
+	}, "; ")
+}
+
+// cspSource is the source expression allowing what's under the absolute
+// URL raw, its directory rather than its whole origin, "" for a path.
+func cspSource(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	return u.Scheme + "://" + u.Host + strings.TrimSuffix(u.EscapedPath(), "/") + "/"
+}
+
+// hasRemoteMedia reports whether content loads images or media from other
+// origins.
+func hasRemoteMedia(content string) bool {
+	return remoteMediaRe.MatchString(content)
+}
+
+// securityHeaders sets the security headers of every response. Handlers
+// serving something else than the app's pages, the original page or a
+// snapshot, set their own Content-Security-Policy over this one.
+func securityHeaders(next http.Handler) http.Handler {
+	csp := contentSecurityPolicy(false)
+	hsts := ""
+	if HSTS_MAX_AGE > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(HSTS_MAX_AGE.Seconds()))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", csp)
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		h.Set("Cross-Origin-Opener-Policy", "same-origin")
+		if r.TLS != nil && hsts != "" {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	// READ_TIMEOUT bounds reading a request, WRITE_TIMEOUT serving it,
+	// reading the article included, and IDLE_TIMEOUT how long a kept-alive
+	// connection waits for the next request.
+	READ_TIMEOUT  = envDuration("READ_TIMEOUT", 30*time.Second)
+	WRITE_TIMEOUT = envDuration("WRITE_TIMEOUT", 2*time.Minute)
+	IDLE_TIMEOUT  = envDuration("IDLE_TIMEOUT", 2*time.Minute)
+	// SHUTDOWN_DELAY is how long /readyz fails before the server stops
+	// taking requests on SIGTERM, for the load balancer to notice, and
+	// SHUTDOWN_TIMEOUT how long the requests in flight then have to finish.
+	SHUTDOWN_DELAY   = envDuration("SHUTDOWN_DELAY", 0)
+	SHUTDOWN_TIMEOUT = envDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+)
+
+// shuttingDown is set once a signal asked to stop.
+var shuttingDown atomic.Bool
+
+func newServer(handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              port(),
+		Handler:           handler,
+		ReadHeaderTimeout: READ_TIMEOUT,
+		ReadTimeout:       READ_TIMEOUT,
+		WriteTimeout:      WRITE_TIMEOUT,
+		IdleTimeout:       IDLE_TIMEOUT,
+	}
+	setupTLS(srv)
+
+	return srv
+}
+
+// serve runs srv, and the ACME challenge server with it, until SIGTERM or
+// SIGINT, then drains the requests in flight and closes the storage. A
+// second signal stops at once.
+func serve(srv *http.Server) error {
+	errc := make(chan error, 2)
+	go func() {
+		errc <- listenAndServe(srv)
+	}()
+	challenge := acmeChallengeServer()
+	if challenge != nil {
+		go func() {
+			errc <- challenge.ListenAndServe()
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("shutting down", "delay", SHUTDOWN_DELAY, "timeout", SHUTDOWN_TIMEOUT)
+	shuttingDown.Store(true)
+	time.Sleep(SHUTDOWN_DELAY)
+
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("requests still in flight, closing them", "timeout", SHUTDOWN_TIMEOUT)
+		err = srv.Close()
+	}
+	if challenge != nil {
+		challenge.Close()
+	}
+
+	closeLibraries()
+	if tracerProvider != nil {
+		if err := tracerProvider.Shutdown(ctx); err != nil {
+			slog.Error("failed to flush traces", "err", err)
+		}
+	}
+	slog.Info("shut down")
+
+	return err
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const settingsCookie = "reader_settings"
+
+type readerOption struct {
+	Name  string
+	Label string
+	Value string
+}
+
+// readerFonts, readerSizes, readerWidths and readerLineHeights are the
+// choices of the settings panel, the first of each is the stylesheet's own.
+var (
+	readerFonts = []readerOption{
+		{"", "Default", ""},
+		{"sans", "Sans-serif", "system-ui, -apple-system, 'Segoe UI', Roboto, 'Helvetica Neue', sans-serif"},
+		{"serif", "Serif", "Georgia, Cambria, 'Times New Roman', serif"},
+		{"mono", "Monospace", "ui-monospace, Menlo, Consolas, monospace"},
+	}
+	readerSizes = []readerOption{
+		{"", "Default", ""},
+		{"14", "14px", "14px"},
+		{"16", "16px", "16px"},
+		{"18", "18px", "18px"},
+		{"20", "20px", "20px"},
+		{"24", "24px", "24px"},
+	}
+	readerWidths = []readerOption{
+		{"", "Default", ""},
+		{"narrow", "Narrow", "40em"},
+		{"wide", "Wide", "80%"},
+		{"full", "Full", "100%"},
+	}
+	readerLineHeights = []readerOption{
+		{"", "Default", ""},
+		{"1.3", "Compact", "1.3em"},
+		{"1.8", "Relaxed", "1.8em"},
+		{"2.2", "Loose", "2.2em"},
+	}
+)
+
+// readerSettings are a visitor's typography choices, kept in a cookie and
+// applied by article.html.
+type readerSettings struct {
+	Font       string
+	Size       string
+	Width      string
+	LineHeight string
+}
+
+func readerOptionValue(options []readerOption, name string) string {
+	for _, opt := range options {
+		if opt.Name == name {
+			return opt.Value
+		}
+	}
+
+	return ""
+}
+
+func validReaderOption(options []readerOption, name string) string {
+	for _, opt := range options {
+		if opt.Name == name {
+			return name
+		}
+	}
+
+	return ""
+}
+
+func readerSettingsFrom(values url.Values) readerSettings {
+	return readerSettings{
+		Font:       validReaderOption(readerFonts, values.Get("font")),
+		Size:       validReaderOption(readerSizes, values.Get("size")),
+		Width:      validReaderOption(readerWidths, values.Get("width")),
+		LineHeight: validReaderOption(readerLineHeights, values.Get("lineheight")),
+	}
+}
+
+func readerSettingsFromRequest(r *http.Request) readerSettings {
+	c, err := r.Cookie(settingsCookie)
+	if err != nil {
+		return readerSettings{}
+	}
+
+	values, _ := url.ParseQuery(c.Value)
+
+	return readerSettingsFrom(values)
+}
+
+func (s readerSettings) encode() string {
+	return url.Values{
+		"font":       {s.Font},
+		"size":       {s.Size},
+		"width":      {s.Width},
+		"lineheight": {s.LineHeight},
+	}.Encode()
+}
+
+// CSS returns the rules overriding the stylesheet, built only from the
+// values of the option lists.
+func (s readerSettings) CSS() template.CSS {
+	var body, content []string
+
+	if v := readerOptionValue(readerWidths, s.Width); v != "" {
+		body = append(body, "max-width: "+v)
+	}
+	if v := readerOptionValue(readerFonts, s.Font); v != "" {
+		content = append(content, "font-family: "+v)
+	}
+	if v := readerOptionValue(readerSizes, s.Size); v != "" {
+		content = append(content, "font-size: "+v)
+	}
+	if v := readerOptionValue(readerLineHeights, s.LineHeight); v != "" {
+		content = append(content, "line-height: "+v)
+	}
+
+	var css strings.Builder
+	if len(body) > 0 {
+		fmt.Fprintf(&css, "body { %s }\n", strings.Join(body, "; "))
+	}
+	if len(content) > 0 {
+		fmt.Fprintf(&css, ".content, .content p, .content li { %s }\n", strings.Join(content, "; "))
+	}
+
+	return template.CSS(css.String())
+}
+
+func (s readerSettings) Fonts() []readerOption       { return readerFonts }
+func (s readerSettings) Sizes() []readerOption       { return readerSizes }
+func (s readerSettings) Widths() []readerOption      { return readerWidths }
+func (s readerSettings) LineHeights() []readerOption { return readerLineHeights }
+
+// settingsHandler saves the settings form in a cookie and goes back to the
+// page it was posted from.
+func settingsHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	settings := readerSettingsFrom(r.PostForm)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     settingsCookie,
+		Value:    settings.encode(),
+		Path:     "/",
+		Expires:  time.Now().AddDate(1, 0, 0),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, safeBack(r.FormValue("back")), http.StatusSeeOther)
+}
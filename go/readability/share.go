@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var errShareInvalid = errors.New("the share link is invalid or has expired")
+
+// shareToken is a share link's token: the owner of the library, the key of
+// the article and when it expires, 0 never, base64 encoded and signed with
+// the session key so none has to be stored. The article's URL isn't in it.
+func shareToken(user, key string, expires time.Time) string {
+	exp := int64(0)
+	if !expires.IsZero() {
+		exp = expires.Unix()
+	}
+	payload := base64.RawURLEncoding.EncodeToString([]byte(user + "\x00" + key + "\x00" + strconv.FormatInt(exp, 10)))
+
+	return payload + "." + shareSign(payload)
+}
+
+func shareSign(payload string) string {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte("share\x00" + payload))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// parseShareToken returns the library owner and article key of token, and
+// when it expires, zero never, when it's signed and hasn't expired.
+func parseShareToken(token string) (user, key string, expires time.Time, err error) {
+	payload, sig, ok := cutLast(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(shareSign(payload))) {
+		return "", "", time.Time{}, errShareInvalid
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", "", time.Time{}, errShareInvalid
+	}
+	parts := strings.Split(string(data), "\x00")
+	if len(parts) != 3 {
+		return "", "", time.Time{}, errShareInvalid
+	}
+	exp, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || exp != 0 && time.Now().Unix() > exp {
+		return "", "", time.Time{}, errShareInvalid
+	}
+	if exp != 0 {
+		expires = time.Unix(exp, 0)
+	}
+
+	return parts[0], parts[1], expires, nil
+}
+
+// shareLink is a link to an article anyone may read.
+type shareLink struct {
+	Link      string     `json:"link"`
+	Token     string     `json:"token"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// share makes a link to the article at uri expiring in ttl, never when 0.
+func (lib *library) share(r *http.Request, uri string, ttl time.Duration) (*shareLink, error) {
+	key := lib.resolveKey(uri)
+	art, err := lib.store.GetArticle(key)
+	if err != nil {
+		return nil, err
+	}
+	if art == nil || art.ErrMsg != "" {
+		return nil, errArticleNotFound
+	}
+
+	link := &shareLink{}
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+		link.ExpiresAt = &expires
+	}
+	link.Token = shareToken(lib.User, key, expires)
+	link.Link = baseURL(r) + "/s/" + link.Token
+
+	return link, nil
+}
+
+// shareHandler makes a share link from the article page, which shows it.
+func shareHandler(w http.ResponseWriter, r *http.Request) {
+	uri := r.FormValue("url")
+	ttl, err := time.ParseDuration(r.FormValue("expires"))
+	if uri == "" || err != nil || ttl < 0 {
+		http.Error(w, "url and expires, a duration, are required", http.StatusBadRequest)
+		return
+	}
+
+	link, err := libraryFor(r).share(r, uri, ttl)
+	if err == errArticleNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, articlePath("read", uri)+"?shared="+link.Token, http.StatusSeeOther)
+}
+
+var apiShareOp = apiOperation{
+	Summary:     "Share an article",
+	Description: "A link anyone may open to read the saved article, without signing in or seeing its URL, until it expires.",
+	Tag:         "articles",
+	Query: []apiParam{
+		{Name: "url", Description: "The article's URL.", Required: true},
+		{Name: "expires", Description: "How long the link works, e.g. 24h, never expiring without it."},
+	},
+	Status:   http.StatusCreated,
+	Response: shareLink{},
+	Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError},
+}
+
+func apiShareHandler(w http.ResponseWriter, r *http.Request) {
+	uri := r.URL.Query().Get("url")
+	if uri == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing url"})
+		return
+	}
+	var ttl time.Duration
+	if v := r.URL.Query().Get("expires"); v != "" {
+		var err error
+		if ttl, err = time.ParseDuration(v); err != nil || ttl < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "expires is a duration, e.g. 24h"})
+			return
+		}
+	}
+
+	link, err := libraryFor(r).share(r, uri, ttl)
+	if err == errArticleNotFound {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, link)
+}
+
+// sharedHandler shows the article of a share link, /s/{token}, to anyone
+// holding it: its title, site and content, none of the library around it.
+func sharedHandler(w http.ResponseWriter, r *http.Request) {
+	user, key, _, err := parseShareToken(mux.Vars(r)["token"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	lib := instanceLib
+	if user != "" {
+		if lib, err = userLibrary(user); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	art, err := lib.store.GetArticle(key)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get shared article", "key", key, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if art == nil || art.ErrMsg != "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Links aren't for search engines, nor to tell the sites they lead to
+	// where they were followed from.
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Add("Vary", "Cookie")
+	if notModified(w, r, articleETag(art, "shared", r.Header.Get("Cookie"), pageVersion()), time.Time{}) {
+		return
+	}
+
+	// Only what's read is shared, not the notes, highlights or tags.
+	shared := &article{
+		URL:         art.URL,
+		Title:       art.Title,
+		Content:     highlighted(sanitizeContent(art.Content)),
+		WordCount:   art.WordCount,
+		ReadingTime: art.ReadingTime,
+		Byline:      art.Byline,
+		SiteName:    art.SiteName,
+		Language:    art.Language,
+		PublishedAt: art.PublishedAt,
+	}
+	executePage(w, r, "shared.html", map[string]interface{}{
+		"Article": shared,
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"log/slog"
+	"math/big"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	slugAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	slugLen      = 6
+)
+
+// newSlug derives the short permalink slug of key, the base62 hash of the
+// key cut to slugLen characters, one longer each time it collides.
+func (lib *library) newSlug(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	n := new(big.Int).SetBytes(sum[:])
+
+	base := big.NewInt(int64(len(slugAlphabet)))
+	mod := new(big.Int)
+
+	var full []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, base, mod)
+		full = append(full, slugAlphabet[mod.Int64()])
+	}
+
+	for i := slugLen; i < len(full); i++ {
+		slug := string(full[:i])
+		owner, err := lib.store.SlugKey(slug)
+		if err != nil {
+			slog.Error("failed to look up slug", "slug", slug, "err", err)
+		}
+		if owner == "" || owner == key {
+			return slug
+		}
+	}
+
+	return string(full)
+}
+
+// ensureSlug gives a stored article that has no slug yet, saved before slugs
+// existed, its slug.
+func (lib *library) ensureSlug(key string, art *article) {
+	if art.Slug != "" || art.ErrMsg != "" {
+		return
+	}
+
+	slug := lib.newSlug(key)
+	err := lib.store.UpdateArticle(key, func(stored *article) {
+		stored.Slug = slug
+	})
+	if err != nil {
+		return
+	}
+	if err := lib.store.SetSlug(slug, key); err != nil {
+		slog.Error("failed to set slug", "slug", slug, "err", err)
+		return
+	}
+
+	art.Slug = slug
+}
+
+// slugHandler renders the article behind a /a/{slug} permalink, taking the
+// same options as /read.
+func slugHandler(w http.ResponseWriter, r *http.Request) {
+	lib := libraryFor(r)
+	key, err := lib.store.SlugKey(mux.Vars(r)["slug"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	uri := ""
+	if key != "" {
+		uri = lib.articleURL(key)
+	}
+	if uri == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	opts := readOptions{lib: lib, ctx: r.Context()}
+	for name, values := range r.URL.Query() {
+		opts.set(name, values[0])
+	}
+
+	writeArticle(w, r, readabyFormURL(uri, opts), opts)
+}
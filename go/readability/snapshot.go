@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// snapshot is an article frozen into a single standalone HTML page, images
+// included, that keeps working when the source site and the cache entry
+// are gone.
+type snapshot struct {
+	ID        string
+	Key       string
+	URL       string
+	Title     string
+	CreatedAt time.Time
+	HTML      []byte
+}
+
+// newSnapshot renders art with snapshot.html, inlining its images as data
+// URIs.
+func newSnapshot(key string, art *article) (*snapshot, error) {
+	css, err := cssFile.ReadFile("style.css")
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &snapshot{
+		ID:        randomID(8),
+		Key:       key,
+		URL:       art.URL,
+		Title:     art.Title,
+		CreatedAt: time.Now(),
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.ExecuteTemplate(&buf, "snapshot.html", map[string]interface{}{
+		"Article":  art,
+		"Content":  template.HTML(inlineImages(highlighted(art.Content))),
+		"CSS":      template.CSS(css),
+		"Snapshot": snap,
+	})
+	if err != nil {
+		return nil, err
+	}
+	snap.HTML = buf.Bytes()
+
+	return snap, nil
+}
+
+// inlineImages replaces the source of every <img> in content with a data
+// URI of the image, going through the image proxy's cache for proxied
+// ones. Images that can't be fetched keep their source.
+func inlineImages(content string) string {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(content), body)
+	if err != nil {
+		return content
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Img {
+			inlineImage(n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(body)
+
+	var sb strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&sb, c); err != nil {
+			return content
+		}
+	}
+
+	return sb.String()
+}
+
+func inlineImage(n *html.Node) {
+	src := attrValue(n, "src")
+
+	var img *cachedImage
+	if hash := strings.TrimPrefix(src, "/img/"); hash != src {
+		cached, err := getImage(hash)
+		if err != nil || cached == nil {
+			return
+		}
+		img = cached
+		if len(img.Data) == 0 {
+			if err := fetchImage(img); err != nil {
+				slog.Error("failed to fetch image", "url", img.URL, "err", err)
+				setAttr(n, "src", img.URL)
+				return
+			}
+			if err := setImage(hash, img); err != nil {
+				slog.Error("failed to cache image", "url", img.URL, "err", err)
+			}
+		}
+	} else if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		img = &cachedImage{URL: src}
+		if err := fetchImage(img); err != nil {
+			slog.Error("failed to fetch image", "url", img.URL, "err", err)
+			return
+		}
+	} else {
+		return
+	}
+
+	setAttr(n, "src", "data:"+img.ContentType+";base64,"+base64.StdEncoding.EncodeToString(img.Data))
+	for i := 0; i < len(n.Attr); i++ {
+		if n.Attr[i].Key == "srcset" || n.Attr[i].Key == "sizes" {
+			n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+			i--
+		}
+	}
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+// snapshotCreateHandler snapshots the article of the posted url and
+// redirects to it.
+func snapshotCreateHandler(w http.ResponseWriter, r *http.Request) {
+	uri := r.FormValue("url")
+	if uri == "" {
+		http.Error(w, "missing url", http.StatusBadRequest)
+		return
+	}
+
+	lib := libraryFor(r)
+	art := readabyFormURL(uri, readOptions{lib: lib, ctx: r.Context()})
+	if art.ErrMsg != "" {
+		http.Error(w, art.ErrMsg, http.StatusBadGateway)
+		return
+	}
+
+	snap, err := newSnapshot(lib.resolveKey(art.URL), art)
+	if err == nil {
+		err = lib.setSnapshot(snap)
+	}
+	if err != nil {
+		slog.Error("failed to snapshot", "url", art.URL, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/snapshot/"+snap.ID, http.StatusSeeOther)
+}
+
+// snapshotHandler serves /snapshot/{id}, ?download=1 as an attachment.
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	snap, err := libraryFor(r).snapshot(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if snap == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Everything the page needs is inline, don't let it load or run
+	// anything else.
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src data: http: https:; style-src 'unsafe-inline'")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.URL.Query().Get("download") != "" {
+		w.Header().Set("Content-Disposition", `attachment; filename="snapshot-`+snap.ID+`.html"`)
+	}
+	w.Write(snap.HTML)
+}
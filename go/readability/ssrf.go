@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FETCH_ALLOW_PRIVATE lets articles be fetched from loopback, private and
+// link-local addresses, for instances reading an intranet.
+var FETCH_ALLOW_PRIVATE = envOr("FETCH_ALLOW_PRIVATE", "") == "true"
+
+// blockedNets are the addresses beyond the private, loopback, link-local
+// and multicast ones net.IP knows of that aren't fetched.
+var blockedNets = parseCIDRs(
+	"0.0.0.0/8",     // this network
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved
+	"64:ff9b::/96",  // NAT64, maps onto IPv4
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets
+}
+
+// privateAddrError is returned for a fetch that would reach an address
+// FETCH_ALLOW_PRIVATE keeps off limits.
+type privateAddrError struct {
+	host string
+}
+
+func (e *privateAddrError) Error() string {
+	return fmt.Sprintf("%s is a private address, it can't be read here", e.host)
+}
+
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkURL rejects URLs that aren't http(s) and hosts that are private
+// addresses as written. Names resolving to one are stopped when dialing.
+func checkURL(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("only http and https URLs can be read, not %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return errors.New("the URL has no host")
+	}
+
+	if FETCH_ALLOW_PRIVATE {
+		return nil
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && isPrivateIP(ip) {
+		return &privateAddrError{host: u.Hostname()}
+	}
+	if host := strings.ToLower(strings.TrimSuffix(u.Hostname(), ".")); host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return &privateAddrError{host: u.Hostname()}
+	}
+
+	return nil
+}
+
+// dialControl refuses connections to private addresses once names are
+// resolved, so neither DNS nor redirects get around checkURL.
+func dialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+		return &privateAddrError{host: host}
+	}
+
+	return nil
+}
+
+// hostPort is the host:port u is dialed at, with the scheme's port when it
+// has none.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "socks5":
+			port = "1080"
+		default:
+			port = "80"
+		}
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// trustedAddrs are the host:port of the services at raws this instance is
+// set up with, HEADLESS_URL and WAYBACK_API_URL, which may well be private.
+// A URL without a scheme is http.
+func trustedAddrs(raws ...string) map[string]bool {
+	addrs := make(map[string]bool)
+	for _, raw := range raws {
+		if raw == "" {
+			continue
+		}
+		if !strings.Contains(raw, "://") {
+			raw = "http://" + raw
+		}
+		if u, err := url.Parse(raw); err == nil && u.Hostname() != "" {
+			addrs[hostPort(u)] = true
+		}
+	}
+
+	return addrs
+}
+
+// guardedDialContext dials trusted as they are and everything else through
+// dialControl, unless FETCH_ALLOW_PRIVATE is set.
+func guardedDialContext(trusted map[string]bool) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if FETCH_ALLOW_PRIVATE {
+		return dialer.DialContext
+	}
+
+	guarded := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: dialControl}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if trusted[addr] {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return guarded.DialContext(ctx, network, addr)
+	}
+}
+
+// checkProxied checks u before it's fetched through a proxy, which dials
+// it instead of dialControl: it's refused when its host resolves to a
+// private address here. Names that don't resolve here are left to the
+// proxy.
+func checkProxied(ctx context.Context, u *url.URL) error {
+	if err := checkURL(u.String()); err != nil || FETCH_ALLOW_PRIVATE {
+		return err
+	}
+	if net.ParseIP(u.Hostname()) != nil {
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if isPrivateIP(addr.IP) {
+			return &privateAddrError{host: u.Hostname()}
+		}
+	}
+
+	return nil
+}
+
+// permanentFetchError reports whether err is not worth a retry or a look
+// on the Wayback Machine.
+func permanentFetchError(err error) bool {
+	return errors.As(err, new(*rateLimitError)) || errors.As(err, new(*privateAddrError))
+}
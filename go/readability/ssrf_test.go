@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		uri     string
+		ok      bool
+		private bool
+	}{
+		{uri: "https://example.com/a", ok: true},
+		{uri: "http://example.com:8080/a?b=c", ok: true},
+		{uri: "http://93.184.215.14/", ok: true},
+		{uri: "ftp://example.com/a"},
+		{uri: "file:///etc/passwd"},
+		{uri: "http:///a"},
+		{uri: "http://localhost/", private: true},
+		{uri: "http://LOCALHOST./", private: true},
+		{uri: "http://app.localhost:8080/", private: true},
+		{uri: "http://127.0.0.1/", private: true},
+		{uri: "http://10.1.2.3/", private: true},
+		{uri: "http://169.254.169.254/latest/meta-data/", private: true},
+		{uri: "http://0.0.0.0/", private: true},
+		{uri: "http://100.64.0.1/", private: true},
+		{uri: "http://[::1]/", private: true},
+		{uri: "http://[fe80::1]/", private: true},
+		{uri: "http://[64:ff9b::a00:1]/", private: true},
+	}
+
+	for _, tt := range tests {
+		err := checkURL(tt.uri)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkURL(%q) = %v, want ok %v", tt.uri, err, tt.ok)
+		}
+		if private := errors.As(err, new(*privateAddrError)); private != tt.private {
+			t.Errorf("checkURL(%q) = %v, want a private address error %v", tt.uri, err, tt.private)
+		}
+	}
+}
+
+func TestDialControl(t *testing.T) {
+	tests := []struct {
+		address string
+		ok      bool
+	}{
+		{address: "93.184.215.14:443", ok: true},
+		{address: "[2606:2800:21f:cb07:6820:80da:af6b:8b2c]:80", ok: true},
+		{address: "127.0.0.1:80"},
+		{address: "192.168.1.1:443"},
+		{address: "172.16.0.1:80"},
+		{address: "169.254.169.254:80"},
+		{address: "198.18.0.1:80"},
+		{address: "224.0.0.1:80"},
+		{address: "[::1]:80"},
+		{address: "[fc00::1]:80"},
+		{address: "[::ffff:127.0.0.1]:80"},
+		{address: "example.com:80"},
+		{address: "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		if err := dialControl("tcp", tt.address, nil); (err == nil) != tt.ok {
+			t.Errorf("dialControl(%q) = %v, want ok %v", tt.address, err, tt.ok)
+		}
+	}
+}
+
+func TestTrustedAddrs(t *testing.T) {
+	tests := []struct {
+		raws []string
+		want map[string]bool
+	}{
+		{raws: nil, want: map[string]bool{}},
+		{raws: []string{"", ""}, want: map[string]bool{}},
+		{
+			raws: []string{"http://headless:3000/render", "https://archive.org/wayback/available"},
+			want: map[string]bool{"headless:3000": true, "archive.org:443": true},
+		},
+		{raws: []string{"http://10.0.0.5/render"}, want: map[string]bool{"10.0.0.5:80": true}},
+		{raws: []string{"localhost:9222"}, want: map[string]bool{"localhost:9222": true}},
+		{raws: []string{"socks5://127.0.0.1"}, want: map[string]bool{"127.0.0.1:1080": true}},
+		{raws: []string{"http://[::1]:8080/"}, want: map[string]bool{"[::1]:8080": true}},
+		{raws: []string{"http:///render", "://"}, want: map[string]bool{}},
+	}
+
+	for _, tt := range tests {
+		if got := trustedAddrs(tt.raws...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("trustedAddrs(%q) = %v, want %v", tt.raws, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+const (
+	immutableCache = "public, max-age=31536000, immutable"
+	// revalidateCache is for assets requested without their hash, the
+	// version they get may change.
+	revalidateCache = "public, max-age=300, must-revalidate"
+)
+
+// staticHashes are the content hashes of the /static/ files, by name.
+var staticHashes = hashFiles(cssFile)
+
+// contentHash is the short hash an asset's URL carries, changing with it.
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+func hashFiles(fsys fs.FS) map[string]string {
+	hashes := make(map[string]string)
+	fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if data, err := fs.ReadFile(fsys, name); err == nil {
+			hashes[name] = contentHash(data)
+		}
+		return nil
+	})
+
+	return hashes
+}
+
+// staticPath is the URL of the /static/ file name, with its hash for it to
+// be cached for good.
+func staticPath(name string) string {
+	if hash, ok := staticHashes[name]; ok {
+		return "/static/" + name + "?v=" + hash
+	}
+
+	return "/static/" + name
+}
+
+// themePath is the URL of the stylesheet of the theme name, with its hash.
+func themePath(name string) string {
+	if css := themes[name]; css != nil {
+		return "/themes/" + name + ".css?v=" + contentHash(css)
+	}
+
+	return "/themes/" + name + ".css"
+}
+
+// assetCache sets how long the asset at r can be cached, for good when it
+// is requested with the hash it has.
+func assetCache(w http.ResponseWriter, r *http.Request, hash string) {
+	if v := r.URL.Query().Get("v"); v != "" && v == hash {
+		w.Header().Set("Cache-Control", immutableCache)
+		return
+	}
+
+	w.Header().Set("Cache-Control", revalidateCache)
+}
+
+func staticHandler() http.Handler {
+	files := http.StripPrefix("/static/", http.FileServer(http.FS(cssFile)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assetCache(w, r, staticHashes[strings.TrimPrefix(r.URL.Path, "/static/")])
+		files.ServeHTTP(w, r)
+	})
+}
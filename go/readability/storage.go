@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// Storage persists extracted articles together with their view counts and
+// the order in which they were saved.
+type Storage interface {
+	// GetArticle returns nil, nil when the key is not stored.
+	GetArticle(key string) (*article, error)
+	SetArticle(key string, art *article) error
+	// UpdateArticle rewrites a stored article with fn without moving it in
+	// the recents.
+	UpdateArticle(key string, fn func(art *article)) error
+	DeleteArticle(key string) error
+	IncrViewCount(key string) error
+	ViewCount(key string) (int64, error)
+	// SetViewCount sets the total views of a stored article, copying it
+	// from another storage. The views by day aren't set.
+	SetViewCount(key string, views int64) error
+	// TrendingArticles returns the n most viewed keys, weighing the views
+	// of i days ago by weights[i], or by total views when weights is empty.
+	TrendingArticles(n int, weights []float64) ([]string, error)
+	LastNArticles(n int) ([]string, error)
+	// Keys returns the key of every stored article.
+	Keys() ([]string, error)
+	// ArticleURL returns the URL of the article stored under key, "" when
+	// there is none.
+	ArticleURL(key string) (string, error)
+	// SetTags replaces the tags of a stored article.
+	SetTags(key string, tags []string) error
+	// TaggedArticles returns the keys of the articles tagged with tag.
+	TaggedArticles(tag string) ([]string, error)
+	// Tags returns every tag in use with the number of articles carrying it.
+	Tags() (map[string]int, error)
+	// SetStarred stars or unstars a stored article.
+	SetStarred(key string, starred bool) error
+	// StarredArticles returns the keys of the starred articles, most
+	// recently starred first.
+	StarredArticles() ([]string, error)
+	// SetAlias makes alias resolve to the article stored under key.
+	SetAlias(alias, key string) error
+	// Alias returns the key alias resolves to, "" when it has none.
+	Alias(alias string) (string, error)
+	// Aliases returns every alias with the key it resolves to.
+	Aliases() (map[string]string, error)
+	// SetSlug makes the permalink slug resolve to key.
+	SetSlug(slug, key string) error
+	// SlugKey returns the key slug resolves to, "" when it is unused.
+	SlugKey(slug string) (string, error)
+	// WallabagID returns the Wallabag API ID of the article stored under
+	// key, giving it the next one the first time. IDs aren't reused.
+	WallabagID(key string) (int, error)
+	// WallabagKey returns the key of the article with the Wallabag API ID
+	// id, "" when there is none.
+	WallabagKey(id int) (string, error)
+	// SetImage stores an image of the image proxy under its hash.
+	SetImage(hash string, img *cachedImage) error
+	// GetImage returns nil, nil when the hash is not stored.
+	GetImage(hash string) (*cachedImage, error)
+	// SetRaw stores the compressed page an article was extracted from, it
+	// goes with the article.
+	SetRaw(key string, data []byte) error
+	// Raw returns nil, nil when no page is stored for key.
+	Raw(key string) ([]byte, error)
+	// SetTranslation stores a translation of the article stored under key,
+	// replacing the one into the same language. Translations go with the
+	// article.
+	SetTranslation(key string, tr *translation) error
+	// Translation returns nil, nil when key isn't translated into lang.
+	Translation(key, lang string) (*translation, error)
+	// SetAudio stores the narration of an article, it goes with the
+	// article.
+	SetAudio(key string, data []byte) error
+	// Audio returns nil, nil when no narration is stored for key.
+	Audio(key string) ([]byte, error)
+	// SetCredential stores the sealed credentials of a domain.
+	SetCredential(domain string, sealed []byte) error
+	DeleteCredential(domain string) error
+	// Credentials returns the sealed credentials of every domain.
+	Credentials() (map[string][]byte, error)
+	// SetAPIToken stores an API token by its ID.
+	SetAPIToken(tok *apiToken) error
+	DeleteAPIToken(id string) error
+	// APITokens returns every API token.
+	APITokens() ([]*apiToken, error)
+	// IncrTokenUsage counts a request made with the API token id on day,
+	// returning how many it made that day. Usage goes with the token.
+	IncrTokenUsage(id, day string) (int64, error)
+	// TokenUsage returns how many requests the API token id made by day.
+	TokenUsage(id string) (map[string]int64, error)
+	// SetUser stores an account by its name.
+	SetUser(u *user) error
+	// GetUser returns nil, nil when there is no user name.
+	GetUser(name string) (*user, error)
+	// Users returns every account.
+	Users() ([]*user, error)
+	// SetSnapshot stores a snapshot, it is kept when its article goes.
+	SetSnapshot(snap *snapshot) error
+	// GetSnapshot returns nil, nil when there is no snapshot id.
+	GetSnapshot(id string) (*snapshot, error)
+	// Snapshots returns the IDs of the snapshots of key, newest first.
+	Snapshots(key string) ([]string, error)
+	// SetWatched adds a stored article to the ones re-fetched on a
+	// schedule, or takes it off.
+	SetWatched(key string, watched bool) error
+	// WatchedArticles returns the keys of the watched articles.
+	WatchedArticles() ([]string, error)
+	// SetPrivate marks a stored article private, hidden from visitors not
+	// signed in, or lists it again.
+	SetPrivate(key string, private bool) error
+	// PrivateArticles returns the keys of the private articles.
+	PrivateArticles() ([]string, error)
+	// AddVersion stores an earlier version of an article, keeping the keep
+	// newest ones. Versions go with the article.
+	AddVersion(key string, v *articleVersion, keep int) error
+	// Versions returns the earlier versions of an article, newest first.
+	Versions(key string) ([]*articleVersion, error)
+	// Usage returns how many articles are stored and their size in bytes.
+	Usage() (int, int64, error)
+	// LeastRecentlyViewed returns up to n keys, least recently viewed or
+	// saved first.
+	LeastRecentlyViewed(n int) ([]string, error)
+	// Ping checks the storage can be reached.
+	Ping() error
+	Close() error
+}
+
+// fullTextSearcher is a storage indexing the articles for search itself,
+// searched instead of the in-process index.
+type fullTextSearcher interface {
+	// SearchArticles returns up to n articles matching every word of q,
+	// best match first, but for the private ones when public is set.
+	SearchArticles(q string, n int, public bool) ([]searchResult, error)
+}
+
+var errArticleNotFound = errors.New("article not found")
+
+// MEMORY_CACHE_SIZE is how many articles the in-memory storage keeps.
+var MEMORY_CACHE_SIZE = envInt("MEMORY_CACHE_SIZE", 1000)
+
+// viewRetentionDays is how long per-day view counts are kept for trending.
+const viewRetentionDays = 30
+
+func viewDay(t time.Time) int64 {
+	return t.Unix() / 86400
+}
+
+// topScores returns the n keys with the highest positive score.
+func topScores(scores map[string]float64, n int) []string {
+	keys := make([]string, 0, len(scores))
+	for key, score := range scores {
+		if score > 0 {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if scores[keys[i]] != scores[keys[j]] {
+			return scores[keys[i]] > scores[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+
+	return keys
+}
+
+func newStorage(kind string) (Storage, error) {
+	switch kind {
+	case "":
+		if !redisConfigured() {
+			slog.Warn("REDIS_URL is not set, falling back to in-memory cache, articles will not persist across restarts")
+			return newMemoryStorage(MEMORY_CACHE_SIZE), nil
+		}
+		return newRedisStorage()
+	case "redis":
+		return newRedisStorage()
+	case "sqlite":
+		return newSQLiteStorage(SQLITE_PATH)
+	case "postgres":
+		return newPostgresStorage(POSTGRES_URL, "")
+	case "memory":
+		return newMemoryStorage(MEMORY_CACHE_SIZE), nil
+	}
+
+	return nil, fmt.Errorf("unknown storage: %s", kind)
+}
@@ -0,0 +1,788 @@
+package main
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStorage keeps articles in process, evicting the least recently used
+// ones once size is reached. Nothing survives a restart.
+type memoryStorage struct {
+	mu sync.Mutex
+
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+	views map[string]float64
+	queue []string
+	tags  map[string]map[string]bool
+
+	starred map[string]time.Time
+	daily   map[int64]map[string]float64
+	aliases map[string]string
+	slugs   map[string]string
+	images  map[string]cachedImage
+	raw     map[string][]byte
+	trans   map[string]map[string]translation
+	audio   map[string][]byte
+	creds   map[string][]byte
+	tokens  map[string]apiToken
+	usage   map[string]map[string]int64
+	users   map[string]user
+
+	snapshots map[string]snapshot
+	snapKeys  map[string][]string
+
+	watched  map[string]time.Time
+	private  map[string]bool
+	versions map[string][]articleVersion
+
+	wallabagIDs  map[string]int
+	wallabagKeys map[int]string
+	wallabagSeq  int
+}
+
+type memoryEntry struct {
+	key string
+	art article
+}
+
+func newMemoryStorage(size int) *memoryStorage {
+	return &memoryStorage{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+		views: make(map[string]float64),
+		tags:  make(map[string]map[string]bool),
+
+		starred: make(map[string]time.Time),
+		daily:   make(map[int64]map[string]float64),
+		aliases: make(map[string]string),
+		slugs:   make(map[string]string),
+		images:  make(map[string]cachedImage),
+		raw:     make(map[string][]byte),
+		trans:   make(map[string]map[string]translation),
+		audio:   make(map[string][]byte),
+		creds:   make(map[string][]byte),
+		tokens:  make(map[string]apiToken),
+		usage:   make(map[string]map[string]int64),
+		users:   make(map[string]user),
+
+		snapshots: make(map[string]snapshot),
+		snapKeys:  make(map[string][]string),
+
+		watched:  make(map[string]time.Time),
+		private:  make(map[string]bool),
+		versions: make(map[string][]articleVersion),
+
+		wallabagIDs:  make(map[string]int),
+		wallabagKeys: make(map[int]string),
+	}
+}
+
+func (s *memoryStorage) GetArticle(key string) (*article, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	s.ll.MoveToFront(e)
+
+	art := e.Value.(*memoryEntry).art
+	return &art, nil
+}
+
+func (s *memoryStorage) SetArticle(key string, art *article) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.items[key]; ok {
+		e.Value.(*memoryEntry).art = *art
+		s.ll.MoveToFront(e)
+	} else {
+		s.items[key] = s.ll.PushFront(&memoryEntry{key: key, art: *art})
+	}
+	s.dequeue(key)
+	s.queue = append([]string{key}, s.queue...)
+
+	for s.size > 0 && s.ll.Len() > s.size {
+		s.remove(s.ll.Back().Value.(*memoryEntry).key)
+	}
+
+	return nil
+}
+
+func (s *memoryStorage) UpdateArticle(key string, fn func(art *article)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.items[key]
+	if !ok {
+		return errArticleNotFound
+	}
+
+	fn(&e.Value.(*memoryEntry).art)
+
+	return nil
+}
+
+func (s *memoryStorage) DeleteArticle(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(key)
+	return nil
+}
+
+// remove drops key from every index, callers must hold mu.
+func (s *memoryStorage) remove(key string) {
+	if e, ok := s.items[key]; ok {
+		s.untag(key, e.Value.(*memoryEntry).art.Tags)
+		delete(s.slugs, e.Value.(*memoryEntry).art.Slug)
+		s.ll.Remove(e)
+		delete(s.items, key)
+	}
+	delete(s.views, key)
+	delete(s.starred, key)
+	delete(s.raw, key)
+	delete(s.trans, key)
+	delete(s.audio, key)
+	delete(s.watched, key)
+	delete(s.private, key)
+	delete(s.versions, key)
+	for alias, k := range s.aliases {
+		if k == key {
+			delete(s.aliases, alias)
+		}
+	}
+	if id, ok := s.wallabagIDs[key]; ok {
+		delete(s.wallabagKeys, id)
+		delete(s.wallabagIDs, key)
+	}
+	for _, views := range s.daily {
+		delete(views, key)
+	}
+
+	s.dequeue(key)
+}
+
+// dequeue drops key from the recents, callers must hold mu.
+func (s *memoryStorage) dequeue(key string) {
+	queue := s.queue[:0]
+	for _, k := range s.queue {
+		if k != key {
+			queue = append(queue, k)
+		}
+	}
+	s.queue = queue
+}
+
+func (s *memoryStorage) IncrViewCount(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[key]; !ok {
+		return nil
+	}
+
+	today := viewDay(time.Now())
+	if s.daily[today] == nil {
+		s.daily[today] = make(map[string]float64)
+		for day := range s.daily {
+			if day < today-viewRetentionDays {
+				delete(s.daily, day)
+			}
+		}
+	}
+
+	s.views[key]++
+	s.daily[today][key]++
+	return nil
+}
+
+func (s *memoryStorage) ViewCount(key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return int64(s.views[key]), nil
+}
+
+func (s *memoryStorage) SetViewCount(key string, views int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[key]; ok {
+		s.views[key] = float64(views)
+	}
+	return nil
+}
+
+func (s *memoryStorage) TrendingArticles(n int, weights []float64) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(weights) == 0 {
+		return topScores(s.views, n), nil
+	}
+
+	today := viewDay(time.Now())
+	scores := make(map[string]float64)
+	for i, weight := range weights {
+		for key, views := range s.daily[today-int64(i)] {
+			scores[key] += views * weight
+		}
+	}
+
+	return topScores(scores, n), nil
+}
+
+func (s *memoryStorage) SetAlias(alias, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.aliases[alias] = key
+	return nil
+}
+
+func (s *memoryStorage) Alias(alias string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.aliases[alias], nil
+}
+
+func (s *memoryStorage) Aliases() (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	aliases := make(map[string]string, len(s.aliases))
+	for alias, key := range s.aliases {
+		aliases[alias] = key
+	}
+	return aliases, nil
+}
+
+func (s *memoryStorage) SetSlug(slug, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.slugs[slug] = key
+	return nil
+}
+
+func (s *memoryStorage) SlugKey(slug string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.slugs[slug], nil
+}
+
+func (s *memoryStorage) WallabagID(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, ok := s.wallabagIDs[key]; ok {
+		return id, nil
+	}
+	s.wallabagSeq++
+	id := s.wallabagSeq
+	s.wallabagIDs[key] = id
+	s.wallabagKeys[id] = key
+
+	return id, nil
+}
+
+func (s *memoryStorage) WallabagKey(id int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.wallabagKeys[id], nil
+}
+
+func (s *memoryStorage) SetImage(hash string, img *cachedImage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.images[hash] = *img
+	return nil
+}
+
+func (s *memoryStorage) GetImage(hash string) (*cachedImage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	img, ok := s.images[hash]
+	if !ok {
+		return nil, nil
+	}
+
+	return &img, nil
+}
+
+func (s *memoryStorage) SetRaw(key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[key]; !ok {
+		return errArticleNotFound
+	}
+	s.raw[key] = data
+	return nil
+}
+
+func (s *memoryStorage) Raw(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.raw[key], nil
+}
+
+func (s *memoryStorage) SetTranslation(key string, tr *translation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[key]; !ok {
+		return errArticleNotFound
+	}
+	if s.trans[key] == nil {
+		s.trans[key] = make(map[string]translation)
+	}
+	s.trans[key][tr.Lang] = *tr
+	return nil
+}
+
+func (s *memoryStorage) Translation(key, lang string) (*translation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tr, ok := s.trans[key][lang]
+	if !ok {
+		return nil, nil
+	}
+
+	return &tr, nil
+}
+
+func (s *memoryStorage) SetAudio(key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[key]; !ok {
+		return errArticleNotFound
+	}
+	s.audio[key] = data
+	return nil
+}
+
+func (s *memoryStorage) Audio(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.audio[key], nil
+}
+
+func (s *memoryStorage) SetCredential(domain string, sealed []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.creds[domain] = sealed
+	return nil
+}
+
+func (s *memoryStorage) DeleteCredential(domain string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.creds, domain)
+	return nil
+}
+
+func (s *memoryStorage) Credentials() (map[string][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	creds := make(map[string][]byte, len(s.creds))
+	for domain, sealed := range s.creds {
+		creds[domain] = sealed
+	}
+
+	return creds, nil
+}
+
+func (s *memoryStorage) SetAPIToken(tok *apiToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[tok.ID] = *tok
+	return nil
+}
+
+func (s *memoryStorage) DeleteAPIToken(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tokens, id)
+	delete(s.usage, id)
+	return nil
+}
+
+func (s *memoryStorage) APITokens() ([]*apiToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := make([]*apiToken, 0, len(s.tokens))
+	for _, tok := range s.tokens {
+		tok := tok
+		tokens = append(tokens, &tok)
+	}
+
+	return tokens, nil
+}
+
+func (s *memoryStorage) IncrTokenUsage(id, day string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.usage[id] == nil {
+		s.usage[id] = make(map[string]int64)
+	}
+	s.usage[id][day]++
+
+	return s.usage[id][day], nil
+}
+
+func (s *memoryStorage) TokenUsage(id string) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := make(map[string]int64, len(s.usage[id]))
+	for day, n := range s.usage[id] {
+		usage[day] = n
+	}
+
+	return usage, nil
+}
+
+func (s *memoryStorage) SetUser(u *user) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[u.Name] = *u
+	return nil
+}
+
+func (s *memoryStorage) GetUser(name string) (*user, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[name]
+	if !ok {
+		return nil, nil
+	}
+
+	return &u, nil
+}
+
+func (s *memoryStorage) Users() ([]*user, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]*user, 0, len(s.users))
+	for _, u := range s.users {
+		u := u
+		users = append(users, &u)
+	}
+
+	return users, nil
+}
+
+func (s *memoryStorage) SetSnapshot(snap *snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.snapshots[snap.ID]; !ok {
+		s.snapKeys[snap.Key] = append([]string{snap.ID}, s.snapKeys[snap.Key]...)
+	}
+	s.snapshots[snap.ID] = *snap
+	return nil
+}
+
+func (s *memoryStorage) GetSnapshot(id string) (*snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap, ok := s.snapshots[id]
+	if !ok {
+		return nil, nil
+	}
+
+	return &snap, nil
+}
+
+func (s *memoryStorage) Snapshots(key string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.snapKeys[key]...), nil
+}
+
+func (s *memoryStorage) SetWatched(key string, watched bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.items[key]
+	if !ok {
+		return errArticleNotFound
+	}
+
+	e.Value.(*memoryEntry).art.Watched = watched
+	if !watched {
+		delete(s.watched, key)
+	} else if _, ok := s.watched[key]; !ok {
+		s.watched[key] = time.Now()
+	}
+
+	return nil
+}
+
+func (s *memoryStorage) WatchedArticles() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.watched))
+	for key := range s.watched {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return s.watched[keys[i]].After(s.watched[keys[j]]) })
+
+	return keys, nil
+}
+
+func (s *memoryStorage) SetPrivate(key string, private bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.items[key]
+	if !ok {
+		return errArticleNotFound
+	}
+
+	e.Value.(*memoryEntry).art.Private = private
+	if private {
+		s.private[key] = true
+	} else {
+		delete(s.private, key)
+	}
+
+	return nil
+}
+
+func (s *memoryStorage) PrivateArticles() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.private))
+	for key := range s.private {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (s *memoryStorage) AddVersion(key string, v *articleVersion, keep int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[key]; !ok {
+		return errArticleNotFound
+	}
+
+	versions := append([]articleVersion{*v}, s.versions[key]...)
+	if len(versions) > keep {
+		versions = versions[:keep]
+	}
+	s.versions[key] = versions
+
+	return nil
+}
+
+func (s *memoryStorage) Versions(key string) ([]*articleVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := make([]*articleVersion, 0, len(s.versions[key]))
+	for i := range s.versions[key] {
+		v := s.versions[key][i]
+		versions = append(versions, &v)
+	}
+
+	return versions, nil
+}
+
+func (s *memoryStorage) Usage() (int, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var size int64
+	for e := s.ll.Front(); e != nil; e = e.Next() {
+		art := &e.Value.(*memoryEntry).art
+		size += int64(len(art.URL) + len(art.Title) + len(art.Content))
+	}
+
+	return s.ll.Len(), size, nil
+}
+
+func (s *memoryStorage) LeastRecentlyViewed(n int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, n)
+	for e := s.ll.Back(); e != nil && len(keys) < n; e = e.Prev() {
+		keys = append(keys, e.Value.(*memoryEntry).key)
+	}
+
+	return keys, nil
+}
+
+func (s *memoryStorage) LastNArticles(n int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n > len(s.queue) {
+		n = len(s.queue)
+	}
+
+	records := make([]string, n)
+	copy(records, s.queue)
+
+	return records, nil
+}
+
+func (s *memoryStorage) Keys() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, s.ll.Len())
+	for e := s.ll.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(*memoryEntry).key)
+	}
+
+	return keys, nil
+}
+
+func (s *memoryStorage) ArticleURL(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.items[key]; ok {
+		return e.Value.(*memoryEntry).art.URL, nil
+	}
+
+	return "", nil
+}
+
+func (s *memoryStorage) SetTags(key string, tags []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.items[key]
+	if !ok {
+		return errArticleNotFound
+	}
+
+	entry := e.Value.(*memoryEntry)
+	s.untag(key, entry.art.Tags)
+	entry.art.Tags = tags
+
+	for _, tag := range tags {
+		if s.tags[tag] == nil {
+			s.tags[tag] = make(map[string]bool)
+		}
+		s.tags[tag][key] = true
+	}
+
+	return nil
+}
+
+// untag removes key from the given tags, callers must hold mu.
+func (s *memoryStorage) untag(key string, tags []string) {
+	for _, tag := range tags {
+		delete(s.tags[tag], key)
+		if len(s.tags[tag]) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+}
+
+func (s *memoryStorage) TaggedArticles(tag string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.tags[tag]))
+	for e := s.ll.Front(); e != nil; e = e.Next() {
+		if key := e.Value.(*memoryEntry).key; s.tags[tag][key] {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+func (s *memoryStorage) Tags() (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tags := make(map[string]int, len(s.tags))
+	for tag, keys := range s.tags {
+		tags[tag] = len(keys)
+	}
+
+	return tags, nil
+}
+
+func (s *memoryStorage) SetStarred(key string, starred bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.items[key]
+	if !ok {
+		return errArticleNotFound
+	}
+
+	e.Value.(*memoryEntry).art.Starred = starred
+	if !starred {
+		delete(s.starred, key)
+	} else if _, ok := s.starred[key]; !ok {
+		s.starred[key] = time.Now()
+	}
+
+	return nil
+}
+
+func (s *memoryStorage) StarredArticles() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.starred))
+	for key := range s.starred {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return s.starred[keys[i]].After(s.starred[keys[j]]) })
+
+	return keys, nil
+}
+
+func (s *memoryStorage) Ping() error {
+	return nil
+}
+
+func (s *memoryStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,977 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// POSTGRES_URL is the database of STORAGE=postgres, a postgres:// URL or a
+// key=value connection string.
+var POSTGRES_URL = envOr("POSTGRES_URL", "")
+
+// postgresSchema is the schema of the Postgres storage. The articles'
+// search column indexes their title, text and notes for full-text search,
+// with the simple configuration as they're in any language.
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS articles (
+	key        TEXT PRIMARY KEY,
+	url        TEXT NOT NULL DEFAULT '',
+	data       BYTEA NOT NULL,
+	views      BIGINT NOT NULL DEFAULT 0,
+	updated_at BIGINT NOT NULL,
+	viewed_at  BIGINT NOT NULL DEFAULT 0,
+	search     TSVECTOR
+);
+CREATE INDEX IF NOT EXISTS articles_updated_at ON articles (updated_at);
+CREATE INDEX IF NOT EXISTS articles_search ON articles USING GIN (search);
+CREATE TABLE IF NOT EXISTS tags (
+	key TEXT NOT NULL REFERENCES articles (key) ON DELETE CASCADE,
+	tag TEXT NOT NULL,
+	PRIMARY KEY (key, tag)
+);
+CREATE INDEX IF NOT EXISTS tags_tag ON tags (tag);
+CREATE TABLE IF NOT EXISTS views (
+	key   TEXT NOT NULL REFERENCES articles (key) ON DELETE CASCADE,
+	day   BIGINT NOT NULL,
+	views BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (key, day)
+);
+CREATE TABLE IF NOT EXISTS aliases (
+	alias TEXT PRIMARY KEY,
+	key   TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS slugs (
+	slug TEXT PRIMARY KEY,
+	key  TEXT NOT NULL REFERENCES articles (key) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS wallabag_ids (
+	id  BIGSERIAL PRIMARY KEY,
+	key TEXT NOT NULL UNIQUE
+);
+CREATE TABLE IF NOT EXISTS starred (
+	key        TEXT PRIMARY KEY REFERENCES articles (key) ON DELETE CASCADE,
+	starred_at BIGINT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS raw (
+	key  TEXT PRIMARY KEY REFERENCES articles (key) ON DELETE CASCADE,
+	html BYTEA NOT NULL
+);
+CREATE TABLE IF NOT EXISTS translations (
+	key  TEXT NOT NULL REFERENCES articles (key) ON DELETE CASCADE,
+	lang TEXT NOT NULL,
+	data BYTEA NOT NULL,
+	PRIMARY KEY (key, lang)
+);
+CREATE TABLE IF NOT EXISTS audio (
+	key  TEXT PRIMARY KEY REFERENCES articles (key) ON DELETE CASCADE,
+	data BYTEA NOT NULL
+);
+CREATE TABLE IF NOT EXISTS credentials (
+	domain TEXT PRIMARY KEY,
+	sealed BYTEA NOT NULL
+);
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id         TEXT PRIMARY KEY,
+	name       TEXT NOT NULL,
+	hash       TEXT NOT NULL UNIQUE,
+	created_at BIGINT NOT NULL,
+	rate       INTEGER NOT NULL DEFAULT 0,
+	quota      INTEGER NOT NULL DEFAULT 0,
+	"user"     TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS users (
+	name          TEXT PRIMARY KEY,
+	id            TEXT NOT NULL UNIQUE,
+	password_hash BYTEA,
+	created_at    BIGINT NOT NULL,
+	display_name  TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS token_usage (
+	id    TEXT NOT NULL REFERENCES api_tokens (id) ON DELETE CASCADE,
+	day   TEXT NOT NULL,
+	count BIGINT NOT NULL,
+	PRIMARY KEY (id, day)
+);
+CREATE TABLE IF NOT EXISTS snapshots (
+	id         TEXT PRIMARY KEY,
+	key        TEXT NOT NULL,
+	url        TEXT NOT NULL,
+	title      TEXT NOT NULL,
+	html       BYTEA NOT NULL,
+	created_at BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS snapshots_key ON snapshots (key, created_at);
+CREATE TABLE IF NOT EXISTS watched (
+	key        TEXT PRIMARY KEY REFERENCES articles (key) ON DELETE CASCADE,
+	watched_at BIGINT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS private (
+	key TEXT PRIMARY KEY REFERENCES articles (key) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS versions (
+	id       BIGSERIAL PRIMARY KEY,
+	key      TEXT NOT NULL REFERENCES articles (key) ON DELETE CASCADE,
+	data     BYTEA NOT NULL,
+	saved_at BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS versions_key ON versions (key, id);
+CREATE TABLE IF NOT EXISTS images (
+	hash         TEXT PRIMARY KEY,
+	url          TEXT NOT NULL,
+	content_type TEXT NOT NULL DEFAULT '',
+	data         BYTEA,
+	fetched_at   BIGINT NOT NULL DEFAULT 0
+);
+`
+
+// postgresSearch is the search column of an article from its title, $1,
+// weighed over its text, $2.
+const postgresSearch = `setweight(to_tsvector('simple', $1), 'A') || to_tsvector('simple', $2)`
+
+type postgresStorage struct {
+	db *sql.DB
+	// dsn is the connection string, the users' libraries are schemas of
+	// the same database.
+	dsn string
+}
+
+// newPostgresStorage connects to the Postgres database at dsn, keeping the
+// tables in schema, created if needed, or in the default one when empty.
+func newPostgresStorage(dsn, schema string) (*postgresStorage, error) {
+	conn := dsn
+	if schema != "" {
+		conn = postgresSearchPath(dsn, schema)
+	}
+	db, err := sql.Open("postgres", conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open postgres: %w", err)
+	}
+
+	if schema != "" {
+		if _, err := db.Exec(`CREATE SCHEMA IF NOT EXISTS ` + pq.QuoteIdentifier(schema)); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create postgres schema %s: %w", schema, err)
+		}
+	}
+	if _, err := db.Exec(postgresSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create postgres tables: %w", err)
+	}
+
+	return &postgresStorage{db: db, dsn: dsn}, nil
+}
+
+// postgresSearchPath is dsn, a URL or key=value connection string, with the
+// connections' search_path set to schema.
+func postgresSearchPath(dsn, schema string) string {
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	return dsn + " search_path=" + schema
+}
+
+// postgresForeignKey reports whether err is a foreign key violation, of an
+// article not stored.
+func postgresForeignKey(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+func (s *postgresStorage) GetArticle(key string) (*article, error) {
+	var data []byte
+
+	err := s.db.QueryRow(`SELECT data FROM articles WHERE key = $1`, key).Scan(&data)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var art article
+	if err := json.Unmarshal(uncompress(data), &art); err != nil {
+		return nil, err
+	}
+
+	return &art, nil
+}
+
+// searchText is the text of art searched besides its title, with the
+// reader's notes.
+func searchText(art *article) string {
+	return htmlText(art.Content) + "\n" + art.Notes
+}
+
+func (s *postgresStorage) SetArticle(key string, art *article) error {
+	data, err := json.Marshal(art)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano()
+	_, err = s.db.Exec(`INSERT INTO articles (key, url, data, updated_at, viewed_at, search)
+		VALUES ($3, $4, $5, $6, $6, `+postgresSearch+`)
+		ON CONFLICT (key) DO UPDATE SET url = excluded.url, data = excluded.data,
+			updated_at = excluded.updated_at, viewed_at = excluded.viewed_at, search = excluded.search`,
+		art.Title, searchText(art), key, art.URL, compress(data), now)
+
+	return err
+}
+
+func (s *postgresStorage) UpdateArticle(key string, fn func(art *article)) error {
+	art, err := s.GetArticle(key)
+	if err != nil {
+		return err
+	}
+	if art == nil {
+		return errArticleNotFound
+	}
+
+	fn(art)
+
+	data, err := json.Marshal(art)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`UPDATE articles SET data = $3, search = `+postgresSearch+` WHERE key = $4`,
+		art.Title, searchText(art), compress(data), key)
+	return err
+}
+
+// DeleteArticle removes the article, what references it going with it,
+// and its aliases and Wallabag ID.
+func (s *postgresStorage) DeleteArticle(key string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM articles WHERE key = $1`,
+		`DELETE FROM aliases WHERE key = $1`,
+		`DELETE FROM wallabag_ids WHERE key = $1`,
+	} {
+		if _, err := tx.Exec(query, key); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *postgresStorage) IncrViewCount(key string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE articles SET views = views + 1, viewed_at = $1 WHERE key = $2`, time.Now().UnixNano(), key)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	today := viewDay(time.Now())
+	if _, err := tx.Exec(`INSERT INTO views (key, day, views) VALUES ($1, $2, 1)
+		ON CONFLICT (key, day) DO UPDATE SET views = views.views + 1`, key, today); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM views WHERE day < $1`, today-viewRetentionDays); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *postgresStorage) ViewCount(key string) (int64, error) {
+	var views int64
+
+	err := s.db.QueryRow(`SELECT views FROM articles WHERE key = $1`, key).Scan(&views)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return views, err
+}
+
+func (s *postgresStorage) SetViewCount(key string, views int64) error {
+	_, err := s.db.Exec(`UPDATE articles SET views = $1 WHERE key = $2`, views, key)
+	return err
+}
+
+func (s *postgresStorage) TrendingArticles(n int, weights []float64) ([]string, error) {
+	if len(weights) == 0 {
+		return s.queryKeys(`SELECT key FROM articles WHERE views > 0 ORDER BY views DESC, key LIMIT $1`, n)
+	}
+
+	today := viewDay(time.Now())
+	rows, err := s.db.Query(`SELECT key, day, views FROM views WHERE day > $1`, today-int64(len(weights)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := make(map[string]float64)
+	for rows.Next() {
+		var key string
+		var day, views int64
+		if err := rows.Scan(&key, &day, &views); err != nil {
+			return nil, err
+		}
+		if age := today - day; age >= 0 {
+			scores[key] += float64(views) * weights[age]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return topScores(scores, n), nil
+}
+
+// SearchArticles returns up to n articles matching every word of q by
+// their search column, best match first, but for the private ones when
+// public is set.
+func (s *postgresStorage) SearchArticles(q string, n int, public bool) ([]searchResult, error) {
+	rows, err := s.db.Query(`SELECT a.data FROM articles a, plainto_tsquery('simple', $1) q
+		WHERE a.search @@ q AND NOT ($2 AND EXISTS (SELECT 1 FROM private p WHERE p.key = a.key))
+		ORDER BY ts_rank(a.search, q) DESC, a.url LIMIT $3`, q, public, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []searchResult
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var art article
+		if err := json.Unmarshal(uncompress(data), &art); err != nil {
+			return nil, err
+		}
+		results = append(results, searchResult{
+			URL:     art.URL,
+			Title:   art.Title,
+			Excerpt: excerpt(htmlText(art.Content), 200),
+			Starred: art.Starred,
+			private: art.Private,
+		})
+	}
+
+	return results, rows.Err()
+}
+
+func (s *postgresStorage) SetAlias(alias, key string) error {
+	_, err := s.db.Exec(`INSERT INTO aliases (alias, key) VALUES ($1, $2)
+		ON CONFLICT (alias) DO UPDATE SET key = excluded.key`, alias, key)
+	return err
+}
+
+func (s *postgresStorage) Alias(alias string) (string, error) {
+	var key string
+
+	err := s.db.QueryRow(`SELECT key FROM aliases WHERE alias = $1`, alias).Scan(&key)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return key, err
+}
+
+func (s *postgresStorage) Aliases() (map[string]string, error) {
+	rows, err := s.db.Query(`SELECT alias, key FROM aliases`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := make(map[string]string)
+	for rows.Next() {
+		var alias, key string
+		if err := rows.Scan(&alias, &key); err != nil {
+			return nil, err
+		}
+		aliases[alias] = key
+	}
+
+	return aliases, rows.Err()
+}
+
+func (s *postgresStorage) SetSlug(slug, key string) error {
+	_, err := s.db.Exec(`INSERT INTO slugs (slug, key) VALUES ($1, $2)
+		ON CONFLICT (slug) DO UPDATE SET key = excluded.key`, slug, key)
+	if postgresForeignKey(err) {
+		return errArticleNotFound
+	}
+
+	return err
+}
+
+func (s *postgresStorage) SlugKey(slug string) (string, error) {
+	var key string
+
+	err := s.db.QueryRow(`SELECT key FROM slugs WHERE slug = $1`, slug).Scan(&key)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return key, err
+}
+
+func (s *postgresStorage) WallabagID(key string) (int, error) {
+	if _, err := s.db.Exec(`INSERT INTO wallabag_ids (key) VALUES ($1) ON CONFLICT (key) DO NOTHING`, key); err != nil {
+		return 0, err
+	}
+
+	var id int
+	err := s.db.QueryRow(`SELECT id FROM wallabag_ids WHERE key = $1`, key).Scan(&id)
+	return id, err
+}
+
+func (s *postgresStorage) WallabagKey(id int) (string, error) {
+	var key string
+
+	err := s.db.QueryRow(`SELECT key FROM wallabag_ids WHERE id = $1`, id).Scan(&key)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return key, err
+}
+
+func (s *postgresStorage) SetImage(hash string, img *cachedImage) error {
+	var fetchedAt int64
+	if !img.FetchedAt.IsZero() {
+		fetchedAt = img.FetchedAt.Unix()
+	}
+
+	_, err := s.db.Exec(`INSERT INTO images (hash, url, content_type, data, fetched_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (hash) DO UPDATE SET url = excluded.url, content_type = excluded.content_type,
+			data = excluded.data, fetched_at = excluded.fetched_at`,
+		hash, img.URL, img.ContentType, img.Data, fetchedAt)
+	return err
+}
+
+func (s *postgresStorage) GetImage(hash string) (*cachedImage, error) {
+	var img cachedImage
+	var fetchedAt int64
+
+	err := s.db.QueryRow(`SELECT url, content_type, data, fetched_at FROM images WHERE hash = $1`, hash).
+		Scan(&img.URL, &img.ContentType, &img.Data, &fetchedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fetchedAt > 0 {
+		img.FetchedAt = time.Unix(fetchedAt, 0)
+	}
+
+	return &img, nil
+}
+
+func (s *postgresStorage) SetRaw(key string, data []byte) error {
+	_, err := s.db.Exec(`INSERT INTO raw (key, html) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET html = excluded.html`, key, data)
+	if postgresForeignKey(err) {
+		return errArticleNotFound
+	}
+
+	return err
+}
+
+func (s *postgresStorage) Raw(key string) ([]byte, error) {
+	var data []byte
+
+	err := s.db.QueryRow(`SELECT html FROM raw WHERE key = $1`, key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+func (s *postgresStorage) SetTranslation(key string, tr *translation) error {
+	data, err := json.Marshal(tr)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`INSERT INTO translations (key, lang, data) VALUES ($1, $2, $3)
+		ON CONFLICT (key, lang) DO UPDATE SET data = excluded.data`, key, tr.Lang, compress(data))
+	if postgresForeignKey(err) {
+		return errArticleNotFound
+	}
+
+	return err
+}
+
+func (s *postgresStorage) Translation(key, lang string) (*translation, error) {
+	var data []byte
+
+	err := s.db.QueryRow(`SELECT data FROM translations WHERE key = $1 AND lang = $2`, key, lang).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tr translation
+	if err := json.Unmarshal(uncompress(data), &tr); err != nil {
+		return nil, err
+	}
+
+	return &tr, nil
+}
+
+func (s *postgresStorage) SetAudio(key string, data []byte) error {
+	_, err := s.db.Exec(`INSERT INTO audio (key, data) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET data = excluded.data`, key, data)
+	if postgresForeignKey(err) {
+		return errArticleNotFound
+	}
+
+	return err
+}
+
+func (s *postgresStorage) Audio(key string) ([]byte, error) {
+	var data []byte
+
+	err := s.db.QueryRow(`SELECT data FROM audio WHERE key = $1`, key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+func (s *postgresStorage) SetCredential(domain string, sealed []byte) error {
+	_, err := s.db.Exec(`INSERT INTO credentials (domain, sealed) VALUES ($1, $2)
+		ON CONFLICT (domain) DO UPDATE SET sealed = excluded.sealed`, domain, sealed)
+	return err
+}
+
+func (s *postgresStorage) DeleteCredential(domain string) error {
+	_, err := s.db.Exec(`DELETE FROM credentials WHERE domain = $1`, domain)
+	return err
+}
+
+func (s *postgresStorage) Credentials() (map[string][]byte, error) {
+	rows, err := s.db.Query(`SELECT domain, sealed FROM credentials`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	creds := make(map[string][]byte)
+	for rows.Next() {
+		var domain string
+		var sealed []byte
+		if err := rows.Scan(&domain, &sealed); err != nil {
+			return nil, err
+		}
+		creds[domain] = sealed
+	}
+
+	return creds, rows.Err()
+}
+
+func (s *postgresStorage) SetAPIToken(tok *apiToken) error {
+	_, err := s.db.Exec(`INSERT INTO api_tokens (id, name, hash, created_at, rate, quota, "user") VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, hash = excluded.hash, created_at = excluded.created_at,
+			rate = excluded.rate, quota = excluded.quota, "user" = excluded."user"`,
+		tok.ID, tok.Name, tok.Hash, tok.CreatedAt.UnixNano(), tok.Rate, tok.Quota, tok.User)
+	return err
+}
+
+func (s *postgresStorage) DeleteAPIToken(id string) error {
+	_, err := s.db.Exec(`DELETE FROM api_tokens WHERE id = $1`, id)
+	return err
+}
+
+func (s *postgresStorage) APITokens() ([]*apiToken, error) {
+	rows, err := s.db.Query(`SELECT id, name, hash, created_at, rate, quota, "user" FROM api_tokens`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*apiToken
+	for rows.Next() {
+		var tok apiToken
+		var created int64
+		if err := rows.Scan(&tok.ID, &tok.Name, &tok.Hash, &created, &tok.Rate, &tok.Quota, &tok.User); err != nil {
+			return nil, err
+		}
+		tok.CreatedAt = time.Unix(0, created)
+		tokens = append(tokens, &tok)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (s *postgresStorage) IncrTokenUsage(id, day string) (int64, error) {
+	var n int64
+	err := s.db.QueryRow(`INSERT INTO token_usage (id, day, count) VALUES ($1, $2, 1)
+		ON CONFLICT (id, day) DO UPDATE SET count = token_usage.count + 1 RETURNING count`, id, day).Scan(&n)
+	return n, err
+}
+
+func (s *postgresStorage) TokenUsage(id string) (map[string]int64, error) {
+	rows, err := s.db.Query(`SELECT day, count FROM token_usage WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := make(map[string]int64)
+	for rows.Next() {
+		var day string
+		var n int64
+		if err := rows.Scan(&day, &n); err != nil {
+			return nil, err
+		}
+		usage[day] = n
+	}
+
+	return usage, rows.Err()
+}
+
+func (s *postgresStorage) SetUser(u *user) error {
+	_, err := s.db.Exec(`INSERT INTO users (name, id, password_hash, created_at, display_name) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (name) DO UPDATE SET id = excluded.id, password_hash = excluded.password_hash,
+			created_at = excluded.created_at, display_name = excluded.display_name`,
+		u.Name, u.ID, u.PasswordHash, u.CreatedAt.UnixNano(), u.DisplayName)
+	return err
+}
+
+func (s *postgresStorage) GetUser(name string) (*user, error) {
+	u := user{Name: name}
+	var created int64
+
+	err := s.db.QueryRow(`SELECT id, password_hash, created_at, display_name FROM users WHERE name = $1`, name).
+		Scan(&u.ID, &u.PasswordHash, &created, &u.DisplayName)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.CreatedAt = time.Unix(0, created)
+
+	return &u, nil
+}
+
+func (s *postgresStorage) Users() ([]*user, error) {
+	rows, err := s.db.Query(`SELECT name, id, password_hash, created_at, display_name FROM users`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*user
+	for rows.Next() {
+		var u user
+		var created int64
+		if err := rows.Scan(&u.Name, &u.ID, &u.PasswordHash, &created, &u.DisplayName); err != nil {
+			return nil, err
+		}
+		u.CreatedAt = time.Unix(0, created)
+		users = append(users, &u)
+	}
+
+	return users, rows.Err()
+}
+
+func (s *postgresStorage) SetSnapshot(snap *snapshot) error {
+	_, err := s.db.Exec(`INSERT INTO snapshots (id, key, url, title, html, created_at) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO UPDATE SET key = excluded.key, url = excluded.url, title = excluded.title,
+			html = excluded.html, created_at = excluded.created_at`,
+		snap.ID, snap.Key, snap.URL, snap.Title, compress(snap.HTML), snap.CreatedAt.UnixNano())
+	return err
+}
+
+func (s *postgresStorage) GetSnapshot(id string) (*snapshot, error) {
+	snap := snapshot{ID: id}
+	var data []byte
+	var createdAt int64
+
+	err := s.db.QueryRow(`SELECT key, url, title, html, created_at FROM snapshots WHERE id = $1`, id).
+		Scan(&snap.Key, &snap.URL, &snap.Title, &data, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snap.HTML = uncompress(data)
+	snap.CreatedAt = time.Unix(0, createdAt)
+
+	return &snap, nil
+}
+
+func (s *postgresStorage) Snapshots(key string) ([]string, error) {
+	return s.queryKeys(`SELECT id FROM snapshots WHERE key = $1 ORDER BY created_at DESC`, key)
+}
+
+// setFlag rewrites the article stored under key with fn, and adds it to
+// or removes it from table, in a transaction.
+func (s *postgresStorage) setFlag(key, table string, on bool, fn func(art *article)) error {
+	art, err := s.GetArticle(key)
+	if err != nil {
+		return err
+	}
+	if art == nil {
+		return errArticleNotFound
+	}
+
+	fn(art)
+	data, err := json.Marshal(art)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE articles SET data = $1 WHERE key = $2`, compress(data), key); err != nil {
+		return err
+	}
+	switch {
+	case !on:
+		_, err = tx.Exec(`DELETE FROM `+table+` WHERE key = $1`, key)
+	case table == "private":
+		_, err = tx.Exec(`INSERT INTO private (key) VALUES ($1) ON CONFLICT DO NOTHING`, key)
+	default:
+		_, err = tx.Exec(`INSERT INTO `+table+` (key, `+table+`_at) VALUES ($1, $2) ON CONFLICT DO NOTHING`, key, time.Now().UnixNano())
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *postgresStorage) SetWatched(key string, watched bool) error {
+	return s.setFlag(key, "watched", watched, func(art *article) { art.Watched = watched })
+}
+
+func (s *postgresStorage) WatchedArticles() ([]string, error) {
+	return s.queryKeys(`SELECT key FROM watched ORDER BY watched_at DESC`)
+}
+
+func (s *postgresStorage) SetPrivate(key string, private bool) error {
+	return s.setFlag(key, "private", private, func(art *article) { art.Private = private })
+}
+
+func (s *postgresStorage) PrivateArticles() ([]string, error) {
+	return s.queryKeys(`SELECT key FROM private ORDER BY key`)
+}
+
+func (s *postgresStorage) AddVersion(key string, v *articleVersion, keep int) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO versions (key, data, saved_at) VALUES ($1, $2, $3)`, key, compress(data), v.SavedAt.UnixNano())
+	if err != nil {
+		if postgresForeignKey(err) {
+			return errArticleNotFound
+		}
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM versions WHERE key = $1 AND id NOT IN
+		(SELECT id FROM versions WHERE key = $1 ORDER BY id DESC LIMIT $2)`, key, keep); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *postgresStorage) Versions(key string) ([]*articleVersion, error) {
+	rows, err := s.db.Query(`SELECT data FROM versions WHERE key = $1 ORDER BY id DESC`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []*articleVersion
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var v articleVersion
+		if err := json.Unmarshal(uncompress(data), &v); err != nil {
+			return nil, err
+		}
+		versions = append(versions, &v)
+	}
+
+	return versions, rows.Err()
+}
+
+func (s *postgresStorage) Usage() (int, int64, error) {
+	var count int
+	var size int64
+
+	err := s.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(LENGTH(data)), 0) FROM articles`).Scan(&count, &size)
+
+	return count, size, err
+}
+
+func (s *postgresStorage) LeastRecentlyViewed(n int) ([]string, error) {
+	return s.queryKeys(`SELECT key FROM articles ORDER BY viewed_at, updated_at LIMIT $1`, n)
+}
+
+func (s *postgresStorage) LastNArticles(n int) ([]string, error) {
+	return s.queryKeys(`SELECT key FROM articles ORDER BY updated_at DESC LIMIT $1`, n)
+}
+
+func (s *postgresStorage) Keys() ([]string, error) {
+	return s.queryKeys(`SELECT key FROM articles ORDER BY updated_at DESC`)
+}
+
+func (s *postgresStorage) queryKeys(query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		records = append(records, key)
+	}
+
+	return records, rows.Err()
+}
+
+func (s *postgresStorage) ArticleURL(key string) (string, error) {
+	var uri string
+
+	err := s.db.QueryRow(`SELECT url FROM articles WHERE key = $1`, key).Scan(&uri)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return uri, err
+}
+
+func (s *postgresStorage) SetTags(key string, tags []string) error {
+	art, err := s.GetArticle(key)
+	if err != nil {
+		return err
+	}
+	if art == nil {
+		return errArticleNotFound
+	}
+
+	art.Tags = tags
+	data, err := json.Marshal(art)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE articles SET data = $1 WHERE key = $2`, compress(data), key); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM tags WHERE key = $1`, key); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.Exec(`INSERT INTO tags (key, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING`, key, tag); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *postgresStorage) TaggedArticles(tag string) ([]string, error) {
+	return s.queryKeys(`SELECT t.key FROM tags t JOIN articles a ON a.key = t.key
+		WHERE t.tag = $1 ORDER BY a.updated_at DESC`, tag)
+}
+
+func (s *postgresStorage) Tags() (map[string]int, error) {
+	rows, err := s.db.Query(`SELECT tag, COUNT(*) FROM tags GROUP BY tag`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[string]int)
+	for rows.Next() {
+		var tag string
+		var n int
+		if err := rows.Scan(&tag, &n); err != nil {
+			return nil, err
+		}
+		tags[tag] = n
+	}
+
+	return tags, rows.Err()
+}
+
+func (s *postgresStorage) SetStarred(key string, starred bool) error {
+	return s.setFlag(key, "starred", starred, func(art *article) { art.Starred = starred })
+}
+
+func (s *postgresStorage) StarredArticles() ([]string, error) {
+	return s.queryKeys(`SELECT key FROM starred ORDER BY starred_at DESC`)
+}
+
+func (s *postgresStorage) Ping() error {
+	return s.db.Ping()
+}
+
+func (s *postgresStorage) Close() error {
+	return s.db.Close()
+}
+
+// postgresUserSchema is the schema of the library of the user id.
+func postgresUserSchema(id string) string {
+	return "readability_u_" + id
+}
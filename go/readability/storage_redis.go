@@ -0,0 +1,1096 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisRecents are the articles scored by when they were saved,
+	// redisTimeQueue the list they were pushed to before.
+	redisRecents   = "readability-recents"
+	redisTimeQueue = "readability-timequeue"
+	redisViewCount = "readability-viewcount"
+	redisTags      = "readability-tags"
+	redisTagPrefix = "readability-tag:"
+	redisStarred   = "readability-starred"
+	redisViewsDay  = "readability-views:"
+	redisTrending  = "readability-trending"
+	redisLastView  = "readability-lastview"
+	redisSizes     = "readability-sizes"
+	redisAliases   = "readability-aliases"
+	redisURLs      = "readability-urls"
+	redisSlugs     = "readability-slugs"
+	redisImage     = "readability-img:"
+	redisSnapshot  = "readability-snapshot:"
+	redisRaw       = "readability-raw:"
+	redisTrans     = "readability-translations:"
+	redisAudio     = "readability-audio:"
+	redisCreds     = "readability-credentials"
+	redisTokens    = "readability-tokens"
+	redisUsers     = "readability-users"
+	redisUsage     = "readability-usage:"
+	redisSnapshots = "readability-snapshots:"
+	redisWatched   = "readability-watched"
+	redisPrivate   = "readability-private"
+	redisVersions  = "readability-versions:"
+
+	// redisWallabagIDs holds the Wallabag API ID of each key and
+	// redisWallabagKeys the key of each ID, redisWallabagSeq the last ID
+	// given.
+	redisWallabagIDs  = "readability-wallabag-ids"
+	redisWallabagKeys = "readability-wallabag-keys"
+	redisWallabagSeq  = "readability-wallabag-seq"
+
+	// redisAliasesOf holds the aliases of a key, removed with its article.
+	redisAliasesOf = "readability-aliases-of:"
+)
+
+var (
+	// REDIS_ADDRS are the host:port addresses connected to instead of
+	// REDIS_URL's: of the Sentinels with REDIS_MASTER_NAME, else of the
+	// nodes of a Cluster when there are several or with REDIS_CLUSTER=true.
+	REDIS_ADDRS       = splitList(envOr("REDIS_ADDRS", ""))
+	REDIS_MASTER_NAME = envOr("REDIS_MASTER_NAME", "")
+	REDIS_CLUSTER     = envOr("REDIS_CLUSTER", "") == "true"
+	// REDIS_USERNAME, REDIS_PASSWORD and REDIS_DB replace those of
+	// REDIS_URL, REDIS_SENTINEL_PASSWORD is the Sentinels' own.
+	REDIS_USERNAME          = envOr("REDIS_USERNAME", "")
+	REDIS_PASSWORD          = envOr("REDIS_PASSWORD", "")
+	REDIS_DB                = envInt("REDIS_DB", 0)
+	REDIS_SENTINEL_USERNAME = envOr("REDIS_SENTINEL_USERNAME", "")
+	REDIS_SENTINEL_PASSWORD = envOr("REDIS_SENTINEL_PASSWORD", "")
+	// REDIS_TLS=true connects over TLS, as a rediss:// URL does, checking
+	// the server's certificate against REDIS_TLS_CA when set, the system's
+	// roots otherwise, and presenting REDIS_TLS_CERT and REDIS_TLS_KEY to
+	// servers asking for a client certificate.
+	REDIS_TLS             = envOr("REDIS_TLS", "") == "true"
+	REDIS_TLS_CA          = envOr("REDIS_TLS_CA", "")
+	REDIS_TLS_CERT        = envOr("REDIS_TLS_CERT", "")
+	REDIS_TLS_KEY         = envOr("REDIS_TLS_KEY", "")
+	REDIS_TLS_SERVER_NAME = envOr("REDIS_TLS_SERVER_NAME", "")
+	REDIS_TLS_INSECURE    = envOr("REDIS_TLS_INSECURE", "") == "true"
+
+	// RECENTS_MAX is how many articles the recents keep, 0 keeps them all.
+	// The articles left out are still stored, and listed after the others.
+	RECENTS_MAX = envInt("RECENTS_MAX", 0)
+)
+
+type redisStorage struct {
+	client redis.UniversalClient
+	// ctx is the context of the commands, see library.withContext.
+	ctx context.Context
+	// ns prefixes the keys of a user's library, see newUserStorage.
+	ns string
+	// cluster is set when client is a Cluster's.
+	cluster bool
+}
+
+// k is the redis key of name in the storage's namespace. In a Cluster the
+// namespace is the keys' hash tag, so that the keys of a library are on
+// the same node, for its transactions, and libraries spread over them.
+func (s *redisStorage) k(name string) string {
+	if s.cluster {
+		ns := s.ns
+		if ns == "" {
+			ns = "readability-"
+		}
+		return "{" + ns + "}" + strings.TrimPrefix(name, "readability-")
+	}
+	if s.ns == "" {
+		return name
+	}
+
+	return s.ns + strings.TrimPrefix(name, "readability-")
+}
+
+// redisConfigured reports whether a Redis server is set up.
+func redisConfigured() bool {
+	return REDIS_URL != "" || len(REDIS_ADDRS) > 0
+}
+
+func newRedisStorage() (*redisStorage, error) {
+	opt, err := redisOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	return newRedisStorageWith(opt)
+}
+
+// newRedisStorageWith connects to the Redis server, Sentinels or Cluster
+// of opt.
+func newRedisStorageWith(opt *redis.UniversalOptions) (*redisStorage, error) {
+
+	s := &redisStorage{ctx: context.Background()}
+	switch {
+	case opt.MasterName != "":
+		s.client = redis.NewFailoverClient(opt.Failover())
+	case len(opt.Addrs) > 1 || REDIS_CLUSTER:
+		s.client = redis.NewClusterClient(opt.Cluster())
+		s.cluster = true
+	default:
+		s.client = redis.NewClient(opt.Simple())
+	}
+	s.client.AddHook(redisMetrics{})
+	if tracingEnabled {
+		s.client.AddHook(redisTracing{})
+	}
+
+	if err := s.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis %s: %w", strings.Join(opt.Addrs, ","), err)
+	}
+	if err := s.migrateRecents(); err != nil {
+		return nil, fmt.Errorf("failed to migrate the recents: %w", err)
+	}
+	if err := s.trackUsage(); err != nil {
+		return nil, fmt.Errorf("failed to index cached articles: %w", err)
+	}
+
+	return s, nil
+}
+
+// redisOptions are the options of the client, from REDIS_URL and the other
+// REDIS_ settings.
+func redisOptions() (*redis.UniversalOptions, error) {
+	opt := &redis.UniversalOptions{
+		Addrs:            REDIS_ADDRS,
+		MasterName:       REDIS_MASTER_NAME,
+		SentinelUsername: REDIS_SENTINEL_USERNAME,
+		SentinelPassword: REDIS_SENTINEL_PASSWORD,
+	}
+	if REDIS_URL != "" {
+		u, err := redis.ParseURL(REDIS_URL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse redis url: %w", err)
+		}
+		if len(opt.Addrs) == 0 {
+			opt.Addrs = []string{u.Addr}
+		}
+		opt.Username, opt.Password, opt.DB, opt.TLSConfig = u.Username, u.Password, u.DB, u.TLSConfig
+	}
+	if REDIS_USERNAME != "" {
+		opt.Username = REDIS_USERNAME
+	}
+	if REDIS_PASSWORD != "" {
+		opt.Password = REDIS_PASSWORD
+	}
+	if REDIS_DB != 0 {
+		opt.DB = REDIS_DB
+	}
+
+	if REDIS_TLS || REDIS_TLS_CA != "" || REDIS_TLS_CERT != "" {
+		if opt.TLSConfig == nil {
+			opt.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		if REDIS_TLS_SERVER_NAME != "" {
+			opt.TLSConfig.ServerName = REDIS_TLS_SERVER_NAME
+		}
+		opt.TLSConfig.InsecureSkipVerify = REDIS_TLS_INSECURE
+		if REDIS_TLS_CA != "" {
+			pem, err := os.ReadFile(REDIS_TLS_CA)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read REDIS_TLS_CA: %w", err)
+			}
+			opt.TLSConfig.RootCAs = x509.NewCertPool()
+			if !opt.TLSConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate in REDIS_TLS_CA %s", REDIS_TLS_CA)
+			}
+		}
+		if REDIS_TLS_CERT != "" {
+			cert, err := tls.LoadX509KeyPair(REDIS_TLS_CERT, REDIS_TLS_KEY)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load the redis client certificate: %w", err)
+			}
+			opt.TLSConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	return opt, nil
+}
+
+// migrateRecents moves the recents from the list they were kept in to
+// their sorted set, newest first as they were, without their duplicates.
+func (s *redisStorage) migrateRecents() error {
+	keys, err := s.client.LRange(s.ctx, s.k(redisTimeQueue), 0, -1).Result()
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+
+	now := time.Now().UnixMilli()
+	_, err = s.client.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			// The first of duplicates is the latest.
+			pipe.ZAddNX(s.ctx, s.k(redisRecents), redis.Z{Score: float64(now - int64(i)), Member: key})
+		}
+		pipe.Del(s.ctx, s.k(redisTimeQueue))
+		return nil
+	})
+	if err == nil {
+		slog.Info("migrated the recents to a sorted set", "articles", len(keys), "namespace", s.ns)
+	}
+
+	return err
+}
+
+// trackUsage adds articles cached before eviction existed to the last view
+// and size indexes.
+func (s *redisStorage) trackUsage() error {
+	keys, err := s.Keys()
+	if err != nil {
+		return err
+	}
+
+	tracked, err := s.client.HKeys(s.ctx, s.k(redisSizes)).Result()
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(tracked))
+	for _, key := range tracked {
+		known[key] = true
+	}
+
+	sizes := make(map[string]*redis.IntCmd)
+	_, err = s.client.Pipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			if !known[key] {
+				sizes[key] = pipe.StrLen(s.ctx, s.k(key))
+			}
+		}
+		return nil
+	})
+	if err != nil || len(sizes) == 0 {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		for key, size := range sizes {
+			pipe.HSet(s.ctx, s.k(redisSizes), key, size.Val())
+			pipe.ZAddNX(s.ctx, s.k(redisLastView), redis.Z{Score: 0, Member: key})
+		}
+		return nil
+	})
+
+	return err
+}
+
+// redisTTL lets redis drop the article itself once it expires, the janitor
+// then cleans up the lists and sets still pointing at it.
+func redisTTL(art *article) time.Duration {
+	if art.ExpiresAt.IsZero() {
+		return 0
+	}
+	if ttl := time.Until(art.ExpiresAt); ttl > time.Second {
+		return ttl
+	}
+
+	return time.Second
+}
+
+func (s *redisStorage) GetArticle(key string) (*article, error) {
+	var data []byte
+
+	if err := s.client.Get(s.ctx, s.k(key)).Scan(&data); err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var art article
+	if err := json.Unmarshal(uncompress(data), &art); err != nil {
+		return nil, err
+	}
+
+	return &art, nil
+}
+
+// SetArticle stores the article, moves it to the top of the recents and
+// indexes its URL, size and last view in a single transaction, so that a
+// failure leaves none of them half written.
+func (s *redisStorage) SetArticle(key string, art *article) error {
+	data, err := json.Marshal(art)
+	if err != nil {
+		return err
+	}
+
+	data = compress(data)
+
+	_, err = s.client.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(s.ctx, s.k(key), data, redisTTL(art))
+		// A refreshed article moves to the top instead of showing up twice.
+		pipe.ZAdd(s.ctx, s.k(redisRecents), redis.Z{Score: float64(time.Now().UnixMilli()), Member: key})
+		if RECENTS_MAX > 0 {
+			pipe.ZRemRangeByRank(s.ctx, s.k(redisRecents), 0, int64(-RECENTS_MAX-1))
+		}
+		pipe.HSet(s.ctx, s.k(redisURLs), key, art.URL)
+		pipe.HSet(s.ctx, s.k(redisSizes), key, len(data))
+		pipe.ZAdd(s.ctx, s.k(redisLastView), redis.Z{Score: float64(time.Now().Unix()), Member: key})
+		return nil
+	})
+
+	return err
+}
+
+func (s *redisStorage) UpdateArticle(key string, fn func(art *article)) error {
+	return s.watchArticle(key, func(pipe redis.Pipeliner, art *article) error {
+		fn(art)
+		return s.putArticle(pipe, key, art)
+	})
+}
+
+// watchArticle reads the article stored under key and has fn queue what
+// changes with it in a transaction, retried if the article changes while
+// it is being read, as DeleteArticle does.
+func (s *redisStorage) watchArticle(key string, fn func(pipe redis.Pipeliner, art *article) error) error {
+	var err error
+	for i := 0; i < 3; i++ {
+		if err = s.client.Watch(s.ctx, func(tx *redis.Tx) error {
+			data, err := tx.Get(s.ctx, s.k(key)).Bytes()
+			if err == redis.Nil {
+				return errArticleNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			var art article
+			if err := json.Unmarshal(uncompress(data), &art); err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+				return fn(pipe, &art)
+			})
+			return err
+		}, s.k(key)); err != redis.TxFailedErr {
+			return err
+		}
+	}
+
+	return err
+}
+
+// putArticle queues storing the article under key again, as it expires.
+func (s *redisStorage) putArticle(pipe redis.Pipeliner, key string, art *article) error {
+	data, err := json.Marshal(art)
+	if err != nil {
+		return err
+	}
+	pipe.Set(s.ctx, s.k(key), compress(data), redisTTL(art))
+
+	return nil
+}
+
+// DeleteArticle removes the article, its place in the recents, its views,
+// tags, star, aliases and Wallabag ID in a single transaction, retried if the article changes
+// while it is being read.
+func (s *redisStorage) DeleteArticle(key string) error {
+	var err error
+	for i := 0; i < 3; i++ {
+		if err = s.client.Watch(s.ctx, func(tx *redis.Tx) error {
+			return s.deleteArticle(tx, key)
+		}, s.k(key)); err != redis.TxFailedErr {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (s *redisStorage) deleteArticle(tx *redis.Tx, key string) error {
+	var art article
+
+	data, err := tx.Get(s.ctx, s.k(key)).Bytes()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(uncompress(data), &art); err != nil {
+			return err
+		}
+	}
+	aliases, err := tx.SMembers(s.ctx, s.k(redisAliasesOf)+key).Result()
+	if err != nil {
+		return err
+	}
+	wallabagID, err := tx.HGet(s.ctx, s.k(redisWallabagIDs), key).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	_, err = tx.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		for _, tag := range art.Tags {
+			pipe.SRem(s.ctx, s.k(redisTagPrefix)+tag, key)
+		}
+
+		pipe.Del(s.ctx, s.k(key))
+		pipe.Del(s.ctx, s.k(redisRaw)+key)
+		pipe.Del(s.ctx, s.k(redisTrans)+key)
+		pipe.Del(s.ctx, s.k(redisAudio)+key)
+		pipe.Del(s.ctx, s.k(redisVersions)+key)
+		pipe.ZRem(s.ctx, s.k(redisWatched), key)
+		pipe.SRem(s.ctx, s.k(redisPrivate), key)
+		pipe.ZRem(s.ctx, s.k(redisRecents), key)
+		pipe.ZRem(s.ctx, s.k(redisViewCount), key)
+		pipe.ZRem(s.ctx, s.k(redisStarred), key)
+		pipe.ZRem(s.ctx, s.k(redisLastView), key)
+		pipe.HDel(s.ctx, s.k(redisSizes), key)
+		pipe.HDel(s.ctx, s.k(redisURLs), key)
+		if art.Slug != "" {
+			pipe.HDel(s.ctx, s.k(redisSlugs), art.Slug)
+		}
+		if len(aliases) > 0 {
+			pipe.HDel(s.ctx, s.k(redisAliases), aliases...)
+		}
+		pipe.Del(s.ctx, s.k(redisAliasesOf)+key)
+		if wallabagID != "" {
+			pipe.HDel(s.ctx, s.k(redisWallabagIDs), key)
+			pipe.HDel(s.ctx, s.k(redisWallabagKeys), wallabagID)
+		}
+
+		today := viewDay(time.Now())
+		for i := int64(0); i <= viewRetentionDays; i++ {
+			pipe.ZRem(s.ctx, s.viewsDayKey(today-i), key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		slog.Error("failed to delete article from redis", "key", key, "err", err)
+	}
+
+	return err
+}
+
+func (s *redisStorage) IncrViewCount(key string) error {
+	day := s.viewsDayKey(viewDay(time.Now()))
+
+	_, err := s.client.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZIncrBy(s.ctx, s.k(redisViewCount), 1, key)
+		pipe.ZAdd(s.ctx, s.k(redisLastView), redis.Z{Score: float64(time.Now().Unix()), Member: key})
+		pipe.ZIncrBy(s.ctx, day, 1, key)
+		pipe.Expire(s.ctx, day, (viewRetentionDays+1)*24*time.Hour)
+		return nil
+	})
+
+	return err
+}
+
+func (s *redisStorage) viewsDayKey(day int64) string {
+	return fmt.Sprintf("%s%d", s.k(redisViewsDay), day)
+}
+
+func (s *redisStorage) ViewCount(key string) (int64, error) {
+	views, err := s.client.ZScore(s.ctx, s.k(redisViewCount), key).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+
+	return int64(views), err
+}
+
+func (s *redisStorage) SetViewCount(key string, views int64) error {
+	return s.client.ZAdd(s.ctx, s.k(redisViewCount), redis.Z{Score: float64(views), Member: key}).Err()
+}
+
+func (s *redisStorage) TrendingArticles(n int, weights []float64) ([]string, error) {
+	if len(weights) == 0 {
+		return s.client.ZRevRange(s.ctx, s.k(redisViewCount), 0, int64(n-1)).Result()
+	}
+
+	today := viewDay(time.Now())
+	days := make([]string, len(weights))
+	for i := range weights {
+		days[i] = s.viewsDayKey(today - int64(i))
+	}
+
+	// Build the weighted union, read it and drop it in one transaction so
+	// concurrent requests don't see each other's scratch key.
+	var top *redis.StringSliceCmd
+	_, err := s.client.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZUnionStore(s.ctx, s.k(redisTrending), &redis.ZStore{Keys: days, Weights: weights})
+		top = pipe.ZRevRange(s.ctx, s.k(redisTrending), 0, int64(n-1))
+		pipe.Del(s.ctx, s.k(redisTrending))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return top.Val(), nil
+}
+
+// SetAlias points alias at key, moving it from the aliases of the key it
+// pointed at before.
+func (s *redisStorage) SetAlias(alias, key string) error {
+	old, err := s.client.HGet(s.ctx, s.k(redisAliases), alias).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(s.ctx, s.k(redisAliases), alias, key)
+		if old != "" && old != key {
+			pipe.SRem(s.ctx, s.k(redisAliasesOf)+old, alias)
+		}
+		pipe.SAdd(s.ctx, s.k(redisAliasesOf)+key, alias)
+		return nil
+	})
+
+	return err
+}
+
+func (s *redisStorage) Alias(alias string) (string, error) {
+	key, err := s.client.HGet(s.ctx, s.k(redisAliases), alias).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+
+	return key, err
+}
+
+func (s *redisStorage) Aliases() (map[string]string, error) {
+	return s.client.HGetAll(s.ctx, s.k(redisAliases)).Result()
+}
+
+func (s *redisStorage) SetSlug(slug, key string) error {
+	return s.client.HSet(s.ctx, s.k(redisSlugs), slug, key).Err()
+}
+
+func (s *redisStorage) SlugKey(slug string) (string, error) {
+	key, err := s.client.HGet(s.ctx, s.k(redisSlugs), slug).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+
+	return key, err
+}
+
+func (s *redisStorage) WallabagID(key string) (int, error) {
+	id, err := s.client.HGet(s.ctx, s.k(redisWallabagIDs), key).Int()
+	if err != redis.Nil {
+		return id, err
+	}
+
+	next, err := s.client.Incr(s.ctx, s.k(redisWallabagSeq)).Result()
+	if err != nil {
+		return 0, err
+	}
+	// Given one at the same time elsewhere, that one is kept.
+	set, err := s.client.HSetNX(s.ctx, s.k(redisWallabagIDs), key, next).Result()
+	if err != nil {
+		return 0, err
+	}
+	if !set {
+		return s.client.HGet(s.ctx, s.k(redisWallabagIDs), key).Int()
+	}
+
+	return int(next), s.client.HSet(s.ctx, s.k(redisWallabagKeys), strconv.FormatInt(next, 10), key).Err()
+}
+
+func (s *redisStorage) WallabagKey(id int) (string, error) {
+	key, err := s.client.HGet(s.ctx, s.k(redisWallabagKeys), strconv.Itoa(id)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+
+	return key, err
+}
+
+func (s *redisStorage) SetImage(hash string, img *cachedImage) error {
+	data, err := json.Marshal(img)
+	if err != nil {
+		return err
+	}
+
+	return s.client.Set(s.ctx, s.k(redisImage)+hash, data, 0).Err()
+}
+
+func (s *redisStorage) GetImage(hash string) (*cachedImage, error) {
+	data, err := s.client.Get(s.ctx, s.k(redisImage)+hash).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var img cachedImage
+	if err := json.Unmarshal(data, &img); err != nil {
+		return nil, err
+	}
+
+	return &img, nil
+}
+
+// SetRaw expires the page with its article.
+func (s *redisStorage) SetRaw(key string, data []byte) error {
+	ttl, err := s.client.PTTL(s.ctx, s.k(key)).Result()
+	if err != nil {
+		return err
+	}
+	// PTTL is -2 for a missing key and -1 for one that doesn't expire.
+	if ttl == -2*time.Millisecond {
+		return errArticleNotFound
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	return s.client.Set(s.ctx, s.k(redisRaw)+key, data, ttl).Err()
+}
+
+func (s *redisStorage) Raw(key string) ([]byte, error) {
+	data, err := s.client.Get(s.ctx, s.k(redisRaw)+key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+// SetTranslation keeps the translations of an article in a hash by
+// language, expiring with the article.
+func (s *redisStorage) SetTranslation(key string, tr *translation) error {
+	data, err := json.Marshal(tr)
+	if err != nil {
+		return err
+	}
+
+	ttl, err := s.client.PTTL(s.ctx, s.k(key)).Result()
+	if err != nil {
+		return err
+	}
+	if ttl == -2*time.Millisecond {
+		return errArticleNotFound
+	}
+
+	_, err = s.client.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(s.ctx, s.k(redisTrans)+key, tr.Lang, compress(data))
+		if ttl > 0 {
+			pipe.PExpire(s.ctx, s.k(redisTrans)+key, ttl)
+		}
+		return nil
+	})
+
+	return err
+}
+
+func (s *redisStorage) Translation(key, lang string) (*translation, error) {
+	data, err := s.client.HGet(s.ctx, s.k(redisTrans)+key, lang).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tr translation
+	if err := json.Unmarshal(uncompress(data), &tr); err != nil {
+		return nil, err
+	}
+
+	return &tr, nil
+}
+
+// SetAudio expires the narration with its article.
+func (s *redisStorage) SetAudio(key string, data []byte) error {
+	ttl, err := s.client.PTTL(s.ctx, s.k(key)).Result()
+	if err != nil {
+		return err
+	}
+	if ttl == -2*time.Millisecond {
+		return errArticleNotFound
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	return s.client.Set(s.ctx, s.k(redisAudio)+key, data, ttl).Err()
+}
+
+func (s *redisStorage) Audio(key string) ([]byte, error) {
+	data, err := s.client.Get(s.ctx, s.k(redisAudio)+key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+func (s *redisStorage) SetCredential(domain string, sealed []byte) error {
+	return s.client.HSet(s.ctx, s.k(redisCreds), domain, sealed).Err()
+}
+
+func (s *redisStorage) DeleteCredential(domain string) error {
+	return s.client.HDel(s.ctx, s.k(redisCreds), domain).Err()
+}
+
+func (s *redisStorage) Credentials() (map[string][]byte, error) {
+	all, err := s.client.HGetAll(s.ctx, s.k(redisCreds)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	creds := make(map[string][]byte, len(all))
+	for domain, sealed := range all {
+		creds[domain] = []byte(sealed)
+	}
+
+	return creds, nil
+}
+
+func (s *redisStorage) SetAPIToken(tok *apiToken) error {
+	data, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+
+	return s.client.HSet(s.ctx, s.k(redisTokens), tok.ID, data).Err()
+}
+
+func (s *redisStorage) DeleteAPIToken(id string) error {
+	_, err := s.client.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(s.ctx, s.k(redisTokens), id)
+		pipe.Del(s.ctx, s.k(redisUsage)+id)
+		return nil
+	})
+	return err
+}
+
+func (s *redisStorage) APITokens() ([]*apiToken, error) {
+	all, err := s.client.HGetAll(s.ctx, s.k(redisTokens)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*apiToken, 0, len(all))
+	for _, data := range all {
+		var tok apiToken
+		if err := json.Unmarshal([]byte(data), &tok); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &tok)
+	}
+
+	return tokens, nil
+}
+
+func (s *redisStorage) IncrTokenUsage(id, day string) (int64, error) {
+	return s.client.HIncrBy(s.ctx, s.k(redisUsage)+id, day, 1).Result()
+}
+
+func (s *redisStorage) TokenUsage(id string) (map[string]int64, error) {
+	all, err := s.client.HGetAll(s.ctx, s.k(redisUsage)+id).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string]int64, len(all))
+	for day, n := range all {
+		usage[day], _ = strconv.ParseInt(n, 10, 64)
+	}
+
+	return usage, nil
+}
+
+func (s *redisStorage) SetUser(u *user) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	return s.client.HSet(s.ctx, s.k(redisUsers), u.Name, data).Err()
+}
+
+func (s *redisStorage) GetUser(name string) (*user, error) {
+	data, err := s.client.HGet(s.ctx, s.k(redisUsers), name).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var u user
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+func (s *redisStorage) Users() ([]*user, error) {
+	all, err := s.client.HGetAll(s.ctx, s.k(redisUsers)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*user, 0, len(all))
+	for _, data := range all {
+		var u user
+		if err := json.Unmarshal([]byte(data), &u); err != nil {
+			return nil, err
+		}
+		users = append(users, &u)
+	}
+
+	return users, nil
+}
+
+func (s *redisStorage) SetSnapshot(snap *snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(s.ctx, s.k(redisSnapshot)+snap.ID, compress(data), 0)
+		pipe.LRem(s.ctx, s.k(redisSnapshots)+snap.Key, 0, snap.ID)
+		pipe.LPush(s.ctx, s.k(redisSnapshots)+snap.Key, snap.ID)
+		return nil
+	})
+
+	return err
+}
+
+func (s *redisStorage) GetSnapshot(id string) (*snapshot, error) {
+	data, err := s.client.Get(s.ctx, s.k(redisSnapshot)+id).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(uncompress(data), &snap); err != nil {
+		return nil, err
+	}
+
+	return &snap, nil
+}
+
+func (s *redisStorage) Snapshots(key string) ([]string, error) {
+	return s.client.LRange(s.ctx, s.k(redisSnapshots)+key, 0, -1).Result()
+}
+
+func (s *redisStorage) SetWatched(key string, watched bool) error {
+	return s.watchArticle(key, func(pipe redis.Pipeliner, art *article) error {
+		art.Watched = watched
+		if err := s.putArticle(pipe, key, art); err != nil {
+			return err
+		}
+		if watched {
+			pipe.ZAdd(s.ctx, s.k(redisWatched), redis.Z{Score: float64(time.Now().Unix()), Member: key})
+		} else {
+			pipe.ZRem(s.ctx, s.k(redisWatched), key)
+		}
+		return nil
+	})
+}
+
+func (s *redisStorage) WatchedArticles() ([]string, error) {
+	return s.client.ZRevRange(s.ctx, s.k(redisWatched), 0, -1).Result()
+}
+
+func (s *redisStorage) SetPrivate(key string, private bool) error {
+	return s.watchArticle(key, func(pipe redis.Pipeliner, art *article) error {
+		art.Private = private
+		if err := s.putArticle(pipe, key, art); err != nil {
+			return err
+		}
+		if private {
+			pipe.SAdd(s.ctx, s.k(redisPrivate), key)
+		} else {
+			pipe.SRem(s.ctx, s.k(redisPrivate), key)
+		}
+		return nil
+	})
+}
+
+func (s *redisStorage) PrivateArticles() ([]string, error) {
+	return s.client.SMembers(s.ctx, s.k(redisPrivate)).Result()
+}
+
+func (s *redisStorage) AddVersion(key string, v *articleVersion, keep int) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(s.ctx, s.k(redisVersions)+key, compress(data))
+		pipe.LTrim(s.ctx, s.k(redisVersions)+key, 0, int64(keep-1))
+		return nil
+	})
+
+	return err
+}
+
+func (s *redisStorage) Versions(key string) ([]*articleVersion, error) {
+	items, err := s.client.LRange(s.ctx, s.k(redisVersions)+key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]*articleVersion, 0, len(items))
+	for _, item := range items {
+		var v articleVersion
+		if err := json.Unmarshal(uncompress([]byte(item)), &v); err != nil {
+			return nil, err
+		}
+		versions = append(versions, &v)
+	}
+
+	return versions, nil
+}
+
+func (s *redisStorage) Usage() (int, int64, error) {
+	sizes, err := s.client.HVals(s.ctx, s.k(redisSizes)).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var total int64
+	for _, size := range sizes {
+		n, _ := strconv.ParseInt(size, 10, 64)
+		total += n
+	}
+
+	return len(sizes), total, nil
+}
+
+func (s *redisStorage) LeastRecentlyViewed(n int) ([]string, error) {
+	return s.client.ZRange(s.ctx, s.k(redisLastView), 0, int64(n-1)).Result()
+}
+
+func (s *redisStorage) LastNArticles(n int) ([]string, error) {
+	return s.client.ZRevRange(s.ctx, s.k(redisRecents), 0, int64(n-1)).Result()
+}
+
+// Keys are the recents, then the articles RECENTS_MAX left out of them.
+func (s *redisStorage) Keys() ([]string, error) {
+	var recents, sized *redis.StringSliceCmd
+	_, err := s.client.Pipelined(s.ctx, func(pipe redis.Pipeliner) error {
+		recents = pipe.ZRevRange(s.ctx, s.k(redisRecents), 0, -1)
+		sized = pipe.HKeys(s.ctx, s.k(redisSizes))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := recents.Val()
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		seen[key] = true
+	}
+	for _, key := range sized.Val() {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+func (s *redisStorage) ArticleURL(key string) (string, error) {
+	uri, err := s.client.HGet(s.ctx, s.k(redisURLs), key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+
+	return uri, err
+}
+
+func (s *redisStorage) SetTags(key string, tags []string) error {
+	return s.watchArticle(key, func(pipe redis.Pipeliner, art *article) error {
+		old := art.Tags
+		art.Tags = tags
+		if err := s.putArticle(pipe, key, art); err != nil {
+			return err
+		}
+		for _, tag := range old {
+			pipe.SRem(s.ctx, s.k(redisTagPrefix)+tag, key)
+		}
+		for _, tag := range tags {
+			pipe.SAdd(s.ctx, s.k(redisTagPrefix)+tag, key)
+			pipe.SAdd(s.ctx, s.k(redisTags), tag)
+		}
+		return nil
+	})
+}
+
+func (s *redisStorage) TaggedArticles(tag string) ([]string, error) {
+	return s.client.SMembers(s.ctx, s.k(redisTagPrefix)+tag).Result()
+}
+
+func (s *redisStorage) Tags() (map[string]int, error) {
+	names, err := s.client.SMembers(s.ctx, s.k(redisTags)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]int, len(names))
+	for _, name := range names {
+		n, err := s.client.SCard(s.ctx, s.k(redisTagPrefix)+name).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		// Tag sets disappear with their last member, forget the name too.
+		if n == 0 {
+			s.client.SRem(s.ctx, s.k(redisTags), name)
+			continue
+		}
+		tags[name] = int(n)
+	}
+
+	return tags, nil
+}
+
+func (s *redisStorage) SetStarred(key string, starred bool) error {
+	return s.watchArticle(key, func(pipe redis.Pipeliner, art *article) error {
+		art.Starred = starred
+		if err := s.putArticle(pipe, key, art); err != nil {
+			return err
+		}
+		if starred {
+			pipe.ZAdd(s.ctx, s.k(redisStarred), redis.Z{Score: float64(time.Now().Unix()), Member: key})
+		} else {
+			pipe.ZRem(s.ctx, s.k(redisStarred), key)
+		}
+		return nil
+	})
+}
+
+func (s *redisStorage) StarredArticles() ([]string, error) {
+	return s.client.ZRevRange(s.ctx, s.k(redisStarred), 0, -1).Result()
+}
+
+func (s *redisStorage) Ping() error {
+	return s.client.Ping(s.ctx).Err()
+}
+
+// Close closes the client, a user's library leaves it to the instance's it
+// shares it with.
+func (s *redisStorage) Close() error {
+	if s.ns != "" {
+		return nil
+	}
+
+	return s.client.Close()
+}
@@ -0,0 +1,971 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS articles (
+	key        TEXT PRIMARY KEY,
+	data       BLOB NOT NULL,
+	views      INTEGER NOT NULL DEFAULT 0,
+	updated_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS articles_updated_at ON articles (updated_at);
+CREATE TABLE IF NOT EXISTS tags (
+	key TEXT NOT NULL REFERENCES articles (key) ON DELETE CASCADE,
+	tag TEXT NOT NULL,
+	PRIMARY KEY (key, tag)
+);
+CREATE INDEX IF NOT EXISTS tags_tag ON tags (tag);
+CREATE TABLE IF NOT EXISTS views (
+	key   TEXT NOT NULL REFERENCES articles (key) ON DELETE CASCADE,
+	day   INTEGER NOT NULL,
+	views INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (key, day)
+);
+CREATE TABLE IF NOT EXISTS aliases (
+	alias TEXT PRIMARY KEY,
+	key   TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS slugs (
+	slug TEXT PRIMARY KEY,
+	key  TEXT NOT NULL REFERENCES articles (key) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS wallabag_ids (
+	id  INTEGER PRIMARY KEY AUTOINCREMENT,
+	key TEXT NOT NULL UNIQUE
+);
+CREATE TABLE IF NOT EXISTS starred (
+	key        TEXT PRIMARY KEY REFERENCES articles (key) ON DELETE CASCADE,
+	starred_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS raw (
+	key  TEXT PRIMARY KEY REFERENCES articles (key) ON DELETE CASCADE,
+	html BLOB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS translations (
+	key  TEXT NOT NULL REFERENCES articles (key) ON DELETE CASCADE,
+	lang TEXT NOT NULL,
+	data BLOB NOT NULL,
+	PRIMARY KEY (key, lang)
+);
+CREATE TABLE IF NOT EXISTS audio (
+	key  TEXT PRIMARY KEY REFERENCES articles (key) ON DELETE CASCADE,
+	data BLOB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS credentials (
+	domain TEXT PRIMARY KEY,
+	sealed BLOB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id         TEXT PRIMARY KEY,
+	name       TEXT NOT NULL,
+	hash       TEXT NOT NULL UNIQUE,
+	created_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS users (
+	name          TEXT PRIMARY KEY,
+	id            TEXT NOT NULL UNIQUE,
+	password_hash BLOB,
+	created_at    INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS token_usage (
+	id    TEXT NOT NULL REFERENCES api_tokens (id) ON DELETE CASCADE,
+	day   TEXT NOT NULL,
+	count INTEGER NOT NULL,
+	PRIMARY KEY (id, day)
+);
+CREATE TABLE IF NOT EXISTS snapshots (
+	id         TEXT PRIMARY KEY,
+	key        TEXT NOT NULL,
+	url        TEXT NOT NULL,
+	title      TEXT NOT NULL,
+	html       BLOB NOT NULL,
+	created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS snapshots_key ON snapshots (key, created_at);
+CREATE TABLE IF NOT EXISTS watched (
+	key        TEXT PRIMARY KEY REFERENCES articles (key) ON DELETE CASCADE,
+	watched_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS private (
+	key TEXT PRIMARY KEY REFERENCES articles (key) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS versions (
+	id       INTEGER PRIMARY KEY AUTOINCREMENT,
+	key      TEXT NOT NULL REFERENCES articles (key) ON DELETE CASCADE,
+	data     BLOB NOT NULL,
+	saved_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS versions_key ON versions (key, id);
+CREATE TABLE IF NOT EXISTS images (
+	hash         TEXT PRIMARY KEY,
+	url          TEXT NOT NULL,
+	content_type TEXT NOT NULL DEFAULT '',
+	data         BLOB,
+	fetched_at   INTEGER NOT NULL DEFAULT 0
+);
+`
+
+type sqliteStorage struct {
+	db *sql.DB
+	// path is the database's, the users' libraries are next to it.
+	path string
+}
+
+func newSQLiteStorage(path string) (*sqliteStorage, error) {
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite, path: %s, error: %w", path, err)
+	}
+
+	// sqlite only allows a single writer, serialize access instead of
+	// fighting over the lock.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create sqlite schema: %w", err)
+	}
+
+	for _, c := range []struct{ table, column string }{
+		{"articles", "viewed_at INTEGER NOT NULL DEFAULT 0"},
+		{"articles", "url TEXT NOT NULL DEFAULT ''"},
+		{"api_tokens", "rate INTEGER NOT NULL DEFAULT 0"},
+		{"api_tokens", "quota INTEGER NOT NULL DEFAULT 0"},
+		{"api_tokens", "user TEXT NOT NULL DEFAULT ''"},
+		{"users", "display_name TEXT NOT NULL DEFAULT ''"},
+	} {
+		if err := sqliteAddColumn(db, c.table, c.column); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to migrate sqlite schema: %w", err)
+		}
+	}
+
+	return &sqliteStorage{db: db, path: path}, nil
+}
+
+// sqliteAddColumn adds a column introduced after the table was first
+// created, doing nothing when it is already there.
+func sqliteAddColumn(db *sql.DB, table, column string) error {
+	_, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s`, table, column))
+	if err != nil && strings.Contains(err.Error(), "duplicate column name") {
+		return nil
+	}
+
+	return err
+}
+
+func (s *sqliteStorage) GetArticle(key string) (*article, error) {
+	var data []byte
+
+	err := s.db.QueryRow(`SELECT data FROM articles WHERE key = ?`, key).Scan(&data)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var art article
+	if err := json.Unmarshal(uncompress(data), &art); err != nil {
+		return nil, err
+	}
+
+	return &art, nil
+}
+
+func (s *sqliteStorage) SetArticle(key string, art *article) error {
+	data, err := json.Marshal(art)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano()
+	_, err = s.db.Exec(`INSERT INTO articles (key, url, data, updated_at, viewed_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET url = excluded.url, data = excluded.data,
+			updated_at = excluded.updated_at, viewed_at = excluded.viewed_at`,
+		key, art.URL, compress(data), now, now)
+
+	return err
+}
+
+func (s *sqliteStorage) UpdateArticle(key string, fn func(art *article)) error {
+	art, err := s.GetArticle(key)
+	if err != nil {
+		return err
+	}
+	if art == nil {
+		return errArticleNotFound
+	}
+
+	fn(art)
+
+	data, err := json.Marshal(art)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`UPDATE articles SET data = ? WHERE key = ?`, compress(data), key)
+	return err
+}
+
+// DeleteArticle removes the article, what references it going with it,
+// and its aliases and Wallabag ID.
+func (s *sqliteStorage) DeleteArticle(key string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM articles WHERE key = ?`,
+		`DELETE FROM aliases WHERE key = ?`,
+		`DELETE FROM wallabag_ids WHERE key = ?`,
+	} {
+		if _, err := tx.Exec(query, key); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStorage) IncrViewCount(key string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE articles SET views = views + 1, viewed_at = ? WHERE key = ?`, time.Now().UnixNano(), key)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	today := viewDay(time.Now())
+	if _, err := tx.Exec(`INSERT INTO views (key, day, views) VALUES (?, ?, 1)
+		ON CONFLICT (key, day) DO UPDATE SET views = views + 1`, key, today); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM views WHERE day < ?`, today-viewRetentionDays); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStorage) ViewCount(key string) (int64, error) {
+	var views int64
+
+	err := s.db.QueryRow(`SELECT views FROM articles WHERE key = ?`, key).Scan(&views)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return views, err
+}
+
+func (s *sqliteStorage) SetViewCount(key string, views int64) error {
+	_, err := s.db.Exec(`UPDATE articles SET views = ? WHERE key = ?`, views, key)
+	return err
+}
+
+func (s *sqliteStorage) TrendingArticles(n int, weights []float64) ([]string, error) {
+	if len(weights) == 0 {
+		return s.queryKeys(`SELECT key FROM articles WHERE views > 0 ORDER BY views DESC LIMIT ?`, n)
+	}
+
+	today := viewDay(time.Now())
+	rows, err := s.db.Query(`SELECT key, day, views FROM views WHERE day > ?`, today-int64(len(weights)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := make(map[string]float64)
+	for rows.Next() {
+		var key string
+		var day, views int64
+		if err := rows.Scan(&key, &day, &views); err != nil {
+			return nil, err
+		}
+		if age := today - day; age >= 0 {
+			scores[key] += float64(views) * weights[age]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return topScores(scores, n), nil
+}
+
+func (s *sqliteStorage) SetAlias(alias, key string) error {
+	_, err := s.db.Exec(`INSERT INTO aliases (alias, key) VALUES (?, ?)
+		ON CONFLICT (alias) DO UPDATE SET key = excluded.key`, alias, key)
+	return err
+}
+
+func (s *sqliteStorage) Alias(alias string) (string, error) {
+	var key string
+
+	err := s.db.QueryRow(`SELECT key FROM aliases WHERE alias = ?`, alias).Scan(&key)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return key, err
+}
+
+func (s *sqliteStorage) Aliases() (map[string]string, error) {
+	rows, err := s.db.Query(`SELECT alias, key FROM aliases`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := make(map[string]string)
+	for rows.Next() {
+		var alias, key string
+		if err := rows.Scan(&alias, &key); err != nil {
+			return nil, err
+		}
+		aliases[alias] = key
+	}
+
+	return aliases, rows.Err()
+}
+
+func (s *sqliteStorage) SetSlug(slug, key string) error {
+	_, err := s.db.Exec(`INSERT INTO slugs (slug, key) VALUES (?, ?)
+		ON CONFLICT (slug) DO UPDATE SET key = excluded.key`, slug, key)
+	return err
+}
+
+func (s *sqliteStorage) SlugKey(slug string) (string, error) {
+	var key string
+
+	err := s.db.QueryRow(`SELECT key FROM slugs WHERE slug = ?`, slug).Scan(&key)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return key, err
+}
+
+func (s *sqliteStorage) WallabagID(key string) (int, error) {
+	if _, err := s.db.Exec(`INSERT INTO wallabag_ids (key) VALUES (?) ON CONFLICT (key) DO NOTHING`, key); err != nil {
+		return 0, err
+	}
+
+	var id int
+	err := s.db.QueryRow(`SELECT id FROM wallabag_ids WHERE key = ?`, key).Scan(&id)
+	return id, err
+}
+
+func (s *sqliteStorage) WallabagKey(id int) (string, error) {
+	var key string
+
+	err := s.db.QueryRow(`SELECT key FROM wallabag_ids WHERE id = ?`, id).Scan(&key)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return key, err
+}
+
+func (s *sqliteStorage) SetImage(hash string, img *cachedImage) error {
+	var fetchedAt int64
+	if !img.FetchedAt.IsZero() {
+		fetchedAt = img.FetchedAt.Unix()
+	}
+
+	_, err := s.db.Exec(`INSERT INTO images (hash, url, content_type, data, fetched_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (hash) DO UPDATE SET url = excluded.url, content_type = excluded.content_type,
+			data = excluded.data, fetched_at = excluded.fetched_at`,
+		hash, img.URL, img.ContentType, img.Data, fetchedAt)
+	return err
+}
+
+func (s *sqliteStorage) GetImage(hash string) (*cachedImage, error) {
+	var img cachedImage
+	var fetchedAt int64
+
+	err := s.db.QueryRow(`SELECT url, content_type, data, fetched_at FROM images WHERE hash = ?`, hash).
+		Scan(&img.URL, &img.ContentType, &img.Data, &fetchedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fetchedAt > 0 {
+		img.FetchedAt = time.Unix(fetchedAt, 0)
+	}
+
+	return &img, nil
+}
+
+func (s *sqliteStorage) SetRaw(key string, data []byte) error {
+	_, err := s.db.Exec(`INSERT INTO raw (key, html) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET html = excluded.html`, key, data)
+	if err != nil && strings.Contains(err.Error(), "FOREIGN KEY") {
+		return errArticleNotFound
+	}
+
+	return err
+}
+
+func (s *sqliteStorage) Raw(key string) ([]byte, error) {
+	var data []byte
+
+	err := s.db.QueryRow(`SELECT html FROM raw WHERE key = ?`, key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+func (s *sqliteStorage) SetTranslation(key string, tr *translation) error {
+	data, err := json.Marshal(tr)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`INSERT INTO translations (key, lang, data) VALUES (?, ?, ?)
+		ON CONFLICT (key, lang) DO UPDATE SET data = excluded.data`, key, tr.Lang, compress(data))
+	if err != nil && strings.Contains(err.Error(), "FOREIGN KEY") {
+		return errArticleNotFound
+	}
+
+	return err
+}
+
+func (s *sqliteStorage) Translation(key, lang string) (*translation, error) {
+	var data []byte
+
+	err := s.db.QueryRow(`SELECT data FROM translations WHERE key = ? AND lang = ?`, key, lang).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tr translation
+	if err := json.Unmarshal(uncompress(data), &tr); err != nil {
+		return nil, err
+	}
+
+	return &tr, nil
+}
+
+func (s *sqliteStorage) SetAudio(key string, data []byte) error {
+	_, err := s.db.Exec(`INSERT INTO audio (key, data) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET data = excluded.data`, key, data)
+	if err != nil && strings.Contains(err.Error(), "FOREIGN KEY") {
+		return errArticleNotFound
+	}
+
+	return err
+}
+
+func (s *sqliteStorage) Audio(key string) ([]byte, error) {
+	var data []byte
+
+	err := s.db.QueryRow(`SELECT data FROM audio WHERE key = ?`, key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+func (s *sqliteStorage) SetCredential(domain string, sealed []byte) error {
+	_, err := s.db.Exec(`INSERT INTO credentials (domain, sealed) VALUES (?, ?)
+		ON CONFLICT (domain) DO UPDATE SET sealed = excluded.sealed`, domain, sealed)
+	return err
+}
+
+func (s *sqliteStorage) DeleteCredential(domain string) error {
+	_, err := s.db.Exec(`DELETE FROM credentials WHERE domain = ?`, domain)
+	return err
+}
+
+func (s *sqliteStorage) Credentials() (map[string][]byte, error) {
+	rows, err := s.db.Query(`SELECT domain, sealed FROM credentials`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	creds := make(map[string][]byte)
+	for rows.Next() {
+		var domain string
+		var sealed []byte
+		if err := rows.Scan(&domain, &sealed); err != nil {
+			return nil, err
+		}
+		creds[domain] = sealed
+	}
+
+	return creds, rows.Err()
+}
+
+func (s *sqliteStorage) SetAPIToken(tok *apiToken) error {
+	_, err := s.db.Exec(`INSERT INTO api_tokens (id, name, hash, created_at, rate, quota, user) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, hash = excluded.hash, created_at = excluded.created_at,
+			rate = excluded.rate, quota = excluded.quota, user = excluded.user`,
+		tok.ID, tok.Name, tok.Hash, tok.CreatedAt.UnixNano(), tok.Rate, tok.Quota, tok.User)
+	return err
+}
+
+func (s *sqliteStorage) DeleteAPIToken(id string) error {
+	_, err := s.db.Exec(`DELETE FROM api_tokens WHERE id = ?`, id)
+	return err
+}
+
+func (s *sqliteStorage) APITokens() ([]*apiToken, error) {
+	rows, err := s.db.Query(`SELECT id, name, hash, created_at, rate, quota, user FROM api_tokens`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*apiToken
+	for rows.Next() {
+		var tok apiToken
+		var created int64
+		if err := rows.Scan(&tok.ID, &tok.Name, &tok.Hash, &created, &tok.Rate, &tok.Quota, &tok.User); err != nil {
+			return nil, err
+		}
+		tok.CreatedAt = time.Unix(0, created)
+		tokens = append(tokens, &tok)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (s *sqliteStorage) IncrTokenUsage(id, day string) (int64, error) {
+	var n int64
+	err := s.db.QueryRow(`INSERT INTO token_usage (id, day, count) VALUES (?, ?, 1)
+		ON CONFLICT (id, day) DO UPDATE SET count = count + 1 RETURNING count`, id, day).Scan(&n)
+	return n, err
+}
+
+func (s *sqliteStorage) TokenUsage(id string) (map[string]int64, error) {
+	rows, err := s.db.Query(`SELECT day, count FROM token_usage WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := make(map[string]int64)
+	for rows.Next() {
+		var day string
+		var n int64
+		if err := rows.Scan(&day, &n); err != nil {
+			return nil, err
+		}
+		usage[day] = n
+	}
+
+	return usage, rows.Err()
+}
+
+func (s *sqliteStorage) SetUser(u *user) error {
+	_, err := s.db.Exec(`INSERT INTO users (name, id, password_hash, created_at, display_name) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET id = excluded.id, password_hash = excluded.password_hash,
+			created_at = excluded.created_at, display_name = excluded.display_name`,
+		u.Name, u.ID, u.PasswordHash, u.CreatedAt.UnixNano(), u.DisplayName)
+	return err
+}
+
+func (s *sqliteStorage) GetUser(name string) (*user, error) {
+	u := user{Name: name}
+	var created int64
+
+	err := s.db.QueryRow(`SELECT id, password_hash, created_at, display_name FROM users WHERE name = ?`, name).
+		Scan(&u.ID, &u.PasswordHash, &created, &u.DisplayName)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.CreatedAt = time.Unix(0, created)
+
+	return &u, nil
+}
+
+func (s *sqliteStorage) Users() ([]*user, error) {
+	rows, err := s.db.Query(`SELECT name, id, password_hash, created_at, display_name FROM users`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*user
+	for rows.Next() {
+		var u user
+		var created int64
+		if err := rows.Scan(&u.Name, &u.ID, &u.PasswordHash, &created, &u.DisplayName); err != nil {
+			return nil, err
+		}
+		u.CreatedAt = time.Unix(0, created)
+		users = append(users, &u)
+	}
+
+	return users, rows.Err()
+}
+
+func (s *sqliteStorage) SetSnapshot(snap *snapshot) error {
+	_, err := s.db.Exec(`INSERT INTO snapshots (id, key, url, title, html, created_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET key = excluded.key, url = excluded.url, title = excluded.title,
+			html = excluded.html, created_at = excluded.created_at`,
+		snap.ID, snap.Key, snap.URL, snap.Title, compress(snap.HTML), snap.CreatedAt.UnixNano())
+	return err
+}
+
+func (s *sqliteStorage) GetSnapshot(id string) (*snapshot, error) {
+	snap := snapshot{ID: id}
+	var data []byte
+	var createdAt int64
+
+	err := s.db.QueryRow(`SELECT key, url, title, html, created_at FROM snapshots WHERE id = ?`, id).
+		Scan(&snap.Key, &snap.URL, &snap.Title, &data, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snap.HTML = uncompress(data)
+	snap.CreatedAt = time.Unix(0, createdAt)
+
+	return &snap, nil
+}
+
+func (s *sqliteStorage) Snapshots(key string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT id FROM snapshots WHERE key = ? ORDER BY created_at DESC`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (s *sqliteStorage) SetWatched(key string, watched bool) error {
+	art, err := s.GetArticle(key)
+	if err != nil {
+		return err
+	}
+	if art == nil {
+		return errArticleNotFound
+	}
+
+	art.Watched = watched
+	data, err := json.Marshal(art)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE articles SET data = ? WHERE key = ?`, compress(data), key); err != nil {
+		return err
+	}
+	if watched {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO watched (key, watched_at) VALUES (?, ?)`, key, time.Now().UnixNano())
+	} else {
+		_, err = tx.Exec(`DELETE FROM watched WHERE key = ?`, key)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStorage) WatchedArticles() ([]string, error) {
+	return s.queryKeys(`SELECT key FROM watched ORDER BY watched_at DESC`)
+}
+
+func (s *sqliteStorage) SetPrivate(key string, private bool) error {
+	art, err := s.GetArticle(key)
+	if err != nil {
+		return err
+	}
+	if art == nil {
+		return errArticleNotFound
+	}
+
+	art.Private = private
+	data, err := json.Marshal(art)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE articles SET data = ? WHERE key = ?`, compress(data), key); err != nil {
+		return err
+	}
+	if private {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO private (key) VALUES (?)`, key)
+	} else {
+		_, err = tx.Exec(`DELETE FROM private WHERE key = ?`, key)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStorage) PrivateArticles() ([]string, error) {
+	return s.queryKeys(`SELECT key FROM private ORDER BY key`)
+}
+
+func (s *sqliteStorage) AddVersion(key string, v *articleVersion, keep int) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO versions (key, data, saved_at) VALUES (?, ?, ?)`, key, compress(data), v.SavedAt.UnixNano())
+	if err != nil {
+		if strings.Contains(err.Error(), "FOREIGN KEY") {
+			return errArticleNotFound
+		}
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM versions WHERE key = ? AND id NOT IN
+		(SELECT id FROM versions WHERE key = ? ORDER BY id DESC LIMIT ?)`, key, key, keep); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStorage) Versions(key string) ([]*articleVersion, error) {
+	rows, err := s.db.Query(`SELECT data FROM versions WHERE key = ? ORDER BY id DESC`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []*articleVersion
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		var v articleVersion
+		if err := json.Unmarshal(uncompress(data), &v); err != nil {
+			return nil, err
+		}
+		versions = append(versions, &v)
+	}
+
+	return versions, rows.Err()
+}
+
+func (s *sqliteStorage) Usage() (int, int64, error) {
+	var count int
+	var size int64
+
+	err := s.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(LENGTH(data)), 0) FROM articles`).Scan(&count, &size)
+
+	return count, size, err
+}
+
+func (s *sqliteStorage) LeastRecentlyViewed(n int) ([]string, error) {
+	return s.queryKeys(`SELECT key FROM articles ORDER BY viewed_at, updated_at LIMIT ?`, n)
+}
+
+func (s *sqliteStorage) LastNArticles(n int) ([]string, error) {
+	return s.queryKeys(`SELECT key FROM articles ORDER BY updated_at DESC LIMIT ?`, n)
+}
+
+func (s *sqliteStorage) Keys() ([]string, error) {
+	return s.queryKeys(`SELECT key FROM articles ORDER BY updated_at DESC`)
+}
+
+func (s *sqliteStorage) queryKeys(query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		records = append(records, key)
+	}
+
+	return records, rows.Err()
+}
+
+func (s *sqliteStorage) ArticleURL(key string) (string, error) {
+	var uri string
+
+	err := s.db.QueryRow(`SELECT url FROM articles WHERE key = ?`, key).Scan(&uri)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return uri, err
+}
+
+func (s *sqliteStorage) SetTags(key string, tags []string) error {
+	art, err := s.GetArticle(key)
+	if err != nil {
+		return err
+	}
+	if art == nil {
+		return errArticleNotFound
+	}
+
+	art.Tags = tags
+	data, err := json.Marshal(art)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE articles SET data = ? WHERE key = ?`, compress(data), key); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM tags WHERE key = ?`, key); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.Exec(`INSERT INTO tags (key, tag) VALUES (?, ?)`, key, tag); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStorage) TaggedArticles(tag string) ([]string, error) {
+	return s.queryKeys(`SELECT t.key FROM tags t JOIN articles a ON a.key = t.key
+		WHERE t.tag = ? ORDER BY a.updated_at DESC`, tag)
+}
+
+func (s *sqliteStorage) Tags() (map[string]int, error) {
+	rows, err := s.db.Query(`SELECT tag, COUNT(*) FROM tags GROUP BY tag`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[string]int)
+	for rows.Next() {
+		var tag string
+		var n int
+		if err := rows.Scan(&tag, &n); err != nil {
+			return nil, err
+		}
+		tags[tag] = n
+	}
+
+	return tags, rows.Err()
+}
+
+func (s *sqliteStorage) SetStarred(key string, starred bool) error {
+	art, err := s.GetArticle(key)
+	if err != nil {
+		return err
+	}
+	if art == nil {
+		return errArticleNotFound
+	}
+
+	art.Starred = starred
+	data, err := json.Marshal(art)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE articles SET data = ? WHERE key = ?`, compress(data), key); err != nil {
+		return err
+	}
+	if starred {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO starred (key, starred_at) VALUES (?, ?)`, key, time.Now().UnixNano())
+	} else {
+		_, err = tx.Exec(`DELETE FROM starred WHERE key = ?`, key)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStorage) StarredArticles() ([]string, error) {
+	return s.queryKeys(`SELECT key FROM starred ORDER BY starred_at DESC`)
+}
+
+func (s *sqliteStorage) Ping() error {
+	return s.db.Ping()
+}
+
+func (s *sqliteStorage) Close() error {
+	return s.db.Close()
+}
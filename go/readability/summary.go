@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	// SUMMARY_PROMPT is what the model is told to do with the article.
+	SUMMARY_PROMPT = envOr("SUMMARY_PROMPT", "Summarize the article you are given in 3 to 5 short sentences, in the language of the article. Answer with the summary only, in plain text.")
+	// SUMMARY_INPUT is how much of the article's text the model is given,
+	// in runes.
+	SUMMARY_INPUT = envInt("SUMMARY_INPUT", 24000)
+
+	summaryGroup singleflight.Group
+)
+
+// summarize returns the summary of the article stored under key, asking
+// the model for it unless it's stored with the article already. Once
+// asked, it's stored with it until its content changes.
+func (lib *library) summarize(ctx context.Context, key string) (string, error) {
+	art, err := lib.store.GetArticle(key)
+	if err != nil {
+		return "", err
+	}
+	if art == nil || art.ErrMsg != "" {
+		return "", errArticleNotFound
+	}
+	if art.Summary != "" && art.SummaryHash == art.hash() {
+		return art.Summary, nil
+	}
+	if !llmEnabled() {
+		return "", errLLMOff
+	}
+
+	v, err, _ := summaryGroup.Do(lib.User+"\x00"+key, func() (interface{}, error) {
+		text := truncateRunes(art.Title+"\n\n"+htmlText(art.Content), SUMMARY_INPUT)
+		summary, err := llmComplete(ctx, SUMMARY_PROMPT, text)
+		if err != nil {
+			return "", err
+		}
+
+		hash := art.hash()
+		err = lib.store.UpdateArticle(key, func(art *article) {
+			art.Summary = summary
+			art.SummaryHash = hash
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to store summary", "key", key, "err", err)
+		}
+
+		return summary, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return v.(string), nil
+}
+
+type summaryResponse struct {
+	URL     string `json:"url"`
+	Summary string `json:"summary"`
+}
+
+var apiSummarizeOp = apiOperation{
+	Summary:     "Summarize an article",
+	Description: "A summary of the saved article by the language model at LLM_URL, asked once and stored with the article until it changes.",
+	Tag:         "articles",
+	Query:       []apiParam{{Name: "url", Description: "The article's URL.", Required: true}},
+	Response:    summaryResponse{},
+	Errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotImplemented, http.StatusBadGateway},
+}
+
+func apiSummarizeHandler(w http.ResponseWriter, r *http.Request) {
+	uri := r.URL.Query().Get("url")
+	if uri == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing url"})
+		return
+	}
+
+	lib := libraryFor(r)
+	summary, err := lib.summarize(r.Context(), lib.resolveKey(uri))
+	switch {
+	case errors.Is(err, errArticleNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	case errors.Is(err, errLLMOff):
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "failed to summarize article", "url", uri, "err", err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, summaryResponse{URL: uri, Summary: summary})
+}
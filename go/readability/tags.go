@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const maxTagLen = 64
+
+type tagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// normalizeTags lowercases tags and joins words with dashes so the same tag
+// typed twice ends up in one set.
+func normalizeTags(raw []string) []string {
+	seen := make(map[string]bool)
+	tags := []string{}
+
+	for _, tag := range raw {
+		tag = strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+		tag = strings.Trim(tag, "#")
+		if tag == "" || len(tag) > maxTagLen || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	sort.Strings(tags)
+
+	return tags
+}
+
+func (lib *library) sortedTags() []tagCount {
+	tags, err := lib.store.Tags()
+	if err != nil {
+		slog.Error("failed to get tags", "err", err)
+		return nil
+	}
+
+	sorted := make([]tagCount, 0, len(tags))
+	for name, count := range tags {
+		sorted = append(sorted, tagCount{Name: name, Count: count})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	return sorted
+}
+
+// tagsHandler replaces the tags of an article from the comma separated tags
+// form field.
+func tagsHandler(w http.ResponseWriter, r *http.Request) {
+	uri := r.FormValue("url")
+	if uri == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	tags := normalizeTags(strings.Split(r.FormValue("tags"), ","))
+	lib := libraryFor(r)
+	if err := lib.store.SetTags(lib.resolveKey(uri), tags); err != nil {
+		if err == errArticleNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, articlePath("read", uri), http.StatusSeeOther)
+}
+
+func tagHandler(w http.ResponseWriter, r *http.Request) {
+	tag := mux.Vars(r)["name"]
+	lib := libraryFor(r)
+
+	keys, err := lib.store.TaggedArticles(tag)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var arts []*article
+	for _, key := range keys {
+		if art, err := lib.store.GetArticle(key); err == nil && art != nil {
+			arts = append(arts, art)
+		}
+	}
+
+	sort.SliceStable(arts, func(i, j int) bool { return arts[i].CreatedAt.After(arts[j].CreatedAt) })
+
+	executePage(w, r, "tag.html", map[string]interface{}{
+		"Tag":      tag,
+		"Articles": arts,
+	})
+}
+
+var apiTagsOp = apiOperation{
+	Summary:  "List the tags",
+	Tag:      "library",
+	Response: []tagCount{},
+}
+
+func apiTagsHandler(w http.ResponseWriter, r *http.Request) {
+	tags := libraryFor(r).sortedTags()
+	if tags == nil {
+		tags = []tagCount{}
+	}
+
+	writeJSON(w, http.StatusOK, tags)
+}
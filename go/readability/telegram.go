@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// TELEGRAM_TOKEN runs a Telegram bot reading the URLs sent to it, for
+	// the chats in TELEGRAM_CHATS only when it is set.
+	TELEGRAM_TOKEN   = envOr("TELEGRAM_TOKEN", "")
+	TELEGRAM_CHATS   = splitList(envOr("TELEGRAM_CHATS", ""))
+	TELEGRAM_API_URL = envOr("TELEGRAM_API_URL", "https://api.telegram.org")
+	// TELEGRAM_SEND_TEXT replies with the article's text too, in up to
+	// telegramMaxMessages messages.
+	TELEGRAM_SEND_TEXT = envOr("TELEGRAM_SEND_TEXT", "") == "true"
+
+	// Long polls wait up to telegramPollTimeout for updates.
+	telegramClient = &http.Client{Timeout: telegramPollTimeout + 10*time.Second}
+
+	messageURL = regexp.MustCompile(`https?://\S+`)
+)
+
+const (
+	telegramPollTimeout = 50 * time.Second
+	// Telegram takes messages of up to 4096 characters.
+	telegramMessageLen  = 4000
+	telegramMaxMessages = 5
+)
+
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		MessageID int64  `json:"message_id"`
+		Text      string `json:"text"`
+		Chat      struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+	} `json:"message"`
+}
+
+// telegramCall calls method of the bot API with params, decoding its
+// result into result.
+func telegramCall(method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	resp, err := telegramClient.Post(TELEGRAM_API_URL+"/bot"+TELEGRAM_TOKEN+"/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var data struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return fmt.Errorf("telegram %s: %s", method, resp.Status)
+	}
+	if !data.OK {
+		return fmt.Errorf("telegram %s: %s", method, data.Description)
+	}
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(data.Result, result)
+}
+
+// telegramBot long polls for the messages sent to the bot until the
+// process exits.
+func telegramBot() {
+	if TELEGRAM_TOKEN == "" {
+		return
+	}
+	if BASE_URL == "" {
+		slog.Warn("BASE_URL is not set, the telegram bot will reply without links")
+	}
+
+	var offset int64
+	for {
+		var updates []telegramUpdate
+		err := telegramCall("getUpdates", map[string]interface{}{
+			"offset":          offset,
+			"timeout":         int(telegramPollTimeout.Seconds()),
+			"allowed_updates": []string{"message"},
+		}, &updates)
+		if err != nil {
+			slog.Error("failed to get telegram updates", "err", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			if update.Message != nil {
+				go handleTelegramMessage(update.Message.Chat.ID, update.Message.MessageID, update.Message.Text)
+			}
+		}
+	}
+}
+
+func telegramChatAllowed(chat int64) bool {
+	if len(TELEGRAM_CHATS) == 0 {
+		return true
+	}
+
+	id := strconv.FormatInt(chat, 10)
+	for _, allowed := range TELEGRAM_CHATS {
+		if allowed == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func handleTelegramMessage(chat, message int64, text string) {
+	if !telegramChatAllowed(chat) {
+		slog.Warn("ignoring telegram message", "chat", chat)
+		return
+	}
+
+	uri := messageURL.FindString(text)
+	if uri == "" {
+		telegramReply(chat, message, "Send me a link to read it.")
+		return
+	}
+
+	art := readabyFormURL(uri, readOptions{})
+	if art.ErrMsg != "" {
+		telegramReply(chat, message, "Couldn't read "+uri+": "+art.ErrMsg)
+		return
+	}
+
+	reply := art.Title
+	if reply == "" {
+		reply = art.URL
+	}
+	if link := articleLink(art); link != "" {
+		reply += "\n" + link
+	}
+	telegramReply(chat, message, reply)
+
+	if TELEGRAM_SEND_TEXT {
+		for _, chunk := range splitMessage(htmlText(art.Content), telegramMessageLen, telegramMaxMessages) {
+			telegramReply(chat, 0, chunk)
+		}
+	}
+}
+
+// articleLink is the absolute permalink of a saved article, "" without
+// BASE_URL.
+func articleLink(art *article) string {
+	if BASE_URL == "" {
+		return ""
+	}
+
+	return permalink(BASE_URL, art)
+}
+
+// telegramReply sends text to chat, as a reply to message unless it's 0.
+func telegramReply(chat, message int64, text string) {
+	params := map[string]interface{}{
+		"chat_id": chat,
+		"text":    text,
+	}
+	if message != 0 {
+		params["reply_to_message_id"] = message
+	}
+
+	if err := telegramCall("sendMessage", params, nil); err != nil {
+		slog.Error("failed to reply on telegram", "err", err)
+	}
+}
+
+// splitMessage cuts text into at most max chunks of up to n bytes, on
+// line or word breaks where it can, marking where it stops short.
+func splitMessage(text string, n, max int) []string {
+	var chunks []string
+
+	for text != "" && len(chunks) < max {
+		if len(text) <= n {
+			chunks = append(chunks, text)
+			return chunks
+		}
+
+		cut := strings.LastIndexByte(text[:n], '\n')
+		if cut < n/2 {
+			cut = strings.LastIndexByte(text[:n], ' ')
+		}
+		if cut < n/2 {
+			cut = n
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+		}
+
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+		for len(text) > 0 && (text[0] == '\n' || text[0] == ' ') {
+			text = text[1:]
+		}
+	}
+
+	if text != "" && len(chunks) > 0 {
+		chunks[len(chunks)-1] += " …"
+	}
+
+	return chunks
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	themeCookie = "theme"
+	styleCookie = "style"
+)
+
+var (
+	// THEMES_DIR holds extra stylesheets, {name}.css, offered next to the
+	// embedded ones; a file named like an embedded theme replaces it.
+	THEMES_DIR = envOr("THEMES_DIR", "")
+	// THEME is the stylesheet of visitors who haven't picked one, empty for
+	// style.css.
+	THEME = envOr("THEME", "")
+
+	//go:embed themes/*.css
+	themeFiles embed.FS
+
+	themes     map[string][]byte
+	themeNames []string
+
+	themeNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+)
+
+type styleOption struct {
+	Name  string
+	Label string
+}
+
+func init() {
+	themes = make(map[string][]byte)
+
+	entries, _ := fs.ReadDir(themeFiles, "themes")
+	for _, entry := range entries {
+		data, err := themeFiles.ReadFile(path.Join("themes", entry.Name()))
+		if err != nil {
+			continue
+		}
+		addTheme(entry.Name(), data)
+	}
+
+	if THEMES_DIR != "" {
+		entries, err := os.ReadDir(THEMES_DIR)
+		if err != nil {
+			slog.Error("failed to read themes dir", "err", err)
+		}
+		for _, entry := range entries {
+			data, err := os.ReadFile(path.Join(THEMES_DIR, entry.Name()))
+			if err != nil {
+				slog.Error("failed to read theme", "err", err)
+				continue
+			}
+			addTheme(entry.Name(), data)
+		}
+	}
+
+	for name := range themes {
+		themeNames = append(themeNames, name)
+	}
+	sort.Strings(themeNames)
+
+	if THEME != "" && themes[THEME] == nil {
+		slog.Warn("unknown theme, using the default", "theme", THEME)
+		THEME = ""
+	}
+}
+
+func addTheme(file string, data []byte) {
+	name := strings.TrimSuffix(file, ".css")
+	if name == file || !themeNameRe.MatchString(name) {
+		return
+	}
+	themes[name] = data
+}
+
+// styleOptions lists the stylesheets a visitor can pick, style.css first.
+func styleOptions() []styleOption {
+	options := []styleOption{{"", "Default"}}
+	for _, name := range themeNames {
+		label := strings.ReplaceAll(name, "-", " ")
+		options = append(options, styleOption{name, strings.ToUpper(label[:1]) + label[1:]})
+	}
+
+	return options
+}
+
+// visitorStyle is the stylesheet the visitor picked, THEME if they haven't.
+func visitorStyle(r *http.Request) string {
+	if c, err := r.Cookie(styleCookie); err == nil {
+		if c.Value == "default" {
+			return ""
+		}
+		if themes[c.Value] != nil {
+			return c.Value
+		}
+	}
+
+	return THEME
+}
+
+// colorScheme is the visitor's theme choice, auto follows the system's
+// prefers-color-scheme.
+func colorScheme(r *http.Request) string {
+	if c, err := r.Cookie(themeCookie); err == nil && (c.Value == "light" || c.Value == "dark") {
+		return c.Value
+	}
+
+	return "auto"
+}
+
+// executePage renders a page template with what every page needs about the
+// visitor added to data.
+func executePage(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}) {
+	data["Theme"] = colorScheme(r)
+	data["Style"] = visitorStyle(r)
+	data["Styles"] = styleOptions()
+	data["Back"] = r.URL.RequestURI()
+
+	if err := tmpl.ExecuteTemplate(w, name, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// safeBack returns the local path a form asked to go back to, "/" when it
+// points anywhere else.
+func safeBack(back string) string {
+	if !strings.HasPrefix(back, "/") || strings.HasPrefix(back, "//") || strings.HasPrefix(back, "/\\") {
+		return "/"
+	}
+
+	return back
+}
+
+func themeHandler(w http.ResponseWriter, r *http.Request) {
+	theme := r.FormValue("theme")
+	if theme != "light" && theme != "dark" {
+		theme = "auto"
+	}
+
+	setThemeCookie(w, themeCookie, theme)
+
+	// Kept apart from "no choice" so picking style.css sticks when THEME
+	// sets another default.
+	if style, ok := r.Form["style"]; ok {
+		name := style[0]
+		if themes[name] == nil {
+			name = "default"
+		}
+		setThemeCookie(w, styleCookie, name)
+	}
+
+	http.Redirect(w, r, safeBack(r.FormValue("back")), http.StatusSeeOther)
+}
+
+func setThemeCookie(w http.ResponseWriter, name, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  time.Now().AddDate(1, 0, 0),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func themeCSSHandler(w http.ResponseWriter, r *http.Request) {
+	css := themes[mux.Vars(r)["name"]]
+	if css == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	assetCache(w, r, contentHash(css))
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Write(css)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	// TLS_CERT and TLS_KEY are the files of a certificate to serve HTTPS
+	// with, PEM encoded, the chain included.
+	TLS_CERT = envOr("TLS_CERT", "")
+	TLS_KEY  = envOr("TLS_KEY", "")
+	// ACME_DOMAINS, comma separated, serves HTTPS with certificates issued
+	// and renewed by Let's Encrypt for them. The domains must reach this
+	// server on ports 443 and ACME_HTTP_PORT. ACME_EMAIL is told about
+	// problems with the certificates, ACME_CACHE is the directory they are
+	// kept in and ACME_DIRECTORY another CA's ACME directory, e.g. Let's
+	// Encrypt's staging one.
+	ACME_DOMAINS   = envOr("ACME_DOMAINS", "")
+	ACME_EMAIL     = envOr("ACME_EMAIL", "")
+	ACME_CACHE     = envOr("ACME_CACHE", "autocert")
+	ACME_DIRECTORY = envOr("ACME_DIRECTORY", "")
+	// ACME_HTTP_PORT answers the HTTP challenges, redirecting every other
+	// plain HTTP request to HTTPS.
+	ACME_HTTP_PORT = envOr("ACME_HTTP_PORT", "80")
+
+	certManager *autocert.Manager
+)
+
+func acmeEnabled() bool {
+	return ACME_DOMAINS != ""
+}
+
+func tlsEnabled() bool {
+	return acmeEnabled() || TLS_CERT != ""
+}
+
+// setupTLS has srv serve HTTPS with ACME certificates, certificate files
+// need nothing more.
+func setupTLS(srv *http.Server) {
+	if !acmeEnabled() {
+		return
+	}
+
+	certManager = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(splitList(ACME_DOMAINS)...),
+		Cache:      autocert.DirCache(ACME_CACHE),
+		Email:      ACME_EMAIL,
+	}
+	if ACME_DIRECTORY != "" {
+		certManager.Client = &acme.Client{DirectoryURL: ACME_DIRECTORY}
+	}
+	srv.TLSConfig = certManager.TLSConfig()
+}
+
+// listenAndServe serves srv over HTTPS when it's configured.
+func listenAndServe(srv *http.Server) error {
+	switch {
+	case acmeEnabled():
+		return srv.ListenAndServeTLS("", "")
+	case TLS_CERT != "":
+		return srv.ListenAndServeTLS(TLS_CERT, TLS_KEY)
+	}
+
+	return srv.ListenAndServe()
+}
+
+// acmeChallengeServer answers the ACME HTTP challenges on ACME_HTTP_PORT,
+// nil without ACME.
+func acmeChallengeServer() *http.Server {
+	if certManager == nil {
+		return nil
+	}
+
+	return &http.Server{
+		Addr:              ":" + ACME_HTTP_PORT,
+		Handler:           certManager.HTTPHandler(nil),
+		ReadHeaderTimeout: READ_TIMEOUT,
+		ReadTimeout:       READ_TIMEOUT,
+		WriteTimeout:      WRITE_TIMEOUT,
+		IdleTimeout:       IDLE_TIMEOUT,
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// TOC_MIN_HEADINGS is how many headings an article needs to get a table of
+// contents, shorter ones read fine without.
+var TOC_MIN_HEADINGS = envInt("TOC_MIN_HEADINGS", 3)
+
+type tocEntry struct {
+	ID    string
+	Title string
+	Level int
+	// Href links to the heading on another page of the article, when it's
+	// split into pages.
+	Href string
+}
+
+// tableOfContents lists the h1 to h3 headings of content, returning it
+// with an id on every heading for the entries to link to. Headings keep
+// the id they have.
+func tableOfContents(content string) ([]tocEntry, string) {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(content), body)
+	if err != nil {
+		return nil, content
+	}
+
+	var headings []*html.Node
+	used := make(map[string]bool)
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.H1, atom.H2, atom.H3:
+				headings = append(headings, n)
+			}
+			for _, attr := range n.Attr {
+				if attr.Key == "id" {
+					used[attr.Val] = true
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+
+	if len(headings) < TOC_MIN_HEADINGS {
+		return nil, content
+	}
+
+	toc := make([]tocEntry, 0, len(headings))
+	for _, n := range headings {
+		title := strings.Join(strings.Fields(nodeText(n)), " ")
+		if title == "" {
+			continue
+		}
+
+		id := attrValue(n, "id")
+		if id == "" {
+			id = uniqueAnchor(anchorName(title), used)
+			n.Attr = append(n.Attr, html.Attribute{Key: "id", Val: id})
+		}
+
+		toc = append(toc, tocEntry{ID: id, Title: title, Level: int(n.Data[1] - '0')})
+	}
+
+	var sb strings.Builder
+	for _, n := range nodes {
+		if err := html.Render(&sb, n); err != nil {
+			return nil, content
+		}
+	}
+
+	return toc, sb.String()
+}
+
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(nodeText(c))
+	}
+
+	return sb.String()
+}
+
+func attrValue(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+
+	return ""
+}
+
+// anchorName turns a heading into a readable fragment, lowercase words
+// joined by dashes.
+func anchorName(title string) string {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return "section"
+	}
+
+	return strings.Join(words, "-")
+}
+
+func uniqueAnchor(name string, used map[string]bool) string {
+	id := name
+	for i := 2; used[id]; i++ {
+		id = name + "-" + strconv.Itoa(i)
+	}
+	used[id] = true
+
+	return id
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	// API_TOKEN_REQUIRED=true has /api/v1/ answer requests carrying an API
+	// token only, so it can be reached from outside while the rest isn't,
+	// and =writes its POST and DELETE endpoints only.
+	API_TOKEN_REQUIRED = envOr("API_TOKEN_REQUIRED", "")
+	// API_TOKEN_RATE is how many requests a minute a token may make, and
+	// API_TOKEN_QUOTA a day, 0 doesn't limit them. Tokens can be given
+	// their own.
+	API_TOKEN_RATE  = envInt("API_TOKEN_RATE", 60)
+	API_TOKEN_QUOTA = envInt("API_TOKEN_QUOTA", 0)
+)
+
+// apiToken lets a browser extension or shortcut use the API, only the hash
+// of the token is kept.
+type apiToken struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+	// Rate and Quota override API_TOKEN_RATE and API_TOKEN_QUOTA when set.
+	Rate  int `json:"rate,omitempty"`
+	Quota int `json:"quota,omitempty"`
+	// User is the ID of whose library the token reads and saves to, the
+	// instance's when "".
+	User string `json:"user,omitempty"`
+}
+
+func (tok *apiToken) rate() int {
+	if tok.Rate != 0 {
+		return tok.Rate
+	}
+
+	return API_TOKEN_RATE
+}
+
+func (tok *apiToken) quota() int {
+	if tok.Quota != 0 {
+		return tok.Quota
+	}
+
+	return API_TOKEN_QUOTA
+}
+
+var (
+	apiTokensMu sync.RWMutex
+	// apiTokens are the tokens by hash.
+	apiTokens = make(map[string]*apiToken)
+	// tokenLimiters enforce the tokens' rates, by ID.
+	tokenLimiters = make(map[string]*rate.Limiter)
+)
+
+const apiTokenPrefix = "rdt_"
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func loadAPITokens() {
+	tokens, err := store.APITokens()
+	if err != nil {
+		slog.Error("failed to load API tokens", "err", err)
+		return
+	}
+
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+
+	for _, tok := range tokens {
+		apiTokens[tok.Hash] = tok
+	}
+}
+
+// usageDay is the day requests are counted in for quotas, in UTC.
+func usageDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// newAPIToken stores a token named name for the user userID, returning it.
+// It can't be seen again afterwards.
+func newAPIToken(userID, name string, rate, quota int) (string, error) {
+	token := apiTokenPrefix + randomID(20)
+	tok := &apiToken{
+		ID:        randomID(4),
+		Name:      name,
+		Hash:      hashAPIToken(token),
+		CreatedAt: time.Now(),
+		Rate:      rate,
+		Quota:     quota,
+		User:      userID,
+	}
+	if err := store.SetAPIToken(tok); err != nil {
+		return "", err
+	}
+
+	apiTokensMu.Lock()
+	apiTokens[tok.Hash] = tok
+	apiTokensMu.Unlock()
+
+	return token, nil
+}
+
+func deleteAPIToken(id string) error {
+	if err := store.DeleteAPIToken(id); err != nil {
+		return err
+	}
+
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+
+	for hash, tok := range apiTokens {
+		if tok.ID == id {
+			delete(apiTokens, hash)
+		}
+	}
+	delete(tokenLimiters, id)
+
+	return nil
+}
+
+func tokenLimiter(tok *apiToken) *rate.Limiter {
+	apiTokensMu.Lock()
+	defer apiTokensMu.Unlock()
+
+	l, ok := tokenLimiters[tok.ID]
+	if !ok {
+		l = rate.NewLimiter(rate.Limit(float64(tok.rate())/60), tok.rate())
+		tokenLimiters[tok.ID] = l
+	}
+
+	return l
+}
+
+// useAPIToken counts a request made with tok, returning why it was refused
+// and when to try again when it's over its rate or quota.
+func useAPIToken(tok *apiToken) (string, time.Duration) {
+	if tok.rate() > 0 {
+		r := tokenLimiter(tok).Reserve()
+		if delay := r.Delay(); delay > 0 {
+			r.Cancel()
+			return fmt.Sprintf("more than %d requests a minute", tok.rate()), delay
+		}
+	}
+
+	now := time.Now()
+	n, err := store.IncrTokenUsage(tok.ID, usageDay(now))
+	if err != nil {
+		slog.Error("failed to count API token usage", "err", err)
+		return "", 0
+	}
+	if quota := tok.quota(); quota > 0 && n > int64(quota) {
+		midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return fmt.Sprintf("daily quota of %d requests used up", quota), midnight.Sub(now)
+	}
+
+	return "", 0
+}
+
+// tokenRequired reports whether API_TOKEN_REQUIRED asks for a token on r.
+func tokenRequired(r *http.Request) bool {
+	switch API_TOKEN_REQUIRED {
+	case "true":
+		return true
+	case "writes":
+		return r.Method != http.MethodGet && r.Method != http.MethodHead
+	}
+
+	return false
+}
+
+// listAPITokens returns the tokens of the user userID, oldest first.
+func listAPITokens(userID string) []*apiToken {
+	apiTokensMu.RLock()
+	defer apiTokensMu.RUnlock()
+
+	tokens := make([]*apiToken, 0, len(apiTokens))
+	for _, tok := range apiTokens {
+		if tok.User == userID {
+			tokens = append(tokens, tok)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+
+	return tokens
+}
+
+func ownsAPIToken(userID, id string) bool {
+	for _, tok := range listAPITokens(userID) {
+		if tok.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func lookupAPIToken(token string) (*apiToken, bool) {
+	apiTokensMu.RLock()
+	defer apiTokensMu.RUnlock()
+
+	tok, ok := apiTokens[hashAPIToken(token)]
+	return tok, ok
+}
+
+// requestAPIToken is the token of a request, in a bearer Authorization
+// header or, where headers can't be set, ?token=.
+func requestAPIToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+
+	return r.URL.Query().Get("token")
+}
+
+// apiAccess checks the API token of requests to /api/v1/ and lets pages on
+// other origins, like a browser extension's, call it with one. Responses
+// to requests without a token have no CORS headers, but for CORS_ORIGINS,
+// so other sites open in the browser can't read an instance on a private
+// network.
+func apiAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/v1/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			corsPreflight(w, r)
+			return
+		}
+
+		token := requestAPIToken(r)
+		setCORS(w, r, token != "")
+		if token == "" {
+			if tokenRequired(r) {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "API token required"})
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tok, ok := lookupAPIToken(token)
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid API token"})
+			return
+		}
+
+		if msg, after := useAPIToken(tok); msg != "" {
+			writeTooManyRequests(w, msg, after, true)
+			return
+		}
+
+		lib := instanceLib
+		if tok.User != "" {
+			var err error
+			if lib, err = userLibrary(tok.User); err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+
+		next.ServeHTTP(w, withLibrary(r, lib))
+	})
+}
+
+type tokenUsage struct {
+	*apiToken
+	Today, Total int64
+}
+
+func apiTokenUsage(userID string) []tokenUsage {
+	today := usageDay(time.Now())
+
+	var usage []tokenUsage
+	for _, tok := range listAPITokens(userID) {
+		u := tokenUsage{apiToken: tok}
+		days, err := store.TokenUsage(tok.ID)
+		if err != nil {
+			slog.Error("failed to get API token usage", "err", err)
+		}
+		for day, n := range days {
+			if day == today {
+				u.Today = n
+			}
+			u.Total += n
+		}
+		usage = append(usage, u)
+	}
+
+	return usage
+}
+
+// tokensHandler lists the API tokens with their usage and creates or
+// revokes them, the signed in user's with MULTI_USER. A new token is shown
+// once. A single-user instance's tokens are its admin's.
+func tokensHandler(w http.ResponseWriter, r *http.Request) {
+	if !MULTI_USER && !adminAllowed(w, r) {
+		return
+	}
+
+	var errMsg, created string
+	userID := libraryFor(r).User
+
+	if r.Method == http.MethodPost {
+		if id := r.FormValue("delete"); id != "" {
+			if !ownsAPIToken(userID, id) {
+				errMsg = "no such token"
+			} else if err := deleteAPIToken(id); err != nil {
+				errMsg = err.Error()
+			} else {
+				http.Redirect(w, r, "/tokens", http.StatusSeeOther)
+				return
+			}
+		} else {
+			name := strings.TrimSpace(r.FormValue("name"))
+			if name == "" {
+				name = "Unnamed"
+			}
+			rate, _ := strconv.Atoi(r.FormValue("rate"))
+			quota, _ := strconv.Atoi(r.FormValue("quota"))
+			token, err := newAPIToken(userID, name, rate, quota)
+			if err != nil {
+				errMsg = err.Error()
+			}
+			created = token
+		}
+	}
+
+	executePage(w, r, "tokens.html", map[string]interface{}{
+		"Tokens":   apiTokenUsage(userID),
+		"Created":  created,
+		"Required": API_TOKEN_REQUIRED,
+		"Rate":     API_TOKEN_RATE,
+		"Quota":    API_TOKEN_QUOTA,
+		"Error":    errMsg,
+	})
+}
+
+// tokensCommand manages the API tokens from the command line:
+//
+//	readability tokens list [-user name]
+//	readability tokens create [-user name] [-rate n] [-quota n] name
+//	readability tokens revoke id
+func tokensCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: tokens list|create|revoke")
+	}
+
+	fs := flag.NewFlagSet("tokens "+args[0], flag.ExitOnError)
+	name := fs.String("user", "", "the user whose library the tokens are for, the instance's when empty")
+	owner := func() (string, error) {
+		if *name == "" {
+			return "", nil
+		}
+		return userID(*name)
+	}
+
+	switch args[0] {
+	case "list":
+		fs.Parse(args[1:])
+		id, err := owner()
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tRATE/MIN\tQUOTA/DAY\tTODAY\tTOTAL\tCREATED")
+		for _, u := range apiTokenUsage(id) {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%s\n", u.ID, u.Name, u.rate(), u.quota(), u.Today, u.Total, u.CreatedAt.Format(time.RFC3339))
+		}
+		return tw.Flush()
+	case "create":
+		rate := fs.Int("rate", 0, "requests a minute, API_TOKEN_RATE when 0")
+		quota := fs.Int("quota", 0, "requests a day, API_TOKEN_QUOTA when 0")
+		fs.Parse(args[1:])
+		id, err := owner()
+		if err != nil {
+			return err
+		}
+
+		token, err := newAPIToken(id, strings.Join(fs.Args(), " "), *rate, *quota)
+		if err != nil {
+			return err
+		}
+		fmt.Println(token)
+		return nil
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New("usage: tokens revoke id")
+		}
+		return deleteAPIToken(args[1])
+	}
+
+	return fmt.Errorf("unknown tokens command: %s", args[0])
+}
+
+var apiArticleOp = apiOperation{
+	Summary:     "Read an article",
+	Description: "Reads the article at url, extracting and saving it when it isn't yet.",
+	Tag:         "articles",
+	Query:       []apiParam{{Name: "url", Description: "The page to read.", Required: true}},
+	Response:    articleJSON{},
+	Errors:      []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusBadGateway},
+}
+
+// apiArticleHandler reads the article at ?url=, saving it when it isn't yet,
+// as JSON.
+func apiArticleHandler(w http.ResponseWriter, r *http.Request) {
+	uri := cleanURL(r.URL.Query().Get("url"))
+	if uri == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "url is required"})
+		return
+	}
+
+	writeArticle(w, r, readabyFormURL(uri, readOptions{lib: libraryFor(r), ctx: r.Context()}), readOptions{Format: "json"})
+}
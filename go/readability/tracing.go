@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// OTEL_EXPORTER_OTLP_ENDPOINT, or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT,
+	// turns tracing on, exporting the spans over OTLP/HTTP there. The
+	// exporter and the SDK read the other OTEL_* variables themselves, e.g.
+	// OTEL_SERVICE_NAME and OTEL_TRACES_SAMPLER.
+	tracingEnabled = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+
+	tracer         = otel.Tracer("github.com/abcdlsj/share/go/readability")
+	tracerProvider *sdktrace.TracerProvider
+)
+
+func setupTracing() error {
+	if !tracingEnabled {
+		return nil
+	}
+
+	ctx := context.Background()
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("readability")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return err
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return nil
+}
+
+// traceHTTP starts a span for every request to next, under the caller's
+// when it sends a traceparent, except the quietPaths.
+func traceHTTP(next http.Handler) http.Handler {
+	if !tracingEnabled {
+		return next
+	}
+
+	return otelhttp.NewHandler(next, "http.request", otelhttp.WithFilter(func(r *http.Request) bool {
+		for _, path := range quietPaths {
+			if r.URL.Path == path {
+				return false
+			}
+		}
+		return true
+	}))
+}
+
+// routeSpanName names a request's span after the route it matched, so
+// /read/{url} requests are one operation instead of one each.
+func routeSpanName(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				span := trace.SpanFromContext(r.Context())
+				span.SetName(r.Method + " " + tmpl)
+				span.SetAttributes(semconv.HTTPRoute(tmpl))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// traceTransport has the requests sent through rt spanned, when they are
+// sent for a traced request. Fetches outside one, images or the headless
+// extractor, aren't traces of their own.
+func traceTransport(rt http.RoundTripper) http.RoundTripper {
+	if !tracingEnabled {
+		return rt
+	}
+
+	return otelhttp.NewTransport(rt, otelhttp.WithFilter(func(r *http.Request) bool {
+		return trace.SpanContextFromContext(r.Context()).IsValid()
+	}))
+}
+
+// withContext is lib with its Redis commands sent with ctx's values, and
+// spanned under its span, but not canceled with it: an article being saved
+// when its reader goes away is still saved. The other storages aren't
+// traced.
+func (lib *library) withContext(ctx context.Context) *library {
+	if ps, ok := lib.store.(publicStorage); ok {
+		return (&library{User: lib.User, store: ps.Storage, index: lib.index}).withContext(ctx).publicView()
+	}
+
+	s, ok := lib.store.(*redisStorage)
+	if !ok {
+		return lib
+	}
+
+	ts := *s
+	ts.ctx = context.WithoutCancel(ctx)
+	return &library{User: lib.User, store: &ts, index: lib.index}
+}
+
+// redisTracing starts a span for every command and pipeline sent with the
+// context of a traced request.
+type redisTracing struct{}
+
+func (redisTracing) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (redisTracing) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		return traceRedis(ctx, cmd.Name(), func(ctx context.Context) error {
+			return next(ctx, cmd)
+		})
+	}
+}
+
+func (redisTracing) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		return traceRedis(ctx, "pipeline", func(ctx context.Context) error {
+			return next(ctx, cmds)
+		})
+	}
+}
+
+func traceRedis(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return fn(ctx)
+	}
+
+	ctx, span := tracer.Start(ctx, "redis "+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperation(op)))
+	defer span.End()
+
+	err := fn(ctx)
+	if err != nil && err != redis.Nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
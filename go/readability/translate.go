@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	// TRANSLATE turns on the translation of articles, by deepl, DeepL's
+	// API, or libretranslate, a LibreTranslate server, at TRANSLATE_URL
+	// with TRANSLATE_API_KEY.
+	TRANSLATE         = envOr("TRANSLATE", "")
+	TRANSLATE_URL     = envOr("TRANSLATE_URL", "")
+	TRANSLATE_API_KEY = envOr("TRANSLATE_API_KEY", "")
+	// TRANSLATE_LANGUAGES are the languages articles may be translated
+	// into, comma separated codes.
+	TRANSLATE_LANGUAGES = splitList(envOr("TRANSLATE_LANGUAGES", "en,de,fr,es,it,ja,zh"))
+
+	translateClient = &http.Client{Timeout: 2 * time.Minute}
+
+	translator     = newTranslator()
+	translateGroup singleflight.Group
+)
+
+var errTranslateOff = errors.New("translation is off, see TRANSLATE")
+
+// translation is an article translated into Lang, from the content whose
+// hash is SourceHash.
+type translation struct {
+	Lang         string
+	Title        string
+	Content      string
+	SourceHash   string
+	TranslatedAt time.Time
+}
+
+// translationBackend translates text, HTML when html is set, into lang.
+type translationBackend interface {
+	Translate(ctx context.Context, text, lang string, html bool) (string, error)
+}
+
+func newTranslator() translationBackend {
+	switch TRANSLATE {
+	case "deepl":
+		url := TRANSLATE_URL
+		if url == "" {
+			// The keys of the free API end with :fx.
+			url = "https://api.deepl.com"
+			if strings.HasSuffix(TRANSLATE_API_KEY, ":fx") {
+				url = "https://api-free.deepl.com"
+			}
+		}
+		return &deepL{url: strings.TrimSuffix(url, "/")}
+	case "libretranslate":
+		return &libreTranslate{url: strings.TrimSuffix(envDefault(TRANSLATE_URL, "http://localhost:5000"), "/")}
+	}
+
+	return nil
+}
+
+type deepL struct {
+	url string
+}
+
+func (t *deepL) Translate(ctx context.Context, text, lang string, html bool) (string, error) {
+	body := map[string]interface{}{
+		"text":        []string{text},
+		"target_lang": strings.ToUpper(lang),
+	}
+	if html {
+		body["tag_handling"] = "html"
+	}
+
+	var resp struct {
+		Translations []struct {
+			Text string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := translateCall(ctx, t.url+"/v2/translate", "DeepL-Auth-Key "+TRANSLATE_API_KEY, body, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Translations) == 0 {
+		return "", errors.New("translate: no translation in the response")
+	}
+
+	return resp.Translations[0].Text, nil
+}
+
+type libreTranslate struct {
+	url string
+}
+
+func (t *libreTranslate) Translate(ctx context.Context, text, lang string, html bool) (string, error) {
+	body := map[string]string{
+		"q":      text,
+		"source": "auto",
+		"target": strings.ToLower(lang),
+		"format": "text",
+	}
+	if html {
+		body["format"] = "html"
+	}
+	if TRANSLATE_API_KEY != "" {
+		body["api_key"] = TRANSLATE_API_KEY
+	}
+
+	var resp struct {
+		TranslatedText string `json:"translatedText"`
+	}
+	if err := translateCall(ctx, t.url+"/translate", "", body, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.TranslatedText, nil
+}
+
+// translateCall posts body as JSON to url, decoding the answer into out.
+func translateCall(ctx context.Context, url, auth string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := translateClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// DeepL answers {"message": ...}, LibreTranslate {"error": ...}.
+		var e struct {
+			Message string          `json:"message"`
+			Error   json.RawMessage `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		if msg := e.Message + errorMessage(e.Error); msg != "" {
+			return fmt.Errorf("translate: %s: %s", resp.Status, msg)
+		}
+		return fmt.Errorf("translate: %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// translationLanguage is lang as one of TRANSLATE_LANGUAGES, "" when it
+// isn't one.
+func translationLanguage(lang string) string {
+	for _, l := range TRANSLATE_LANGUAGES {
+		if strings.EqualFold(l, lang) {
+			return l
+		}
+	}
+
+	return ""
+}
+
+// translate returns art, stored under key, translated into lang, stored
+// once translated until the article's content changes.
+func (lib *library) translate(ctx context.Context, key string, art *article, lang string) (*translation, error) {
+	if translator == nil {
+		return nil, errTranslateOff
+	}
+	if lang = translationLanguage(lang); lang == "" {
+		return nil, fmt.Errorf("translate: the languages are %s", strings.Join(TRANSLATE_LANGUAGES, ", "))
+	}
+
+	hash := art.hash()
+	tr, err := lib.store.Translation(key, lang)
+	if err != nil {
+		return nil, err
+	}
+	if tr != nil && tr.SourceHash == hash {
+		return tr, nil
+	}
+
+	v, err, _ := translateGroup.Do(lib.User+"\x00"+key+"\x00"+lang, func() (interface{}, error) {
+		title, err := translator.Translate(ctx, art.Title, lang, false)
+		if err != nil {
+			return nil, err
+		}
+		content, err := translator.Translate(ctx, art.Content, lang, true)
+		if err != nil {
+			return nil, err
+		}
+
+		tr := &translation{Lang: lang, Title: title, Content: content, SourceHash: hash, TranslatedAt: time.Now()}
+		if err := lib.store.SetTranslation(key, tr); err != nil {
+			slog.ErrorContext(ctx, "failed to store translation", "key", key, "lang", lang, "err", err)
+		}
+
+		return tr, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*translation), nil
+}
+
+type translationResponse struct {
+	URL     string `json:"url"`
+	Lang    string `json:"lang"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+}
+
+var apiTranslateOp = apiOperation{
+	Summary:     "Translate an article",
+	Description: "The saved article translated into lang, one of TRANSLATE_LANGUAGES, by the TRANSLATE backend, translated once and stored with the article until it changes.",
+	Tag:         "articles",
+	Query: []apiParam{
+		{Name: "url", Description: "The article's URL.", Required: true},
+		{Name: "lang", Description: "The language to translate into, e.g. de.", Required: true},
+	},
+	Response: translationResponse{},
+	Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotImplemented, http.StatusBadGateway},
+}
+
+func apiTranslateHandler(w http.ResponseWriter, r *http.Request) {
+	uri, lang := r.URL.Query().Get("url"), r.URL.Query().Get("lang")
+	if uri == "" || lang == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing url or lang"})
+		return
+	}
+	if translator == nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": errTranslateOff.Error()})
+		return
+	}
+	if translationLanguage(lang) == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "lang is one of " + strings.Join(TRANSLATE_LANGUAGES, ", ")})
+		return
+	}
+
+	lib := libraryFor(r)
+	key := lib.resolveKey(uri)
+	art, err := lib.store.GetArticle(key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if art == nil || art.ErrMsg != "" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": errArticleNotFound.Error()})
+		return
+	}
+
+	tr, err := lib.translate(r.Context(), key, art, lang)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to translate article", "url", uri, "lang", lang, "err", err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, translationResponse{URL: uri, Lang: tr.Lang, Title: tr.Title, Content: sanitizeContent(tr.Content)})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// TRENDING_WINDOW is how many days of views count towards trending,
+	// 0 ranks by views of all time.
+	TRENDING_WINDOW = envInt("TRENDING_WINDOW", 7)
+	// TRENDING_HALF_LIFE is the age at which a view counts half as much.
+	TRENDING_HALF_LIFE = envDuration("TRENDING_HALF_LIFE", 48*time.Hour)
+)
+
+const trendingSize = 20
+
+// trendingWeights returns the weight of the views of each of the last days,
+// decaying by half every TRENDING_HALF_LIFE.
+func trendingWeights(days int) []float64 {
+	if days <= 0 {
+		return nil
+	}
+	if days > viewRetentionDays {
+		days = viewRetentionDays
+	}
+
+	weights := make([]float64, days)
+	for i := range weights {
+		weights[i] = 1
+		if TRENDING_HALF_LIFE > 0 {
+			age := time.Duration(i) * 24 * time.Hour
+			weights[i] = math.Pow(0.5, float64(age)/float64(TRENDING_HALF_LIFE))
+		}
+	}
+
+	return weights
+}
+
+func trendingArticles(r *http.Request) (int, []archiveEntry, error) {
+	days := TRENDING_WINDOW
+	if v, err := strconv.Atoi(r.URL.Query().Get("window")); err == nil {
+		days = v
+	}
+	if days > viewRetentionDays {
+		days = viewRetentionDays
+	}
+
+	lib := libraryFor(r)
+	keys, err := lib.store.TrendingArticles(trendingSize, trendingWeights(days))
+	if err != nil {
+		return days, nil, err
+	}
+
+	entries := make([]archiveEntry, 0, len(keys))
+	for _, key := range keys {
+		if art, err := lib.store.GetArticle(key); err == nil && art != nil {
+			entries = append(entries, lib.newArchiveEntry(key, art))
+		}
+	}
+
+	return days, entries, nil
+}
+
+func trendingHandler(w http.ResponseWriter, r *http.Request) {
+	days, entries, err := trendingArticles(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	executePage(w, r, "trending.html", map[string]interface{}{
+		"Window":   days,
+		"Articles": entries,
+	})
+}
+
+type trendingResult struct {
+	URL    string `json:"url"`
+	Title  string `json:"title"`
+	Domain string `json:"domain"`
+	Views  int64  `json:"views"`
+}
+
+type trendingResponse struct {
+	Window  int              `json:"window"`
+	Results []trendingResult `json:"results"`
+}
+
+var apiTrendingOp = apiOperation{
+	Summary:  "List the most read articles",
+	Tag:      "library",
+	Query:    []apiParam{{Name: "window", Description: "How many days to count the views of.", Type: "integer"}},
+	Response: trendingResponse{},
+	Errors:   []int{http.StatusInternalServerError},
+}
+
+func apiTrendingHandler(w http.ResponseWriter, r *http.Request) {
+	days, entries, err := trendingArticles(r)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	results := make([]trendingResult, 0, len(entries))
+	for _, entry := range entries {
+		results = append(results, trendingResult{URL: entry.URL, Title: entry.Title, Domain: entry.Domain, Views: entry.Views})
+	}
+
+	writeJSON(w, http.StatusOK, trendingResponse{Window: days, Results: results})
+}
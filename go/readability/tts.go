@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	// TTS turns on the narration of articles, by openai, OpenAI's speech
+	// API or one compatible with it at TTS_URL, or command, TTS_COMMAND
+	// given the text on stdin and writing the audio, of TTS_CONTENT_TYPE,
+	// on stdout, e.g. espeak-ng --stdout or piper.
+	TTS              = envOr("TTS", "")
+	TTS_URL          = strings.TrimSuffix(envOr("TTS_URL", "https://api.openai.com/v1"), "/")
+	TTS_API_KEY      = envOr("TTS_API_KEY", "")
+	TTS_MODEL        = envOr("TTS_MODEL", "tts-1")
+	TTS_VOICE        = envOr("TTS_VOICE", "alloy")
+	TTS_COMMAND      = envOr("TTS_COMMAND", "")
+	TTS_CONTENT_TYPE = envOr("TTS_CONTENT_TYPE", "audio/wav")
+	// TTS_MAX_CHARS is how much of an article is narrated.
+	TTS_MAX_CHARS = envInt("TTS_MAX_CHARS", 50000)
+	// TTS_ON_SAVE narrates every article once saved, instead of the first
+	// time it's played.
+	TTS_ON_SAVE = envOr("TTS_ON_SAVE", "") == "true"
+
+	ttsClient = &http.Client{Timeout: 5 * time.Minute}
+
+	narrator     = newNarrator()
+	narrateGroup singleflight.Group
+)
+
+// openAISpeechInput is the most text the speech API takes at once, longer
+// articles are narrated in parts whose MP3s are joined.
+const openAISpeechInput = 4000
+
+// articleAudio is what's known of an article's narration, the audio is
+// stored on its own.
+type articleAudio struct {
+	ContentType string
+	Size        int
+	// SourceHash is the hash of the content narrated.
+	SourceHash string
+	CreatedAt  time.Time
+}
+
+// ttsBackend turns text into speech.
+type ttsBackend interface {
+	Speak(ctx context.Context, text string) (audio []byte, contentType string, err error)
+}
+
+func newNarrator() ttsBackend {
+	switch TTS {
+	case "openai":
+		return openAISpeech{}
+	case "command":
+		return commandSpeech{}
+	}
+
+	return nil
+}
+
+type openAISpeech struct{}
+
+func (openAISpeech) Speak(ctx context.Context, text string) ([]byte, string, error) {
+	var audio []byte
+	for _, part := range splitText(text, openAISpeechInput) {
+		data, err := openAISpeak(ctx, part)
+		if err != nil {
+			return nil, "", err
+		}
+		audio = append(audio, data...)
+	}
+
+	return audio, "audio/mpeg", nil
+}
+
+func openAISpeak(ctx context.Context, text string) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{
+		"model":           TTS_MODEL,
+		"voice":           TTS_VOICE,
+		"input":           text,
+		"response_format": "mp3",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, TTS_URL+"/audio/speech", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if TTS_API_KEY != "" {
+		req.Header.Set("Authorization", "Bearer "+TTS_API_KEY)
+	}
+
+	resp, err := ttsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error json.RawMessage `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		if msg := errorMessage(e.Error); msg != "" {
+			return nil, fmt.Errorf("tts: %s: %s", resp.Status, msg)
+		}
+		return nil, fmt.Errorf("tts: %s", resp.Status)
+	}
+
+	var audio bytes.Buffer
+	if _, err := audio.ReadFrom(resp.Body); err != nil {
+		return nil, err
+	}
+
+	return audio.Bytes(), nil
+}
+
+type commandSpeech struct{}
+
+func (commandSpeech) Speak(ctx context.Context, text string) ([]byte, string, error) {
+	args := strings.Fields(TTS_COMMAND)
+	if len(args) == 0 {
+		return nil, "", errors.New("tts: TTS=command needs TTS_COMMAND")
+	}
+
+	var out, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, "", fmt.Errorf("tts: %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	if out.Len() == 0 {
+		return nil, "", fmt.Errorf("tts: %s wrote no audio", args[0])
+	}
+
+	return out.Bytes(), TTS_CONTENT_TYPE, nil
+}
+
+// splitText cuts text into parts of at most n bytes, after a sentence when
+// there is one in the part, else after a word.
+func splitText(text string, n int) []string {
+	var parts []string
+	for len(text) > n {
+		end := strings.LastIndexAny(text[:n], ".!?\n") + 1
+		if end < n/2 {
+			end = strings.LastIndex(text[:n], " ") + 1
+		}
+		if end == 0 {
+			// Not a space in sight, cut at the start of a rune.
+			end = n
+			for end > 0 && !utf8.RuneStart(text[end]) {
+				end--
+			}
+		}
+		if part := strings.TrimSpace(text[:end]); part != "" {
+			parts = append(parts, part)
+		}
+		text = text[end:]
+	}
+	if text = strings.TrimSpace(text); text != "" {
+		parts = append(parts, text)
+	}
+
+	return parts
+}
+
+// narrationText is what's read out of an article, its title and text.
+func narrationText(art *article) string {
+	return truncateRunes(art.Title+".\n\n"+htmlText(art.Content), TTS_MAX_CHARS)
+}
+
+// narration returns the audio of the article stored under key, narrating
+// it unless it's stored already for its content.
+func (lib *library) narration(ctx context.Context, key string, art *article) ([]byte, *articleAudio, error) {
+	if art.Audio != nil && art.Audio.SourceHash == art.hash() {
+		audio, err := lib.store.Audio(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if audio != nil {
+			return audio, art.Audio, nil
+		}
+	}
+
+	type narrated struct {
+		audio []byte
+		info  *articleAudio
+	}
+	v, err, _ := narrateGroup.Do(lib.User+"\x00"+key, func() (interface{}, error) {
+		audio, contentType, err := narrator.Speak(ctx, narrationText(art))
+		if err != nil {
+			return nil, err
+		}
+
+		info := &articleAudio{ContentType: contentType, Size: len(audio), SourceHash: art.hash(), CreatedAt: time.Now()}
+		if err := lib.store.SetAudio(key, audio); err != nil {
+			return nil, err
+		}
+		if err := lib.store.UpdateArticle(key, func(art *article) { art.Audio = info }); err != nil {
+			return nil, err
+		}
+		slog.InfoContext(ctx, "narrated article", "key", key, "bytes", len(audio))
+
+		return narrated{audio, info}, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	n := v.(narrated)
+
+	return n.audio, n.info, nil
+}
+
+// audioHandler serves the narration of an article, narrating it the first
+// time.
+func audioHandler(w http.ResponseWriter, r *http.Request) {
+	uri, ok := decodePathURL(mux.Vars(r)["url"])
+	if !ok || narrator == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	lib := libraryFor(r)
+	key := lib.resolveKey(uri)
+	art, err := lib.store.GetArticle(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if art == nil || art.ErrMsg != "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	audio, info, err := lib.narration(r.Context(), key, art)
+	if errors.Is(err, exec.ErrNotFound) {
+		http.Error(w, "narration needs TTS_COMMAND, not installed here", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to narrate article", "key", key, "err", err)
+		http.Error(w, "failed to narrate the article: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeContent(w, r, "", info.CreatedAt, bytes.NewReader(audio))
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int    `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// podcastHandler is an RSS feed of the narrated articles, for podcast
+// apps.
+func podcastHandler(w http.ResponseWriter, r *http.Request) {
+	if narrator == nil {
+		http.NotFound(w, r)
+		return
+	}
+	base := baseURL(r)
+
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       "Readability",
+			Link:        base + "/",
+			Description: "Recently read articles, narrated",
+		},
+	}
+
+	for _, art := range feedArticles(libraryFor(r), feedSize) {
+		if art.Audio == nil || art.Audio.SourceHash != art.hash() {
+			continue
+		}
+		link := base + articlePath("read", art.URL)
+		item := rssItem{
+			Title:       art.Title,
+			Link:        link,
+			GUID:        link + "/audio",
+			Description: excerpt(htmlText(art.Content), 500),
+			Enclosure:   &rssEnclosure{URL: link + "/audio", Length: art.Audio.Size, Type: art.Audio.ContentType},
+			PubDate:     art.Audio.CreatedAt.Format(time.RFC1123Z),
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	writeXML(w, "application/rss+xml", feed)
+}
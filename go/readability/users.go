@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// MULTI_USER=true gives everyone signing in a library of their own,
+	// with its own recents, favorites, tags and views. It is on by default
+	// once users can sign in with a provider.
+	MULTI_USER = envOr("MULTI_USER", strconv.FormatBool(oauthConfigured())) == "true"
+	// SIGNUP=closed leaves adding accounts to the users command.
+	SIGNUP = envOr("SIGNUP", "open")
+	// SESSION_SECRET signs the session cookies, without it sessions don't
+	// survive a restart.
+	SESSION_SECRET = envOr("SESSION_SECRET", "")
+)
+
+const (
+	sessionCookie   = "readability-session"
+	sessionDuration = 30 * 24 * time.Hour
+)
+
+var userName = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// user is an account, its library is stored under its ID.
+type user struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	PasswordHash []byte    `json:"password_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+	// DisplayName is shown instead of Name, for users signing in with a
+	// provider.
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+func (u *user) displayName() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+
+	return u.Name
+}
+
+// session is who signed in with a session cookie.
+type session struct {
+	ID, Name string
+}
+
+type sessionContextKey struct{}
+
+var sessionKey = func() []byte {
+	if SESSION_SECRET != "" {
+		return []byte(SESSION_SECRET)
+	}
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	if MULTI_USER {
+		slog.Warn("SESSION_SECRET is not set, sessions end on restart")
+	}
+	return key
+}()
+
+func signSession(payload string) string {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newUser stores an account named name, with the password hashed.
+func newUser(name, password string) (*user, error) {
+	if !userName.MatchString(name) {
+		return nil, errors.New("names are up to 32 lowercase letters, digits, - and _")
+	}
+	if len(password) < 8 {
+		return nil, errors.New("passwords are at least 8 characters")
+	}
+
+	existing, err := store.GetUser(name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("%s is taken", name)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &user{ID: randomID(8), Name: name, PasswordHash: hash, CreatedAt: time.Now()}
+	if err := store.SetUser(u); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// authenticate returns the user named name when password is theirs.
+func authenticate(name, password string) (*user, error) {
+	u, err := store.GetUser(name)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil || bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(password)) != nil {
+		return nil, errors.New("wrong name or password")
+	}
+
+	return u, nil
+}
+
+// setSession signs u in, in a cookie holding their ID, name and when it
+// expires, signed with SESSION_SECRET.
+func setSession(w http.ResponseWriter, r *http.Request, u *user) {
+	expires := time.Now().Add(sessionDuration)
+	name := base64.RawURLEncoding.EncodeToString([]byte(u.displayName()))
+	payload := u.ID + "." + name + "." + strconv.FormatInt(expires.Unix(), 10)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    payload + "." + signSession(payload),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(baseURL(r), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// requestSession returns who signed in on r, when the cookie is theirs and
+// hasn't expired.
+func requestSession(r *http.Request) (session, bool) {
+	if s, ok := r.Context().Value(sessionContextKey{}).(session); ok {
+		return s, true
+	}
+
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return session{}, false
+	}
+
+	parts := strings.Split(c.Value, ".")
+	if len(parts) != 4 {
+		return session{}, false
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(signSession(payload))) {
+		return session{}, false
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return session{}, false
+	}
+
+	name, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return session{}, false
+	}
+
+	return session{ID: parts[0], Name: string(name)}, true
+}
+
+// userPublic are the paths reachable without signing in, those signing in,
+// the assets and the endpoints checking their callers themselves.
+var userPublic = []string{"/login", "/signup", "/auth/", "/metrics", "/healthz", "/readyz", "/static/", "/themes/", "/katex/", "/img/", "/integrations/", "/oauth/", "/s/", "/api/"}
+
+// userAccess has MULTI_USER requests signed in, reading and saving to the
+// library of whoever did. API requests may use a token instead, apiAccess
+// sets its owner's library.
+func userAccess(next http.Handler) http.Handler {
+	if !MULTI_USER {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(libraryContextKey{}).(*library); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if s, ok := requestSession(r); ok {
+			lib, err := userLibrary(s.ID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			ctx := context.WithValue(r.Context(), sessionContextKey{}, s)
+			next.ServeHTTP(w, withLibrary(r.WithContext(ctx), lib))
+			return
+		}
+
+		for _, prefix := range userPublic {
+			if strings.HasPrefix(r.URL.Path, prefix) && !strings.HasPrefix(r.URL.Path, "/api/v1/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/api/v1/") {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "sign in or use an API token"})
+			return
+		}
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+	})
+}
+
+// loginHandler signs in with a name and password, and signupHandler makes
+// an account first while SIGNUP is open.
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	userForm(w, r, false)
+}
+
+func signupHandler(w http.ResponseWriter, r *http.Request) {
+	if SIGNUP != "open" {
+		http.Error(w, "signing up is closed", http.StatusForbidden)
+		return
+	}
+
+	userForm(w, r, true)
+}
+
+// ownerUser is who signs in to a single-user instance, with ADMIN_PASSWORD.
+var ownerUser = &user{ID: "owner", Name: "owner"}
+
+func userForm(w http.ResponseWriter, r *http.Request, signup bool) {
+	if !MULTI_USER && (signup || ADMIN_PASSWORD == "") {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if !MULTI_USER {
+		ownerForm(w, r)
+		return
+	}
+
+	next := safeBack(r.FormValue("next"))
+
+	var errMsg string
+	if r.Method == http.MethodPost {
+		name := strings.ToLower(strings.TrimSpace(r.FormValue("name")))
+		password := r.FormValue("password")
+
+		var u *user
+		var err error
+		if signup {
+			u, err = newUser(name, password)
+		} else {
+			u, err = authenticate(name, password)
+		}
+		if err == nil {
+			setSession(w, r, u)
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		errMsg = err.Error()
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	executePage(w, r, "login.html", map[string]interface{}{
+		"Signup":     signup,
+		"SignupOpen": SIGNUP == "open",
+		"Providers":  oauthProviders,
+		"Next":       next,
+		"Error":      errMsg,
+	})
+}
+
+// ownerForm signs the owner of a single-user instance in with
+// ADMIN_PASSWORD, to see and make private articles.
+func ownerForm(w http.ResponseWriter, r *http.Request) {
+	next := safeBack(r.FormValue("next"))
+
+	var errMsg string
+	if r.Method == http.MethodPost {
+		if secureEqual(r.FormValue("password"), ADMIN_PASSWORD) {
+			setSession(w, r, ownerUser)
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		errMsg = "wrong password"
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	executePage(w, r, "login.html", map[string]interface{}{
+		"Owner": true,
+		"Next":  next,
+		"Error": errMsg,
+	})
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// userID returns the ID of the user named name.
+func userID(name string) (string, error) {
+	u, err := store.GetUser(name)
+	if err != nil {
+		return "", err
+	}
+	if u == nil {
+		return "", fmt.Errorf("no user %s", name)
+	}
+
+	return u.ID, nil
+}
+
+// usersCommand manages the accounts from the command line, reading the
+// password from stdin:
+//
+//	readability users list
+//	readability users add name
+func usersCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: users list|add")
+	}
+
+	switch args[0] {
+	case "list":
+		users, err := store.Users()
+		if err != nil {
+			return err
+		}
+		sort.Slice(users, func(i, j int) bool {
+			return users[i].CreatedAt.Before(users[j].CreatedAt)
+		})
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tDISPLAY NAME\tCREATED")
+		for _, u := range users {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", u.ID, u.Name, u.displayName(), u.CreatedAt.Format(time.RFC3339))
+		}
+		return tw.Flush()
+	case "add":
+		if len(args) != 2 {
+			return errors.New("usage: users add name")
+		}
+
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && password == "" {
+			return err
+		}
+
+		u, err := newUser(args[1], strings.TrimRight(password, "\r\n"))
+		if err != nil {
+			return err
+		}
+		fmt.Println(u.ID)
+		return nil
+	}
+
+	return fmt.Errorf("unknown users command: %s", args[0])
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// A subset of the Wallabag v2 API, enough for the Wallabag apps to log in,
+// list, save, read and delete articles.
+
+var (
+	WALLABAG_CLIENT_ID     = envOr("WALLABAG_CLIENT_ID", "")
+	WALLABAG_CLIENT_SECRET = envOr("WALLABAG_CLIENT_SECRET", "")
+	WALLABAG_USERNAME      = envOr("WALLABAG_USERNAME", "")
+	WALLABAG_PASSWORD      = envOr("WALLABAG_PASSWORD", "")
+)
+
+const (
+	wallabagVersion    = "2.6.0"
+	wallabagTimeFormat = "2006-01-02T15:04:05-0700"
+	wallabagAccessTTL  = time.Hour
+	wallabagRefreshTTL = 30 * 24 * time.Hour
+)
+
+type wallabagEntry struct {
+	ID             int           `json:"id"`
+	URL            string        `json:"url"`
+	GivenURL       string        `json:"given_url"`
+	Title          string        `json:"title"`
+	Content        string        `json:"content"`
+	IsArchived     int           `json:"is_archived"`
+	IsStarred      int           `json:"is_starred"`
+	IsPublic       bool          `json:"is_public"`
+	CreatedAt      string        `json:"created_at"`
+	UpdatedAt      string        `json:"updated_at"`
+	ReadingTime    int           `json:"reading_time"`
+	DomainName     string        `json:"domain_name"`
+	MimeType       string        `json:"mimetype"`
+	Language       *string       `json:"language"`
+	PreviewPicture *string       `json:"preview_picture"`
+	PublishedAt    *string       `json:"published_at"`
+	PublishedBy    []string      `json:"published_by"`
+	HTTPStatus     string        `json:"http_status"`
+	UserName       string        `json:"user_name"`
+	UserID         int           `json:"user_id"`
+	Tags           []interface{} `json:"tags"`
+	Annotations    []interface{} `json:"annotations"`
+}
+
+// wallabagObject documents the responses shaped as wallabag's, see its
+// API documentation.
+var wallabagObject = map[string]interface{}{}
+
+func wallabagRoutes(r *mux.Router) {
+	root := documentedRoutes(r, "")
+	root.handle("POST", "/oauth/v2/token", wallabagTokenHandler, apiOperation{
+		Summary: "Get a wallabag token",
+		Tag:     "wallabag",
+		Form: []apiParam{
+			{Name: "grant_type", Description: "password or refresh_token.", Required: true},
+			{Name: "client_id", Required: true},
+			{Name: "client_secret", Required: true},
+			{Name: "username"},
+			{Name: "password"},
+			{Name: "refresh_token"},
+		},
+		Response: wallabagObject,
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound},
+		Security: "none",
+	})
+	root.handle("GET", "/api/version", wallabagVersionHandler, apiOperation{
+		Summary: "Get the wallabag version", Tag: "wallabag", Response: wallabagVersion, Security: "none",
+	})
+	root.handle("GET", "/api/info", wallabagInfoHandler, apiOperation{
+		Summary: "Get the wallabag instance's info", Tag: "wallabag", Response: wallabagObject, Security: "none",
+	})
+
+	api := r.PathPrefix("/api").Subrouter()
+	api.Use(wallabagAuth)
+	entries := documentedRoutes(api, "/api")
+	entries.handle("GET", "/user", wallabagUserHandler, apiOperation{
+		Summary: "Get the wallabag user", Tag: "wallabag", Response: wallabagObject, Security: "wallabag",
+	})
+	entries.handle("GET", "/entries", wallabagListHandler, apiOperation{
+		Summary: "List the entries",
+		Tag:     "wallabag",
+		Query: []apiParam{
+			{Name: "page", Type: "integer"},
+			{Name: "perPage", Type: "integer"},
+			{Name: "since", Description: "A Unix time.", Type: "integer"},
+			{Name: "starred", Description: "1 or 0."},
+			{Name: "order", Description: "asc or desc."},
+		},
+		Response: wallabagObject,
+		Errors:   []int{http.StatusInternalServerError},
+		Security: "wallabag",
+	})
+	entries.handle("POST", "/entries", wallabagCreateHandler, apiOperation{
+		Summary:  "Save an entry",
+		Tag:      "wallabag",
+		Form:     []apiParam{{Name: "url", Required: true}},
+		Response: wallabagEntry{},
+		Errors:   []int{http.StatusBadRequest, http.StatusBadGateway},
+		Security: "wallabag",
+	})
+	entries.handle("GET", "/entries/exists", wallabagExistsHandler, apiOperation{
+		Summary:  "Check whether an entry is saved",
+		Tag:      "wallabag",
+		Query:    []apiParam{{Name: "url", Required: true}},
+		Response: map[string]bool{"exists": false},
+		Security: "wallabag",
+	})
+	entries.handle("GET", "/entries/{id:[0-9]+}", wallabagGetHandler, apiOperation{
+		Summary: "Get an entry", Tag: "wallabag", Response: wallabagEntry{}, Errors: []int{http.StatusNotFound}, Security: "wallabag",
+	})
+	entries.handle("PATCH", "/entries/{id:[0-9]+}", wallabagPatchHandler, apiOperation{
+		Summary:  "Star or unstar an entry",
+		Tag:      "wallabag",
+		Form:     []apiParam{{Name: "starred", Description: "1 or 0."}},
+		Response: wallabagEntry{},
+		Errors:   []int{http.StatusNotFound, http.StatusInternalServerError},
+		Security: "wallabag",
+	})
+	entries.handle("DELETE", "/entries/{id:[0-9]+}", wallabagDeleteHandler, apiOperation{
+		Summary: "Delete an entry", Tag: "wallabag", Response: wallabagEntry{}, Errors: []int{http.StatusNotFound, http.StatusInternalServerError}, Security: "wallabag",
+	})
+	entries.handle("GET", "/entries/{id:[0-9]+}/tags", wallabagTagsHandler, apiOperation{
+		Summary: "List an entry's tags", Tag: "wallabag", Response: []map[string]interface{}{}, Errors: []int{http.StatusNotFound}, Security: "wallabag",
+	})
+}
+
+func wallabagEnabled() bool {
+	return WALLABAG_CLIENT_ID != "" && WALLABAG_CLIENT_SECRET != "" && WALLABAG_USERNAME != "" && WALLABAG_PASSWORD != ""
+}
+
+// wallabagToken signs kind and expiry with the client secret, tokens don't
+// need to be stored and survive restarts.
+func wallabagToken(kind string, ttl time.Duration) string {
+	payload := fmt.Sprintf("%s.%d", kind, time.Now().Add(ttl).Unix())
+
+	mac := hmac.New(sha256.New, []byte(WALLABAG_CLIENT_SECRET))
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func wallabagVerify(token, kind string) bool {
+	payload64, sig64, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(payload64)
+	if err != nil {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sig64)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(WALLABAG_CLIENT_SECRET))
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return false
+	}
+
+	gotKind, expiry, _ := strings.Cut(string(payload), ".")
+	exp, err := strconv.ParseInt(expiry, 10, 64)
+
+	return err == nil && gotKind == kind && time.Now().Unix() < exp
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func wallabagTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !wallabagEnabled() {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "wallabag api is not configured"})
+		return
+	}
+
+	if !secureEqual(r.FormValue("client_id"), WALLABAG_CLIENT_ID) || !secureEqual(r.FormValue("client_secret"), WALLABAG_CLIENT_SECRET) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	switch r.FormValue("grant_type") {
+	case "password":
+		if !secureEqual(r.FormValue("username"), WALLABAG_USERNAME) || !secureEqual(r.FormValue("password"), WALLABAG_PASSWORD) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+	case "refresh_token":
+		if !wallabagVerify(r.FormValue("refresh_token"), "refresh") {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  wallabagToken("access", wallabagAccessTTL),
+		"expires_in":    int(wallabagAccessTTL.Seconds()),
+		"token_type":    "bearer",
+		"scope":         nil,
+		"refresh_token": wallabagToken("refresh", wallabagRefreshTTL),
+	})
+}
+
+func wallabagAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = r.URL.Query().Get("access_token")
+		}
+
+		if !wallabagEnabled() || !wallabagVerify(token, "access") {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{
+				"error":             "invalid_grant",
+				"error_description": "The access token provided is invalid.",
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func wallabagVersionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, wallabagVersion)
+}
+
+func wallabagInfoHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"appname":                "wallabag",
+		"version":                wallabagVersion,
+		"allowed_registration":   false,
+		"features":               []string{},
+		"allowed_client_domains": []string{},
+	})
+}
+
+func wallabagUserHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":       1,
+		"username": WALLABAG_USERNAME,
+		"email":    "",
+		"name":     WALLABAG_USERNAME,
+	})
+}
+
+// wallabagID is the ID the Wallabag apps know the article stored under key
+// by, given in order the first time it's listed and kept in the storage.
+// It's 0 when the storage fails.
+func wallabagID(key string) int {
+	id, err := store.WallabagID(key)
+	if err != nil {
+		slog.Error("failed to get wallabag id", "key", key, "err", err)
+	}
+
+	return id
+}
+
+// wallabagFind returns the article with the Wallabag ID id and its key, nil
+// when there is none.
+func wallabagFind(id int) (string, *article) {
+	key, err := store.WallabagKey(id)
+	if err != nil || key == "" {
+		return "", nil
+	}
+
+	art, err := store.GetArticle(key)
+	if err != nil || art == nil {
+		return "", nil
+	}
+
+	return key, art
+}
+
+// wallabagTagID is the ID of tag, which isn't stored.
+func wallabagTagID(tag string) int {
+	h := fnv.New32a()
+	h.Write([]byte(tag))
+
+	return int(h.Sum32() & math.MaxInt32)
+}
+
+func wallabagFromArticle(key string, art *article) wallabagEntry {
+	domain := ""
+	if u, err := url.Parse(art.URL); err == nil {
+		domain = u.Hostname()
+	}
+
+	created := art.CreatedAt.Format(wallabagTimeFormat)
+
+	tags := []interface{}{}
+	for _, tag := range art.Tags {
+		tags = append(tags, wallabagTag(tag))
+	}
+
+	starred := 0
+	if art.Starred {
+		starred = 1
+	}
+
+	entry := wallabagEntry{
+		ID:          wallabagID(key),
+		URL:         art.URL,
+		GivenURL:    art.URL,
+		Title:       art.Title,
+		Content:     art.Content,
+		IsStarred:   starred,
+		CreatedAt:   created,
+		UpdatedAt:   created,
+		ReadingTime: len(strings.Fields(htmlText(art.Content))) / 200,
+		DomainName:  domain,
+		MimeType:    "text/html",
+		HTTPStatus:  "200",
+		UserName:    WALLABAG_USERNAME,
+		UserID:      1,
+		Tags:        tags,
+		Annotations: []interface{}{},
+	}
+	if art.Image != "" {
+		entry.PreviewPicture = &art.Image
+	}
+	if art.Language != "" {
+		entry.Language = &art.Language
+	}
+	if !art.PublishedAt.IsZero() {
+		published := art.PublishedAt.Format(wallabagTimeFormat)
+		entry.PublishedAt = &published
+	}
+	if art.Byline != "" {
+		entry.PublishedBy = []string{art.Byline}
+	}
+
+	return entry
+}
+
+func wallabagListHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := store.Keys()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("perPage"))
+	if perPage < 1 {
+		perPage = 30
+	}
+	since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	starred := r.URL.Query().Get("starred")
+
+	var arts []wallabagEntry
+	for _, key := range keys {
+		art, err := store.GetArticle(key)
+		if err != nil || art == nil || art.CreatedAt.Unix() < since {
+			continue
+		}
+		if (starred == "1" && !art.Starred) || (starred == "0" && art.Starred) {
+			continue
+		}
+		arts = append(arts, wallabagFromArticle(key, art))
+	}
+
+	if r.URL.Query().Get("order") == "asc" {
+		for i, j := 0, len(arts)-1; i < j; i, j = i+1, j-1 {
+			arts[i], arts[j] = arts[j], arts[i]
+		}
+	}
+
+	total := len(arts)
+	pages := (total + perPage - 1) / perPage
+	if pages == 0 {
+		pages = 1
+	}
+
+	start := (page - 1) * perPage
+	if start > total {
+		start = total
+	}
+	end := start + perPage
+	if end > total {
+		end = total
+	}
+
+	items := arts[start:end]
+	if items == nil {
+		items = []wallabagEntry{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"page":  page,
+		"limit": perPage,
+		"pages": pages,
+		"total": total,
+		"_links": map[string]interface{}{
+			"self": map[string]string{"href": r.URL.String()},
+		},
+		"_embedded": map[string]interface{}{
+			"items": items,
+		},
+	})
+}
+
+func wallabagCreateHandler(w http.ResponseWriter, r *http.Request) {
+	uri := r.FormValue("url")
+	if uri == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing url"})
+		return
+	}
+
+	art := readabyFormURL(uri, readOptions{ctx: r.Context()})
+	if art.ErrMsg != "" {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": art.ErrMsg})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, wallabagFromArticle(articleKey(art.URL), art))
+}
+
+func wallabagExistsHandler(w http.ResponseWriter, r *http.Request) {
+	art, err := store.GetArticle(instanceLib.resolveKey(r.URL.Query().Get("url")))
+
+	writeJSON(w, http.StatusOK, map[string]bool{"exists": err == nil && art != nil})
+}
+
+func wallabagEntry404(w http.ResponseWriter) {
+	writeJSON(w, http.StatusNotFound, map[string]interface{}{
+		"error": map[string]interface{}{"code": 404, "message": "Not Found"},
+	})
+}
+
+func wallabagGetHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	key, art := wallabagFind(id)
+	if art == nil {
+		wallabagEntry404(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, wallabagFromArticle(key, art))
+}
+
+// wallabagPatchHandler applies starred updates, archive isn't tracked by
+// this service and is ignored.
+func wallabagPatchHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	key, art := wallabagFind(id)
+	if art == nil {
+		wallabagEntry404(w)
+		return
+	}
+
+	if v := r.FormValue("starred"); v != "" {
+		art.Starred = v == "1"
+		if err := instanceLib.setStarred(key, art.Starred); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, wallabagFromArticle(key, art))
+}
+
+func wallabagDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	key, art := wallabagFind(id)
+	if art == nil {
+		wallabagEntry404(w)
+		return
+	}
+
+	if err := instanceLib.deleteArticle(key); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, wallabagFromArticle(key, art))
+}
+
+func wallabagTag(tag string) map[string]interface{} {
+	return map[string]interface{}{"id": wallabagTagID(tag), "label": tag, "slug": tag}
+}
+
+func wallabagTagsHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	_, art := wallabagFind(id)
+	if art == nil {
+		wallabagEntry404(w)
+		return
+	}
+
+	tags := []interface{}{}
+	for _, tag := range art.Tags {
+		tags = append(tags, wallabagTag(tag))
+	}
+
+	writeJSON(w, http.StatusOK, tags)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	// WATCH_INTERVAL is how often watched articles are re-fetched, checked
+	// for every WATCH_CHECK_INTERVAL. WATCH_MAX_VERSIONS earlier versions
+	// are kept of each.
+	WATCH_INTERVAL       = envDuration("WATCH_INTERVAL", 24*time.Hour)
+	WATCH_CHECK_INTERVAL = envDuration("WATCH_CHECK_INTERVAL", 10*time.Minute)
+	WATCH_MAX_VERSIONS   = envInt("WATCH_MAX_VERSIONS", 10)
+)
+
+// articleVersion is what an article was before a re-fetch changed it.
+type articleVersion struct {
+	Title     string
+	Content   string
+	WordCount int
+	// SavedAt is when this version was fetched.
+	SavedAt time.Time
+}
+
+func articleWatcher() {
+	if WATCH_CHECK_INTERVAL <= 0 {
+		return
+	}
+
+	for range time.Tick(WATCH_CHECK_INTERVAL) {
+		for _, lib := range allLibraries() {
+			lib.checkWatched()
+		}
+	}
+}
+
+// checkWatched re-fetches the watched articles last fetched over
+// WATCH_INTERVAL ago.
+func (lib *library) checkWatched() {
+	keys, err := lib.store.WatchedArticles()
+	if err != nil {
+		slog.Error("failed to list watched articles", "err", err)
+		return
+	}
+
+	for _, key := range keys {
+		art, err := lib.store.GetArticle(key)
+		if err != nil || art == nil {
+			continue
+		}
+
+		fetched := art.RefreshedAt
+		if fetched.IsZero() {
+			fetched = art.CreatedAt
+		}
+		if time.Since(fetched) < WATCH_INTERVAL {
+			continue
+		}
+
+		if err := lib.refetchWatched(key, art); err != nil {
+			slog.Error("failed to re-fetch watched article", "url", art.URL, "err", err)
+		}
+	}
+}
+
+// refetchWatched refreshes a watched article, keeping what it was as a
+// version and flagging it changed when its text is no longer the same.
+func (lib *library) refetchWatched(key string, old *article) error {
+	fresh := readabyFormURL(old.URL, readOptions{Refresh: true, lib: lib})
+	if fresh.ErrMsg != "" {
+		return fmt.Errorf("%s", fresh.ErrMsg)
+	}
+
+	if htmlText(fresh.Content) == htmlText(old.Content) {
+		return nil
+	}
+
+	saved := old.RefreshedAt
+	if saved.IsZero() {
+		saved = old.CreatedAt
+	}
+	err := lib.store.AddVersion(key, &articleVersion{
+		Title:     old.Title,
+		Content:   old.Content,
+		WordCount: old.WordCount,
+		SavedAt:   saved,
+	}, WATCH_MAX_VERSIONS)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("watched article changed", "url", old.URL)
+
+	return lib.store.UpdateArticle(key, func(art *article) {
+		art.Changed = true
+		art.ChangedAt = time.Now()
+	})
+}
+
+// changedArticles returns the watched articles with changes not read yet.
+func (lib *library) changedArticles() []*article {
+	keys, err := lib.store.WatchedArticles()
+	if err != nil {
+		slog.Error("failed to list watched articles", "err", err)
+		return nil
+	}
+
+	var arts []*article
+	for _, key := range keys {
+		if art, err := lib.store.GetArticle(key); err == nil && art != nil && art.Changed {
+			arts = append(arts, art)
+		}
+	}
+
+	return arts
+}
+
+// watchHandler watches the article in the url form field, or stops
+// watching it when watched is 0.
+func watchHandler(w http.ResponseWriter, r *http.Request) {
+	uri := r.FormValue("url")
+	if uri == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	lib := libraryFor(r)
+	if err := lib.store.SetWatched(lib.resolveKey(uri), r.FormValue("watched") != "0"); err != nil {
+		if err == errArticleNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, articlePath("read", uri), http.StatusSeeOther)
+}
+
+// versionHandler renders the n-th earlier version of an article, 0 being
+// the one before the current.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uri, ok := decodePathURL(vars["url"])
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	lib := libraryFor(r)
+	key := lib.resolveKey(uri)
+	art, err := lib.store.GetArticle(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	versions, err := lib.store.Versions(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	n, _ := strconv.Atoi(vars["n"])
+	if art == nil || n >= len(versions) {
+		http.NotFound(w, r)
+		return
+	}
+
+	v := versions[n]
+	art.Title, art.Content, art.WordCount = v.Title, v.Content, v.WordCount
+	art.ReadingTime = readingMinutes(v.WordCount)
+
+	render(w, r, &articlePage{
+		article: art,
+		Notice:  fmt.Sprintf("This is the article as fetched %s, it has changed since.", v.SavedAt.Format("2006-01-02 15:04")),
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// WAYBACK reads pages that are gone from the Wayback Machine.
+	WAYBACK         = envOr("WAYBACK", "true") != "false"
+	WAYBACK_API_URL = envOr("WAYBACK_API_URL", "https://archive.org/wayback/available")
+)
+
+const waybackTimestamp = "20060102150405"
+
+// waybackSnapshot returns the URL of the closest Wayback Machine snapshot
+// of uri, "" when it was never archived. The URL is the id_ form, the page
+// as archived without the Wayback Machine's toolbar and link rewriting.
+func waybackSnapshot(ctx context.Context, uri string) (string, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, WAYBACK_API_URL+"?url="+url.QueryEscape(uri), nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	resp, err := serviceClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("wayback api: %s", resp.Status)
+	}
+
+	var data struct {
+		ArchivedSnapshots struct {
+			Closest struct {
+				Available bool   `json:"available"`
+				URL       string `json:"url"`
+				Timestamp string `json:"timestamp"`
+				Status    string `json:"status"`
+			} `json:"closest"`
+		} `json:"archived_snapshots"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return "", time.Time{}, err
+	}
+
+	closest := data.ArchivedSnapshots.Closest
+	if !closest.Available || closest.URL == "" || (closest.Status != "" && closest.Status != "200") {
+		return "", time.Time{}, nil
+	}
+
+	at, _ := time.Parse(waybackTimestamp, closest.Timestamp)
+	snapURL := strings.Replace(closest.URL, "/web/"+closest.Timestamp+"/", "/web/"+closest.Timestamp+"id_/", 1)
+
+	return snapURL, at, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// WEBHOOK_URLS are posted an article.saved event, comma separated,
+	// whenever a new article is saved. With WEBHOOK_SECRET the body is
+	// signed in X-Readability-Signature.
+	WEBHOOK_URLS   = splitList(envOr("WEBHOOK_URLS", ""))
+	WEBHOOK_SECRET = envOr("WEBHOOK_SECRET", "")
+	// BASE_URL is where this instance is reached, for links sent outside
+	// of a request, e.g. https://read.example.com.
+	BASE_URL = strings.TrimSuffix(envOr("BASE_URL", ""), "/")
+
+	// Webhooks go to services set up by the operator, often on the local
+	// network, so they skip the fetcher and its address checks.
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+const webhookAttempts = 3
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+type webhookEvent struct {
+	Event     string    `json:"event"`
+	URL       string    `json:"url"`
+	Title     string    `json:"title"`
+	Excerpt   string    `json:"excerpt,omitempty"`
+	Byline    string    `json:"byline,omitempty"`
+	SiteName  string    `json:"site_name,omitempty"`
+	WordCount int       `json:"word_count"`
+	Link      string    `json:"link,omitempty"`
+	SavedAt   time.Time `json:"saved_at"`
+}
+
+// notifySaved posts art to the WEBHOOK_URLS in the background.
+func notifySaved(art *article) {
+	if len(WEBHOOK_URLS) == 0 {
+		return
+	}
+
+	event := webhookEvent{
+		Event:     "article.saved",
+		URL:       art.URL,
+		Title:     art.Title,
+		Excerpt:   art.Excerpt,
+		Byline:    art.Byline,
+		SiteName:  art.SiteName,
+		WordCount: art.WordCount,
+		SavedAt:   art.CreatedAt,
+	}
+	if event.Excerpt == "" {
+		event.Excerpt = excerpt(htmlText(art.Content), 200)
+	}
+	if BASE_URL != "" {
+		event.Link = BASE_URL + articlePath("read", art.URL)
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("failed to encode webhook", "err", err)
+		return
+	}
+
+	for _, hook := range WEBHOOK_URLS {
+		go postWebhook(hook, body)
+	}
+}
+
+// postWebhook posts body to hook, trying again with backoff when it fails.
+func postWebhook(hook string, body []byte) {
+	wait := time.Second
+
+	for attempt := 1; ; attempt++ {
+		err := sendWebhook(hook, body)
+		if err == nil {
+			return
+		}
+		if attempt >= webhookAttempts {
+			slog.Error("failed to post webhook", "url", hook, "err", err)
+			return
+		}
+
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func sendWebhook(hook string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", FETCH_USER_AGENT)
+	if WEBHOOK_SECRET != "" {
+		mac := hmac.New(sha256.New, []byte(WEBHOOK_SECRET))
+		mac.Write(body)
+		req.Header.Set("X-Readability-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	// FETCH_WORKERS is how many pages are fetched and extracted at once,
+	// more requests wait for a free worker.
+	FETCH_WORKERS = envInt("FETCH_WORKERS", 8)
+
+	fetchWorkers = make(chan struct{}, workerCount())
+	fetchGroup   singleflight.Group
+)
+
+func workerCount() int {
+	if FETCH_WORKERS < 1 {
+		return 1
+	}
+
+	return FETCH_WORKERS
+}
+
+// withWorker runs fn on one of the FETCH_WORKERS, sharing its result with
+// every request for the same key that comes in while it runs. fn doesn't
+// end with the request that started it but within WRITE_TIMEOUT, after
+// which none of them could be answered, while each request stops waiting
+// when its ctx is done.
+func withWorker(ctx context.Context, key string, fn func(context.Context) (interface{}, error)) (interface{}, error) {
+	ch := fetchGroup.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), WRITE_TIMEOUT)
+		defer cancel()
+
+		select {
+		case fetchWorkers <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		defer func() { <-fetchWorkers }()
+
+		return fn(ctx)
+	})
+
+	select {
+	case res := <-ch:
+		return res.Val, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetchReadableShared is fetchReadable on a worker, once per URL at a time.
+// The requests sharing it log with the first one's ctx.
+func fetchReadableShared(ctx context.Context, uri string) (fetchedPage, error) {
+	v, err := withWorker(ctx, "page:"+uri, func(ctx context.Context) (interface{}, error) {
+		return fetchReadable(ctx, uri)
+	})
+
+	page, _ := v.(fetchedPage)
+	return page, err
+}
+
+// getDataFromURLShared is getDataFromURL on a worker, once per URL at a
+// time.
+func getDataFromURLShared(ctx context.Context, uri string) ([]byte, error) {
+	v, err := withWorker(ctx, "data:"+uri, func(ctx context.Context) (interface{}, error) {
+		return getDataFromURL(ctx, uri)
+	})
+
+	data, _ := v.([]byte)
+	return data, err
+}